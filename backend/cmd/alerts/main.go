@@ -0,0 +1,74 @@
+package main
+
+import (
+	"log"
+
+	"openreplay/backend/internal/alerts"
+	"openreplay/backend/internal/alerts/digest"
+	config "openreplay/backend/internal/config/alerts"
+	"openreplay/backend/pkg/db/postgres/pool"
+	"openreplay/backend/pkg/db/redis"
+	"openreplay/backend/pkg/memory"
+	"openreplay/backend/pkg/messages"
+	"openreplay/backend/pkg/metrics"
+	alertsMetrics "openreplay/backend/pkg/metrics/alerts"
+	databaseMetrics "openreplay/backend/pkg/metrics/database"
+	"openreplay/backend/pkg/projects"
+	"openreplay/backend/pkg/queue"
+	"openreplay/backend/pkg/sessions"
+	"openreplay/backend/pkg/terminator"
+)
+
+func main() {
+	m := metrics.New()
+	m.Register(alertsMetrics.List())
+	m.Register(databaseMetrics.List())
+
+	log.SetFlags(log.LstdFlags | log.LUTC | log.Llongfile)
+	cfg := config.New()
+
+	// Init postgres connection
+	pgConn, err := pool.New(cfg.Postgres.String())
+	if err != nil {
+		log.Printf("can't init postgres connection: %s", err)
+		return
+	}
+	defer pgConn.Close()
+
+	// Init redis connection
+	redisClient, err := redis.New(&cfg.Redis)
+	if err != nil {
+		log.Printf("can't init redis connection: %s", err)
+	}
+	defer redisClient.Close()
+
+	projManager := projects.New(pgConn, redisClient)
+	sessManager := sessions.New(pgConn, projManager, redisClient)
+
+	storage := digest.NewStorage(pgConn, cfg.RulesTTL)
+	digester := digest.New(cfg, sessManager, storage, digest.NewNotifiers(cfg))
+
+	msgFilter := []int{messages.MsgIssueEvent, messages.MsgIOSIssueEvent}
+	consumer := queue.NewConsumer(
+		cfg.GroupAlerts,
+		[]string{
+			cfg.TopicAnalytics,
+		},
+		messages.NewMessageIterator(digester.Handle, msgFilter, true),
+		false,
+		cfg.MessageSizeLimit,
+	)
+
+	// Init memory manager
+	memoryManager, err := memory.NewManager(cfg.MemoryLimitMB, cfg.MaxMemoryUsage)
+	if err != nil {
+		log.Printf("can't init memory manager: %s", err)
+		return
+	}
+
+	// Run service and wait for TERM signal
+	service := alerts.New(cfg, consumer, digester, memoryManager)
+	log.Printf("Alerts service started\n")
+	terminator.Wait(service)
+	log.Printf("Alerts service stopped\n")
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"log"
+	"os"
+
+	dbConfig "openreplay/backend/internal/config/db"
+	enderConfig "openreplay/backend/internal/config/ender"
+	heuristicsConfig "openreplay/backend/internal/config/heuristics"
+	httpConfig "openreplay/backend/internal/config/http"
+	sinkConfig "openreplay/backend/internal/config/sink"
+	storageConfig "openreplay/backend/internal/config/storage"
+	"openreplay/backend/internal/db"
+	"openreplay/backend/internal/db/datasaver"
+	"openreplay/backend/internal/ender"
+	"openreplay/backend/internal/heuristics"
+	"openreplay/backend/internal/http/router"
+	"openreplay/backend/internal/http/server"
+	"openreplay/backend/internal/http/services"
+	"openreplay/backend/internal/service"
+	"openreplay/backend/internal/sink"
+	"openreplay/backend/internal/storage"
+	"openreplay/backend/pkg/builders"
+	"openreplay/backend/pkg/db/postgres"
+	"openreplay/backend/pkg/db/postgres/pool"
+	"openreplay/backend/pkg/db/redis"
+	"openreplay/backend/pkg/failover"
+	"openreplay/backend/pkg/memory"
+	"openreplay/backend/pkg/memqueue"
+	"openreplay/backend/pkg/messages"
+	"openreplay/backend/pkg/metrics"
+	databaseMetrics "openreplay/backend/pkg/metrics/database"
+	enderMetrics "openreplay/backend/pkg/metrics/ender"
+	heuristicsMetrics "openreplay/backend/pkg/metrics/heuristics"
+	httpMetrics "openreplay/backend/pkg/metrics/http"
+	sinkMetrics "openreplay/backend/pkg/metrics/sink"
+	storageMetrics "openreplay/backend/pkg/metrics/storage"
+	"openreplay/backend/pkg/objectstorage/store"
+	"openreplay/backend/pkg/projects"
+	"openreplay/backend/pkg/queue"
+	"openreplay/backend/pkg/sessions"
+	"openreplay/backend/pkg/sourcemaps"
+	"openreplay/backend/pkg/terminator"
+)
+
+/*
+	All-in-one runs http, sink, ender, heuristics, db and storage services in one process.
+	Services use the in-process queue by default (set QUEUE_TYPE to use an external broker instead),
+	so the configuration is the union of env variables of all services.
+*/
+
+// serviceGroup stops services in the reverse order of start, so producers stop before their consumers
+type serviceGroup []service.Interface
+
+func (g serviceGroup) Stop() {
+	for i := len(g) - 1; i >= 0; i-- {
+		g[i].Stop()
+	}
+}
+
+type brokerStopper struct{}
+
+func (brokerStopper) Stop() {
+	if err := memqueue.Default().Close(); err != nil {
+		log.Printf("can't close memory queue: %s", err)
+	}
+}
+
+func main() {
+	m := metrics.New()
+	m.Register(httpMetrics.List())
+	m.Register(databaseMetrics.List())
+	m.Register(sinkMetrics.List())
+	m.Register(enderMetrics.List())
+	m.Register(heuristicsMetrics.List())
+	m.Register(storageMetrics.List())
+
+	log.SetFlags(log.LstdFlags | log.LUTC | log.Llongfile)
+
+	if os.Getenv("QUEUE_TYPE") == "" {
+		os.Setenv("QUEUE_TYPE", "memory")
+	}
+	var running serviceGroup
+	if os.Getenv("QUEUE_TYPE") == "memory" {
+		running = append(running, brokerStopper{})
+	}
+
+	httpCfg := httpConfig.New()
+	sinkCfg := sinkConfig.New()
+	enderCfg := enderConfig.New()
+	heuristicsCfg := heuristicsConfig.New()
+	dbCfg := dbConfig.New()
+	storageCfg := storageConfig.New()
+
+	// Init postgres connection shared by all services
+	pgConn, err := pool.New(dbCfg.Postgres.String())
+	if err != nil {
+		log.Printf("can't init postgres connection: %s", err)
+		return
+	}
+	defer pgConn.Close()
+
+	// Init redis connection
+	redisClient, err := redis.New(&dbCfg.Redis)
+	if err != nil {
+		log.Printf("can't init redis connection: %s", err)
+	}
+	defer redisClient.Close()
+
+	memoryManager, err := memory.NewManager(dbCfg.MemoryLimitMB, dbCfg.MaxMemoryUsage)
+	if err != nil {
+		log.Printf("can't init memory manager: %s", err)
+		return
+	}
+
+	// Storage
+	objStore, err := store.NewStore(&storageCfg.ObjectsConfig)
+	if err != nil {
+		log.Fatalf("can't init object storage: %s", err)
+	}
+	srv, err := storage.New(storageCfg, objStore)
+	if err != nil {
+		log.Fatalf("can't init storage service: %s", err)
+	}
+	sessionFinder, err := failover.NewSessionFinder(storageCfg, srv)
+	if err != nil {
+		log.Fatalf("can't init sessionFinder module: %s", err)
+	}
+	running = append(running, storage.NewService(storageCfg, srv, sessionFinder))
+
+	// DB
+	pg := postgres.NewConn(pgConn)
+	defer pg.Close()
+	dbSessions := sessions.New(pgConn, projects.New(pgConn, redisClient), redisClient)
+	var resolver *sourcemaps.Resolver
+	if dbCfg.UseSourcemaps {
+		if resolver, err = db.NewSourcemapsResolver(dbCfg); err != nil {
+			log.Fatalf("can't init source maps resolver: %s", err)
+		}
+	}
+	saver := datasaver.New(dbCfg, pg, dbSessions, resolver)
+	dbConsumer := queue.NewConsumer(
+		dbCfg.GroupDB,
+		[]string{
+			dbCfg.TopicRawWeb,
+			dbCfg.TopicRawIOS,
+			dbCfg.TopicAnalytics,
+		},
+		messages.NewMessageIterator(saver.Handle, db.MessageFilter(), true),
+		false,
+		dbCfg.MessageSizeLimit,
+	)
+	running = append(running, db.New(dbCfg, dbConsumer, saver, memoryManager, dbSessions))
+
+	// Heuristics
+	eventBuilder := builders.NewBuilderMap(heuristics.HandlersFabric)
+	heuristicsConsumer := queue.NewConsumer(
+		heuristicsCfg.GroupHeuristics,
+		[]string{
+			heuristicsCfg.TopicRawWeb,
+			heuristicsCfg.TopicRawIOS,
+		},
+		messages.NewMessageIterator(eventBuilder.HandleMessage, nil, true),
+		false,
+		heuristicsCfg.MessageSizeLimit,
+	)
+	heuristicsProducer := queue.NewProducer(heuristicsCfg.MessageSizeLimit, true)
+	running = append(running, heuristics.New(heuristicsCfg, heuristicsProducer, heuristicsConsumer, eventBuilder, memoryManager))
+
+	// Ender
+	enderSessions := sessions.New(pgConn, projects.New(pgConn, redisClient), redisClient)
+	enderService, err := ender.New(enderCfg, enderSessions, memoryManager)
+	if err != nil {
+		log.Fatalf("can't init ender service: %s", err)
+	}
+	running = append(running, enderService)
+
+	// Sink
+	sinkService, err := sink.New(sinkCfg)
+	if err != nil {
+		log.Fatalf("can't init sink service: %s", err)
+	}
+	running = append(running, sinkService)
+
+	// HTTP
+	httpProducer := queue.NewProducer(httpCfg.MessageSizeLimit, true)
+	httpServices, err := services.New(httpCfg, httpProducer, pgConn, redisClient)
+	if err != nil {
+		log.Fatalf("failed while creating services: %s", err)
+	}
+	httpRouter, err := router.NewRouter(httpCfg, httpServices)
+	if err != nil {
+		log.Fatalf("failed while creating engine: %s", err)
+	}
+	httpServer, err := server.New(httpRouter.GetHandler(), httpCfg.HTTPHost, httpCfg.HTTPPort, httpCfg.HTTPTimeout)
+	if err != nil {
+		log.Fatalf("failed while creating server: %s", err)
+	}
+	go func() {
+		if err := httpServer.Start(); err != nil {
+			log.Fatalf("Server error: %v\n", err)
+		}
+	}()
+	running = append(running, httpServer)
+
+	log.Printf("All-in-one service started, http port: %v\n", httpCfg.HTTPPort)
+	terminator.Wait(running)
+}
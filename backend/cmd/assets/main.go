@@ -10,11 +10,14 @@ import (
 	"openreplay/backend/internal/assets"
 	"openreplay/backend/internal/assets/cacher"
 	config "openreplay/backend/internal/config/assets"
+	"openreplay/backend/pkg/db/postgres/pool"
 	"openreplay/backend/pkg/messages"
 	"openreplay/backend/pkg/metrics"
 	assetsMetrics "openreplay/backend/pkg/metrics/assets"
 	"openreplay/backend/pkg/objectstorage/store"
+	"openreplay/backend/pkg/projects"
 	"openreplay/backend/pkg/queue"
+	"openreplay/backend/pkg/sessions"
 )
 
 func main() {
@@ -29,7 +32,18 @@ func main() {
 	if err != nil {
 		log.Fatalf("Error on object storage creation: %v", err)
 	}
-	cacher, err := cacher.NewCacher(cfg, objStore)
+	// Sessions manager is needed only for project specific credentials
+	var sessManager sessions.Sessions
+	if cfg.Postgres != "" {
+		pgConn, err := pool.New(cfg.Postgres)
+		if err != nil {
+			log.Fatalf("can't init postgres connection: %s", err)
+		}
+		defer pgConn.Close()
+		sessManager = sessions.New(pgConn, projects.New(pgConn, nil), nil)
+	}
+
+	cacher, err := cacher.NewCacher(cfg, objStore, sessManager)
 	if err != nil {
 		log.Fatalf("Error on cacher creation: %v", err)
 	}
@@ -15,6 +15,7 @@ import (
 	"openreplay/backend/pkg/projects"
 	"openreplay/backend/pkg/queue"
 	"openreplay/backend/pkg/sessions"
+	"openreplay/backend/pkg/sourcemaps"
 	"openreplay/backend/pkg/terminator"
 )
 
@@ -48,26 +49,19 @@ func main() {
 	projManager := projects.New(pgConn, redisClient)
 	sessManager := sessions.New(pgConn, projManager, redisClient)
 
-	// Init data saver
-	saver := datasaver.New(cfg, pg, sessManager)
-
-	// Message filter
-	msgFilter := []int{
-		// Web messages
-		messages.MsgMetadata, messages.MsgIssueEvent, messages.MsgSessionStart, messages.MsgSessionEnd,
-		messages.MsgUserID, messages.MsgUserAnonymousID, messages.MsgIntegrationEvent, messages.MsgPerformanceTrackAggr,
-		messages.MsgJSException, messages.MsgResourceTiming, messages.MsgCustomEvent, messages.MsgCustomIssue,
-		messages.MsgFetch, messages.MsgNetworkRequest, messages.MsgGraphQL, messages.MsgStateAction, messages.MsgMouseClick,
-		messages.MsgSetPageLocation, messages.MsgPageLoadTiming, messages.MsgPageRenderTiming,
-		messages.MsgPageEvent, messages.MsgMouseThrashing, messages.MsgInputChange,
-		messages.MsgUnbindNodes, messages.MsgCanvasNode,
-		// Mobile messages
-		messages.MsgIOSSessionStart, messages.MsgIOSSessionEnd, messages.MsgIOSUserID, messages.MsgIOSUserAnonymousID,
-		messages.MsgIOSMetadata, messages.MsgIOSEvent, messages.MsgIOSNetworkCall,
-		messages.MsgIOSClickEvent, messages.MsgIOSSwipeEvent, messages.MsgIOSInputEvent,
-		messages.MsgIOSCrash, messages.MsgIOSIssueEvent,
+	// Init source maps resolver
+	var resolver *sourcemaps.Resolver
+	if cfg.UseSourcemaps {
+		resolver, err = db.NewSourcemapsResolver(cfg)
+		if err != nil {
+			log.Printf("can't init source maps resolver: %s", err)
+			return
+		}
 	}
 
+	// Init data saver
+	saver := datasaver.New(cfg, pg, sessManager, resolver)
+
 	// Init consumer
 	consumer := queue.NewConsumer(
 		cfg.GroupDB,
@@ -76,7 +70,7 @@ func main() {
 			cfg.TopicRawIOS,
 			cfg.TopicAnalytics,
 		},
-		messages.NewMessageIterator(saver.Handle, msgFilter, true),
+		messages.NewMessageIterator(saver.Handle, db.MessageFilter(), true),
 		false,
 		cfg.MessageSizeLimit,
 	)
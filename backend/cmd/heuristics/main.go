@@ -5,10 +5,6 @@ import (
 	config "openreplay/backend/internal/config/heuristics"
 	"openreplay/backend/internal/heuristics"
 	"openreplay/backend/pkg/builders"
-	"openreplay/backend/pkg/handlers"
-	"openreplay/backend/pkg/handlers/custom"
-	"openreplay/backend/pkg/handlers/ios"
-	"openreplay/backend/pkg/handlers/web"
 	"openreplay/backend/pkg/memory"
 	"openreplay/backend/pkg/messages"
 	"openreplay/backend/pkg/metrics"
@@ -24,23 +20,7 @@ func main() {
 	log.SetFlags(log.LstdFlags | log.LUTC | log.Llongfile)
 	cfg := config.New()
 
-	// HandlersFabric returns the list of message handlers we want to be applied to each incoming message.
-	handlersFabric := func() []handlers.MessageProcessor {
-		return []handlers.MessageProcessor{
-			custom.NewPageEventBuilder(),
-			web.NewDeadClickDetector(),
-			&web.ClickRageDetector{},
-			&web.CpuIssueDetector{},
-			&web.MemoryIssueDetector{},
-			&web.NetworkIssueDetector{},
-			&web.PerformanceAggregator{},
-			web.NewAppCrashDetector(),
-			&ios.TapRageDetector{},
-			ios.NewViewComponentDurations(),
-		}
-	}
-
-	eventBuilder := builders.NewBuilderMap(handlersFabric)
+	eventBuilder := builders.NewBuilderMap(heuristics.HandlersFabric)
 	producer := queue.NewProducer(cfg.MessageSizeLimit, true)
 	consumer := queue.NewConsumer(
 		cfg.GroupHeuristics,
@@ -2,6 +2,7 @@ package main
 
 import (
 	"log"
+	"net/http"
 	"openreplay/backend/pkg/db/postgres/pool"
 	"openreplay/backend/pkg/integrations"
 	"os"
@@ -10,10 +11,15 @@ import (
 	"time"
 
 	config "openreplay/backend/internal/config/integrations"
+	"openreplay/backend/internal/http/server"
+	"openreplay/backend/internal/http/util"
 	"openreplay/backend/internal/integrations/clientManager"
+	"openreplay/backend/internal/integrations/otel"
+	"openreplay/backend/internal/integrations/webhooks"
 	"openreplay/backend/pkg/intervals"
 	"openreplay/backend/pkg/metrics"
 	databaseMetrics "openreplay/backend/pkg/metrics/database"
+	integrationsMetrics "openreplay/backend/pkg/metrics/integrations"
 	"openreplay/backend/pkg/queue"
 	"openreplay/backend/pkg/token"
 )
@@ -21,6 +27,7 @@ import (
 func main() {
 	m := metrics.New()
 	m.Register(databaseMetrics.List())
+	m.Register(integrationsMetrics.List())
 
 	log.SetFlags(log.LstdFlags | log.LUTC | log.Llongfile)
 
@@ -62,6 +69,42 @@ func main() {
 		}
 	})
 
+	// Push-based integrations (webhooks and OTLP), events go to the same channel as polled ones.
+	// The same server exposes the integrations health status.
+	var webhookServer *server.Server
+	if cfg.WebhooksPort != "" {
+		webhookReceiver, err := webhooks.NewReceiver(cfg, manager, manager.Events)
+		if err != nil {
+			log.Fatalf("can't init webhook receiver: %s", err)
+		}
+		handler := http.NewServeMux()
+		handler.Handle("/v1/webhooks/", webhookReceiver)
+		if cfg.StatusAuthToken != "" {
+			handler.Handle("/v1/integrations/status", util.BearerAuth(cfg.StatusAuthToken, http.HandlerFunc(manager.StatusHandler)))
+		} else {
+			log.Printf("Integrations status endpoint is disabled, STATUS_AUTH_TOKEN is empty")
+		}
+		if cfg.OTLPAuthToken != "" {
+			otelReceiver, err := otel.NewReceiver(cfg, manager.Events)
+			if err != nil {
+				log.Fatalf("can't init otlp receiver: %s", err)
+			}
+			handler.Handle("/v1/traces", otelReceiver)
+			handler.Handle("/v1/logs", otelReceiver)
+		} else {
+			log.Printf("OTLP receiver is disabled, OTLP_AUTH_TOKEN is empty")
+		}
+		webhookServer, err = server.New(handler, cfg.WebhooksHost, cfg.WebhooksPort, cfg.WebhooksTimeout)
+		if err != nil {
+			log.Fatalf("can't init webhook server: %s", err)
+		}
+		go func() {
+			if err := webhookServer.Start(); err != nil {
+				log.Printf("webhook server error: %s", err)
+			}
+		}()
+	}
+
 	sigchan := make(chan os.Signal, 1)
 	signal.Notify(sigchan, syscall.SIGINT, syscall.SIGTERM)
 
@@ -73,6 +116,9 @@ func main() {
 		select {
 		case sig := <-sigchan:
 			log.Printf("Caught signal %v: terminating\n", sig)
+			if webhookServer != nil {
+				webhookServer.Stop()
+			}
 			listener.Close()
 			pgConn.Close()
 			os.Exit(0)
@@ -80,7 +126,7 @@ func main() {
 			log.Printf("Requesting all...\n")
 			manager.RequestAll()
 		case event := <-manager.Events:
-			log.Printf("New integration event: %+v\n", *event.IntegrationEvent)
+			log.Printf("New integration event: %+v\n", event.Msg())
 			sessionID := event.SessionID
 			if sessionID == 0 {
 				sessData, err := tokenizer.Parse(event.Token)
@@ -90,7 +136,15 @@ func main() {
 				}
 				sessionID = sessData.ID
 			}
-			producer.Produce(cfg.TopicAnalytics, sessionID, event.IntegrationEvent.Encode())
+			// Webhook requests are authorized per project, so they can't add errors to sessions of other projects
+			if event.ProjectID != 0 {
+				if projectID, err := sessionProject(pgConn, sessionID); err != nil || projectID != event.ProjectID {
+					log.Printf("Webhook event rejected, sessionID: %d, projectID: %d, session projectID: %d, err: %v",
+						sessionID, event.ProjectID, projectID, err)
+					continue
+				}
+			}
+			producer.Produce(cfg.TopicAnalytics, sessionID, event.Encode())
 		case err := <-manager.Errors:
 			log.Printf("Integration error: %v\n", err)
 		case i := <-manager.RequestDataUpdates:
@@ -112,3 +166,9 @@ func main() {
 		}
 	}
 }
+
+func sessionProject(conn pool.Pool, sessionID uint64) (uint32, error) {
+	var projectID uint32
+	err := conn.QueryRow(`SELECT project_id FROM public.sessions WHERE session_id = $1`, sessionID).Scan(&projectID)
+	return projectID, err
+}
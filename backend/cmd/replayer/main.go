@@ -0,0 +1,85 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	config "openreplay/backend/internal/config/replayer"
+	"openreplay/backend/internal/replayer"
+	"openreplay/backend/pkg/queue"
+)
+
+func main() {
+	log.SetFlags(log.LstdFlags | log.LUTC | log.Llongfile)
+
+	cfg := config.New()
+
+	producer := queue.NewProducer(cfg.MessageSizeLimit, true)
+	defer producer.Close(cfg.ProducerTimeout)
+	batchReplayer, err := replayer.New(cfg, producer)
+	if err != nil {
+		log.Fatalf("can't init replayer: %s", err)
+	}
+	defer func() {
+		if err := batchReplayer.Close(); err != nil {
+			log.Printf("can't save replay state: %s", err)
+		}
+	}()
+	consumer := queue.NewConsumer(
+		cfg.GroupReplayer,
+		[]string{
+			cfg.TopicDeadLetter,
+		},
+		batchReplayer,
+		false,
+		cfg.MessageSizeLimit,
+	)
+	defer consumer.Close()
+
+	commit := func() {
+		// Dry run doesn't move offsets, so the real replay gets the same batches
+		if cfg.DryRun {
+			return
+		}
+		producer.Flush(cfg.ProducerTimeout)
+		if err := batchReplayer.Sync(); err != nil {
+			// Batches will be read again, so they can be replayed twice, but not lost
+			log.Printf("can't save replay state: %s", err)
+			return
+		}
+		if err := consumer.Commit(); err != nil {
+			log.Printf("can't commit messages: %s", err)
+		}
+	}
+
+	log.Printf("Replayer started, dead-letter topic: %s\n", cfg.TopicDeadLetter)
+
+	sigchan := make(chan os.Signal, 1)
+	signal.Notify(sigchan, syscall.SIGINT, syscall.SIGTERM)
+
+	tick := time.Tick(time.Second)
+	for {
+		select {
+		case sig := <-sigchan:
+			log.Printf("Caught signal %v: terminating\n", sig)
+			commit()
+			log.Printf("Replay interrupted, %s\n", batchReplayer.Stats())
+			return
+		case <-tick:
+			commit()
+			if batchReplayer.Idle() >= cfg.IdleTimeout {
+				log.Printf("Replay finished, %s\n", batchReplayer.Stats())
+				return
+			}
+		case msg := <-consumer.Rebalanced():
+			log.Println(msg)
+		default:
+			if err := consumer.ConsumeNext(); err != nil {
+				log.Fatalf("Error on consumption: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"log"
+
+	objConfig "openreplay/backend/internal/config/objectstorage"
+	config "openreplay/backend/internal/config/retention"
+	"openreplay/backend/internal/retention"
+	"openreplay/backend/internal/retention/eraser"
+	"openreplay/backend/pkg/db/postgres/pool"
+	"openreplay/backend/pkg/db/redis"
+	"openreplay/backend/pkg/metrics"
+	databaseMetrics "openreplay/backend/pkg/metrics/database"
+	retentionMetrics "openreplay/backend/pkg/metrics/retention"
+	"openreplay/backend/pkg/objectstorage"
+	"openreplay/backend/pkg/objectstorage/store"
+	"openreplay/backend/pkg/terminator"
+)
+
+func main() {
+	m := metrics.New()
+	m.Register(retentionMetrics.List())
+	m.Register(databaseMetrics.List())
+
+	log.SetFlags(log.LstdFlags | log.LUTC | log.Llongfile)
+	cfg := config.New()
+
+	// Init postgres connection
+	pgConn, err := pool.New(cfg.Postgres.String())
+	if err != nil {
+		log.Printf("can't init postgres connection: %s", err)
+		return
+	}
+	defer pgConn.Close()
+
+	// Init redis connection
+	redisClient, err := redis.New(&cfg.Redis)
+	if err != nil {
+		log.Printf("can't init redis connection: %s", err)
+	} else {
+		defer redisClient.Close()
+	}
+
+	// Init object storages with session files
+	objStore, err := store.NewStore(&cfg.ObjectsConfig)
+	if err != nil {
+		log.Fatalf("can't init object storage: %s", err)
+	}
+	objStores := []objectstorage.ObjectStorage{objStore}
+	for _, bucket := range cfg.ExtraBuckets {
+		bucketCfg, err := objConfig.NewForBucket(bucket)
+		if err != nil {
+			log.Fatalf("can't load config of %s bucket: %s", bucket, err)
+		}
+		bucketStore, err := store.NewStore(bucketCfg)
+		if err != nil {
+			log.Fatalf("can't init object storage for %s bucket: %s", bucket, err)
+		}
+		objStores = append(objStores, bucketStore)
+	}
+
+	analytics, err := eraser.NewAnalytics(cfg)
+	if err != nil {
+		log.Fatalf("can't init analytics connection: %s", err)
+	}
+	defer analytics.Close()
+
+	if cfg.DryRun {
+		log.Printf("dry run, nothing will be deleted")
+	}
+	sessionsEraser := eraser.New(cfg, eraser.NewStorage(pgConn), objStores, analytics, redisClient)
+
+	// Run service and wait for TERM signal
+	service := retention.New(cfg, sessionsEraser)
+	log.Printf("Retention service started\n")
+	terminator.Wait(service)
+	log.Printf("Retention service stopped\n")
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	objConfig "openreplay/backend/internal/config/objectstorage"
+	config "openreplay/backend/internal/config/sessionexport"
+	"openreplay/backend/internal/sessionexport"
+	"openreplay/backend/pkg/db/postgres/pool"
+	"openreplay/backend/pkg/objectstorage"
+	"openreplay/backend/pkg/objectstorage/store"
+	"openreplay/backend/pkg/projects"
+)
+
+const usage = `Usage:
+  sessionexport keygen                                 generate a key pair to sign archives
+  sessionexport export -session <id> [-out <file>]     export the session into a signed archive
+  sessionexport import -in <file> -project <id>        import the archive into the project`
+
+type env struct {
+	cfg      *config.Config
+	pg       pool.Pool
+	storage  sessionexport.Storage
+	projects projects.Projects
+	files    objectstorage.ObjectStorage
+	assets   objectstorage.ObjectStorage
+}
+
+func newEnv() *env {
+	cfg := config.New()
+	pgConn, err := pool.New(cfg.Postgres.String())
+	if err != nil {
+		log.Fatalf("can't init postgres connection: %s", err)
+	}
+	files, err := store.NewStore(&cfg.ObjectsConfig)
+	if err != nil {
+		log.Fatalf("can't init object storage: %s", err)
+	}
+	assetsCfg, err := objConfig.NewForBucket(cfg.AssetsBucket)
+	if err != nil {
+		log.Fatalf("can't load config of assets bucket: %s", err)
+	}
+	assets, err := store.NewStore(assetsCfg)
+	if err != nil {
+		log.Fatalf("can't init object storage for assets: %s", err)
+	}
+	return &env{
+		cfg:      cfg,
+		pg:       pgConn,
+		storage:  sessionexport.NewStorage(pgConn),
+		projects: projects.New(pgConn, nil),
+		files:    files,
+		assets:   assets,
+	}
+}
+
+func main() {
+	log.SetFlags(log.LstdFlags | log.LUTC | log.Llongfile)
+	if len(os.Args) < 2 {
+		fmt.Println(usage)
+		os.Exit(2)
+	}
+	switch os.Args[1] {
+	case "keygen":
+		keygen()
+	case "export":
+		exportSession(os.Args[2:])
+	case "import":
+		importSession(os.Args[2:])
+	default:
+		fmt.Println(usage)
+		os.Exit(2)
+	}
+}
+
+func keygen() {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		log.Fatalf("can't generate key: %s", err)
+	}
+	fmt.Printf("EXPORT_SIGNING_KEY=%s\n", base64.StdEncoding.EncodeToString(private.Seed()))
+	fmt.Printf("IMPORT_TRUSTED_KEYS=%s\n", base64.StdEncoding.EncodeToString(public))
+}
+
+func exportSession(args []string) {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	sessionID := flags.Uint64("session", 0, "session id")
+	out := flags.String("out", "", "archive path, <session id>.tar.gz by default")
+	flags.Parse(args)
+	if *sessionID == 0 {
+		log.Fatalf("session id is required")
+	}
+	if *out == "" {
+		*out = fmt.Sprintf("%d.tar.gz", *sessionID)
+	}
+
+	e := newEnv()
+	defer e.pg.Close()
+	if e.cfg.SigningKey == "" {
+		log.Fatalf("EXPORT_SIGNING_KEY is required to sign archives, run keygen to create it")
+	}
+	key, err := sessionexport.ParsePrivateKey(e.cfg.SigningKey)
+	if err != nil {
+		log.Fatalf("wrong signing key: %s", err)
+	}
+
+	file, err := os.Create(*out)
+	if err != nil {
+		log.Fatalf("can't create archive: %s", err)
+	}
+	exporter := sessionexport.NewExporter(e.cfg, e.storage, e.projects, e.files, e.assets, key)
+	manifest, err := exporter.Export(*sessionID, file)
+	if err == nil {
+		err = file.Close()
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(*out)
+		log.Fatalf("can't export session %d: %s", *sessionID, err)
+	}
+	log.Printf("session %d is exported to %s, files: %d", *sessionID, *out, len(manifest.Files))
+}
+
+func importSession(args []string) {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	in := flags.String("in", "", "archive path")
+	projectID := flags.Uint("project", 0, "id of the project to import the session into")
+	flags.Parse(args)
+	if *in == "" || *projectID == 0 {
+		log.Fatalf("archive and project id are required")
+	}
+
+	e := newEnv()
+	defer e.pg.Close()
+	trusted := make([]ed25519.PublicKey, 0, len(e.cfg.TrustedKeys))
+	for _, k := range e.cfg.TrustedKeys {
+		key, err := sessionexport.ParsePublicKey(k)
+		if err != nil {
+			log.Fatalf("wrong trusted key: %s", err)
+		}
+		trusted = append(trusted, key)
+	}
+	if len(trusted) == 0 {
+		log.Fatalf("IMPORT_TRUSTED_KEYS is required to verify archives")
+	}
+
+	file, err := os.Open(*in)
+	if err != nil {
+		log.Fatalf("can't open archive: %s", err)
+	}
+	defer file.Close()
+	importer := sessionexport.NewImporter(e.cfg, e.storage, e.projects, e.files, e.assets, trusted)
+	manifest, err := importer.Import(file, uint32(*projectID))
+	if err != nil {
+		log.Fatalf("can't import %s: %s", *in, err)
+	}
+	log.Printf("session %d of project %d (exported at %s) is imported into project %d",
+		manifest.SessionID, manifest.ProjectID, manifest.CreatedAt.Format("2006-01-02 15:04:05"), *projectID)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"log"
+
+	config "openreplay/backend/internal/config/webhooks"
+	"openreplay/backend/internal/webhooks"
+	"openreplay/backend/internal/webhooks/notifier"
+	"openreplay/backend/pkg/db/postgres/pool"
+	"openreplay/backend/pkg/db/redis"
+	"openreplay/backend/pkg/memory"
+	"openreplay/backend/pkg/messages"
+	"openreplay/backend/pkg/metrics"
+	databaseMetrics "openreplay/backend/pkg/metrics/database"
+	webhooksMetrics "openreplay/backend/pkg/metrics/webhooks"
+	"openreplay/backend/pkg/projects"
+	"openreplay/backend/pkg/queue"
+	"openreplay/backend/pkg/sessions"
+	"openreplay/backend/pkg/terminator"
+)
+
+func main() {
+	m := metrics.New()
+	m.Register(webhooksMetrics.List())
+	m.Register(databaseMetrics.List())
+
+	log.SetFlags(log.LstdFlags | log.LUTC | log.Llongfile)
+	cfg := config.New()
+
+	// Init postgres connection
+	pgConn, err := pool.New(cfg.Postgres.String())
+	if err != nil {
+		log.Printf("can't init postgres connection: %s", err)
+		return
+	}
+	defer pgConn.Close()
+
+	// Init redis connection
+	redisClient, err := redis.New(&cfg.Redis)
+	if err != nil {
+		log.Printf("can't init redis connection: %s", err)
+	}
+	defer redisClient.Close()
+
+	projManager := projects.New(pgConn, redisClient)
+	sessManager := sessions.New(pgConn, projManager, redisClient)
+
+	storage := notifier.NewStorage(pgConn, cfg.SubscriptionsTTL)
+	sender := notifier.NewSender(cfg, storage)
+	webhooksNotifier := notifier.New(cfg, sessManager, projManager, storage, sender)
+
+	msgFilter := []int{messages.MsgSessionEnd, messages.MsgIssueEvent, messages.MsgJSException}
+	consumer := queue.NewConsumer(
+		cfg.GroupWebhooks,
+		[]string{
+			cfg.TopicRawWeb,
+			cfg.TopicAnalytics,
+		},
+		messages.NewMessageIterator(webhooksNotifier.Handle, msgFilter, true),
+		false,
+		cfg.MessageSizeLimit,
+	)
+
+	// Init memory manager
+	memoryManager, err := memory.NewManager(cfg.MemoryLimitMB, cfg.MaxMemoryUsage)
+	if err != nil {
+		log.Printf("can't init memory manager: %s", err)
+		return
+	}
+
+	// Run service and wait for TERM signal
+	service := webhooks.New(cfg, consumer, webhooksNotifier, memoryManager)
+	log.Printf("Webhooks service started\n")
+	terminator.Wait(service)
+	log.Printf("Webhooks service stopped\n")
+}
@@ -0,0 +1,172 @@
+package digest
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"sort"
+	"time"
+
+	"openreplay/backend/pkg/url"
+)
+
+const MAX_CONTEXT_LENGTH = 200
+
+type groupKey struct {
+	Type          string
+	URL           string
+	ContextString string
+}
+
+// Issue is the number of issues of the same group in the session, it's the unit of the shared storage
+type Issue struct {
+	Partition     uint64
+	ProjectID     uint32
+	SessionID     uint64
+	Type          string
+	URL           string // path only
+	ContextString string
+	LastTs        uint64
+	Events        int
+}
+
+func newIssue(projectID uint32, sessionID uint64, timestamp uint64, issueType, rawURL, contextString string) *Issue {
+	if len(contextString) > MAX_CONTEXT_LENGTH {
+		contextString = contextString[:MAX_CONTEXT_LENGTH]
+	}
+	return &Issue{
+		ProjectID:     projectID,
+		SessionID:     sessionID,
+		Type:          issueType,
+		URL:           urlPath(rawURL),
+		ContextString: contextString,
+		LastTs:        timestamp,
+		Events:        1,
+	}
+}
+
+type sessionIssues struct {
+	lastTs uint64
+	events int
+}
+
+// Aggregator groups sessions with issues by project, type, url path and context string.
+// It's built from the shared storage on every run, which keeps data for the baseline period
+// (also the longest possible window).
+type Aggregator struct {
+	period    time.Duration
+	startedAt time.Time                                         // there is no data before the start, so the baseline period is shorter
+	groups    map[uint32]map[groupKey]map[uint64]*sessionIssues // project -> group -> session
+}
+
+// Item is a group of issues in a window
+type Item struct {
+	Type          string  `json:"type"`
+	URL           string  `json:"url"`
+	ContextString string  `json:"contextString"`
+	Sessions      int     `json:"sessions"`
+	Events        int     `json:"events"`
+	Baseline      float64 `json:"baseline"` // average number of sessions per window before the current one, -1 if unknown yet
+}
+
+func (i *Item) fingerprint() string {
+	hash := sha1.Sum([]byte(i.Type + "\n" + i.URL + "\n" + i.ContextString))
+	return hex.EncodeToString(hash[:])
+}
+
+func NewAggregator(period time.Duration, startedAt time.Time) *Aggregator {
+	return &Aggregator{
+		period:    period,
+		startedAt: startedAt,
+		groups:    make(map[uint32]map[groupKey]map[uint64]*sessionIssues),
+	}
+}
+
+func urlPath(rawURL string) string {
+	if rawURL == "" {
+		return ""
+	}
+	if _, path, _, err := url.GetURLParts(rawURL); err == nil {
+		return path
+	}
+	return url.DiscardURLQuery(rawURL)
+}
+
+func (a *Aggregator) Add(projectID uint32, sessionID uint64, timestamp uint64, issueType, rawURL, contextString string) {
+	a.AddIssue(newIssue(projectID, sessionID, timestamp, issueType, rawURL, contextString))
+}
+
+func (a *Aggregator) AddIssue(issue *Issue) {
+	project, ok := a.groups[issue.ProjectID]
+	if !ok {
+		project = make(map[groupKey]map[uint64]*sessionIssues)
+		a.groups[issue.ProjectID] = project
+	}
+	key := groupKey{Type: issue.Type, URL: issue.URL, ContextString: issue.ContextString}
+	group, ok := project[key]
+	if !ok {
+		group = make(map[uint64]*sessionIssues)
+		project[key] = group
+	}
+	sess, ok := group[issue.SessionID]
+	if !ok {
+		sess = &sessionIssues{}
+		group[issue.SessionID] = sess
+	}
+	sess.events += issue.Events
+	if issue.LastTs > sess.lastTs {
+		sess.lastTs = issue.LastTs
+	}
+}
+
+// Items returns groups of the project with at least one session in the window, the most frequent first.
+// All issue types are returned if issueTypes is empty.
+func (a *Aggregator) Items(projectID uint32, issueTypes []string, window time.Duration, now time.Time) []*Item {
+	windowFrom := uint64(now.Add(-window).UnixMilli())
+	period := a.period
+	if sinceStart := now.Sub(a.startedAt); sinceStart < period {
+		period = sinceStart
+	}
+	periodFrom := uint64(now.Add(-period).UnixMilli())
+	baselineWindows := float64(period-window) / float64(window)
+	items := make([]*Item, 0)
+	for key, group := range a.groups[projectID] {
+		if len(issueTypes) > 0 && !contains(issueTypes, key.Type) {
+			continue
+		}
+		item := &Item{Type: key.Type, URL: key.URL, ContextString: key.ContextString}
+		before := 0
+		for _, sess := range group {
+			if sess.lastTs >= windowFrom {
+				item.Sessions++
+				item.Events += sess.events
+			} else if sess.lastTs >= periodFrom {
+				before++
+			}
+		}
+		if item.Sessions == 0 {
+			continue
+		}
+		if baselineWindows >= 1 {
+			item.Baseline = float64(before) / baselineWindows
+		} else {
+			item.Baseline = -1 // unknown
+		}
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Sessions != items[j].Sessions {
+			return items[i].Sessions > items[j].Sessions
+		}
+		return items[i].fingerprint() < items[j].fingerprint()
+	})
+	return items
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
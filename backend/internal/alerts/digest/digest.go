@@ -0,0 +1,114 @@
+package digest
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const MAX_DIGEST_ITEMS = 20
+
+type Digest struct {
+	RuleID        uint32  `json:"digestId"`
+	ProjectID     uint32  `json:"projectId"`
+	From          int64   `json:"from"` // unix milliseconds
+	To            int64   `json:"to"`
+	WindowMinutes int     `json:"windowMinutes"`
+	Items         []*Item `json:"items"`
+	Skipped       int     `json:"skipped"` // groups which didn't fit into the digest
+}
+
+func newDigest(rule *Rule, items []*Item, now time.Time) *Digest {
+	d := &Digest{
+		RuleID:        rule.ID,
+		ProjectID:     rule.ProjectID,
+		From:          now.Add(-rule.Window).UnixMilli(),
+		To:            now.UnixMilli(),
+		WindowMinutes: int(rule.Window / time.Minute),
+		Items:         items,
+	}
+	if len(items) > MAX_DIGEST_ITEMS {
+		d.Items, d.Skipped = items[:MAX_DIGEST_ITEMS], len(items)-MAX_DIGEST_ITEMS
+	}
+	return d
+}
+
+var issueNames = map[string]string{
+	"click_rage":          "click rage",
+	"tap_rage":            "tap rage",
+	"dead_click":          "dead clicks",
+	"excessive_scrolling": "excessive scrolling",
+	"bad_request":         "bad requests",
+	"missing_resource":    "missing resources",
+	"memory":              "high memory usage",
+	"cpu":                 "high CPU usage",
+	"slow_resource":       "slow resources",
+	"slow_page_load":      "slow page load",
+	"crash":               "crashes",
+	"app_crash":           "app crashes",
+	"js_exception":        "JS exceptions",
+	"mouse_thrashing":     "mouse thrashing",
+	"custom":              "custom issues",
+}
+
+func issueName(issueType string) string {
+	if name, ok := issueNames[issueType]; ok {
+		return name
+	}
+	return strings.ReplaceAll(issueType, "_", " ")
+}
+
+func windowName(minutes int) string {
+	switch {
+	case minutes == 1:
+		return "minute"
+	case minutes == 60:
+		return "hour"
+	case minutes == 24*60:
+		return "day"
+	case minutes%60 == 0:
+		return fmt.Sprintf("%d hours", minutes/60)
+	}
+	return fmt.Sprintf("%d minutes", minutes)
+}
+
+// Describe returns a human-readable line, e.g. "23 sessions with dead clicks on /checkout in the last hour"
+func (d *Digest) Describe(item *Item) string {
+	var b strings.Builder
+	if item.Sessions == 1 {
+		b.WriteString("1 session")
+	} else {
+		fmt.Fprintf(&b, "%d sessions", item.Sessions)
+	}
+	b.WriteString(" with ")
+	b.WriteString(issueName(item.Type))
+	if item.URL != "" {
+		b.WriteString(" on ")
+		b.WriteString(item.URL)
+	}
+	b.WriteString(" in the last ")
+	b.WriteString(windowName(d.WindowMinutes))
+	if item.ContextString != "" {
+		fmt.Fprintf(&b, " (%s)", item.ContextString)
+	}
+	return b.String()
+}
+
+func (d *Digest) Title() string {
+	title := d.Describe(d.Items[0])
+	if others := len(d.Items) - 1 + d.Skipped; others > 0 {
+		title += fmt.Sprintf(" and %d more", others)
+	}
+	return title
+}
+
+func (d *Digest) Lines() []string {
+	lines := make([]string, 0, len(d.Items)+1)
+	for _, item := range d.Items {
+		lines = append(lines, d.Describe(item))
+	}
+	if d.Skipped > 0 {
+		lines = append(lines, fmt.Sprintf("... and %d more", d.Skipped))
+	}
+	return lines
+}
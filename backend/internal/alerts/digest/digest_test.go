@@ -0,0 +1,201 @@
+package digest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	config "openreplay/backend/internal/config/alerts"
+)
+
+type testStorage struct {
+	rules  []*Rule
+	states map[string]*State
+	issues map[issueKey]*Issue
+	claims map[uint32]time.Time
+}
+
+func newTestStorage(rules ...*Rule) *testStorage {
+	return &testStorage{
+		rules:  rules,
+		states: make(map[string]*State),
+		issues: make(map[issueKey]*Issue),
+		claims: make(map[uint32]time.Time),
+	}
+}
+
+func (s *testStorage) GetRules() ([]*Rule, error) {
+	return s.rules, nil
+}
+
+func (s *testStorage) GetStates(ruleID uint32) (map[string]*State, error) {
+	states := make(map[string]*State)
+	for fingerprint, state := range s.states {
+		states[fingerprint] = state
+	}
+	return states, nil
+}
+
+func (s *testStorage) SaveState(ruleID uint32, fingerprint string, state *State) error {
+	s.states[fingerprint] = state
+	return nil
+}
+
+func (s *testStorage) DeleteStates(before time.Time) error {
+	return nil
+}
+
+func (s *testStorage) SaveIssues(issues []*Issue) error {
+	for _, issue := range issues {
+		key := issueKey{issue.Partition, issue.ProjectID, issue.SessionID, groupKey{issue.Type, issue.URL, issue.ContextString}}
+		if existing, ok := s.issues[key]; ok {
+			existing.Events += issue.Events
+			continue
+		}
+		saved := *issue
+		s.issues[key] = &saved
+	}
+	return nil
+}
+
+func (s *testStorage) GetIssues(projectID uint32, from time.Time) ([]*Issue, error) {
+	issues := make([]*Issue, 0)
+	for _, issue := range s.issues {
+		if issue.ProjectID == projectID && issue.LastTs >= uint64(from.UnixMilli()) {
+			issues = append(issues, issue)
+		}
+	}
+	return issues, nil
+}
+
+func (s *testStorage) DeleteIssues(before time.Time) error {
+	return nil
+}
+
+func (s *testStorage) GetStartTime() (time.Time, error) {
+	return time.Now().Add(-time.Hour), nil
+}
+
+// ClaimRule rejects the claim if the rule is already claimed for the same or a later run
+func (s *testStorage) ClaimRule(ruleID uint32, until time.Time) (bool, error) {
+	if claimed, ok := s.claims[ruleID]; ok && !until.After(claimed) {
+		return false, nil
+	}
+	s.claims[ruleID] = until
+	return true, nil
+}
+
+func TestAggregatorItems(t *testing.T) {
+	now := time.Now()
+	a := NewAggregator(24*time.Hour, now.Add(-24*time.Hour))
+	ts := func(ago time.Duration) uint64 {
+		return uint64(now.Add(-ago).UnixMilli())
+	}
+	for sessionID := uint64(1); sessionID <= 23; sessionID++ {
+		a.Add(1, sessionID, ts(10*time.Minute), "dead_click", "https://shop.com/checkout?step=2", "Pay")
+		a.Add(1, sessionID, ts(5*time.Minute), "dead_click", "https://shop.com/checkout?step=3", "Pay")
+	}
+	a.Add(1, 100, ts(3*time.Hour), "dead_click", "https://shop.com/checkout", "Pay")
+	a.Add(1, 101, ts(30*time.Minute), "click_rage", "https://shop.com/", "Menu")
+	a.Add(2, 102, ts(time.Minute), "dead_click", "https://shop.com/checkout", "Pay")
+	a.Add(1, 103, ts(25*time.Hour), "dead_click", "https://shop.com/checkout", "Pay")
+
+	items := a.Items(1, []string{"dead_click"}, time.Hour, now)
+	if len(items) != 1 {
+		t.Fatalf("Expected 1 item, got %d", len(items))
+	}
+	item := items[0]
+	if item.URL != "/checkout" || item.Sessions != 23 || item.Events != 46 {
+		t.Errorf("Wrong item: %+v", item)
+	}
+	if expected := 1.0 / 23; item.Baseline != expected {
+		t.Errorf("Expected baseline %v, got %v", expected, item.Baseline)
+	}
+	digest := newDigest(&Rule{ID: 1, ProjectID: 1, Window: time.Hour}, items, now)
+	if title := digest.Title(); title != "23 sessions with dead clicks on /checkout in the last hour (Pay)" {
+		t.Errorf("Wrong title: %s", title)
+	}
+	if items := a.Items(1, nil, time.Hour, now); len(items) != 2 {
+		t.Errorf("Expected 2 items for all issue types, got %d", len(items))
+	}
+}
+
+func TestDigestDeduplication(t *testing.T) {
+	var received []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("Wrong body: %s", err)
+		}
+		received = append(received, body)
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{BaselinePeriod: 24 * time.Hour, DigestInterval: time.Minute, NotifierTimeout: time.Second}
+	rule := &Rule{
+		ID:        1,
+		ProjectID: 1,
+		Window:    time.Hour,
+		Threshold: 3,
+		Channel:   &Channel{ID: 1, Type: "slack", Endpoint: server.URL},
+	}
+	storage := newTestStorage(rule)
+	// Instances consume different partitions and share the storage
+	first := New(cfg, nil, storage, NewNotifiers(cfg)).(*digesterImpl)
+	second := New(cfg, nil, storage, NewNotifiers(cfg)).(*digesterImpl)
+
+	now := time.Now()
+	add := func(from, to uint64) {
+		for sessionID := from; sessionID < to; sessionID++ {
+			d := first
+			if sessionID%2 == 1 {
+				d = second
+			}
+			issue := newIssue(1, sessionID, uint64(now.UnixMilli()), "click_rage", "https://shop.com/cart", "")
+			issue.Partition = sessionID % 2
+			d.add(issue)
+		}
+		if err := first.Flush(); err != nil {
+			t.Fatalf("can't flush issues: %s", err)
+		}
+		if err := second.Flush(); err != nil {
+			t.Fatalf("can't flush issues: %s", err)
+		}
+	}
+	add(0, 2)
+	first.run(now)
+	if len(received) != 0 {
+		t.Fatalf("Expected no digests below threshold, got %d", len(received))
+	}
+	add(2, 4)
+	first.run(now.Add(time.Minute))
+	second.run(now.Add(time.Minute))
+	if len(received) != 1 {
+		t.Fatalf("Expected 1 digest, got %d", len(received))
+	}
+	if text := received[0]["text"]; text != "4 sessions with click rage on /cart in the last hour" {
+		t.Errorf("Wrong slack text: %v", text)
+	}
+	// Same group isn't reported until it grows twice
+	add(4, 6)
+	second.run(now.Add(2 * time.Minute))
+	if len(received) != 1 {
+		t.Fatalf("Expected duplicate digest to be skipped, got %d", len(received))
+	}
+	add(6, 8)
+	second.Run(now.Add(3 * time.Minute))
+	second.Stop()
+	if len(received) != 2 {
+		t.Fatalf("Expected digest for grown group, got %d", len(received))
+	}
+	if len(storage.states) != 1 {
+		t.Errorf("Expected 1 saved state, got %d", len(storage.states))
+	}
+	for _, state := range storage.states {
+		if state.LastCount != 8 {
+			t.Errorf("Expected last count 8, got %d", state.LastCount)
+		}
+	}
+}
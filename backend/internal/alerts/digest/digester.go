@@ -0,0 +1,250 @@
+package digest
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	config "openreplay/backend/internal/config/alerts"
+	"openreplay/backend/pkg/messages"
+	metrics "openreplay/backend/pkg/metrics/alerts"
+	"openreplay/backend/pkg/sessions"
+)
+
+// The group is reported again during the window only if the number of sessions has grown this much
+const DEDUP_GROWTH = 2
+
+type Digester interface {
+	Handle(msg messages.Message)
+	Flush() error
+	Run(now time.Time)
+	Stop()
+}
+
+type issueKey struct {
+	partition uint64
+	projectID uint32
+	sessionID uint64
+	group     groupKey
+}
+
+// digesterImpl keeps consumed issues in memory until Flush, then they are aggregated in the shared storage,
+// so all service instances see issues of all partitions. Each rule is checked by one instance per interval.
+type digesterImpl struct {
+	cfg       *config.Config
+	sessions  sessions.Sessions
+	storage   Storage
+	notifiers map[string]Notifier
+	mutex     sync.Mutex
+	buffer    map[issueKey]*Issue
+	running   sync.WaitGroup
+	busy      bool
+	lastClean time.Time
+}
+
+func New(cfg *config.Config, sessions sessions.Sessions, storage Storage, notifiers map[string]Notifier) Digester {
+	return &digesterImpl{
+		cfg:       cfg,
+		sessions:  sessions,
+		storage:   storage,
+		notifiers: notifiers,
+		buffer:    make(map[issueKey]*Issue),
+	}
+}
+
+func (d *digesterImpl) Handle(msg messages.Message) {
+	sessionID := msg.SessionID()
+	var issueType, url, contextString string
+	var timestamp uint64
+	switch m := msg.(type) {
+	case *messages.IssueEvent:
+		issueType, url, contextString, timestamp = m.Type, m.URL, m.ContextString, m.Timestamp
+	case *messages.IOSIssueEvent:
+		issueType, contextString, timestamp = m.Type, m.ContextString, m.Timestamp
+	default:
+		return
+	}
+	sess, err := d.sessions.Get(sessionID)
+	if err != nil {
+		log.Printf("can't get session %d: %s", sessionID, err)
+		return
+	}
+	issue := newIssue(sess.ProjectID, sessionID, timestamp, issueType, url, contextString)
+	if batch := msg.Meta().Batch(); batch != nil {
+		issue.Partition = batch.Partition()
+	}
+	d.add(issue)
+	metrics.IncreaseIssues(issueType)
+}
+
+func (d *digesterImpl) add(issue *Issue) {
+	key := issueKey{
+		partition: issue.Partition,
+		projectID: issue.ProjectID,
+		sessionID: issue.SessionID,
+		group:     groupKey{Type: issue.Type, URL: issue.URL, ContextString: issue.ContextString},
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if existing, ok := d.buffer[key]; ok {
+		existing.Events += issue.Events
+		if issue.LastTs > existing.LastTs {
+			existing.LastTs = issue.LastTs
+		}
+		return
+	}
+	d.buffer[key] = issue
+}
+
+// Flush saves consumed issues to the shared storage, it must succeed before the consumer commit
+func (d *digesterImpl) Flush() error {
+	d.mutex.Lock()
+	issues := make([]*Issue, 0, len(d.buffer))
+	for _, issue := range d.buffer {
+		issues = append(issues, issue)
+	}
+	d.buffer = make(map[issueKey]*Issue)
+	d.mutex.Unlock()
+	if len(issues) == 0 {
+		return nil
+	}
+	if err := d.storage.SaveIssues(issues); err != nil {
+		// Issues are kept for the next flush
+		for _, issue := range issues {
+			d.add(issue)
+		}
+		return err
+	}
+	return nil
+}
+
+// Run checks rules in the background, so slow channels don't block consuming.
+// The run is skipped if the previous one isn't finished yet.
+func (d *digesterImpl) Run(now time.Time) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.busy {
+		log.Printf("previous digest run isn't finished, skipping")
+		return
+	}
+	d.busy = true
+	d.running.Add(1)
+	go func() {
+		defer d.running.Done()
+		d.run(now)
+		d.mutex.Lock()
+		d.busy = false
+		d.mutex.Unlock()
+	}()
+}
+
+// Stop waits for the current run
+func (d *digesterImpl) Stop() {
+	d.running.Wait()
+}
+
+// run checks all rules and sends digests with new or growing groups of issues
+func (d *digesterImpl) run(now time.Time) {
+	rules, err := d.storage.GetRules()
+	if err != nil {
+		log.Printf("can't get digest rules: %s", err)
+		return
+	}
+	startedAt, err := d.storage.GetStartTime()
+	if err != nil {
+		log.Printf("can't get digest start time: %s", err)
+		return
+	}
+	aggregators := make(map[uint32]*Aggregator)
+	for _, rule := range rules {
+		if rule.Window <= 0 || rule.Window > d.cfg.BaselinePeriod {
+			log.Printf("digest %d: window %s is out of baseline period %s", rule.ID, rule.Window, d.cfg.BaselinePeriod)
+			continue
+		}
+		// Only one instance checks the rule during the interval
+		claimed, err := d.storage.ClaimRule(rule.ID, now.Add(d.cfg.DigestInterval/2))
+		if err != nil {
+			log.Printf("can't claim digest %d: %s", rule.ID, err)
+			continue
+		}
+		if !claimed {
+			continue
+		}
+		aggregator, ok := aggregators[rule.ProjectID]
+		if !ok {
+			issues, err := d.storage.GetIssues(rule.ProjectID, now.Add(-d.cfg.BaselinePeriod))
+			if err != nil {
+				log.Printf("can't get issues of project %d: %s", rule.ProjectID, err)
+				continue
+			}
+			aggregator = NewAggregator(d.cfg.BaselinePeriod, startedAt)
+			for _, issue := range issues {
+				aggregator.AddIssue(issue)
+			}
+			aggregators[rule.ProjectID] = aggregator
+		}
+		d.runRule(rule, aggregator, now)
+	}
+	if now.Sub(d.lastClean) > time.Hour {
+		before := now.Add(-d.cfg.BaselinePeriod)
+		if err := d.storage.DeleteIssues(before); err != nil {
+			log.Printf("can't delete old digest issues: %s", err)
+		}
+		if err := d.storage.DeleteStates(before); err != nil {
+			log.Printf("can't delete old digest states: %s", err)
+		}
+		d.lastClean = now
+	}
+}
+
+func (d *digesterImpl) runRule(rule *Rule, aggregator *Aggregator, now time.Time) {
+	// States are shared with other instances, so they are loaded on every run
+	states, err := d.storage.GetStates(rule.ID)
+	if err != nil {
+		log.Printf("can't get digest %d states: %s", rule.ID, err)
+		return
+	}
+	items := make([]*Item, 0)
+	for _, item := range aggregator.Items(rule.ProjectID, rule.IssueTypes, rule.Window, now) {
+		if shouldReport(rule, item, states[item.fingerprint()], now) {
+			items = append(items, item)
+		}
+	}
+	if len(items) == 0 {
+		return
+	}
+	notifier, ok := d.notifiers[rule.Channel.Type]
+	if !ok {
+		log.Printf("digest %d: unsupported channel type %s", rule.ID, rule.Channel.Type)
+		return
+	}
+	digest := newDigest(rule, items, now)
+	if err := notifier.Send(rule.Channel, digest); err != nil {
+		// State isn't updated, so the digest will be sent again on the next run
+		log.Printf("can't send digest %d to %s channel %d: %s", rule.ID, rule.Channel.Type, rule.Channel.ID, err)
+		metrics.IncreaseDigestErrors(rule.Channel.Type)
+		return
+	}
+	metrics.IncreaseSentDigests(rule.Channel.Type)
+	for _, item := range digest.Items {
+		state := &State{LastSentAt: now.UnixMilli(), LastCount: item.Sessions}
+		if err := d.storage.SaveState(rule.ID, item.fingerprint(), state); err != nil {
+			log.Printf("can't save digest %d state: %s", rule.ID, err)
+		}
+	}
+}
+
+func shouldReport(rule *Rule, item *Item, state *State, now time.Time) bool {
+	if item.Sessions < rule.Threshold {
+		return false
+	}
+	// Unknown baseline (not enough data after the start) doesn't block the digest
+	if rule.BaselineFactor > 0 && item.Baseline >= 0 && float64(item.Sessions) < rule.BaselineFactor*item.Baseline {
+		return false
+	}
+	if state != nil && now.UnixMilli()-state.LastSentAt < rule.Window.Milliseconds() &&
+		item.Sessions < DEDUP_GROWTH*state.LastCount {
+		return false
+	}
+	return true
+}
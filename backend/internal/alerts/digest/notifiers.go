@@ -0,0 +1,202 @@
+package digest
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	config "openreplay/backend/internal/config/alerts"
+)
+
+// Notifier delivers the digest to the channel of a particular type
+type Notifier interface {
+	Send(channel *Channel, digest *Digest) error
+}
+
+// NewNotifiers returns notifiers by channel type (type of webhook)
+func NewNotifiers(cfg *config.Config) map[string]Notifier {
+	client := &http.Client{Timeout: cfg.NotifierTimeout}
+	return map[string]Notifier{
+		"webhook": &webhookNotifier{client: client, body: webhookBody},
+		"slack":   &webhookNotifier{client: client, body: slackBody},
+		"msteams": &webhookNotifier{client: client, body: teamsBody},
+		"email":   &emailNotifier{cfg: cfg},
+	}
+}
+
+type webhookNotifier struct {
+	client *http.Client
+	body   func(digest *Digest) interface{}
+}
+
+func (w *webhookNotifier) Send(channel *Channel, digest *Digest) error {
+	body, err := json.Marshal(w.body(digest))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", channel.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if channel.AuthHeader != nil && *channel.AuthHeader != "" {
+		req.Header.Set("Authorization", *channel.AuthHeader)
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(ioutil.Discard, resp.Body) // Read the body to free socket
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with the code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func webhookBody(digest *Digest) interface{} {
+	return map[string]interface{}{
+		"type":   "issues_digest",
+		"title":  digest.Title(),
+		"text":   strings.Join(digest.Lines(), "\n"),
+		"digest": digest,
+	}
+}
+
+// slackBody is a message for Slack incoming webhook
+func slackBody(digest *Digest) interface{} {
+	lines := digest.Lines()
+	for i := range lines {
+		lines[i] = "• " + lines[i]
+	}
+	return map[string]interface{}{
+		"text": digest.Title(),
+		"blocks": []interface{}{
+			map[string]interface{}{
+				"type": "header",
+				"text": map[string]string{"type": "plain_text", "text": "Issues digest"},
+			},
+			map[string]interface{}{
+				"type": "section",
+				"text": map[string]string{"type": "mrkdwn", "text": strings.Join(lines, "\n")},
+			},
+		},
+	}
+}
+
+// teamsBody is a message card for Microsoft Teams incoming webhook
+func teamsBody(digest *Digest) interface{} {
+	return map[string]interface{}{
+		"@type":    "MessageCard",
+		"@context": "https://schema.org/extensions",
+		"summary":  digest.Title(),
+		"title":    "Issues digest",
+		"text":     "- " + strings.Join(digest.Lines(), "\n- "),
+	}
+}
+
+type emailNotifier struct {
+	cfg *config.Config
+}
+
+func (e *emailNotifier) Send(channel *Channel, digest *Digest) error {
+	if e.cfg.EmailHost == "" {
+		return fmt.Errorf("email host is not configured")
+	}
+	to := make([]string, 0)
+	for _, address := range strings.Split(channel.Endpoint, ",") {
+		if address = strings.TrimSpace(address); address != "" {
+			to = append(to, address)
+		}
+	}
+	if len(to) == 0 {
+		return fmt.Errorf("email channel %d has no recipients", channel.ID)
+	}
+	return e.send(to, e.message(to, digest))
+}
+
+func (e *emailNotifier) message(to []string, digest *Digest) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", e.cfg.EmailFrom)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&b, "Subject: [OpenReplay] %s\r\n", strings.NewReplacer("\r", "", "\n", " ").Replace(digest.Title()))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().UTC().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	for _, line := range digest.Lines() {
+		b.WriteString(line)
+		b.WriteString("\r\n")
+	}
+	return b.Bytes()
+}
+
+func (e *emailNotifier) send(to []string, msg []byte) error {
+	addr := net.JoinHostPort(e.cfg.EmailHost, strconv.Itoa(e.cfg.EmailPort))
+	tlsConfig := &tls.Config{ServerName: e.cfg.EmailHost}
+	var (
+		conn net.Conn
+		err  error
+	)
+	dialer := &net.Dialer{Timeout: e.cfg.NotifierTimeout}
+	if e.cfg.EmailUseTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(e.cfg.NotifierTimeout))
+	client, err := smtp.NewClient(conn, e.cfg.EmailHost)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+	if ok, _ := client.Extension("STARTTLS"); ok && !e.cfg.EmailUseTLS {
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+	if e.cfg.EmailUser != "" {
+		if err := client.Auth(smtp.PlainAuth("", e.cfg.EmailUser, e.cfg.EmailPassword, e.cfg.EmailHost)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(emailAddress(e.cfg.EmailFrom)); err != nil {
+		return err
+	}
+	for _, address := range to {
+		if err := client.Rcpt(address); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// emailAddress extracts address from "Name<address>" format
+func emailAddress(from string) string {
+	if start, end := strings.LastIndex(from, "<"), strings.LastIndex(from, ">"); start != -1 && end > start {
+		return from[start+1 : end]
+	}
+	return from
+}
@@ -0,0 +1,221 @@
+package digest
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+
+	"openreplay/backend/pkg/db/postgres/pool"
+)
+
+// Rule is a digest subscription from the issue_digests table
+type Rule struct {
+	ID             uint32
+	ProjectID      uint32
+	IssueTypes     []string // all issue types if empty
+	Window         time.Duration
+	Threshold      int     // minimal number of sessions in the window
+	BaselineFactor float64 // sessions/baseline ratio to report the group, disabled if 0
+	Channel        *Channel
+}
+
+// Channel is a row of the webhooks table
+type Channel struct {
+	ID         uint32
+	Type       string // webhook, slack, msteams or email
+	Name       *string
+	Endpoint   string // url or comma separated list of emails
+	AuthHeader *string
+}
+
+// State is the last notification about the group, it's used for deduplication
+type State struct {
+	LastSentAt int64 // unix milliseconds
+	LastCount  int
+}
+
+type Storage interface {
+	GetRules() ([]*Rule, error)
+	GetStates(ruleID uint32) (map[string]*State, error)
+	SaveState(ruleID uint32, fingerprint string, state *State) error
+	DeleteStates(before time.Time) error
+	SaveIssues(issues []*Issue) error
+	GetIssues(projectID uint32, from time.Time) ([]*Issue, error)
+	DeleteIssues(before time.Time) error
+	GetStartTime() (time.Time, error)
+	ClaimRule(ruleID uint32, until time.Time) (bool, error)
+}
+
+type storageImpl struct {
+	db        pool.Pool
+	ttl       time.Duration
+	mutex     sync.Mutex
+	rules     []*Rule
+	expiresAt time.Time
+}
+
+func NewStorage(db pool.Pool, ttl time.Duration) Storage {
+	return &storageImpl{
+		db:  db,
+		ttl: ttl,
+	}
+}
+
+func (s *storageImpl) GetRules() ([]*Rule, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.rules != nil && time.Now().Before(s.expiresAt) {
+		return s.rules, nil
+	}
+	rows, err := s.db.Query(`
+		SELECT d.digest_id, d.project_id, d.issue_types, d.window_minutes, d.threshold, d.baseline_factor,
+			w.webhook_id, w.type, w.name, w.endpoint, w.auth_header
+		FROM public.issue_digests AS d
+			INNER JOIN public.webhooks AS w USING (webhook_id)
+			INNER JOIN public.projects AS p USING (project_id)
+		WHERE d.deleted_at IS NULL AND w.deleted_at IS NULL AND p.deleted_at IS NULL`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	rules := make([]*Rule, 0)
+	for rows.Next() {
+		rule, channel := &Rule{}, &Channel{}
+		var windowMinutes int
+		var baselineFactor float32
+		if err := rows.Scan(&rule.ID, &rule.ProjectID, &rule.IssueTypes, &windowMinutes, &rule.Threshold, &baselineFactor,
+			&channel.ID, &channel.Type, &channel.Name, &channel.Endpoint, &channel.AuthHeader); err != nil {
+			return nil, err
+		}
+		rule.Window = time.Duration(windowMinutes) * time.Minute
+		rule.BaselineFactor = float64(baselineFactor)
+		rule.Channel = channel
+		rules = append(rules, rule)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	s.rules, s.expiresAt = rules, time.Now().Add(s.ttl)
+	return rules, nil
+}
+
+func (s *storageImpl) GetStates(ruleID uint32) (map[string]*State, error) {
+	rows, err := s.db.Query(`
+		SELECT fingerprint, last_sent_at, last_count
+		FROM public.issue_digests_state
+		WHERE digest_id = $1`,
+		ruleID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	states := make(map[string]*State)
+	for rows.Next() {
+		var fingerprint string
+		state := &State{}
+		if err := rows.Scan(&fingerprint, &state.LastSentAt, &state.LastCount); err != nil {
+			return nil, err
+		}
+		states[fingerprint] = state
+	}
+	return states, rows.Err()
+}
+
+func (s *storageImpl) SaveState(ruleID uint32, fingerprint string, state *State) error {
+	return s.db.Exec(`
+		INSERT INTO public.issue_digests_state (digest_id, fingerprint, last_sent_at, last_count)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (digest_id, fingerprint) DO UPDATE
+			SET last_sent_at = excluded.last_sent_at, last_count = excluded.last_count`,
+		ruleID, fingerprint, state.LastSentAt, state.LastCount,
+	)
+}
+
+func (s *storageImpl) DeleteStates(before time.Time) error {
+	return s.db.Exec(`DELETE FROM public.issue_digests_state WHERE last_sent_at < $1`, before.UnixMilli())
+}
+
+// SaveIssues adds issues to the shared aggregation, which is keyed by partition and session
+func (s *storageImpl) SaveIssues(issues []*Issue) error {
+	batch := &pgx.Batch{}
+	for _, issue := range issues {
+		batch.Queue(`
+			INSERT INTO public.issue_digests_sessions
+				(partition, project_id, session_id, issue_type, url, context_string, last_ts, events)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+			ON CONFLICT (partition, project_id, session_id, issue_type, url, context_string) DO UPDATE
+				SET last_ts = GREATEST(issue_digests_sessions.last_ts, excluded.last_ts),
+					events = issue_digests_sessions.events + excluded.events`,
+			issue.Partition, issue.ProjectID, issue.SessionID, issue.Type, issue.URL, issue.ContextString,
+			issue.LastTs, issue.Events,
+		)
+	}
+	br := s.db.SendBatch(batch)
+	for range issues {
+		if _, err := br.Exec(); err != nil {
+			br.Close()
+			return fmt.Errorf("can't save digest issues: %w", err)
+		}
+	}
+	return br.Close()
+}
+
+func (s *storageImpl) GetIssues(projectID uint32, from time.Time) ([]*Issue, error) {
+	rows, err := s.db.Query(`
+		SELECT partition, session_id, issue_type, url, context_string, last_ts, events
+		FROM public.issue_digests_sessions
+		WHERE project_id = $1 AND last_ts >= $2`,
+		projectID, from.UnixMilli(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	issues := make([]*Issue, 0)
+	for rows.Next() {
+		issue := &Issue{ProjectID: projectID}
+		if err := rows.Scan(&issue.Partition, &issue.SessionID, &issue.Type, &issue.URL, &issue.ContextString,
+			&issue.LastTs, &issue.Events); err != nil {
+			return nil, err
+		}
+		issues = append(issues, issue)
+	}
+	return issues, rows.Err()
+}
+
+func (s *storageImpl) DeleteIssues(before time.Time) error {
+	return s.db.Exec(`DELETE FROM public.issue_digests_sessions WHERE last_ts < $1`, before.UnixMilli())
+}
+
+// GetStartTime returns the time of the oldest aggregated issue, there is no data for the baseline before it
+func (s *storageImpl) GetStartTime() (time.Time, error) {
+	var oldest *int64
+	if err := s.db.QueryRow(`SELECT MIN(last_ts) FROM public.issue_digests_sessions`).Scan(&oldest); err != nil {
+		return time.Time{}, err
+	}
+	if oldest == nil {
+		return time.Now(), nil
+	}
+	return time.UnixMilli(*oldest), nil
+}
+
+// ClaimRule returns true if the rule wasn't checked by any instance during the interval, the next check is
+// possible after until
+func (s *storageImpl) ClaimRule(ruleID uint32, until time.Time) (bool, error) {
+	var id uint32
+	err := s.db.QueryRow(`
+		UPDATE public.issue_digests
+		SET next_run_at = $2
+		WHERE digest_id = $1 AND (next_run_at IS NULL OR next_run_at <= timezone('utc'::text, now()))
+		RETURNING digest_id`,
+		ruleID, until.UTC(),
+	).Scan(&id)
+	if err == pgx.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
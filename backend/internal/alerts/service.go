@@ -0,0 +1,81 @@
+package alerts
+
+import (
+	"log"
+	"time"
+
+	"openreplay/backend/internal/alerts/digest"
+	config "openreplay/backend/internal/config/alerts"
+	"openreplay/backend/internal/service"
+	"openreplay/backend/pkg/memory"
+	"openreplay/backend/pkg/queue/types"
+)
+
+type alertsImpl struct {
+	cfg      *config.Config
+	consumer types.Consumer
+	digester digest.Digester
+	mm       memory.Manager
+	done     chan struct{}
+	finished chan struct{}
+}
+
+// New runs the service. Consumed issues are saved to the shared storage before the commit,
+// digests are checked and sent in the background.
+func New(cfg *config.Config, consumer types.Consumer, digester digest.Digester, mm memory.Manager) service.Interface {
+	s := &alertsImpl{
+		cfg:      cfg,
+		consumer: consumer,
+		digester: digester,
+		mm:       mm,
+		done:     make(chan struct{}),
+		finished: make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+func (a *alertsImpl) run() {
+	digestTick := time.Tick(a.cfg.DigestInterval)
+	commitTick := time.Tick(10 * time.Second)
+	for {
+		select {
+		case now := <-digestTick:
+			a.digester.Run(now)
+		case <-commitTick:
+			a.commit()
+		case msg := <-a.consumer.Rebalanced():
+			log.Println(msg)
+		case <-a.done:
+			log.Println("stopping alerts service")
+			a.commit()
+			a.consumer.Close()
+			a.digester.Stop()
+			a.finished <- struct{}{}
+			return
+		default:
+			if !a.mm.HasFreeMemory() {
+				continue
+			}
+			if err := a.consumer.ConsumeNext(); err != nil {
+				log.Fatalf("Error on consuming: %v", err)
+			}
+		}
+	}
+}
+
+// commit doesn't commit offsets of issues which aren't saved, they are saved with the next commit
+func (a *alertsImpl) commit() {
+	if err := a.digester.Flush(); err != nil {
+		log.Printf("can't save issues: %s", err)
+		return
+	}
+	if err := a.consumer.Commit(); err != nil {
+		log.Printf("can't commit messages: %s", err)
+	}
+}
+
+func (a *alertsImpl) Stop() {
+	a.done <- struct{}{}
+	<-a.finished
+}
@@ -1,11 +1,8 @@
 package cacher
 
 import (
-	"crypto/tls"
-	"crypto/x509"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"log"
 	"mime"
 	"net/http"
@@ -16,6 +13,8 @@ import (
 	config "openreplay/backend/internal/config/assets"
 	metrics "openreplay/backend/pkg/metrics/assets"
 	"openreplay/backend/pkg/objectstorage"
+	"openreplay/backend/pkg/sessions"
+	"openreplay/backend/pkg/sourcemaps"
 	"openreplay/backend/pkg/url/assets"
 
 	"github.com/pkg/errors"
@@ -23,14 +22,19 @@ import (
 
 const MAX_CACHE_DEPTH = 5
 
+const jsTailSize = 1024
+
 type cacher struct {
 	timeoutMap     *timeoutMap                 // Concurrency implemented
 	objStorage     objectstorage.ObjectStorage // AWS Docs: "These clients are safe to use concurrently."
 	httpClient     *http.Client                // Docs: "Clients are safe for concurrent use by multiple goroutines."
 	rewriter       *assets.Rewriter            // Read only
 	Errors         chan error
-	sizeLimit      int
+	sizeLimits     *sizeLimits
+	compression    objectstorage.CompressionType
 	requestHeaders map[string]string
+	credentials    *credentials
+	sessions       sessions.Sessions // Optional, used to find project for project specific credentials
 	workers        *WorkerPool
 }
 
@@ -38,7 +42,7 @@ func (c *cacher) CanCache() bool {
 	return c.workers.CanAddTask()
 }
 
-func NewCacher(cfg *config.Config, store objectstorage.ObjectStorage) (*cacher, error) {
+func NewCacher(cfg *config.Config, store objectstorage.ObjectStorage, sessManager sessions.Sessions) (*cacher, error) {
 	switch {
 	case cfg == nil:
 		return nil, errors.New("config is nil")
@@ -48,48 +52,36 @@ func NewCacher(cfg *config.Config, store objectstorage.ObjectStorage) (*cacher,
 
 	rewriter := assets.NewRewriter(cfg.AssetsOrigin)
 
-	tlsConfig := &tls.Config{
-		InsecureSkipVerify: true,
+	limits, err := newSizeLimits(cfg.AssetsSizeLimit, cfg.AssetsSizeLimits)
+	if err != nil {
+		return nil, err
 	}
 
-	if cfg.ClientCertFilePath != "" && cfg.ClientKeyFilePath != "" && cfg.CaCertFilePath != "" {
-
-		var cert tls.Certificate
-		var err error
-
-		cert, err = tls.LoadX509KeyPair(cfg.ClientCertFilePath, cfg.ClientKeyFilePath)
-		if err != nil {
-			log.Fatalf("Error creating x509 keypair from the client cert file %s and client key file %s , Error: %s", err, cfg.ClientCertFilePath, cfg.ClientKeyFilePath)
-		}
-
-		caCert, err := ioutil.ReadFile(cfg.CaCertFilePath)
-		if err != nil {
-			log.Fatalf("Error opening cert file %s, Error: %s", cfg.CaCertFilePath, err)
-		}
-		caCertPool := x509.NewCertPool()
-		caCertPool.AppendCertsFromPEM(caCert)
-		tlsConfig = &tls.Config{
-			InsecureSkipVerify: true,
-			Certificates:       []tls.Certificate{cert},
-			RootCAs:            caCertPool,
-		}
+	creds, err := loadCredentials(cfg.AssetsCredentialsFile)
+	if err != nil {
+		return nil, err
+	}
 
+	transport, err := newTransport(cfg)
+	if err != nil {
+		return nil, err
 	}
 
 	c := &cacher{
 		timeoutMap: newTimeoutMap(),
 		objStorage: store,
 		httpClient: &http.Client{
-			Timeout: time.Duration(6) * time.Second,
-			Transport: &http.Transport{
-				Proxy:           http.ProxyFromEnvironment,
-				TLSClientConfig: tlsConfig,
-			},
+			Timeout:       time.Duration(6) * time.Second,
+			Transport:     transport,
+			CheckRedirect: creds.checkRedirect,
 		},
 		rewriter:       rewriter,
 		Errors:         make(chan error),
-		sizeLimit:      cfg.AssetsSizeLimit,
+		sizeLimits:     limits,
+		compression:    parseCompression(cfg.AssetsCompression),
 		requestHeaders: cfg.AssetsRequestHeaders,
+		credentials:    creds,
+		sessions:       sessManager,
 	}
 	c.workers = NewPool(64, c.CacheFile)
 	return c, nil
@@ -109,8 +101,14 @@ func (c *cacher) cacheURL(t *Task) {
 	for k, v := range c.requestHeaders {
 		req.Header.Set(k, v)
 	}
+	if !c.credentials.empty() {
+		req = c.credentials.apply(req, c.projectID(t.sessionID))
+	}
 	res, err := c.httpClient.Do(req)
 	if err != nil {
+		if isTLSError(err) {
+			reportTLSError(req.URL.Hostname(), err)
+		}
 		c.Errors <- errors.Wrap(err, t.urlContext)
 		return
 	}
@@ -128,16 +126,6 @@ func (c *cacher) cacheURL(t *Task) {
 		}
 		return
 	}
-	data, err := ioutil.ReadAll(io.LimitReader(res.Body, int64(c.sizeLimit+1)))
-	if err != nil {
-		c.Errors <- errors.Wrap(err, t.urlContext)
-		return
-	}
-	if len(data) > c.sizeLimit {
-		c.Errors <- errors.Wrap(errors.New("Maximum size exceeded"), t.urlContext)
-		return
-	}
-
 	contentType := res.Header.Get("Content-Type")
 	if contentType == "" {
 		contentType = mime.TypeByExtension(filepath.Ext(res.Request.URL.Path))
@@ -149,16 +137,45 @@ func (c *cacher) cacheURL(t *Task) {
 		return
 	}
 
+	// Don't start downloading if we already know that the file is too big
+	sizeLimit := c.sizeLimits.get(contentType)
+	if res.ContentLength > sizeLimit {
+		c.Errors <- errors.Wrap(errSizeLimit, t.urlContext)
+		return
+	}
+
+	compression := objectstorage.NoCompression
+	if isCompressible(contentType) {
+		compression = c.compression
+	}
+
 	isCSS := strings.HasPrefix(contentType, "text/css")
 
-	strData := string(data)
+	var (
+		body    io.Reader = newLimitedReader(res.Body, sizeLimit)
+		cssData string
+		jsTail  *tailReader
+	)
+	if t.isJS && isJavaScript(contentType) {
+		// Keep the end of js file to find the link to source map
+		jsTail = newTailReader(body, jsTailSize)
+		body = jsTail
+	}
 	if isCSS {
-		strData = c.rewriter.RewriteCSS(t.sessionID, t.requestURL, strData) // TODO: one method for rewrite and return list
+		// CSS file has to be fully loaded into memory for links rewriting
+		data, err := io.ReadAll(body)
+		if err != nil {
+			c.Errors <- errors.Wrap(err, t.urlContext)
+			return
+		}
+		cssData = string(data)
+		body = strings.NewReader(c.rewriter.RewriteCSS(t.sessionID, t.requestURL, cssData)) // TODO: one method for rewrite and return list
 	}
 
-	// TODO: implement in streams
 	start = time.Now()
-	err = c.objStorage.Upload(strings.NewReader(strData), t.cachePath, contentType, objectstorage.NoCompression)
+	reader := compressStream(body, compression)
+	err = c.objStorage.Upload(reader, t.cachePath, contentType, compression)
+	reader.Close()
 	if err != nil {
 		metrics.RecordUploadDuration(float64(time.Now().Sub(start).Milliseconds()), true)
 		c.Errors <- errors.Wrap(err, t.urlContext)
@@ -167,9 +184,13 @@ func (c *cacher) cacheURL(t *Task) {
 	metrics.RecordUploadDuration(float64(time.Now().Sub(start).Milliseconds()), false)
 	metrics.IncreaseSavedSessions()
 
+	if jsTail != nil {
+		c.cacheSourceMap(t.requestURL, res.Header, jsTail.tail)
+	}
+
 	if isCSS {
 		if t.depth > 0 {
-			for _, extractedURL := range assets.ExtractURLsFromCSS(string(data)) {
+			for _, extractedURL := range assets.ExtractURLsFromCSS(cssData) {
 				if fullURL, cachable := assets.GetFullCachableURL(t.requestURL, extractedURL); cachable {
 					c.checkTask(&Task{
 						requestURL: fullURL,
@@ -193,6 +214,36 @@ func (c *cacher) cacheURL(t *Task) {
 	return
 }
 
+// cacheSourceMap adds the js file's source map to the cache queue (used to resolve JSException stack traces)
+func (c *cacher) cacheSourceMap(jsURL string, header http.Header, tail []byte) {
+	mapURL := header.Get("SourceMap")
+	if mapURL == "" {
+		mapURL = header.Get("X-SourceMap")
+	}
+	if mapURL == "" {
+		mapURL = sourcemaps.ExtractURL(tail)
+	}
+	if mapURL == "" || strings.HasPrefix(mapURL, "data:") {
+		return
+	}
+	if fullURL, cachable := assets.GetFullCachableURL(jsURL, mapURL); cachable {
+		c.CacheJSFile(fullURL)
+	}
+}
+
+// projectID is used to find project specific credentials, 0 means global credentials only
+func (c *cacher) projectID(sessionID uint64) uint32 {
+	projectID := uint32(0)
+	if c.credentials.projectSpecific && c.sessions != nil && sessionID != 0 {
+		if sess, err := c.sessions.Get(sessionID); err == nil {
+			projectID = sess.ProjectID
+		} else {
+			log.Printf("can't get session %d for assets credentials: %s", sessionID, err)
+		}
+	}
+	return projectID
+}
+
 func (c *cacher) checkTask(newTask *Task) {
 	// check if file was recently uploaded
 	var cachePath string
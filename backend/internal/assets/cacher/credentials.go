@@ -0,0 +1,182 @@
+package cacher
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+type BasicAuth struct {
+	User     string `json:"user"`
+	Password string `json:"password"`
+}
+
+// OriginCredentials describes how to access a private origin (staging, internal apps, etc.)
+type OriginCredentials struct {
+	ProjectID   uint32            `json:"projectID"` // 0 means any project
+	Domain      string            `json:"domain"`    // exact host or wildcard like "*.staging.example.com"
+	Headers     map[string]string `json:"headers"`
+	Cookies     map[string]string `json:"cookies"`
+	BearerToken string            `json:"bearerToken"`
+	BasicAuth   *BasicAuth        `json:"basicAuth"`
+	Proxy       string            `json:"proxy"`
+	proxyURL    *url.URL
+}
+
+func (o *OriginCredentials) matchHost(host string) bool {
+	domain := strings.ToLower(o.Domain)
+	if strings.HasPrefix(domain, "*.") {
+		return strings.HasSuffix(host, domain[1:])
+	}
+	return host == domain
+}
+
+// priority is used to choose the most specific rule: project rules before global ones, exact hosts before wildcards
+func (o *OriginCredentials) priority() int {
+	p := 0
+	if o.ProjectID != 0 {
+		p += 2
+	}
+	if !strings.HasPrefix(o.Domain, "*.") {
+		p += 1
+	}
+	return p
+}
+
+// set adds credentials to the request headers, proxy is chosen by the transport
+func (o *OriginCredentials) set(req *http.Request) {
+	for k, v := range o.Headers {
+		req.Header.Set(k, v)
+	}
+	for name, value := range o.Cookies {
+		req.AddCookie(&http.Cookie{Name: name, Value: value})
+	}
+	switch {
+	case o.BearerToken != "":
+		req.Header.Set("Authorization", "Bearer "+o.BearerToken)
+	case o.BasicAuth != nil:
+		req.SetBasicAuth(o.BasicAuth.User, o.BasicAuth.Password)
+	}
+}
+
+// unset removes headers added by set, they aren't sent to the host of redirect
+func (o *OriginCredentials) unset(req *http.Request) {
+	for k := range o.Headers {
+		req.Header.Del(k)
+	}
+	if len(o.Cookies) > 0 {
+		req.Header.Del("Cookie")
+	}
+	if o.BearerToken != "" || o.BasicAuth != nil {
+		req.Header.Del("Authorization")
+	}
+}
+
+type credentials struct {
+	rules           []*OriginCredentials
+	projectSpecific bool
+}
+
+func loadCredentials(path string) (*credentials, error) {
+	creds := &credentials{}
+	if path == "" {
+		return creds, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("can't read assets credentials file: %s", err)
+	}
+	if err := json.Unmarshal(data, &creds.rules); err != nil {
+		return nil, fmt.Errorf("can't parse assets credentials file: %s", err)
+	}
+	for _, rule := range creds.rules {
+		if rule.Domain == "" {
+			return nil, fmt.Errorf("empty domain in assets credentials")
+		}
+		if rule.Proxy != "" {
+			if rule.proxyURL, err = url.Parse(rule.Proxy); err != nil {
+				return nil, fmt.Errorf("wrong proxy url for %s: %s", rule.Domain, err)
+			}
+		}
+		if rule.ProjectID != 0 {
+			creds.projectSpecific = true
+		}
+	}
+	return creds, nil
+}
+
+func (c *credentials) empty() bool {
+	return len(c.rules) == 0
+}
+
+func (c *credentials) find(projectID uint32, host string) *OriginCredentials {
+	host = strings.ToLower(host)
+	var found *OriginCredentials
+	for _, rule := range c.rules {
+		if rule.ProjectID != 0 && rule.ProjectID != projectID {
+			continue
+		}
+		if !rule.matchHost(host) {
+			continue
+		}
+		if found == nil || rule.priority() > found.priority() {
+			found = rule
+		}
+	}
+	return found
+}
+
+// appliedCredentials is kept in the request context, it follows the request through redirects
+type appliedCredentials struct {
+	projectID uint32
+	rule      *OriginCredentials // credentials of the current host, nil if there are no ones
+}
+
+type credentialsKey struct{}
+
+// apply sets credentials of the request host
+func (c *credentials) apply(req *http.Request, projectID uint32) *http.Request {
+	applied := &appliedCredentials{projectID: projectID, rule: c.find(projectID, req.URL.Hostname())}
+	if applied.rule != nil {
+		applied.rule.set(req)
+	}
+	return req.WithContext(context.WithValue(req.Context(), credentialsKey{}, applied))
+}
+
+// checkRedirect replaces credentials of the previous host with credentials of the new one.
+// Go keeps custom headers on redirects to other hosts, so they are removed here.
+func (c *credentials) checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+	applied, ok := req.Context().Value(credentialsKey{}).(*appliedCredentials)
+	if !ok {
+		return nil
+	}
+	rule := c.find(applied.projectID, req.URL.Hostname())
+	if rule == applied.rule {
+		return nil
+	}
+	if applied.rule != nil {
+		applied.rule.unset(req)
+	}
+	if rule != nil {
+		rule.set(req)
+	}
+	applied.rule = rule
+	return nil
+}
+
+// proxyFromRequest uses proxy of the host credentials, otherwise proxy from environment
+func proxyFromRequest(req *http.Request) (*url.URL, error) {
+	if applied, ok := req.Context().Value(credentialsKey{}).(*appliedCredentials); ok &&
+		applied.rule != nil && applied.rule.proxyURL != nil {
+		return applied.rule.proxyURL, nil
+	}
+	return http.ProxyFromEnvironment(req)
+}
@@ -0,0 +1,146 @@
+package cacher
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testCredentials = `[
+	{"domain": "*.staging.example.com", "headers": {"X-Env": "staging"}},
+	{"domain": "app.staging.example.com", "bearerToken": "global"},
+	{"domain": "app.staging.example.com", "projectID": 7, "basicAuth": {"user": "u", "password": "p"}},
+	{"domain": "*.internal.example.com", "projectID": 7, "cookies": {"session": "abc"}},
+	{"domain": "cdn.example.com", "proxy": "http://proxy:3128"}
+]`
+
+func loadTestCredentials(t *testing.T) *credentials {
+	path := filepath.Join(t.TempDir(), "credentials.json")
+	if err := os.WriteFile(path, []byte(testCredentials), 0644); err != nil {
+		t.Fatal(err)
+	}
+	creds, err := loadCredentials(path)
+	if err != nil {
+		t.Fatalf("can't load credentials: %s", err)
+	}
+	return creds
+}
+
+func TestCredentialsMatch(t *testing.T) {
+	creds := loadTestCredentials(t)
+	if !creds.projectSpecific {
+		t.Errorf("credentials must be project specific")
+	}
+	for _, tc := range []struct {
+		name      string
+		projectID uint32
+		host      string
+		expected  int // index of the rule, -1 for no match
+	}{
+		{"wildcard", 1, "img.staging.example.com", 0},
+		{"nested wildcard", 1, "a.b.staging.example.com", 0},
+		{"exact before wildcard", 1, "app.staging.example.com", 1},
+		{"host case", 1, "APP.Staging.Example.com", 1},
+		{"project before global", 7, "app.staging.example.com", 2},
+		{"project wildcard", 7, "api.internal.example.com", 3},
+		{"exact host", 3, "cdn.example.com", 4},
+		{"wildcard doesn't match the domain itself", 1, "staging.example.com", -1},
+		{"wildcard suffix only", 1, "evilstaging.example.com", -1},
+		{"other project", 1, "api.internal.example.com", -1},
+		{"exact host only", 1, "static.cdn.example.com", -1},
+		{"unknown host", 1, "example.org", -1},
+	} {
+		found := creds.find(tc.projectID, tc.host)
+		switch {
+		case tc.expected < 0 && found != nil:
+			t.Errorf("%s: expected no match, got %s", tc.name, found.Domain)
+		case tc.expected >= 0 && found != creds.rules[tc.expected]:
+			t.Errorf("%s: expected rule %d, got %+v", tc.name, tc.expected, found)
+		}
+	}
+}
+
+func TestCredentialsApply(t *testing.T) {
+	creds := loadTestCredentials(t)
+	for _, tc := range []struct {
+		name      string
+		projectID uint32
+		host      string
+		check     func(req *http.Request) bool
+	}{
+		{"headers", 1, "img.staging.example.com", func(req *http.Request) bool {
+			return req.Header.Get("X-Env") == "staging"
+		}},
+		{"bearer token", 1, "app.staging.example.com", func(req *http.Request) bool {
+			return req.Header.Get("Authorization") == "Bearer global"
+		}},
+		{"basic auth", 7, "app.staging.example.com", func(req *http.Request) bool {
+			user, password, ok := req.BasicAuth()
+			return ok && user == "u" && password == "p"
+		}},
+		{"cookies", 7, "api.internal.example.com", func(req *http.Request) bool {
+			cookie, err := req.Cookie("session")
+			return err == nil && cookie.Value == "abc"
+		}},
+		{"proxy", 1, "cdn.example.com", func(req *http.Request) bool {
+			proxy, err := proxyFromRequest(req)
+			return err == nil && proxy != nil && proxy.Host == "proxy:3128"
+		}},
+	} {
+		req, _ := http.NewRequest("GET", "https://"+tc.host+"/main.css", nil)
+		if creds.find(tc.projectID, tc.host) == nil {
+			t.Fatalf("%s: no credentials for %s", tc.name, tc.host)
+		}
+		if !tc.check(creds.apply(req, tc.projectID)) {
+			t.Errorf("%s: credentials aren't applied", tc.name)
+		}
+	}
+}
+
+func TestCredentialsRedirect(t *testing.T) {
+	creds := loadTestCredentials(t)
+	req, _ := http.NewRequest("GET", "https://img.staging.example.com/main.css", nil)
+	req = creds.apply(req, 1)
+	redirect := func(host string) *http.Request {
+		// Headers and context are copied to the redirect request in the same way as in http.Client
+		next, _ := http.NewRequestWithContext(req.Context(), "GET", "https://"+host+"/main.css", nil)
+		next.Header = req.Header.Clone()
+		if err := creds.checkRedirect(next, []*http.Request{req}); err != nil {
+			t.Fatalf("redirect to %s is stopped: %s", host, err)
+		}
+		return next
+	}
+
+	// Headers of the origin aren't sent to the cdn, but the proxy of the cdn is used
+	req = redirect("cdn.example.com")
+	if req.Header.Get("X-Env") != "" {
+		t.Errorf("headers of the previous host are sent after redirect")
+	}
+	if proxy, err := proxyFromRequest(req); err != nil || proxy == nil || proxy.Host != "proxy:3128" {
+		t.Errorf("proxy of the new host isn't used: %v", proxy)
+	}
+	// Proxy isn't used for the host without credentials
+	req = redirect("example.org")
+	if proxy, _ := proxyFromRequest(req); proxy != nil && proxy.Host == "proxy:3128" {
+		t.Errorf("proxy of the previous host is used after redirect")
+	}
+	// Credentials of the new host are applied
+	req = redirect("app.staging.example.com")
+	if req.Header.Get("Authorization") != "Bearer global" {
+		t.Errorf("credentials of the new host aren't applied")
+	}
+}
+
+func TestLoadWrongCredentials(t *testing.T) {
+	for _, data := range []string{`{"domain": "a.com"}`, `[{"headers": {"X": "1"}}]`, `[{"domain": "a.com", "proxy": "://"}]`} {
+		path := filepath.Join(t.TempDir(), "credentials.json")
+		os.WriteFile(path, []byte(data), 0644)
+		if _, err := loadCredentials(path); err == nil {
+			t.Errorf("expected error for %s", data)
+		}
+	}
+	if creds, err := loadCredentials(""); err != nil || !creds.empty() {
+		t.Errorf("credentials file is optional")
+	}
+}
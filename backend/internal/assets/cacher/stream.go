@@ -0,0 +1,156 @@
+package cacher
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/pkg/errors"
+
+	"openreplay/backend/pkg/objectstorage"
+)
+
+var errSizeLimit = errors.New("Maximum size exceeded")
+
+// sizeLimits keeps max asset size for each content-type prefix (longest prefix wins)
+type sizeLimits struct {
+	defaultLimit int64
+	prefixes     []string
+	limits       map[string]int64
+}
+
+func newSizeLimits(defaultLimit int, custom map[string]string) (*sizeLimits, error) {
+	l := &sizeLimits{
+		defaultLimit: int64(defaultLimit),
+		limits:       make(map[string]int64, len(custom)),
+	}
+	for prefix, value := range custom {
+		limit, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || limit <= 0 {
+			return nil, fmt.Errorf("wrong size limit for %s: %s", prefix, value)
+		}
+		prefix = strings.ToLower(strings.TrimSpace(prefix))
+		l.prefixes = append(l.prefixes, prefix)
+		l.limits[prefix] = limit
+	}
+	sort.Slice(l.prefixes, func(i, j int) bool {
+		return len(l.prefixes[i]) > len(l.prefixes[j])
+	})
+	return l, nil
+}
+
+func (l *sizeLimits) get(contentType string) int64 {
+	contentType = strings.ToLower(contentType)
+	for _, prefix := range l.prefixes {
+		if strings.HasPrefix(contentType, prefix) {
+			return l.limits[prefix]
+		}
+	}
+	return l.defaultLimit
+}
+
+// limitedReader returns errSizeLimit instead of silent EOF when the reader has more than limit bytes
+type limitedReader struct {
+	r    io.Reader
+	left int64
+}
+
+func newLimitedReader(r io.Reader, limit int64) *limitedReader {
+	return &limitedReader{r: r, left: limit}
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.left < 0 {
+		return 0, errSizeLimit
+	}
+	if int64(len(p)) > l.left+1 {
+		p = p[:l.left+1]
+	}
+	n, err := l.r.Read(p)
+	l.left -= int64(n)
+	if l.left < 0 {
+		return 0, errSizeLimit
+	}
+	return n, err
+}
+
+// tailReader keeps the last bytes of the stream (used to find sourceMappingURL in js files)
+type tailReader struct {
+	r    io.Reader
+	tail []byte
+	size int
+}
+
+func newTailReader(r io.Reader, size int) *tailReader {
+	return &tailReader{r: r, size: size}
+}
+
+func (t *tailReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	t.tail = append(t.tail, p[:n]...)
+	if len(t.tail) > t.size {
+		t.tail = append(t.tail[:0], t.tail[len(t.tail)-t.size:]...)
+	}
+	return n, err
+}
+
+func parseCompression(algo string) objectstorage.CompressionType {
+	switch algo {
+	case "none":
+		return objectstorage.NoCompression
+	case "gzip":
+		return objectstorage.Gzip
+	case "brotli":
+		return objectstorage.Brotli
+	default:
+		// zstd is not supported by browsers as a content-encoding
+		log.Printf("unsupported assets compression algorithm: %s", algo)
+		return objectstorage.NoCompression
+	}
+}
+
+func isJavaScript(contentType string) bool {
+	contentType = strings.ToLower(contentType)
+	return strings.HasPrefix(contentType, "text/javascript") ||
+		strings.HasPrefix(contentType, "application/javascript") ||
+		strings.HasPrefix(contentType, "application/x-javascript")
+}
+
+// isCompressible returns true for text assets which are worth to compress before uploading
+func isCompressible(contentType string) bool {
+	contentType = strings.ToLower(contentType)
+	switch {
+	case strings.HasPrefix(contentType, "text/css"),
+		strings.HasPrefix(contentType, "image/svg+xml"):
+		return true
+	}
+	return isJavaScript(contentType)
+}
+
+// compressStream compresses data on the fly, the caller must close the returned reader
+func compressStream(r io.Reader, compression objectstorage.CompressionType) io.ReadCloser {
+	if compression == objectstorage.NoCompression {
+		return io.NopCloser(r)
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		var w io.WriteCloser
+		switch compression {
+		case objectstorage.Brotli:
+			w = brotli.NewWriterLevel(pw, brotli.DefaultCompression)
+		default:
+			w, _ = gzip.NewWriterLevel(pw, gzip.BestCompression)
+		}
+		_, err := io.Copy(w, r)
+		if closeErr := w.Close(); err == nil {
+			err = closeErr
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr
+}
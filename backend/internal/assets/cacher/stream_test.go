@@ -0,0 +1,75 @@
+package cacher
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+
+	"openreplay/backend/pkg/objectstorage"
+)
+
+func TestLimitedReader(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		data  string
+		limit int64
+		err   error
+	}{
+		{"smaller", "body{}", 10, nil},
+		{"equal", "body{}", 6, nil},
+		{"bigger", "body{color:red}", 6, errSizeLimit},
+		{"empty", "", 0, nil},
+	} {
+		data, err := io.ReadAll(newLimitedReader(strings.NewReader(tc.data), tc.limit))
+		if !errors.Is(err, tc.err) {
+			t.Errorf("%s: expected error %v, got %v", tc.name, tc.err, err)
+		}
+		if tc.err == nil && string(data) != tc.data {
+			t.Errorf("%s: wrong data: %q", tc.name, data)
+		}
+		if tc.err != nil && int64(len(data)) > tc.limit {
+			t.Errorf("%s: read %d bytes over the limit %d", tc.name, len(data), tc.limit)
+		}
+	}
+}
+
+func TestCompressStream(t *testing.T) {
+	data := strings.Repeat("body { background: url(/img/bg.png); }\n", 1000)
+	for _, tc := range []struct {
+		compression objectstorage.CompressionType
+		decompress  func(io.Reader) (io.Reader, error)
+	}{
+		{objectstorage.NoCompression, func(r io.Reader) (io.Reader, error) { return r, nil }},
+		{objectstorage.Gzip, func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) }},
+		{objectstorage.Brotli, func(r io.Reader) (io.Reader, error) { return brotli.NewReader(r), nil }},
+	} {
+		reader := compressStream(strings.NewReader(data), tc.compression)
+		compressed, err := io.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			t.Fatalf("can't compress with %d: %s", tc.compression, err)
+		}
+		if tc.compression != objectstorage.NoCompression && len(compressed) >= len(data) {
+			t.Errorf("data isn't compressed with %d: %d bytes", tc.compression, len(compressed))
+		}
+		decompressed, err := tc.decompress(bytes.NewReader(compressed))
+		if err != nil {
+			t.Fatalf("can't decompress %d: %s", tc.compression, err)
+		}
+		if res, err := io.ReadAll(decompressed); err != nil || string(res) != data {
+			t.Errorf("wrong decompressed data with %d: %v", tc.compression, err)
+		}
+	}
+
+	// Errors of the source reader are passed to the uploader
+	reader := compressStream(newLimitedReader(strings.NewReader(data), 100), objectstorage.Gzip)
+	defer reader.Close()
+	if _, err := io.ReadAll(reader); !errors.Is(err, errSizeLimit) {
+		t.Errorf("expected size limit error, got %v", err)
+	}
+}
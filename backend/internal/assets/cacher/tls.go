@@ -0,0 +1,137 @@
+package cacher
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	config "openreplay/backend/internal/config/assets"
+	metrics "openreplay/backend/pkg/metrics/assets"
+)
+
+// newTLSConfig returns tls config with enabled certificates verification (system CA pool + optional custom CA)
+func newTLSConfig(cfg *config.Config) (*tls.Config, error) {
+	roots, err := x509.SystemCertPool()
+	if err != nil {
+		log.Printf("can't load system cert pool: %s", err)
+		roots = x509.NewCertPool()
+	}
+	if cfg.CaCertFilePath != "" {
+		caCert, err := os.ReadFile(cfg.CaCertFilePath)
+		if err != nil {
+			return nil, fmt.Errorf("can't open cert file %s: %s", cfg.CaCertFilePath, err)
+		}
+		if !roots.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.CaCertFilePath)
+		}
+	}
+	tlsConfig := &tls.Config{
+		RootCAs: roots,
+	}
+	if cfg.ClientCertFilePath != "" && cfg.ClientKeyFilePath != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.ClientCertFilePath, cfg.ClientKeyFilePath)
+		if err != nil {
+			return nil, fmt.Errorf("can't create x509 keypair from the client cert file %s and client key file %s: %s",
+				cfg.ClientCertFilePath, cfg.ClientKeyFilePath, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// newTransport returns transport with tls verification for all domains except domains from the insecure list
+func newTransport(cfg *config.Config) (http.RoundTripper, error) {
+	tlsConfig, err := newTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	secure := &http.Transport{
+		Proxy:           proxyFromRequest,
+		TLSClientConfig: tlsConfig,
+	}
+	if len(cfg.AssetsInsecureDomains) == 0 {
+		return secure, nil
+	}
+	insecureConfig := tlsConfig.Clone()
+	insecureConfig.InsecureSkipVerify = true
+	return &tlsRouter{
+		secure: secure,
+		insecure: &http.Transport{
+			Proxy:           proxyFromRequest,
+			TLSClientConfig: insecureConfig,
+		},
+		insecureDomains: cfg.AssetsInsecureDomains,
+	}, nil
+}
+
+// tlsRouter chooses transport for each request (including redirects) by request's host
+type tlsRouter struct {
+	secure          http.RoundTripper
+	insecure        http.RoundTripper
+	insecureDomains []string
+}
+
+func (r *tlsRouter) RoundTrip(req *http.Request) (*http.Response, error) {
+	if r.isInsecure(req.URL.Hostname()) {
+		return r.insecure.RoundTrip(req)
+	}
+	return r.secure.RoundTrip(req)
+}
+
+func (r *tlsRouter) isInsecure(host string) bool {
+	host = strings.ToLower(host)
+	for _, domain := range r.insecureDomains {
+		domain = strings.ToLower(strings.TrimSpace(domain))
+		switch {
+		case domain == "*", domain == host:
+			return true
+		case strings.HasPrefix(domain, "*.") && strings.HasSuffix(host, domain[1:]):
+			return true
+		}
+	}
+	return false
+}
+
+// isTLSError returns true if the request failed because of the server's certificate
+func isTLSError(err error) bool {
+	var (
+		verificationErr *tls.CertificateVerificationError
+		unknownAuthErr  x509.UnknownAuthorityError
+		hostnameErr     x509.HostnameError
+		invalidErr      x509.CertificateInvalidError
+	)
+	return errors.As(err, &verificationErr) || errors.As(err, &unknownAuthErr) ||
+		errors.As(err, &hostnameErr) || errors.As(err, &invalidErr)
+}
+
+// tlsErrorReason returns the kind of tls error, hosts aren't used as metric labels because there are too many of them
+func tlsErrorReason(err error) string {
+	var (
+		unknownAuthErr x509.UnknownAuthorityError
+		hostnameErr    x509.HostnameError
+		invalidErr     x509.CertificateInvalidError
+	)
+	switch {
+	case errors.As(err, &unknownAuthErr):
+		return "unknown_authority"
+	case errors.As(err, &hostnameErr):
+		return "hostname"
+	case errors.As(err, &invalidErr):
+		if invalidErr.Reason == x509.Expired {
+			return "expired"
+		}
+		return "invalid"
+	default:
+		return "other"
+	}
+}
+
+func reportTLSError(host string, err error) {
+	log.Printf("tls verification failed for %s: %s", host, err)
+	metrics.IncreaseTLSErrors(tlsErrorReason(err))
+}
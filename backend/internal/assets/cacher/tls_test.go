@@ -0,0 +1,63 @@
+package cacher
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	config "openreplay/backend/internal/config/assets"
+)
+
+func TestTLSVerification(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("body{}"))
+	}))
+	defer server.Close()
+	serverURL, _ := url.Parse(server.URL)
+
+	// Self-signed certificate must be rejected by default
+	transport, err := newTransport(&config.Config{})
+	if err != nil {
+		t.Fatalf("can't create transport: %s", err)
+	}
+	client := &http.Client{Transport: transport}
+	_, err = client.Get(server.URL)
+	if err == nil {
+		t.Fatalf("Expected tls error, but request succeeded")
+	}
+	if !isTLSError(err) {
+		t.Errorf("Expected tls error, but got %s", err)
+	}
+	if reason := tlsErrorReason(err); reason != "unknown_authority" {
+		t.Errorf("Expected unknown authority error, but got %s", reason)
+	}
+
+	// Host from insecure list is allowed without verification
+	transport, err = newTransport(&config.Config{AssetsInsecureDomains: []string{serverURL.Hostname()}})
+	if err != nil {
+		t.Fatalf("can't create transport: %s", err)
+	}
+	client = &http.Client{Transport: transport}
+	res, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Expected successful request, but got %s", err)
+	}
+	res.Body.Close()
+}
+
+func TestInsecureDomains(t *testing.T) {
+	r := &tlsRouter{insecureDomains: []string{"staging.example.com", "*.internal.example.com"}}
+	cases := map[string]bool{
+		"staging.example.com":      true,
+		"cdn.internal.example.com": true,
+		"example.com":              false,
+		"internal.example.com":     false,
+		"evil-staging.example.com": false,
+	}
+	for host, expected := range cases {
+		if got := r.isInsecure(host); got != expected {
+			t.Errorf("Expected %v for %s, but got %v", expected, host, got)
+		}
+	}
+}
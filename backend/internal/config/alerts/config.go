@@ -0,0 +1,38 @@
+package alerts
+
+import (
+	"time"
+
+	"openreplay/backend/internal/config/common"
+	"openreplay/backend/internal/config/configurator"
+	"openreplay/backend/internal/config/redis"
+	"openreplay/backend/pkg/pprof"
+)
+
+type Config struct {
+	common.Config
+	common.Postgres
+	redis.Redis
+	GroupAlerts     string        `env:"GROUP_ALERTS,default=alerts"`
+	TopicAnalytics  string        `env:"TOPIC_ANALYTICS,required"`
+	DigestInterval  time.Duration `env:"DIGEST_INTERVAL,default=5m"`
+	BaselinePeriod  time.Duration `env:"BASELINE_PERIOD,default=24h"` // also the longest digest window
+	RulesTTL        time.Duration `env:"DIGEST_RULES_TTL,default=1m"`
+	NotifierTimeout time.Duration `env:"NOTIFIER_TIMEOUT,default=10s"`
+	EmailHost       string        `env:"EMAIL_HOST"`
+	EmailPort       int           `env:"EMAIL_PORT,default=587"`
+	EmailUser       string        `env:"EMAIL_USER"`
+	EmailPassword   string        `env:"EMAIL_PASSWORD"`
+	EmailFrom       string        `env:"EMAIL_FROM,default=OpenReplay<do-not-reply@openreplay.com>"`
+	EmailUseTLS     bool          `env:"EMAIL_USE_TLS,default=false"` // implicit TLS (port 465), STARTTLS is used automatically if supported
+	UseProfiler     bool          `env:"PROFILER_ENABLED,default=false"`
+}
+
+func New() *Config {
+	cfg := &Config{}
+	configurator.Process(cfg)
+	if cfg.UseProfiler {
+		pprof.StartProfilingServer()
+	}
+	return cfg
+}
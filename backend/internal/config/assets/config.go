@@ -9,15 +9,20 @@ import (
 type Config struct {
 	common.Config
 	objectstorage.ObjectsConfig
-	GroupCache           string            `env:"GROUP_CACHE,required"`
-	TopicCache           string            `env:"TOPIC_CACHE,required"`
-	AssetsOrigin         string            `env:"ASSETS_ORIGIN,required"`
-	AssetsSizeLimit      int               `env:"ASSETS_SIZE_LIMIT,required"`
-	AssetsRequestHeaders map[string]string `env:"ASSETS_REQUEST_HEADERS"`
-	UseProfiler          bool              `env:"PROFILER_ENABLED,default=false"`
-	ClientKeyFilePath    string            `env:"CLIENT_KEY_FILE_PATH"`
-	CaCertFilePath       string            `env:"CA_CERT_FILE_PATH"`
-	ClientCertFilePath   string            `env:"CLIENT_CERT_FILE_PATH"`
+	GroupCache            string            `env:"GROUP_CACHE,required"`
+	TopicCache            string            `env:"TOPIC_CACHE,required"`
+	AssetsOrigin          string            `env:"ASSETS_ORIGIN,required"`
+	AssetsSizeLimit       int               `env:"ASSETS_SIZE_LIMIT,required"`
+	AssetsSizeLimits      map[string]string `env:"ASSETS_SIZE_LIMITS"`              // content-type prefix -> max size in bytes
+	AssetsCompression     string            `env:"ASSETS_COMPRESSION,default=none"` // none, gzip, brotli
+	AssetsRequestHeaders  map[string]string `env:"ASSETS_REQUEST_HEADERS"`
+	AssetsCredentialsFile string            `env:"ASSETS_CREDENTIALS_FILE"` // json list of per-domain (and per-project) credentials
+	Postgres              string            `env:"POSTGRES_STRING"`         // optional, required only for project specific credentials
+	UseProfiler           bool              `env:"PROFILER_ENABLED,default=false"`
+	AssetsInsecureDomains []string          `env:"ASSETS_INSECURE_DOMAINS"` // domains without tls verification, "*" for all
+	ClientKeyFilePath     string            `env:"CLIENT_KEY_FILE_PATH"`
+	CaCertFilePath        string            `env:"CA_CERT_FILE_PATH"`
+	ClientCertFilePath    string            `env:"CLIENT_CERT_FILE_PATH"`
 }
 
 func New() *Config {
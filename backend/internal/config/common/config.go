@@ -1,6 +1,9 @@
 package common
 
-import "strings"
+import (
+	"strings"
+	"time"
+)
 
 // Common config for all services
 
@@ -53,8 +56,66 @@ type Redshift struct {
 // Clickhouse config
 
 type Clickhouse struct {
-	URL      string `env:"CLICKHOUSE_STRING"`
-	Database string `env:"CLICKHOUSE_DATABASE,default=default"`
-	UserName string `env:"CLICKHOUSE_USERNAME,default=default"`
-	Password string `env:"CLICKHOUSE_PASSWORD,default="`
+	URL              string        `env:"CLICKHOUSE_STRING"` // comma-separated addresses of cluster nodes
+	Database         string        `env:"CLICKHOUSE_DATABASE"`
+	UserName         string        `env:"CLICKHOUSE_USERNAME"`
+	Password         string        `env:"CLICKHOUSE_PASSWORD"`
+	LegacyUserName   string        `env:"CH_USERNAME"`
+	LegacyPassword   string        `env:"CH_PASSWORD"`
+	ConnOpenStrategy string        `env:"CLICKHOUSE_CONN_OPEN_STRATEGY,default=in_order"` // in_order (failover) or round_robin
+	DialTimeout      time.Duration `env:"CLICKHOUSE_DIAL_TIMEOUT,default=5s"`
+	UseTLS           bool          `env:"CLICKHOUSE_TLS,default=false"`
+	CaCertFilePath   string        `env:"CLICKHOUSE_CA_CERT_FILE_PATH"`
+	ClientCertPath   string        `env:"CLICKHOUSE_CLIENT_CERT_FILE_PATH"`
+	ClientKeyPath    string        `env:"CLICKHOUSE_CLIENT_KEY_FILE_PATH"`
+	TLSSkipVerify    bool          `env:"CLICKHOUSE_TLS_SKIP_VERIFY,default=false"`
+	Retries          int           `env:"CLICKHOUSE_RETRIES,default=3"`
+	RetryBackoff     time.Duration `env:"CLICKHOUSE_RETRY_BACKOFF,default=1s"`
+	RetryMaxBackoff  time.Duration `env:"CLICKHOUSE_RETRY_MAX_BACKOFF,default=30s"`
+	SpillDir         string        `env:"CLICKHOUSE_SPILL_DIR"` // empty value disables spilling of failed batches
+	SpillSizeLimit   int64         `env:"CLICKHOUSE_SPILL_SIZE_LIMIT,default=1073741824"`
+}
+
+// Addresses returns host:port of every node from the connection string (tcp://host:port/database,...)
+func (cfg *Clickhouse) Addresses() []string {
+	addrs := make([]string, 0, 1)
+	for _, addr := range strings.Split(cfg.URL, ",") {
+		addr = strings.TrimPrefix(strings.TrimSpace(addr), "tcp://")
+		if i := strings.Index(addr, "/"); i >= 0 {
+			addr = addr[:i]
+		}
+		if addr != "" {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+// DatabaseName returns CLICKHOUSE_DATABASE, the database from the connection string or "default"
+func (cfg *Clickhouse) DatabaseName() string {
+	if cfg.Database != "" {
+		return cfg.Database
+	}
+	for _, addr := range strings.Split(cfg.URL, ",") {
+		addr = strings.TrimPrefix(strings.TrimSpace(addr), "tcp://")
+		if i := strings.Index(addr, "/"); i >= 0 && addr[i+1:] != "" {
+			return addr[i+1:]
+		}
+	}
+	return "default"
+}
+
+// Credentials returns username and password, CH_USERNAME and CH_PASSWORD are used by older deployments
+func (cfg *Clickhouse) Credentials() (string, string) {
+	user, password := cfg.UserName, cfg.Password
+	if user == "" {
+		user = cfg.LegacyUserName
+	}
+	if user == "" {
+		user = "default"
+	}
+	if password == "" {
+		password = cfg.LegacyPassword
+	}
+	return user, password
 }
@@ -103,6 +103,14 @@ func parseFile(a interface{}, path string) {
 					continue
 				}
 				val.Field(i).Set(reflect.ValueOf(stringMap))
+			case "[]string":
+				var list []string
+				for _, item := range strings.Split(value, ",") {
+					if item = strings.TrimSpace(item); item != "" {
+						list = append(list, item)
+					}
+				}
+				val.Field(i).Set(reflect.ValueOf(list))
 			default:
 				log.Println("unknown config type: ", val.Type().Field(i).Type.String())
 			}
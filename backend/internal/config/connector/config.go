@@ -15,15 +15,44 @@ type Config struct {
 	common.Redshift
 	common.Clickhouse
 	objectstorage.ObjectsConfig
+	Files
+	Snowflake
 	ConnectorType      string        `env:"CONNECTOR_TYPE,default=redshift"`
 	SessionsTableName  string        `env:"SESSIONS_TABLE_NAME,default=connector_user_sessions"`
 	EventsTableName    string        `env:"EVENTS_TABLE_NAME,default=connector_events"`
 	EventLevel         string        `env:"EVENT_LEVEL,default=normal"`
+	SessionColumns     []string      `env:"CONNECTOR_SESSION_COLUMNS"`
+	EventColumns       []string      `env:"CONNECTOR_EVENT_COLUMNS"`
+	ExcludeColumns     []string      `env:"CONNECTOR_EXCLUDE_COLUMNS"`
 	GroupConnector     string        `env:"GROUP_CONNECTOR,default=connector"`
 	TopicRawWeb        string        `env:"TOPIC_RAW_WEB,required"`
 	TopicAnalytics     string        `env:"TOPIC_ANALYTICS,required"`
 	CommitBatchTimeout time.Duration `env:"COMMIT_BATCH_TIMEOUT,default=5s"`
 	UseProfiler        bool          `env:"PROFILER_ENABLED,default=false"`
+	ConnectorPostgres  string        `env:"CONNECTOR_POSTGRES_STRING"`
+	BufferType         string        `env:"CONNECTOR_BUFFER,default=redis"` // redis or file, keeps in-flight sessions
+	BufferDir          string        `env:"CONNECTOR_BUFFER_DIR,default=/mnt/efs/connector"`
+}
+
+// Files config (parquet and jsonl connectors)
+
+type Files struct {
+	FilesPrefix        string `env:"CONNECTOR_FILES_PREFIX,default=connector_data"`
+	FilesDir           string `env:"CONNECTOR_FILES_DIR"` // local directory instead of object storage
+	ParquetCompression string `env:"PARQUET_COMPRESSION,default=snappy"`
+}
+
+// Snowflake config
+
+type Snowflake struct {
+	Account        string `env:"SNOWFLAKE_ACCOUNT"`
+	User           string `env:"SNOWFLAKE_USER"`
+	PrivateKeyFile string `env:"SNOWFLAKE_PRIVATE_KEY_FILE"`
+	Database       string `env:"SNOWFLAKE_DATABASE"`
+	Schema         string `env:"SNOWFLAKE_SCHEMA"`
+	Warehouse      string `env:"SNOWFLAKE_WAREHOUSE"`
+	Role           string `env:"SNOWFLAKE_ROLE"`
+	Stage          string `env:"SNOWFLAKE_STAGE"`
 }
 
 func New() *Config {
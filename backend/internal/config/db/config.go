@@ -10,6 +10,7 @@ import (
 type Config struct {
 	common.Config
 	common.Postgres
+	common.Clickhouse
 	redis.Redis
 	ProjectExpiration  time.Duration `env:"PROJECT_EXPIRATION,default=10m"`
 	LoggerTimeout      int           `env:"LOG_QUEUE_STATS_INTERVAL_SEC,required"`
@@ -23,6 +24,10 @@ type Config struct {
 	UseQuickwit        bool          `env:"QUICKWIT_ENABLED,default=false"`
 	QuickwitTopic      string        `env:"QUICKWIT_TOPIC,default=saas-quickwit"`
 	UseProfiler        bool          `env:"PROFILER_ENABLED,default=false"`
+	UseSourcemaps      bool          `env:"SOURCEMAPS_ENABLED,default=false"`
+	SourcemapsBucket   string        `env:"SOURCEMAPS_BUCKET,default=sourcemaps"`
+	JSCacheBucket      string        `env:"JS_CACHE_BUCKET,default=sessions-assets"`
+	SourcemapsWait     time.Duration `env:"SOURCEMAPS_WAIT_TIMEOUT,default=100ms"` // max delay of an error for loading its source map
 }
 
 func New() *Config {
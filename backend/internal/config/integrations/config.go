@@ -1,6 +1,8 @@
 package integrations
 
 import (
+	"time"
+
 	"openreplay/backend/internal/config/common"
 	"openreplay/backend/internal/config/configurator"
 )
@@ -11,6 +13,18 @@ type Config struct {
 	TopicAnalytics string `env:"TOPIC_ANALYTICS,required"`
 	TokenSecret    string `env:"TOKEN_SECRET,required"`
 	UseProfiler    bool   `env:"PROFILER_ENABLED,default=false"`
+	// Webhook receiver is disabled if the port is empty, the same server handles OTLP requests
+	WebhooksHost      string            `env:"WEBHOOKS_HOST,default="`
+	WebhooksPort      string            `env:"WEBHOOKS_PORT,default="`
+	WebhooksTimeout   time.Duration     `env:"WEBHOOKS_TIMEOUT,default=30s"`
+	WebhooksBodyLimit int64             `env:"WEBHOOKS_BODY_LIMIT,default=1048576"`
+	WebhooksSecrets   map[string]string `env:"WEBHOOKS_SECRETS"` // provider -> secret, used if integration has no webhookSecret
+	// Integrations status endpoint is disabled if the token is empty
+	StatusAuthToken string `env:"STATUS_AUTH_TOKEN"` // expected in "Authorization: Bearer" header
+	// OTLP receiver is disabled if the token is empty
+	OTLPAuthToken         string        `env:"OTLP_AUTH_TOKEN"` // expected in "Authorization: Bearer" header
+	OTLPBodyLimit         int64         `env:"OTLP_BODY_LIMIT,default=4194304"`
+	OTLPSlowSpanThreshold time.Duration `env:"OTLP_SLOW_SPAN_THRESHOLD,default=1s"`
 }
 
 func New() *Config {
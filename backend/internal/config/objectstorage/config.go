@@ -1,5 +1,11 @@
 package objectstorage
 
+import (
+	"context"
+
+	"github.com/sethvargo/go-envconfig"
+)
+
 // Object storage configuration
 
 type ObjectsConfig struct {
@@ -18,3 +24,17 @@ type ObjectsConfig struct {
 func (c *ObjectsConfig) UseFileTags() bool {
 	return c.CloudName != "azure"
 }
+
+// NewForBucket loads object storage config from env vars, but with another bucket name
+// (for services which read files from several buckets)
+func NewForBucket(bucket string) (*ObjectsConfig, error) {
+	cfg := &ObjectsConfig{}
+	lookuper := envconfig.MultiLookuper(
+		envconfig.MapLookuper(map[string]string{"BUCKET_NAME": bucket}),
+		envconfig.OsLookuper(),
+	)
+	if err := envconfig.ProcessWith(context.Background(), cfg, lookuper); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
@@ -0,0 +1,27 @@
+package replayer
+
+import (
+	"time"
+
+	"openreplay/backend/internal/config/common"
+	"openreplay/backend/internal/config/configurator"
+)
+
+type Config struct {
+	common.Config
+	TopicDeadLetter string        `env:"TOPIC_DEAD_LETTER,required"`
+	GroupReplayer   string        `env:"GROUP_REPLAYER,default=replayer"`
+	Services        []string      `env:"REPLAY_SERVICES"` // replay batches rejected by these services only
+	TargetTopic     string        `env:"REPLAY_TOPIC"`    // replay to the replay topic of the consumer group by default
+	StateFile       string        `env:"REPLAY_STATE_FILE,default=/mnt/efs/replayer.state"`
+	Force           bool          `env:"REPLAY_FORCE,default=false"`
+	DryRun          bool          `env:"REPLAY_DRY_RUN,default=false"`
+	IdleTimeout     time.Duration `env:"REPLAY_IDLE_TIMEOUT,default=30s"`
+	ProducerTimeout int           `env:"PRODUCER_TIMEOUT,default=2000"`
+}
+
+func New() *Config {
+	cfg := &Config{}
+	configurator.Process(cfg)
+	return cfg
+}
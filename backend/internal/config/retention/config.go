@@ -0,0 +1,37 @@
+package retention
+
+import (
+	"time"
+
+	"openreplay/backend/internal/config/common"
+	"openreplay/backend/internal/config/configurator"
+	"openreplay/backend/internal/config/objectstorage"
+	"openreplay/backend/internal/config/redis"
+	"openreplay/backend/pkg/pprof"
+)
+
+type Config struct {
+	common.Config
+	common.Postgres
+	common.Clickhouse
+	redis.Redis
+	objectstorage.ObjectsConfig
+	ExtraBuckets      []string      `env:"RETENTION_EXTRA_BUCKETS"`          // other buckets with session files (videos, canvas)
+	DefaultRetention  int           `env:"RETENTION_DEFAULT_DAYS,default=0"` // for projects without retention_days, 0 keeps sessions forever
+	RetentionInterval time.Duration `env:"RETENTION_CHECK_INTERVAL,default=1h"`
+	RequestsInterval  time.Duration `env:"ERASURE_REQUESTS_INTERVAL,default=1m"`
+	RequestAttempts   int           `env:"ERASURE_REQUEST_ATTEMPTS,default=5"`
+	BatchSize         int           `env:"RETENTION_BATCH_SIZE,default=500"`
+	RunSize           int           `env:"RETENTION_RUN_SIZE,default=100000"` // expired sessions per run, deleted from analytics at once
+	DryRun            bool          `env:"RETENTION_DRY_RUN,default=false"`   // only logs sessions which would be deleted
+	UseProfiler       bool          `env:"PROFILER_ENABLED,default=false"`
+}
+
+func New() *Config {
+	cfg := &Config{}
+	configurator.Process(cfg)
+	if cfg.UseProfiler {
+		pprof.StartProfilingServer()
+	}
+	return cfg
+}
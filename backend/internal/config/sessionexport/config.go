@@ -0,0 +1,24 @@
+package sessionexport
+
+import (
+	"openreplay/backend/internal/config/common"
+	"openreplay/backend/internal/config/configurator"
+	"openreplay/backend/internal/config/objectstorage"
+)
+
+type Config struct {
+	common.Config
+	common.Postgres
+	objectstorage.ObjectsConfig
+	AssetsBucket string   `env:"ASSETS_BUCKET,default=sessions-assets"`
+	AssetsOrigin string   `env:"ASSETS_ORIGIN,required"`                 // to find cached assets in the replay and to rewrite their urls on import
+	SigningKey   string   `env:"EXPORT_SIGNING_KEY"`                     // base64 ed25519 private key, required for export
+	TrustedKeys  []string `env:"IMPORT_TRUSTED_KEYS"`                    // base64 ed25519 public keys of installations the archives are accepted from
+	MaxFileSize  int64    `env:"EXPORT_MAX_FILE_SIZE,default=524288000"` // limit of every file in the archive
+}
+
+func New() *Config {
+	cfg := &Config{}
+	configurator.Process(cfg)
+	return cfg
+}
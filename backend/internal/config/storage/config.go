@@ -22,8 +22,17 @@ type Config struct {
 	UseFailover          bool          `env:"USE_FAILOVER,default=false"`
 	MaxFileSize          int64         `env:"MAX_FILE_SIZE,default=524288000"`
 	UseSort              bool          `env:"USE_SESSION_SORT,default=true"`
+	SortRunSize          int           `env:"SORT_RUN_SIZE,default=67108864"` // messages size kept in memory while sorting
+	SortTmpDir           string        `env:"SORT_TMP_DIR"`                   // system temp dir by default
 	UseProfiler          bool          `env:"PROFILER_ENABLED,default=false"`
 	CompressionAlgo      string        `env:"COMPRESSION_ALGO,default=gzip"` // none, gzip, brotli, zstd
+	UseChunks            bool          `env:"USE_CHUNKED_UPLOAD,default=false"`
+	ChunkSize            int64         `env:"CHUNK_SIZE,default=5242880"`
+	ChunkInterval        time.Duration `env:"CHUNK_INTERVAL,default=1m"`
+	ChunkCheckInterval   time.Duration `env:"CHUNK_CHECK_INTERVAL,default=10s"`
+	ChunkIdleTimeout     time.Duration `env:"CHUNK_IDLE_TIMEOUT,default=30m"`
+	ChunkLockTimeout     time.Duration `env:"CHUNK_LOCK_TIMEOUT,default=2m"`
+	UseEncryption        bool          `env:"USE_ENCRYPTION,default=false"` // the same as in ender, live sessions aren't chunked with encryption
 }
 
 func New() *Config {
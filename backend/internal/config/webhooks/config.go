@@ -0,0 +1,38 @@
+package webhooks
+
+import (
+	"time"
+
+	"openreplay/backend/internal/config/common"
+	"openreplay/backend/internal/config/configurator"
+	"openreplay/backend/internal/config/redis"
+	"openreplay/backend/pkg/pprof"
+)
+
+type Config struct {
+	common.Config
+	common.Postgres
+	redis.Redis
+	GroupWebhooks        string        `env:"GROUP_WEBHOOKS,default=webhooks"`
+	TopicRawWeb          string        `env:"TOPIC_RAW_WEB,required"`
+	TopicAnalytics       string        `env:"TOPIC_ANALYTICS,required"`
+	SessionEndDelay      time.Duration `env:"SESSION_END_DELAY,default=1m"` // wait for late issues and db updates
+	SubscriptionsTTL     time.Duration `env:"SUBSCRIPTIONS_TTL,default=1m"`
+	DeliveryWorkers      int           `env:"DELIVERY_WORKERS,default=8"`
+	DeliveryTimeout      time.Duration `env:"DELIVERY_TIMEOUT,default=10s"`
+	DeliveryAttempts     int           `env:"DELIVERY_ATTEMPTS,default=6"`
+	DeliveryBackoff      time.Duration `env:"DELIVERY_BACKOFF,default=10s"`
+	DeliveryMaxBackoff   time.Duration `env:"DELIVERY_MAX_BACKOFF,default=30m"`
+	DeliveryLease        time.Duration `env:"DELIVERY_LEASE,default=5m"` // pending delivery isn't taken by other instances while it's attempted
+	DeliveryPollInterval time.Duration `env:"DELIVERY_POLL_INTERVAL,default=5s"`
+	UseProfiler          bool          `env:"PROFILER_ENABLED,default=false"`
+}
+
+func New() *Config {
+	cfg := &Config{}
+	configurator.Process(cfg)
+	if cfg.UseProfiler {
+		pprof.StartProfilingServer()
+	}
+	return cfg
+}
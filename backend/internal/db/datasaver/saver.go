@@ -10,6 +10,7 @@ import (
 	. "openreplay/backend/pkg/messages"
 	queue "openreplay/backend/pkg/queue/types"
 	"openreplay/backend/pkg/sessions"
+	"openreplay/backend/pkg/sourcemaps"
 )
 
 type Saver interface {
@@ -24,13 +25,15 @@ type saverImpl struct {
 	sessions sessions.Sessions
 	ch       clickhouse.Connector
 	producer queue.Producer
+	resolver *sourcemaps.Resolver
 }
 
-func New(cfg *db.Config, pg *postgres.Conn, session sessions.Sessions) Saver {
+func New(cfg *db.Config, pg *postgres.Conn, session sessions.Sessions, resolver *sourcemaps.Resolver) Saver {
 	s := &saverImpl{
 		cfg:      cfg,
 		pg:       pg,
 		sessions: session,
+		resolver: resolver,
 	}
 	s.init()
 	return s
@@ -40,6 +43,9 @@ func (s *saverImpl) Handle(msg Message) {
 	if msg.TypeID() == MsgCustomEvent {
 		defer s.Handle(types.WrapCustomEvent(msg.(*CustomEvent)))
 	}
+	if m, ok := msg.(*JSException); ok && s.resolver != nil {
+		s.resolveJSException(m)
+	}
 	if IsIOSType(msg.TypeID()) {
 		// Handle iOS messages
 		if err := s.handleMobileMessage(msg); err != nil {
@@ -61,6 +67,20 @@ func (s *saverImpl) Handle(msg Message) {
 	return
 }
 
+// resolveJSException rewrites minified stack frames to the original ones (for both postgres and clickhouse)
+func (s *saverImpl) resolveJSException(m *JSException) {
+	session, err := s.sessions.Get(m.SessionID())
+	if err != nil {
+		return
+	}
+	payload, err := s.resolver.ResolvePayload(session.ProjectID, m.Payload)
+	if err != nil {
+		log.Printf("can't resolve source maps: %s, sessID: %d", err, m.SessionID())
+		return
+	}
+	m.Payload = payload
+}
+
 func (s *saverImpl) handleMobileMessage(msg Message) error {
 	session, err := s.sessions.Get(msg.SessionID())
 	if err != nil {
@@ -0,0 +1,29 @@
+package db
+
+import (
+	config "openreplay/backend/internal/config/db"
+	objConfig "openreplay/backend/internal/config/objectstorage"
+	"openreplay/backend/pkg/objectstorage/store"
+	"openreplay/backend/pkg/sourcemaps"
+)
+
+// NewSourcemapsResolver creates resolver of js exception stack traces
+func NewSourcemapsResolver(cfg *config.Config) (*sourcemaps.Resolver, error) {
+	jsCacheCfg, err := objConfig.NewForBucket(cfg.JSCacheBucket)
+	if err != nil {
+		return nil, err
+	}
+	jsCache, err := store.NewStore(jsCacheCfg)
+	if err != nil {
+		return nil, err
+	}
+	sourcemapsCfg, err := objConfig.NewForBucket(cfg.SourcemapsBucket)
+	if err != nil {
+		return nil, err
+	}
+	uploaded, err := store.NewStore(sourcemapsCfg)
+	if err != nil {
+		return nil, err
+	}
+	return sourcemaps.NewResolver(uploaded, jsCache, cfg.SourcemapsWait)
+}
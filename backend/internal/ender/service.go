@@ -0,0 +1,238 @@
+package ender
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	config "openreplay/backend/internal/config/ender"
+	"openreplay/backend/internal/service"
+	"openreplay/backend/internal/sessionender"
+	"openreplay/backend/internal/storage"
+	"openreplay/backend/pkg/intervals"
+	"openreplay/backend/pkg/memory"
+	"openreplay/backend/pkg/messages"
+	"openreplay/backend/pkg/queue"
+	"openreplay/backend/pkg/queue/types"
+	"openreplay/backend/pkg/sessions"
+)
+
+type SessionEndType int
+
+const (
+	FailedSessionEnd SessionEndType = iota + 1
+	DuplicatedSessionEnd
+	NegativeDuration
+	ShorterDuration
+	DiffDuration
+	NewSessionEnd
+	NoSessionInDB
+)
+
+var mobileMessages = []int{90, 91, 92, 93, 94, 95, 96, 97, 98, 99, 100, 101, 102, 103, 104, 105, 107, 110, 111}
+
+type enderImpl struct {
+	cfg       *config.Config
+	producer  types.Producer
+	consumer  types.Consumer
+	generator *sessionender.SessionEnder
+	sessions  sessions.Sessions
+	mm        memory.Manager
+	done      chan struct{}
+	finished  chan struct{}
+}
+
+func New(cfg *config.Config, sessions sessions.Sessions, mm memory.Manager) (service.Interface, error) {
+	sessionEndGenerator, err := sessionender.New(intervals.EVENTS_SESSION_END_TIMEOUT, partitionsNumber(cfg))
+	if err != nil {
+		return nil, err
+	}
+	s := &enderImpl{
+		cfg:       cfg,
+		producer:  queue.NewProducer(cfg.MessageSizeLimit, true),
+		generator: sessionEndGenerator,
+		sessions:  sessions,
+		mm:        mm,
+		done:      make(chan struct{}),
+		finished:  make(chan struct{}),
+	}
+	s.consumer = queue.NewConsumer(
+		cfg.GroupEnder,
+		[]string{
+			cfg.TopicRawWeb,
+			cfg.TopicRawIOS,
+		},
+		messages.NewEnderMessageIterator(
+			func(msg messages.Message) { sessionEndGenerator.UpdateSession(msg) },
+			append([]int{messages.MsgTimestamp}, mobileMessages...),
+			false),
+		false,
+		cfg.MessageSizeLimit,
+	)
+	go s.run()
+	return s, nil
+}
+
+// partitionsNumber returns the number of raw topic partitions, ender has to map sessions the same way as producers do
+func partitionsNumber(cfg *config.Config) int {
+	partitions, err := queue.PartitionsNumber(cfg.TopicRawWeb)
+	if err != nil {
+		log.Printf("can't get partitions number of %s topic, PARTITIONS_NUMBER will be used: %s", cfg.TopicRawWeb, err)
+		return cfg.PartitionsNumber
+	}
+	if iosPartitions, err := queue.PartitionsNumber(cfg.TopicRawIOS); err == nil && iosPartitions != partitions {
+		log.Printf("warn: %s topic has %d partitions, but %s has %d, mobile sessions will be mapped inconsistently",
+			cfg.TopicRawIOS, iosPartitions, cfg.TopicRawWeb, partitions)
+	}
+	if cfg.PartitionsNumber != 0 && uint64(cfg.PartitionsNumber) != partitions {
+		log.Printf("warn: PARTITIONS_NUMBER is %d, but %s topic has %d partitions, the last one will be used",
+			cfg.PartitionsNumber, cfg.TopicRawWeb, partitions)
+	}
+	return int(partitions)
+}
+
+func (e *enderImpl) run() {
+	tick := time.Tick(intervals.EVENTS_COMMIT_INTERVAL * time.Millisecond)
+	for {
+		select {
+		case <-e.done:
+			e.producer.Close(e.cfg.ProducerTimeout)
+			if err := e.consumer.CommitBack(intervals.EVENTS_BACK_COMMIT_GAP); err != nil {
+				log.Printf("can't commit messages with offset: %s", err)
+			}
+			e.consumer.Close()
+			e.finished <- struct{}{}
+			return
+		case <-tick:
+			e.handleEndedSessions()
+			e.producer.Flush(e.cfg.ProducerTimeout)
+			if err := e.consumer.CommitBack(intervals.EVENTS_BACK_COMMIT_GAP); err != nil {
+				log.Printf("can't commit messages with offset: %s", err)
+			}
+		case msg := <-e.consumer.Rebalanced():
+			log.Printf("Rebalanced event, type: %s, partitions: %+v", msg.Type, msg.Partitions)
+			if msg.Type == types.RebalanceTypeRevoke {
+				e.generator.Disable()
+			} else {
+				e.generator.ActivePartitions(msg.Partitions)
+				e.generator.Enable()
+			}
+		default:
+			if !e.mm.HasFreeMemory() {
+				continue
+			}
+			if err := e.consumer.ConsumeNext(); err != nil {
+				log.Fatalf("Error on consuming: %v", err)
+			}
+		}
+	}
+}
+
+// handleEndedSessions finds ended sessions and sends notification to other services
+func (e *enderImpl) handleEndedSessions() {
+	failedSessionEnds := make(map[uint64]uint64)
+	duplicatedSessionEnds := make(map[uint64]uint64)
+	negativeDuration := make(map[uint64]uint64)
+	shorterDuration := make(map[uint64]int64)
+	diffDuration := make(map[uint64]int64)
+	noSessionInDB := make(map[uint64]uint64)
+	updatedDurations := 0
+	newSessionEnds := 0
+
+	e.generator.HandleEndedSessions(func(sessionID uint64, timestamp uint64) (bool, int) {
+		msg := &messages.SessionEnd{Timestamp: timestamp}
+		currDuration, err := e.sessions.GetDuration(sessionID)
+		if err != nil {
+			log.Printf("getSessionDuration failed, sessID: %d, err: %s", sessionID, err)
+		}
+		sess, err := e.sessions.Get(sessionID)
+		if err != nil {
+			log.Printf("can't get session from database to compare durations, sessID: %d, err: %s", sessionID, err)
+		} else {
+			newDur := timestamp - sess.Timestamp
+			// Skip if session was ended before with same duration
+			if currDuration == newDur {
+				duplicatedSessionEnds[sessionID] = currDuration
+				return true, int(DuplicatedSessionEnd)
+			}
+			// Skip if session was ended before with longer duration
+			if currDuration > newDur {
+				shorterDuration[sessionID] = int64(currDuration) - int64(newDur)
+				return true, int(ShorterDuration)
+			}
+		}
+		newDuration, err := e.sessions.UpdateDuration(sessionID, msg.Timestamp)
+		if err != nil {
+			if strings.Contains(err.Error(), "integer out of range") {
+				// Skip session with broken duration
+				failedSessionEnds[sessionID] = timestamp
+				return true, int(FailedSessionEnd)
+			}
+			if strings.Contains(err.Error(), "is less than zero for uint64") {
+				negativeDuration[sessionID] = timestamp
+				return true, int(NegativeDuration)
+			}
+			if strings.Contains(err.Error(), "no rows in result set") {
+				noSessionInDB[sessionID] = timestamp
+				return true, int(NoSessionInDB)
+			}
+			log.Printf("can't save sessionEnd to database, sessID: %d, err: %s", sessionID, err)
+			return false, 0
+		}
+		// Check one more time just in case
+		if currDuration == newDuration {
+			duplicatedSessionEnds[sessionID] = currDuration
+			return true, int(DuplicatedSessionEnd)
+		}
+		if e.cfg.UseEncryption {
+			if key := storage.GenerateEncryptionKey(); key != nil {
+				if err := e.sessions.UpdateEncryptionKey(sessionID, key); err != nil {
+					log.Printf("can't save session encryption key: %s, session will not be encrypted", err)
+				} else {
+					msg.EncryptionKey = string(key)
+				}
+			}
+		}
+		if sess != nil && sess.Platform == "ios" {
+			msg := &messages.IOSSessionEnd{Timestamp: timestamp}
+			if err := e.producer.Produce(e.cfg.TopicRawIOS, sessionID, msg.Encode()); err != nil {
+				log.Printf("can't send iOSSessionEnd to topic: %s; sessID: %d", err, sessionID)
+				return false, 0
+			}
+		} else {
+			if err := e.producer.Produce(e.cfg.TopicRawWeb, sessionID, msg.Encode()); err != nil {
+				log.Printf("can't send sessionEnd to raw topic: %s; sessID: %d", err, sessionID)
+				return false, 0
+			}
+			// Inform canvas service about session end
+			if err := e.producer.Produce(e.cfg.TopicCanvasImages, sessionID, msg.Encode()); err != nil {
+				log.Printf("can't send sessionEnd signal to canvas topic: %s; sessID: %d", err, sessionID)
+			}
+		}
+
+		if currDuration != 0 {
+			diffDuration[sessionID] = int64(newDuration) - int64(currDuration)
+			updatedDurations++
+		} else {
+			newSessionEnds++
+		}
+		return true, int(NewSessionEnd)
+	})
+	if n := len(failedSessionEnds); n > 0 {
+		log.Println("sessions with wrong duration:", n, failedSessionEnds)
+	}
+	if n := len(negativeDuration); n > 0 {
+		log.Println("sessions with negative duration:", n, negativeDuration)
+	}
+	if n := len(noSessionInDB); n > 0 {
+		log.Printf("sessions without info in DB: %d, %v", n, noSessionInDB)
+	}
+	log.Printf("[INFO] failed: %d, negative: %d, shorter: %d, same: %d, updated: %d, new: %d, not found: %d",
+		len(failedSessionEnds), len(negativeDuration), len(shorterDuration), len(duplicatedSessionEnds),
+		updatedDurations, newSessionEnds, len(noSessionInDB))
+}
+
+func (e *enderImpl) Stop() {
+	e.done <- struct{}{}
+	<-e.finished
+}
@@ -0,0 +1,24 @@
+package heuristics
+
+import (
+	"openreplay/backend/pkg/handlers"
+	"openreplay/backend/pkg/handlers/custom"
+	"openreplay/backend/pkg/handlers/ios"
+	"openreplay/backend/pkg/handlers/web"
+)
+
+// HandlersFabric returns the list of message handlers we want to be applied to each incoming message.
+func HandlersFabric() []handlers.MessageProcessor {
+	return []handlers.MessageProcessor{
+		custom.NewPageEventBuilder(),
+		web.NewDeadClickDetector(),
+		&web.ClickRageDetector{},
+		&web.CpuIssueDetector{},
+		&web.MemoryIssueDetector{},
+		&web.NetworkIssueDetector{},
+		&web.PerformanceAggregator{},
+		web.NewAppCrashDetector(),
+		&ios.TapRageDetector{},
+		ios.NewViewComponentDurations(),
+	}
+}
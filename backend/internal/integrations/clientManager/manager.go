@@ -1,13 +1,19 @@
 package clientManager
 
 import (
+	"encoding/json"
 	"openreplay/backend/internal/integrations/integration"
 	"openreplay/backend/pkg/integrations"
+	integrationsMetrics "openreplay/backend/pkg/metrics/integrations"
 	"strconv"
+	"sync"
 )
 
 type manager struct {
 	clientMap          integration.ClientMap
+	clientsMutex       sync.RWMutex      // status handler reads clients from another goroutine
+	secrets            map[string]string // webhook secrets, read from the webhook receiver goroutines
+	secretsMutex       sync.RWMutex
 	Events             chan *integration.SessionErrorEvent
 	Errors             chan error
 	RequestDataUpdates chan integrations.Integration // not pointer because it could change in other thread
@@ -16,6 +22,7 @@ type manager struct {
 func NewManager() *manager {
 	return &manager{
 		clientMap:          make(integration.ClientMap),
+		secrets:            make(map[string]string),
 		RequestDataUpdates: make(chan integrations.Integration, 100),
 		Events:             make(chan *integration.SessionErrorEvent, 100),
 		Errors:             make(chan error, 100),
@@ -23,25 +30,60 @@ func NewManager() *manager {
 
 }
 
+// Update changes the client map under the lock, but the client update (it waits for the running request)
+// and creation are done outside it, so the status handler isn't blocked
 func (m *manager) Update(i *integrations.Integration) error {
 	key := strconv.Itoa(int(i.ProjectID)) + i.Provider
+	m.updateSecret(key, i)
 	if i.Options == nil {
+		m.clientsMutex.Lock()
 		delete(m.clientMap, key)
+		m.clientsMutex.Unlock()
+		integrationsMetrics.DeleteHealth(i.Provider, i.ProjectID)
 		return nil
 	}
+	m.clientsMutex.RLock()
 	c, exists := m.clientMap[key]
-	if !exists {
-		c, err := integration.NewClient(i, m.RequestDataUpdates, m.Events, m.Errors)
-		if err != nil {
-			return err
-		}
-		m.clientMap[key] = c
-		return nil
+	m.clientsMutex.RUnlock()
+	if exists {
+		return c.Update(i)
+	}
+	c, err := integration.NewClient(i, m.RequestDataUpdates, m.Events, m.Errors)
+	if err != nil {
+		return err
+	}
+	m.clientsMutex.Lock()
+	m.clientMap[key] = c
+	m.clientsMutex.Unlock()
+	return nil
+}
+
+func (m *manager) updateSecret(key string, i *integrations.Integration) {
+	var options struct {
+		WebhookSecret string `json:"webhookSecret"`
 	}
-	return c.Update(i)
+	if i.Options != nil {
+		json.Unmarshal(i.Options, &options)
+	}
+	m.secretsMutex.Lock()
+	defer m.secretsMutex.Unlock()
+	if options.WebhookSecret == "" {
+		delete(m.secrets, key)
+		return
+	}
+	m.secrets[key] = options.WebhookSecret
+}
+
+// WebhookSecret returns the secret from integration options (empty if not set)
+func (m *manager) WebhookSecret(projectID uint32, provider string) string {
+	m.secretsMutex.RLock()
+	defer m.secretsMutex.RUnlock()
+	return m.secrets[strconv.Itoa(int(projectID))+provider]
 }
 
 func (m *manager) RequestAll() {
+	m.clientsMutex.RLock()
+	defer m.clientsMutex.RUnlock()
 	for _, c := range m.clientMap {
 		go c.Request()
 	}
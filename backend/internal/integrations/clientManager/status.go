@@ -0,0 +1,55 @@
+package clientManager
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"openreplay/backend/internal/integrations/integration"
+)
+
+// Health returns the state of all integrations (or integrations of one project if projectID isn't 0)
+func (m *manager) Health(projectID uint32) []integration.Health {
+	m.clientsMutex.RLock()
+	defer m.clientsMutex.RUnlock()
+	res := make([]integration.Health, 0, len(m.clientMap))
+	for _, c := range m.clientMap {
+		health := c.Health()
+		if projectID != 0 && health.ProjectID != projectID {
+			continue
+		}
+		res = append(res, health)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].ProjectID != res[j].ProjectID {
+			return res[i].ProjectID < res[j].ProjectID
+		}
+		return res[i].Provider < res[j].Provider
+	})
+	return res
+}
+
+// StatusHandler serves GET /v1/integrations/status[?projectID=N]
+func (m *manager) StatusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var projectID uint64
+	if value := r.URL.Query().Get("projectID"); value != "" {
+		id, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			http.Error(w, "wrong project id", http.StatusBadRequest)
+			return
+		}
+		projectID = id
+	}
+	body, err := json.Marshal(m.Health(uint32(projectID)))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
@@ -37,7 +37,7 @@ type bugsnagEvent struct {
 func (b *bugsnag) Request(c *client) error {
 	sinceTs := c.getLastMessageTimestamp() + 1000 // From next second
 	sinceFormatted := time.UnixMilli(int64(sinceTs)).Format(time.RFC3339)
-	requestURL := fmt.Sprintf("https://api.bugsnag.com/projects/%v/events", b.BugsnagProjectId)
+	requestURL := fmt.Sprintf("%v/projects/%v/events", c.baseURL("https://api.bugsnag.com"), b.BugsnagProjectId)
 	req, err := http.NewRequest("GET", requestURL, nil)
 	if err != nil {
 		return err
@@ -56,7 +56,7 @@ func (b *bugsnag) Request(c *client) error {
 	req.Header.Add("X-Version", "2")
 
 	for {
-		resp, err := http.DefaultClient.Do(req)
+		resp, err := c.httpClient.Do(req)
 		if err != nil {
 			return err
 		}
@@ -66,7 +66,7 @@ func (b *bugsnag) Request(c *client) error {
 		//  401 (unauthorised)
 		if resp.StatusCode >= 400 {
 			io.Copy(ioutil.Discard, resp.Body) // Read the body to free socket
-			return fmt.Errorf("Bugsnag: server respond with the code %v | data: %v ", resp.StatusCode, *b)
+			return fmt.Errorf("Bugsnag: server respond with the code %v | project: %v", resp.StatusCode, b.BugsnagProjectId)
 		}
 
 		var jsonEventList []json.RawMessage
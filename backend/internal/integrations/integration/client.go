@@ -3,17 +3,21 @@ package integration
 import (
 	"encoding/json"
 	"fmt"
-	"log"
+	"net/http"
 	"openreplay/backend/pkg/integrations"
+	"reflect"
 	"sync"
 	"time"
 
 	"openreplay/backend/pkg/messages"
 )
 
+// After MAX_ATTEMPTS_IN_A_ROW failed requests the integration is reported as failing,
+// but it's still requested with exponential backoff (BACKOFF_BASE * 2^(failures-1), up to BACKOFF_MAX)
 const MAX_ATTEMPTS_IN_A_ROW = 4
-const MAX_ATTEMPTS = 40
-const ATTEMPTS_INTERVAL = 3 * 60 * 60 * 1000
+const BACKOFF_BASE = 60 * 1000
+const BACKOFF_MAX = 3 * 60 * 60 * 1000
+const MAX_ERROR_LENGTH = 1000
 
 type requester interface {
 	Request(*client) error
@@ -22,25 +26,50 @@ type requester interface {
 type requestData struct {
 	LastMessageTimestamp       uint64 // `json:"lastMessageTimestamp, string"`
 	LastMessageId              string
-	UnsuccessfullAttemptsCount int
+	UnsuccessfullAttemptsCount int // consecutive failures
 	LastAttemptTimestamp       int64
+	LastSuccessTimestamp       int64
+	LastErrorTimestamp         int64
+	LastError                  string
+	NextRetryTimestamp         int64
 }
 
 type client struct {
 	requestData
 	requester
 	integration *integrations.Integration
-	// TODO: timeout ?
-	mux        sync.Mutex
-	updateChan chan<- integrations.Integration
-	evChan     chan<- *SessionErrorEvent
-	errChan    chan<- error
+	httpOptions *httpOptions
+	httpClient  *http.Client // shared by all provider requests, keeps TLS/proxy/timeout settings
+	mux         sync.Mutex
+	health      Health // copy of the state, so it can be read while the request is in progress
+	healthMux   sync.RWMutex
+	updateChan  chan<- integrations.Integration
+	evChan      chan<- *SessionErrorEvent
+	errChan     chan<- error
 }
 
 type SessionErrorEvent struct {
 	SessionID uint64
 	Token     string
+	ProjectID uint32 // set by webhooks, the session must belong to this project
 	*messages.IntegrationEvent
+	// Backend request (OTLP span) shown with network requests of the session, sent instead of IntegrationEvent
+	NetworkRequest *messages.NetworkRequest
+}
+
+func (e *SessionErrorEvent) Msg() messages.Message {
+	if e.NetworkRequest != nil {
+		return e.NetworkRequest
+	}
+	return e.IntegrationEvent
+}
+
+// Encode returns the batch for analytics topic, network request is preceded by timestamp for message meta
+func (e *SessionErrorEvent) Encode() []byte {
+	if e.NetworkRequest == nil {
+		return e.IntegrationEvent.Encode()
+	}
+	return append((&messages.Timestamp{Timestamp: e.NetworkRequest.Timestamp}).Encode(), e.NetworkRequest.Encode()...)
 }
 
 type ClientMap map[string]*client
@@ -62,6 +91,7 @@ func NewClient(i *integrations.Integration, updateChan chan<- integrations.Integ
 		// ?
 		c.requestData.LastMessageTimestamp = uint64(time.Now().Add(-time.Hour * 24).UnixMilli())
 	}
+	c.updateHealth()
 
 	return c, nil
 }
@@ -90,12 +120,41 @@ func (c *client) Update(i *integrations.Integration) error {
 		r = new(stackdriver)
 	case "sumologic":
 		r = new(sumologic)
+	case "loki":
+		r = new(loki)
+	case "opensearch":
+		r = new(opensearch)
+	case "http_logs":
+		r = new(httpLogs)
+	default:
+		return fmt.Errorf("unknown integration provider: %s", i.Provider)
 	}
 	if err := json.Unmarshal(i.Options, r); err != nil {
 		return err
 	}
+	opts, err := parseHTTPOptions(i.Options)
+	if err != nil {
+		return err
+	}
+	// Http client is recreated only if http options are changed, the old one isn't used by requests anymore
+	// (they hold the mutex), so its idle connections are closed
+	httpClient := c.httpClient
+	if httpClient == nil || c.integration.Provider != i.Provider || !reflect.DeepEqual(c.httpOptions, opts) {
+		if httpClient, err = newHTTPClient(i.Provider, opts); err != nil {
+			return err
+		}
+		if c.httpClient != nil {
+			c.httpClient.CloseIdleConnections()
+		}
+	}
 	c.integration = i
 	c.requester = r
+	c.httpOptions = opts
+	c.httpClient = httpClient
+	// New options (e.g. fixed token), so we resume requests without waiting for the backoff
+	c.requestData.UnsuccessfullAttemptsCount = 0
+	c.requestData.NextRetryTimestamp = 0
+	c.updateHealth()
 	return nil
 }
 
@@ -119,29 +178,28 @@ func (c *client) getLastMessageId() string {
 }
 
 func (c *client) handleError(err error) {
-	c.errChan <- fmt.Errorf("%v | Integration: %v", err, *c.integration)
+	// Options are not logged as they contain credentials
+	c.errChan <- fmt.Errorf("%v | Integration: %s, projectID: %d", err, c.integration.Provider, c.integration.ProjectID)
 }
 
 // Thread-safe
 func (c *client) Request() {
 	c.mux.Lock()
 	defer c.mux.Unlock()
-	if c.requestData.UnsuccessfullAttemptsCount >= MAX_ATTEMPTS ||
-		(c.requestData.UnsuccessfullAttemptsCount >= MAX_ATTEMPTS_IN_A_ROW &&
-			time.Now().UnixMilli()-c.requestData.LastAttemptTimestamp < ATTEMPTS_INTERVAL) {
+	now := time.Now().UnixMilli()
+	if now < c.requestData.NextRetryTimestamp {
 		return
 	}
 
-	c.requestData.LastAttemptTimestamp = time.Now().UnixMilli()
+	c.requestData.LastAttemptTimestamp = now
 	err := c.requester.Request(c)
 	if err != nil {
-		log.Println("ERRROR L139")
-		log.Println(err)
 		c.handleError(err)
-		c.requestData.UnsuccessfullAttemptsCount++
+		c.onFailure(err)
 	} else {
-		c.requestData.UnsuccessfullAttemptsCount = 0
+		c.onSuccess()
 	}
+	c.updateHealth()
 	rd, err := json.Marshal(c.requestData)
 	if err != nil {
 		c.handleError(err)
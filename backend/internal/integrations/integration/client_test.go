@@ -0,0 +1,276 @@
+package integration
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"openreplay/backend/pkg/integrations"
+)
+
+func newTestClient(t *testing.T, provider string, options map[string]interface{}) (*client, chan *SessionErrorEvent, chan error) {
+	rawOptions, err := json.Marshal(options)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	events := make(chan *SessionErrorEvent, 100)
+	errs := make(chan error, 100)
+	c, err := NewClient(&integrations.Integration{
+		ProjectID:   1,
+		Provider:    provider,
+		Options:     rawOptions,
+		RequestData: json.RawMessage(`{}`),
+	}, make(chan integrations.Integration, 1), events, errs)
+	if err != nil {
+		t.Fatalf("Can't create client: %s", err)
+	}
+	return c, events, errs
+}
+
+func fixtureHandler(t *testing.T, fixture string, check func(r *http.Request)) http.HandlerFunc {
+	data, err := os.ReadFile(fixture)
+	if err != nil {
+		t.Fatalf("Can't read fixture: %s", err)
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		check(r)
+		w.Header().Set("Link", `<next>; rel="previous"; results="false", <next>; rel="next"; results="false"`)
+		w.Write(data)
+	}
+}
+
+func TestSentryBaseURL(t *testing.T) {
+	server := httptest.NewServer(fixtureHandler(t, "sentry.json", func(r *http.Request) {
+		if r.URL.Path != "/api/0/projects/org/project/events/" {
+			t.Errorf("Unexpected path: %s", r.URL.Path)
+		}
+		if r.Header.Get("Authorization") != "Bearer token" {
+			t.Errorf("Unexpected auth header: %s", r.Header.Get("Authorization"))
+		}
+	}))
+	defer server.Close()
+
+	c, events, _ := newTestClient(t, "sentry", map[string]interface{}{
+		"organizationSlug": "org", "projectSlug": "project", "token": "token", "baseUrl": server.URL + "/",
+	})
+	if err := c.requester.Request(c); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(events) == 0 {
+		t.Fatalf("Expected events from fixture")
+	}
+	if e := <-events; e.SessionID != 525314475541266774 || e.Source != "sentry" {
+		t.Errorf("Unexpected event: %+v", e)
+	}
+}
+
+func TestCustomCA(t *testing.T) {
+	server := httptest.NewTLSServer(fixtureHandler(t, "newrelic_empty.json", func(r *http.Request) {
+		if r.Header.Get("X-Query-Key") != "key" {
+			t.Errorf("Unexpected query key: %s", r.Header.Get("X-Query-Key"))
+		}
+	}))
+	defer server.Close()
+	options := map[string]interface{}{"applicationId": "1", "xQueryKey": "key", "baseUrl": server.URL}
+
+	// Self-signed certificate of the test server isn't trusted by default
+	c, _, _ := newTestClient(t, "newrelic", options)
+	if err := c.requester.Request(c); err == nil {
+		t.Errorf("Expected certificate verification error")
+	}
+
+	options["caCert"] = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}))
+	c, _, _ = newTestClient(t, "newrelic", options)
+	if err := c.requester.Request(c); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+}
+
+func TestWrongHTTPOptions(t *testing.T) {
+	for _, options := range []string{
+		`{"baseUrl": "sentry.example.com"}`,
+		`{"caCert": "not a certificate"}`,
+		`{"proxy": "://proxy"}`,
+	} {
+		_, err := NewClient(&integrations.Integration{Provider: "sentry", Options: json.RawMessage(options),
+			RequestData: json.RawMessage(`{}`)}, nil, nil, nil)
+		if err == nil {
+			t.Errorf("Expected error for options: %s", options)
+		}
+	}
+}
+
+func TestBackoffAndResume(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	options := map[string]interface{}{"applicationId": "1", "xQueryKey": "expired", "baseUrl": server.URL}
+	c, _, errs := newTestClient(t, "newrelic", options)
+	updates := make(chan integrations.Integration, 10)
+	c.updateChan = updates
+
+	c.Request()
+	health := c.Health()
+	if requests != 1 || health.Status != HealthRetrying || health.ConsecutiveFailures != 1 || health.LastError == "" {
+		t.Fatalf("Unexpected health after failed request: %+v", health)
+	}
+	if health.NextRetryTimestamp <= health.LastErrorTimestamp {
+		t.Errorf("Expected next retry in the future: %+v", health)
+	}
+	if len(errs) != 1 {
+		t.Errorf("Expected reported error")
+	}
+	var data requestData
+	if err := json.Unmarshal((<-updates).RequestData, &data); err != nil || data.LastError != health.LastError {
+		t.Errorf("Expected health to be saved in request data: %+v, %v", data, err)
+	}
+
+	// Backoff isn't over yet
+	c.Request()
+	if requests != 1 {
+		t.Errorf("Expected no requests during backoff, got %d", requests)
+	}
+
+	// Options update resumes requests
+	options["xQueryKey"] = "new"
+	rawOptions, _ := json.Marshal(options)
+	if err := c.Update(&integrations.Integration{ProjectID: 1, Provider: "newrelic", Options: rawOptions}); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if health := c.Health(); health.Status != HealthOK || health.NextRetryTimestamp != 0 {
+		t.Errorf("Expected resumed integration: %+v", health)
+	}
+	c.Request()
+	if requests != 2 {
+		t.Errorf("Expected request after options update, got %d", requests)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	if backoff(1) != BACKOFF_BASE || backoff(3) != 4*BACKOFF_BASE || backoff(100) != BACKOFF_MAX {
+		t.Errorf("Unexpected backoff: %d %d %d", backoff(1), backoff(3), backoff(100))
+	}
+}
+
+func TestLoki(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/loki/api/v1/query_range" || r.Header.Get("X-Scope-OrgID") != "tenant" {
+			t.Errorf("Unexpected request: %s %v", r.URL.Path, r.Header)
+		}
+		if query := r.URL.Query().Get("query"); query != `{app="api"}`+LOKI_FILTER {
+			t.Errorf("Unexpected query: %s", query)
+		}
+		w.Write([]byte(`{"status":"success","data":{"resultType":"streams","result":[{"stream":{"app":"api"},
+			"values":[["1682935200000000000","error openReplaySessionToken=tok.1 failed"]]}]}}`))
+	}))
+	defer server.Close()
+
+	c, events, _ := newTestClient(t, "loki", map[string]interface{}{
+		"selector": `{app="api"}`, "orgId": "tenant", "baseUrl": server.URL,
+	})
+	if err := c.requester.Request(c); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if e := <-events; e.Token != "tok.1" || e.Timestamp != 1682935200000 || e.Source != "loki" {
+		t.Errorf("Unexpected event: %+v", e.IntegrationEvent)
+	}
+}
+
+func TestHTTPLogsCursorPagination(t *testing.T) {
+	pages := map[string]string{
+		"":    `{"data":{"logs":[{"ts":"2023-05-01T10:00:00Z","msg":"openReplaySessionToken=tok.1 error"}]},"next":"abc"}`,
+		"abc": `{"data":{"logs":[{"ts":"2023-05-01T10:00:01Z","msg":"crash","meta":{"session":"tok.2"}}]},"next":""}`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Api-Key") != "key" || r.URL.Query().Get("limit") != "100" {
+			t.Errorf("Unexpected request: %s %v", r.URL, r.Header)
+		}
+		w.Write([]byte(pages[r.URL.Query().Get("cursor")]))
+	}))
+	defer server.Close()
+
+	c, events, errs := newTestClient(t, "http_logs", map[string]interface{}{
+		"url":             server.URL + "/search?from={from}&limit={limit}&cursor={cursor}",
+		"headers":         map[string]string{"X-Api-Key": "key"},
+		"itemsPath":       "$.data.logs",
+		"timestampPath":   "$.ts",
+		"timestampFormat": "rfc3339",
+		"messagePath":     "msg",
+		"tokenPath":       "$['meta'].session",
+		"pagination":      "cursor",
+		"cursorPath":      "$.next",
+	})
+	if err := c.requester.Request(c); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(events) != 2 || len(errs) != 0 {
+		t.Fatalf("Expected 2 events and no errors, got %d and %d", len(events), len(errs))
+	}
+	if e := <-events; e.Token != "tok.1" || e.Timestamp != 1682935200000 {
+		t.Errorf("Unexpected event: %+v", e.IntegrationEvent)
+	}
+	if e := <-events; e.Token != "tok.2" || e.Name != "crash" {
+		t.Errorf("Unexpected event: %+v", e.IntegrationEvent)
+	}
+}
+
+func TestJSONPath(t *testing.T) {
+	value := map[string]interface{}{
+		"a":     map[string]interface{}{"b": []interface{}{"x", map[string]interface{}{"c": 1.5}}},
+		"d.e":   true,
+		"empty": nil,
+	}
+	cases := map[string]string{
+		"$.a.b[0]":    "x",
+		"a.b[1].c":    "1.5",
+		"$.a.b[-1].c": "1.5",
+		"$['d.e']":    "true",
+		"$.a.x":       "",
+		"$.empty":     "",
+	}
+	for path, expected := range cases {
+		p, err := parseJSONPath(path)
+		if err != nil {
+			t.Errorf("Unexpected error for %s: %s", path, err)
+			continue
+		}
+		if got := p.getString(value); got != expected {
+			t.Errorf("Expected %q for %s, but got %q", expected, path, got)
+		}
+	}
+	if _, err := parseJSONPath("$.a[x]"); err == nil {
+		t.Errorf("Expected error for wrong selector")
+	}
+}
+
+func TestUpdateHTTPClient(t *testing.T) {
+	options := map[string]interface{}{"organizationSlug": "org", "projectSlug": "project", "token": "token"}
+	c, _, _ := newTestClient(t, "sentry", options)
+	httpClient := c.httpClient
+
+	// Same http options (e.g. new token), the client is reused
+	options["token"] = "new-token"
+	rawOptions, _ := json.Marshal(options)
+	if err := c.Update(&integrations.Integration{ProjectID: 1, Provider: "sentry", Options: rawOptions}); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if c.httpClient != httpClient {
+		t.Errorf("Http client must be reused")
+	}
+
+	options["timeout"] = 5
+	rawOptions, _ = json.Marshal(options)
+	if err := c.Update(&integrations.Integration{ProjectID: 1, Provider: "sentry", Options: rawOptions}); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if c.httpClient == httpClient {
+		t.Errorf("Http client must be recreated with new options")
+	}
+}
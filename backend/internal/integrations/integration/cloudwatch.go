@@ -23,12 +23,16 @@ type cloudwatch struct {
 func (cw *cloudwatch) Request(c *client) error {
 	startTs := int64(c.getLastMessageTimestamp() + 1) // From next millisecond
 	//endTs := utils.CurrentTimestamp()
-	sess, err := session.NewSession(aws.NewConfig().
+	awsConfig := aws.NewConfig().
 		WithRegion(cw.Region).
 		WithCredentials(
 			credentials.NewStaticCredentials(cw.AwsAccessKeyId, cw.AwsSecretAccessKey, ""),
-		),
-	)
+		).
+		WithHTTPClient(c.httpClient)
+	if endpoint := c.baseURL(""); endpoint != "" {
+		awsConfig = awsConfig.WithEndpoint(endpoint)
+	}
+	sess, err := session.NewSession(awsConfig)
 	if err != nil {
 		return err
 	}
@@ -40,9 +40,10 @@ type datadogLog struct {
 	}
 }
 
-func (d *datadog) makeRequest(nextLogId *string, fromTs uint64, toTs uint64) (*http.Request, error) {
+func (d *datadog) makeRequest(baseURL string, nextLogId *string, fromTs uint64, toTs uint64) (*http.Request, error) {
 	requestURL := fmt.Sprintf(
-		"https://api.datadoghq.com/api/v1/logs-queries/list?api_key=%v&application_key=%v",
+		"%v/api/v1/logs-queries/list?api_key=%v&application_key=%v",
+		baseURL,
 		d.ApiKey,
 		d.ApplicationKey,
 	)
@@ -75,11 +76,11 @@ func (d *datadog) Request(c *client) error {
 	toTs := uint64(time.Now().UnixMilli())
 	var nextLogId *string
 	for {
-		req, err := d.makeRequest(nextLogId, fromTs, toTs)
+		req, err := d.makeRequest(c.baseURL("https://api.datadoghq.com"), nextLogId, fromTs, toTs)
 		if err != nil {
 			return err
 		}
-		resp, err := http.DefaultClient.Do(req)
+		resp, err := c.httpClient.Do(req)
 		if err != nil {
 			return err
 		}
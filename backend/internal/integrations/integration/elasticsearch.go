@@ -28,7 +28,7 @@ type elasticsearchLog struct {
 }
 
 func (es *elasticsearch) Request(c *client) error {
-	address := es.Host + ":" + es.Port.String()
+	address := c.baseURL(es.Host + ":" + es.Port.String())
 	apiKey := b64.StdEncoding.EncodeToString([]byte(es.ApiKeyId + ":" + es.ApiKey))
 	cfg := elasticlib.Config{
 		Addresses: []string{
@@ -36,7 +36,8 @@ func (es *elasticsearch) Request(c *client) error {
 		},
 		//Username: es.ApiKeyId,
 		//Password: es.ApiKey,
-		APIKey: apiKey,
+		APIKey:    apiKey,
+		Transport: c.httpClient.Transport,
 	}
 	esC, err := elasticlib.NewClient(cfg)
 
@@ -0,0 +1,84 @@
+package integration
+
+import (
+	"time"
+
+	"openreplay/backend/pkg/metrics/integrations"
+)
+
+const (
+	HealthOK       = "ok"
+	HealthRetrying = "retrying" // a few failed requests in a row
+	HealthFailing  = "failing"  // MAX_ATTEMPTS_IN_A_ROW or more failed requests in a row
+)
+
+type Health struct {
+	ProjectID            uint32 `json:"projectId"`
+	Provider             string `json:"provider"`
+	Status               string `json:"status"`
+	LastSuccessTimestamp int64  `json:"lastSuccessTimestamp"`
+	LastErrorTimestamp   int64  `json:"lastErrorTimestamp"`
+	LastError            string `json:"lastError"`
+	ConsecutiveFailures  int    `json:"consecutiveFailures"`
+	NextRetryTimestamp   int64  `json:"nextRetryTimestamp"`
+}
+
+func backoff(failures int) int64 {
+	delay := int64(BACKOFF_BASE)
+	for i := 1; i < failures && delay < BACKOFF_MAX; i++ {
+		delay *= 2
+	}
+	if delay > BACKOFF_MAX {
+		delay = BACKOFF_MAX
+	}
+	return delay
+}
+
+func (c *client) onSuccess() {
+	c.requestData.UnsuccessfullAttemptsCount = 0
+	c.requestData.LastSuccessTimestamp = c.requestData.LastAttemptTimestamp
+	c.requestData.NextRetryTimestamp = 0
+}
+
+func (c *client) onFailure(err error) {
+	c.requestData.UnsuccessfullAttemptsCount++
+	c.requestData.LastErrorTimestamp = c.requestData.LastAttemptTimestamp
+	c.requestData.LastError = err.Error()
+	if len(c.requestData.LastError) > MAX_ERROR_LENGTH {
+		c.requestData.LastError = c.requestData.LastError[:MAX_ERROR_LENGTH]
+	}
+	c.requestData.NextRetryTimestamp = time.Now().UnixMilli() + backoff(c.requestData.UnsuccessfullAttemptsCount)
+	integrations.IncreaseRequestFailures(c.integration.Provider)
+}
+
+// updateHealth must be called under c.mux
+func (c *client) updateHealth() {
+	status := HealthOK
+	switch failures := c.requestData.UnsuccessfullAttemptsCount; {
+	case failures >= MAX_ATTEMPTS_IN_A_ROW:
+		status = HealthFailing
+	case failures > 0:
+		status = HealthRetrying
+	}
+	health := Health{
+		ProjectID:            c.integration.ProjectID,
+		Provider:             c.integration.Provider,
+		Status:               status,
+		LastSuccessTimestamp: c.requestData.LastSuccessTimestamp,
+		LastErrorTimestamp:   c.requestData.LastErrorTimestamp,
+		LastError:            c.requestData.LastError,
+		ConsecutiveFailures:  c.requestData.UnsuccessfullAttemptsCount,
+		NextRetryTimestamp:   c.requestData.NextRetryTimestamp,
+	}
+	c.healthMux.Lock()
+	c.health = health
+	c.healthMux.Unlock()
+	integrations.RecordHealth(health.Provider, health.ProjectID, health.ConsecutiveFailures, health.LastSuccessTimestamp)
+}
+
+// Health is thread-safe and doesn't wait for the running request
+func (c *client) Health() Health {
+	c.healthMux.RLock()
+	defer c.healthMux.RUnlock()
+	return c.health
+}
@@ -0,0 +1,131 @@
+package integration
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"openreplay/backend/pkg/metrics/integrations"
+)
+
+const defaultRequestTimeout = 60 * time.Second
+
+// httpOptions are common for all providers and are parsed from the same integration options
+type httpOptions struct {
+	BaseURL            string // self-hosted or regional endpoint, e.g. https://sentry.example.com or https://api.datadoghq.eu
+	CACert             string // PEM encoded CA certificate(s) added to the system pool
+	ClientCert         string // PEM encoded client certificate for mTLS
+	ClientKey          string // PEM encoded client key for mTLS
+	InsecureSkipVerify bool
+	Proxy              string
+	Timeout            int // seconds
+}
+
+func parseHTTPOptions(options json.RawMessage) (*httpOptions, error) {
+	opts := &httpOptions{}
+	if err := json.Unmarshal(options, opts); err != nil {
+		return nil, err
+	}
+	if opts.BaseURL != "" {
+		u, err := url.Parse(opts.BaseURL)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("wrong base url: %s", opts.BaseURL)
+		}
+	}
+	return opts, nil
+}
+
+func (o *httpOptions) tlsConfig() (*tls.Config, error) {
+	cfg := &tls.Config{InsecureSkipVerify: o.InsecureSkipVerify}
+	if o.CACert != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM([]byte(o.CACert)) {
+			return nil, errors.New("can't parse CA certificate")
+		}
+		cfg.RootCAs = pool
+	}
+	if o.ClientCert != "" || o.ClientKey != "" {
+		cert, err := tls.X509KeyPair([]byte(o.ClientCert), []byte(o.ClientKey))
+		if err != nil {
+			return nil, fmt.Errorf("can't parse client certificate: %s", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+func (o *httpOptions) transport() (*http.Transport, error) {
+	tlsConfig, err := o.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	if o.Proxy != "" {
+		proxyURL, err := url.Parse(o.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("wrong proxy url: %s", err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	return transport, nil
+}
+
+// metricsTransport records latency and errors of every request (also made by provider SDKs)
+type metricsTransport struct {
+	provider string
+	next     http.RoundTripper
+}
+
+func (t *metricsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		integrations.IncreaseRequestErrors(t.provider)
+		return nil, err
+	}
+	integrations.RecordRequestDuration(float64(time.Now().Sub(start).Milliseconds()), t.provider, resp.StatusCode)
+	if resp.StatusCode >= 400 {
+		integrations.IncreaseRequestErrors(t.provider)
+	}
+	return resp, nil
+}
+
+// CloseIdleConnections is called by http.Client, the wrapped transport keeps connections
+func (t *metricsTransport) CloseIdleConnections() {
+	if transport, ok := t.next.(interface{ CloseIdleConnections() }); ok {
+		transport.CloseIdleConnections()
+	}
+}
+
+func newHTTPClient(provider string, opts *httpOptions) (*http.Client, error) {
+	transport, err := opts.transport()
+	if err != nil {
+		return nil, err
+	}
+	timeout := defaultRequestTimeout
+	if opts.Timeout > 0 {
+		timeout = time.Duration(opts.Timeout) * time.Second
+	}
+	return &http.Client{
+		Transport: &metricsTransport{provider: provider, next: transport},
+		Timeout:   timeout,
+	}, nil
+}
+
+// baseURL returns custom endpoint if it's set in integration options, otherwise the default (SaaS) one
+func (c *client) baseURL(defaultURL string) string {
+	if c.httpOptions != nil && c.httpOptions.BaseURL != "" {
+		return strings.TrimRight(c.httpOptions.BaseURL, "/")
+	}
+	return defaultURL
+}
@@ -0,0 +1,279 @@
+package integration
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"openreplay/backend/pkg/messages"
+)
+
+/*
+	Generic requester for any log search API which returns JSON.
+	URL and Body are templates with placeholders:
+		{from}, {to} - epoch milliseconds; {fromISO}, {toISO} - RFC3339;
+		{cursor}, {page}, {offset}, {limit} - pagination.
+	Selectors are JSONPath expressions ($.data.items[0].message) applied to the response and to each item.
+*/
+
+const (
+	HTTP_LOGS_PAGE_SIZE = 100
+	HTTP_LOGS_MAX_PAGES = 100
+)
+
+type httpLogs struct {
+	URL             string
+	Method          string // GET (default) or POST
+	Body            string
+	Headers         map[string]string
+	ItemsPath       string // array of log records in the response
+	TimestampPath   string
+	TimestampFormat string // unix_ms (default), unix, unix_ns, rfc3339
+	MessagePath     string
+	TokenPath       string // optional, otherwise the token is searched in the message
+	NamePath        string // optional, otherwise the beginning of the message is used
+	Pagination      string // none (default), cursor, page, offset
+	CursorPath      string // next page cursor in the response (cursor pagination)
+	PageSize        int
+	MaxPages        int
+}
+
+type httpLogsPaths struct {
+	items, timestamp, message, token, name, cursor jsonPath
+}
+
+func (h *httpLogs) parsePaths() (*httpLogsPaths, error) {
+	var (
+		paths = &httpLogsPaths{}
+		err   error
+	)
+	for _, p := range []struct {
+		dst      *jsonPath
+		path     string
+		required bool
+	}{
+		{&paths.items, h.ItemsPath, false},
+		{&paths.timestamp, h.TimestampPath, true},
+		{&paths.message, h.MessagePath, true},
+		{&paths.token, h.TokenPath, false},
+		{&paths.name, h.NamePath, false},
+		{&paths.cursor, h.CursorPath, h.Pagination == "cursor"},
+	} {
+		if p.path == "" {
+			if p.required {
+				return nil, errors.New("HTTP logs: required json path is empty")
+			}
+			continue
+		}
+		if *p.dst, err = parseJSONPath(p.path); err != nil {
+			return nil, fmt.Errorf("HTTP logs: %s", err)
+		}
+	}
+	return paths, nil
+}
+
+func expandTemplate(template string, vars map[string]string, escape func(string) string) string {
+	args := make([]string, 0, len(vars)*2)
+	for key, value := range vars {
+		args = append(args, "{"+key+"}", escape(value))
+	}
+	return strings.NewReplacer(args...).Replace(template)
+}
+
+func jsonEscape(s string) string {
+	data, _ := json.Marshal(s)
+	return string(data[1 : len(data)-1])
+}
+
+func parseTimestamp(value, format string) (uint64, error) {
+	if format == "rfc3339" {
+		t, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return 0, err
+		}
+		return uint64(t.UnixMilli()), nil
+	}
+	var multiplier float64
+	switch format {
+	case "", "unix_ms":
+		multiplier = 1
+	case "unix":
+		multiplier = 1e3
+	case "unix_ns":
+		if ts, err := strconv.ParseUint(value, 10, 64); err == nil {
+			return ts / uint64(time.Millisecond), nil
+		}
+		multiplier = 1e-6
+	default:
+		return 0, fmt.Errorf("unknown timestamp format: %s", format)
+	}
+	if ts, err := strconv.ParseUint(value, 10, 64); err == nil && multiplier >= 1 {
+		return ts * uint64(multiplier), nil
+	}
+	ts, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, err
+	}
+	return uint64(ts * multiplier), nil
+}
+
+func (h *httpLogs) makeRequest(vars map[string]string) (*http.Request, error) {
+	requestURL := expandTemplate(h.URL, vars, url.QueryEscape)
+	method := strings.ToUpper(h.Method)
+	if method == "" {
+		method = "GET"
+	}
+	var body io.Reader
+	if h.Body != "" {
+		body = strings.NewReader(expandTemplate(h.Body, vars, jsonEscape))
+	}
+	req, err := http.NewRequest(method, requestURL, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Accept", "application/json")
+	if body != nil {
+		req.Header.Add("Content-Type", "application/json")
+	}
+	for key, value := range h.Headers {
+		req.Header.Set(key, value)
+	}
+	return req, nil
+}
+
+func (h *httpLogs) Request(c *client) error {
+	if h.URL == "" {
+		return errors.New("HTTP logs: url is empty")
+	}
+	paths, err := h.parsePaths()
+	if err != nil {
+		return err
+	}
+	pageSize, maxPages := h.PageSize, h.MaxPages
+	if pageSize <= 0 {
+		pageSize = HTTP_LOGS_PAGE_SIZE
+	}
+	if maxPages <= 0 {
+		maxPages = HTTP_LOGS_MAX_PAGES
+	}
+	from := time.UnixMilli(int64(c.getLastMessageTimestamp() + 1)) // From next millisecond
+	to := time.Now()
+	vars := map[string]string{
+		"from":    strconv.FormatInt(from.UnixMilli(), 10),
+		"to":      strconv.FormatInt(to.UnixMilli(), 10),
+		"fromISO": from.UTC().Format(time.RFC3339Nano),
+		"toISO":   to.UTC().Format(time.RFC3339Nano),
+		"limit":   strconv.Itoa(pageSize),
+		"cursor":  "",
+		"page":    "1",
+		"offset":  "0",
+	}
+	offset := 0
+	for page := 1; page <= maxPages; page++ {
+		vars["page"], vars["offset"] = strconv.Itoa(page), strconv.Itoa(offset)
+		req, err := h.makeRequest(vars)
+		if err != nil {
+			return err
+		}
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode >= 400 {
+			io.Copy(ioutil.Discard, resp.Body) // Read the body to free socket
+			resp.Body.Close()
+			return fmt.Errorf("HTTP logs: server respond with the code %v", resp.StatusCode)
+		}
+		var data bytes.Buffer
+		_, err = data.ReadFrom(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		decoder := json.NewDecoder(&data)
+		decoder.UseNumber() // nanosecond timestamps don't fit into float64
+		var body interface{}
+		if err := decoder.Decode(&body); err != nil {
+			return err
+		}
+		rawItems, ok := paths.items.get(body)
+		if !ok {
+			return fmt.Errorf("HTTP logs: items not found by path %v", h.ItemsPath)
+		}
+		items, ok := rawItems.([]interface{})
+		if !ok {
+			return fmt.Errorf("HTTP logs: items by path %v are not an array", h.ItemsPath)
+		}
+		for _, item := range items {
+			h.handleItem(c, paths, item)
+		}
+
+		switch h.Pagination {
+		case "cursor":
+			cursor := paths.cursor.getString(body)
+			if cursor == "" || cursor == vars["cursor"] || len(items) == 0 {
+				return nil
+			}
+			vars["cursor"] = cursor
+		case "page", "offset":
+			if len(items) < pageSize {
+				return nil
+			}
+			offset += len(items)
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+func (h *httpLogs) handleItem(c *client, paths *httpLogsPaths, item interface{}) {
+	message := paths.message.getString(item)
+	token := ""
+	if paths.token != nil {
+		token = paths.token.getString(item)
+	}
+	if token == "" {
+		var err error
+		if token, err = GetToken(message); err != nil {
+			c.errChan <- err
+			return
+		}
+	}
+	timestamp, err := parseTimestamp(paths.timestamp.getString(item), h.TimestampFormat)
+	if err != nil {
+		c.errChan <- fmt.Errorf("HTTP logs: can't parse timestamp: %v", err)
+		return
+	}
+	name := ""
+	if paths.name != nil {
+		name = paths.name.getString(item)
+	}
+	if name == "" {
+		name = truncate(message, 100)
+	}
+	payload, err := json.Marshal(item)
+	if err != nil {
+		c.errChan <- err
+		return
+	}
+	c.setLastMessageTimestamp(timestamp)
+	c.evChan <- &SessionErrorEvent{
+		Token: token,
+		IntegrationEvent: &messages.IntegrationEvent{
+			Source:    "http_logs",
+			Timestamp: timestamp,
+			Name:      name,
+			Message:   message,
+			Payload:   string(payload),
+		},
+	}
+}
@@ -0,0 +1,100 @@
+package integration
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// jsonPath is a minimal JSONPath subset for decoded JSON values: $.a.b, $.items[0].c, $['key.with.dots']
+type jsonPath []interface{} // string keys or int indexes
+
+func parseJSONPath(path string) (jsonPath, error) {
+	path = strings.TrimSpace(path)
+	if path == "" || path == "$" {
+		return jsonPath{}, nil
+	}
+	if !strings.HasPrefix(path, "$") {
+		path = "$." + path
+	}
+	var res jsonPath
+	for i := 1; i < len(path); {
+		switch path[i] {
+		case '.':
+			end := i + 1
+			for end < len(path) && path[end] != '.' && path[end] != '[' {
+				end++
+			}
+			if end == i+1 {
+				return nil, fmt.Errorf("empty key in json path %q", path)
+			}
+			res = append(res, path[i+1:end])
+			i = end
+		case '[':
+			end := strings.IndexByte(path[i:], ']')
+			if end == -1 {
+				return nil, fmt.Errorf("unclosed bracket in json path %q", path)
+			}
+			token := path[i+1 : i+end]
+			if len(token) >= 2 && (token[0] == '\'' || token[0] == '"') && token[len(token)-1] == token[0] {
+				res = append(res, token[1:len(token)-1])
+			} else if idx, err := strconv.Atoi(token); err == nil {
+				res = append(res, idx)
+			} else {
+				return nil, fmt.Errorf("wrong selector %q in json path %q", token, path)
+			}
+			i += end + 1
+		default:
+			return nil, fmt.Errorf("unexpected symbol %q in json path %q", path[i], path)
+		}
+	}
+	return res, nil
+}
+
+func (p jsonPath) get(value interface{}) (interface{}, bool) {
+	for _, step := range p {
+		switch key := step.(type) {
+		case string:
+			obj, ok := value.(map[string]interface{})
+			if !ok {
+				return nil, false
+			}
+			if value, ok = obj[key]; !ok {
+				return nil, false
+			}
+		case int:
+			arr, ok := value.([]interface{})
+			if !ok {
+				return nil, false
+			}
+			if key < 0 {
+				key += len(arr)
+			}
+			if key < 0 || key >= len(arr) {
+				return nil, false
+			}
+			value = arr[key]
+		}
+	}
+	return value, true
+}
+
+// getString returns string representation of scalar value
+func (p jsonPath) getString(value interface{}) string {
+	v, ok := p.get(value)
+	if !ok || v == nil {
+		return ""
+	}
+	switch val := v.(type) {
+	case string:
+		return val
+	case json.Number:
+		return val.String()
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(val)
+	}
+	return fmt.Sprint(v)
+}
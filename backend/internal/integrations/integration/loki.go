@@ -0,0 +1,154 @@
+package integration
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"openreplay/backend/pkg/messages"
+)
+
+/*
+	Grafana Loki query_range API: https://grafana.com/docs/loki/latest/reference/api/#query-logs-within-a-range-of-time
+	Loki has no default host, so baseUrl option is required (e.g. https://logs-prod-eu-west-0.grafana.net)
+*/
+
+const LOKI_LIMIT = 1000
+const LOKI_FILTER = ` |= "openReplaySessionToken" |~ "(?i)error|exception"`
+
+type loki struct {
+	Selector string // stream selector, e.g. {app="backend"}
+	Username string // basic auth (Grafana Cloud user id)
+	Password string
+	Token    string // bearer token
+	OrgId    string // X-Scope-OrgID header for multi-tenant Loki
+}
+
+type lokiResponse struct {
+	Status string
+	Data   struct {
+		Result []struct {
+			Stream map[string]string
+			Values [][2]string // [<unix epoch in nanoseconds>, <log line>]
+		}
+	}
+}
+
+type lokiEvent struct {
+	Stream    map[string]string `json:"stream"`
+	Line      string            `json:"line"`
+	Timestamp string            `json:"timestamp"`
+}
+
+func (l *loki) makeRequest(baseURL string, startNs, endNs int64) (*http.Request, error) {
+	q := url.Values{}
+	q.Add("query", l.Selector+LOKI_FILTER)
+	q.Add("start", strconv.FormatInt(startNs, 10))
+	q.Add("end", strconv.FormatInt(endNs, 10))
+	q.Add("limit", strconv.Itoa(LOKI_LIMIT))
+	q.Add("direction", "forward")
+	req, err := http.NewRequest("GET", baseURL+"/loki/api/v1/query_range?"+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if l.Token != "" {
+		req.Header.Add("Authorization", "Bearer "+l.Token)
+	} else if l.Username != "" {
+		req.SetBasicAuth(l.Username, l.Password)
+	}
+	if l.OrgId != "" {
+		req.Header.Add("X-Scope-OrgID", l.OrgId)
+	}
+	return req, nil
+}
+
+func (l *loki) Request(c *client) error {
+	baseURL := c.baseURL("")
+	if baseURL == "" {
+		return errors.New("Loki: baseUrl is empty")
+	}
+	if l.Selector == "" {
+		return errors.New("Loki: selector is empty")
+	}
+	startNs := int64(c.getLastMessageTimestamp()+1) * int64(time.Millisecond) // From next millisecond
+	endNs := time.Now().UnixNano()
+	for startNs < endNs {
+		req, err := l.makeRequest(baseURL, startNs, endNs)
+		if err != nil {
+			return err
+		}
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode >= 400 {
+			io.Copy(ioutil.Discard, resp.Body) // Read the body to free socket
+			resp.Body.Close()
+			return fmt.Errorf("Loki: server respond with the code %v", resp.StatusCode)
+		}
+		var lokiResp lokiResponse
+		err = json.NewDecoder(resp.Body).Decode(&lokiResp)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		if lokiResp.Status != "success" {
+			return fmt.Errorf("Loki: query status %v", lokiResp.Status)
+		}
+
+		count, lastNs := 0, startNs
+		for _, stream := range lokiResp.Data.Result {
+			for _, value := range stream.Values {
+				count++
+				ts, err := strconv.ParseInt(value[0], 10, 64)
+				if err != nil {
+					c.errChan <- fmt.Errorf("Loki: wrong timestamp %v", value[0])
+					continue
+				}
+				if ts > lastNs {
+					lastNs = ts
+				}
+				token, err := GetToken(value[1])
+				if err != nil {
+					c.errChan <- err
+					continue
+				}
+				payload, err := json.Marshal(&lokiEvent{Stream: stream.Stream, Line: value[1], Timestamp: value[0]})
+				if err != nil {
+					c.errChan <- err
+					continue
+				}
+				timestamp := uint64(ts / int64(time.Millisecond))
+				c.setLastMessageTimestamp(timestamp)
+				c.evChan <- &SessionErrorEvent{
+					Token: token,
+					IntegrationEvent: &messages.IntegrationEvent{
+						Source:    "loki",
+						Timestamp: timestamp,
+						Name:      truncate(value[1], 100),
+						Payload:   string(payload),
+					},
+				}
+			}
+		}
+		// Results are limited by the number of lines, not streams, so the next page starts after the last line
+		if count < LOKI_LIMIT {
+			break
+		}
+		startNs = lastNs + 1
+	}
+	return nil
+}
+
+func truncate(s string, size int) string {
+	if len(s) <= size {
+		return s
+	}
+	return s[:size]
+}
@@ -42,7 +42,7 @@ func (nr *newrelic) Request(c *client) error {
 	// In docs - format "yyyy-mm-dd HH:MM:ss", but time.RFC3339 works fine too
 	sinceFormatted := time.UnixMilli(int64(sinceTs)).Format(time.RFC3339)
 	// US/EU endpoint ??
-	requestURL := fmt.Sprintf("https://insights-api.eu.newrelic.com/v1/accounts/%v/query", nr.ApplicationId)
+	requestURL := fmt.Sprintf("%v/v1/accounts/%v/query", c.baseURL("https://insights-api.eu.newrelic.com"), nr.ApplicationId)
 	req, err := http.NewRequest("GET", requestURL, nil)
 	if err != nil {
 		return err
@@ -57,7 +57,7 @@ func (nr *newrelic) Request(c *client) error {
 	req.Header.Add("X-Query-Key", nr.XQueryKey)
 	req.Header.Add("Accept", "application/json")
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		return err
 	}
@@ -67,7 +67,7 @@ func (nr *newrelic) Request(c *client) error {
 	// 400 if Query has problems
 	if resp.StatusCode >= 400 {
 		io.Copy(ioutil.Discard, resp.Body) // Read the body to free socket
-		return fmt.Errorf("Newrelic: server respond with the code %v| Request: ", resp.StatusCode, *req)
+		return fmt.Errorf("Newrelic: server respond with the code %v| Request: %v", resp.StatusCode, req.URL)
 	}
 	// Pagination depending on returning metadata ?
 	var nrResp newrelicResponce
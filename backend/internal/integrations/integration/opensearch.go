@@ -0,0 +1,167 @@
+package integration
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"openreplay/backend/pkg/messages"
+)
+
+/*
+	OpenSearch _search API with search_after pagination (scroll isn't recommended for deep pagination).
+	baseUrl option is required (e.g. https://search-logs.eu-west-1.es.amazonaws.com)
+*/
+
+const OS_PAGE_SIZE = 1000
+
+type opensearch struct {
+	Indexes        string
+	Username       string
+	Password       string
+	TimestampField string // default: @timestamp
+	MessageField   string // default: message
+}
+
+type opensearchResponse struct {
+	Hits struct {
+		Hits []struct {
+			ID     string                 `json:"_id"`
+			Source map[string]interface{} `json:"_source"`
+			Sort   []interface{}          `json:"sort"`
+		} `json:"hits"`
+	} `json:"hits"`
+}
+
+func (o *opensearch) fields() (string, string) {
+	timestampField, messageField := o.TimestampField, o.MessageField
+	if timestampField == "" {
+		timestampField = "@timestamp"
+	}
+	if messageField == "" {
+		messageField = "message"
+	}
+	return timestampField, messageField
+}
+
+func (o *opensearch) makeRequest(baseURL string, fromTs uint64, searchAfter []interface{}) (*http.Request, error) {
+	timestampField, messageField := o.fields()
+	query := map[string]interface{}{
+		"size": OS_PAGE_SIZE,
+		"query": map[string]interface{}{
+			"bool": map[string]interface{}{
+				"filter": []map[string]interface{}{
+					{"match_phrase": map[string]interface{}{messageField: "openReplaySessionToken"}},
+					{"range": map[string]interface{}{timestampField: map[string]interface{}{
+						"gte":    fromTs,
+						"format": "epoch_millis",
+					}}},
+				},
+			},
+		},
+		"sort": []map[string]interface{}{
+			{timestampField: "asc"},
+		},
+	}
+	if len(searchAfter) > 0 {
+		query["search_after"] = searchAfter
+	}
+	body, err := json.Marshal(query)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", fmt.Sprintf("%v/%v/_search", baseURL, o.Indexes), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Content-Type", "application/json")
+	if o.Username != "" {
+		req.SetBasicAuth(o.Username, o.Password)
+	}
+	return req, nil
+}
+
+func parseLogTimestamp(value interface{}) (time.Time, error) {
+	switch v := value.(type) {
+	case string:
+		return time.Parse(time.RFC3339Nano, v)
+	case float64:
+		return time.UnixMilli(int64(v)), nil
+	}
+	return time.Time{}, fmt.Errorf("unsupported timestamp: %v", value)
+}
+
+func (o *opensearch) Request(c *client) error {
+	baseURL := c.baseURL("")
+	if baseURL == "" {
+		return errors.New("OpenSearch: baseUrl is empty")
+	}
+	if o.Indexes == "" {
+		return errors.New("OpenSearch: indexes are empty")
+	}
+	timestampField, messageField := o.fields()
+	fromTs := c.getLastMessageTimestamp() + 1 // From next millisecond
+	var searchAfter []interface{}
+	for {
+		req, err := o.makeRequest(baseURL, fromTs, searchAfter)
+		if err != nil {
+			return err
+		}
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode >= 400 {
+			io.Copy(ioutil.Discard, resp.Body) // Read the body to free socket
+			resp.Body.Close()
+			return fmt.Errorf("OpenSearch: server respond with the code %v", resp.StatusCode)
+		}
+		var osResp opensearchResponse
+		err = json.NewDecoder(resp.Body).Decode(&osResp)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		hits := osResp.Hits.Hits
+		for _, hit := range hits {
+			message, _ := hit.Source[messageField].(string)
+			token, err := GetToken(message)
+			if err != nil {
+				c.errChan <- err
+				continue
+			}
+			parsedTime, err := parseLogTimestamp(hit.Source[timestampField])
+			if err != nil {
+				c.errChan <- fmt.Errorf("OpenSearch: %v | doc: %v", err, hit.ID)
+				continue
+			}
+			payload, err := json.Marshal(hit.Source)
+			if err != nil {
+				c.errChan <- err
+				continue
+			}
+			timestamp := uint64(parsedTime.UnixMilli())
+			c.setLastMessageTimestamp(timestamp)
+			c.evChan <- &SessionErrorEvent{
+				Token: token,
+				IntegrationEvent: &messages.IntegrationEvent{
+					Source:    "opensearch",
+					Timestamp: timestamp,
+					Name:      hit.ID,
+					Message:   message,
+					Payload:   string(payload),
+				},
+			}
+		}
+		if len(hits) < OS_PAGE_SIZE {
+			break
+		}
+		searchAfter = hits[len(hits)-1].Sort
+	}
+	return nil
+}
@@ -72,14 +72,15 @@ func (rb *rollbar) Request(c *client) error {
 		"access_token": "%v",
 		"query_string": "%v"
 	}`, rb.AccessToken, query)
-	req, err := http.NewRequest("POST", "https://api.rollbar.com/api/1/rql/jobs", strings.NewReader(jsonBody))
+	baseURL := c.baseURL("https://api.rollbar.com")
+	req, err := http.NewRequest("POST", baseURL+"/api/1/rql/jobs", strings.NewReader(jsonBody))
 	if err != nil {
 		return err
 	}
 	req.Header.Add("Content-Type", "application/json")
 	req.Header.Add("Accept", "application/json")
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		return err
 	}
@@ -100,7 +101,8 @@ func (rb *rollbar) Request(c *client) error {
 	}
 
 	requestURL := fmt.Sprintf(
-		"https://api.rollbar.com/api/1/rql/job/%v?access_token=%v&expand=result",
+		"%v/api/1/rql/job/%v?access_token=%v&expand=result",
+		baseURL,
 		jobResponce.Result.Id,
 		rb.AccessToken,
 	)
@@ -112,7 +114,7 @@ func (rb *rollbar) Request(c *client) error {
 	tick := time.Tick(5 * time.Second)
 	for {
 		<-tick
-		resp, err = http.DefaultClient.Do(req)
+		resp, err = c.httpClient.Do(req)
 		if err != nil {
 			return err // continue + timeout/maxAttempts
 		}
@@ -37,7 +37,7 @@ type sentryEvent struct {
 }
 
 func (sn *sentry) Request(c *client) error {
-	requestURL := fmt.Sprintf("https://sentry.io/api/0/projects/%v/%v/events/", sn.OrganizationSlug, sn.ProjectSlug)
+	requestURL := fmt.Sprintf("%v/api/0/projects/%v/%v/events/", c.baseURL("https://sentry.io"), sn.OrganizationSlug, sn.ProjectSlug)
 	req, err := http.NewRequest("GET", requestURL, nil)
 	if err != nil {
 		return err
@@ -51,7 +51,7 @@ func (sn *sentry) Request(c *client) error {
 
 PageLoop:
 	for {
-		resp, err := http.DefaultClient.Do(req)
+		resp, err := c.httpClient.Do(req)
 		if err != nil {
 			return err
 		}
@@ -9,6 +9,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net/url"
 	"time"
 
 	"openreplay/backend/pkg/messages"
@@ -43,8 +44,14 @@ func (sd *stackdriver) Request(c *client) error {
 		return err
 	}
 
-	opt := option.WithCredentialsJSON([]byte(sd.ServiceAccountCredentials))
-	client, err := logadmin.NewClient(ctx, parsedCreds.ProjectId, opt)
+	opts := []option.ClientOption{option.WithCredentialsJSON([]byte(sd.ServiceAccountCredentials))}
+	// Logging API uses gRPC, so only host:port of the base url is used
+	if baseURL := c.baseURL(""); baseURL != "" {
+		if u, err := url.Parse(baseURL); err == nil {
+			opts = append(opts, option.WithEndpoint(u.Host))
+		}
+	}
+	client, err := logadmin.NewClient(ctx, parsedCreds.ProjectId, opts...)
 	if err != nil {
 		return err
 	}
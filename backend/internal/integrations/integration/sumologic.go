@@ -20,6 +20,9 @@ import (
 */
 const SL_LIMIT = 10000
 
+// Default deployment, other regions are set with the base url (e.g. https://api.us2.sumologic.com)
+const SL_BASE_URL = "https://api.eu.sumologic.com"
+
 type sumologic struct {
 	AccessId  string // `json:"access_id"`
 	AccessKey string // `json:"access_key"`
@@ -47,8 +50,9 @@ type sumologicEvent struct {
 	Raw       string `json:"_raw"`
 }
 
-func (sl *sumologic) deleteJob(jobId string, errChan chan<- error) {
-	requestURL := fmt.Sprintf("https://api.%vsumologic.com/api/v1/search/jobs/%v", "eu.", jobId)
+func (sl *sumologic) deleteJob(c *client, jobId string) {
+	errChan := c.errChan
+	requestURL := fmt.Sprintf("%v/api/v1/search/jobs/%v", c.baseURL(SL_BASE_URL), jobId)
 	req, err := http.NewRequest("DELETE", requestURL, nil)
 	if err != nil {
 		errChan <- fmt.Errorf("Error on DELETE request creation: %v", err)
@@ -57,7 +61,7 @@ func (sl *sumologic) deleteJob(jobId string, errChan chan<- error) {
 	req.Header.Add("Content-Type", "application/json")
 	req.Header.Add("Accept", "application/json")
 	req.SetBasicAuth(sl.AccessId, sl.AccessKey)
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		errChan <- fmt.Errorf("Error on DELETE request: %v", err)
 		return
@@ -69,7 +73,7 @@ func (sl *sumologic) deleteJob(jobId string, errChan chan<- error) {
 func (sl *sumologic) Request(c *client) error {
 	fromTs := c.getLastMessageTimestamp() + 1 // From next millisecond
 	toTs := time.Now().UnixMilli()
-	requestURL := fmt.Sprintf("https://api.%vsumologic.com/api/v1/search/jobs", "eu.") // deployment server??
+	requestURL := fmt.Sprintf("%v/api/v1/search/jobs", c.baseURL(SL_BASE_URL)) // deployment server??
 	jsonBody := fmt.Sprintf(`{
 		"query": "\"openReplaySessionToken=\" AND (*error* OR *fail* OR *exception*)",
 		"from": %v,
@@ -94,7 +98,7 @@ func (sl *sumologic) Request(c *client) error {
 	req.Header.Add("Accept", "application/json")
 	req.SetBasicAuth(sl.AccessId, sl.AccessKey)
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		return fmt.Errorf("Error while requesting search job start: %v", err)
 	}
@@ -105,7 +109,7 @@ func (sl *sumologic) Request(c *client) error {
 	// responce body is NOT the same as in docs (look at the sumologic_job_start.json)
 	if resp.StatusCode >= 400 {
 		io.Copy(ioutil.Discard, resp.Body) // Read the body to free socket
-		return fmt.Errorf("Sumologic: server respond with the code %v | req %v |Resp: %v", resp.StatusCode, *req, *resp)
+		return fmt.Errorf("Sumologic: server respond with the code %v | url: %v", resp.StatusCode, req.URL)
 	}
 	sl.cookies = resp.Cookies()
 
@@ -114,9 +118,9 @@ func (sl *sumologic) Request(c *client) error {
 		return fmt.Errorf("Error on parsing responce: %v", err)
 	}
 
-	defer sl.deleteJob(jobResponce.Id, c.errChan)
+	defer sl.deleteJob(c, jobResponce.Id)
 
-	requestURL = fmt.Sprintf("https://api.%vsumologic.com/api/v1/search/jobs/%v", "eu.", jobResponce.Id)
+	requestURL = fmt.Sprintf("%v/api/v1/search/jobs/%v", c.baseURL(SL_BASE_URL), jobResponce.Id)
 	req, err = http.NewRequest("GET", requestURL, nil)
 	if err != nil {
 		return err
@@ -130,7 +134,7 @@ func (sl *sumologic) Request(c *client) error {
 	tick := time.Tick(5 * time.Second)
 	for {
 		<-tick
-		resp, err = http.DefaultClient.Do(req)
+		resp, err = c.httpClient.Do(req)
 		if err != nil {
 			return err // TODO: retry, counter/timeout
 		}
@@ -146,8 +150,8 @@ func (sl *sumologic) Request(c *client) error {
 			offset := 0
 			for offset < jobStatus.MessageCount {
 				requestURL = fmt.Sprintf(
-					"https://api.%vsumologic.com/api/v1/search/jobs/%v/messages?offset=%v&limit=%v",
-					"eu.",
+					"%v/api/v1/search/jobs/%v/messages?offset=%v&limit=%v",
+					c.baseURL(SL_BASE_URL),
 					jobResponce.Id,
 					offset,
 					SL_LIMIT,
@@ -161,7 +165,7 @@ func (sl *sumologic) Request(c *client) error {
 				for _, cookie := range sl.cookies {
 					req.AddCookie(cookie)
 				}
-				resp, err = http.DefaultClient.Do(req)
+				resp, err = c.httpClient.Do(req)
 				if err != nil {
 					return err
 				}
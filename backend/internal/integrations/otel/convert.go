@@ -0,0 +1,276 @@
+package otel
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+
+	"openreplay/backend/internal/integrations/integration"
+	"openreplay/backend/pkg/messages"
+)
+
+const source = "otel"
+
+// Attributes used to link spans and logs with the session (checked on span/log level first, then on resource level)
+var (
+	tokenAttributes     = []string{"openreplay.session.token", "openReplaySessionToken"}
+	sessionIDAttributes = []string{"openreplay.session.id", "asayer_session_id"}
+)
+
+func attrValue(v *commonpb.AnyValue) string {
+	switch val := v.GetValue().(type) {
+	case *commonpb.AnyValue_StringValue:
+		return val.StringValue
+	case *commonpb.AnyValue_IntValue:
+		return strconv.FormatInt(val.IntValue, 10)
+	case *commonpb.AnyValue_DoubleValue:
+		return strconv.FormatFloat(val.DoubleValue, 'f', -1, 64)
+	case *commonpb.AnyValue_BoolValue:
+		return strconv.FormatBool(val.BoolValue)
+	case nil:
+		return ""
+	}
+	return fmt.Sprint(v)
+}
+
+func findAttr(attrs []*commonpb.KeyValue, keys ...string) string {
+	for _, key := range keys {
+		for _, attr := range attrs {
+			if attr.GetKey() == key {
+				return attrValue(attr.GetValue())
+			}
+		}
+	}
+	return ""
+}
+
+type session struct {
+	token string
+	id    uint64
+}
+
+func findSession(attrs ...[]*commonpb.KeyValue) (session, bool) {
+	for _, list := range attrs {
+		if token := findAttr(list, tokenAttributes...); token != "" {
+			return session{token: token}, true
+		}
+		if id, err := strconv.ParseUint(findAttr(list, sessionIDAttributes...), 10, 64); err == nil && id != 0 {
+			return session{id: id}, true
+		}
+	}
+	return session{}, false
+}
+
+func attrsMap(attrs []*commonpb.KeyValue) map[string]string {
+	res := make(map[string]string, len(attrs))
+	for _, attr := range attrs {
+		res[attr.GetKey()] = attrValue(attr.GetValue())
+	}
+	return res
+}
+
+func newEvent(s session, name, message string, timestampNano uint64, payload interface{}) (*integration.SessionErrorEvent, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	timestamp := timestampNano / uint64(time.Millisecond)
+	if timestamp == 0 {
+		timestamp = uint64(time.Now().UnixMilli())
+	}
+	return &integration.SessionErrorEvent{
+		SessionID: s.id,
+		Token:     s.token,
+		IntegrationEvent: &messages.IntegrationEvent{
+			Source:    source,
+			Timestamp: timestamp,
+			Name:      name,
+			Message:   message,
+			Payload:   string(data),
+		},
+	}, nil
+}
+
+// spanPayload keeps trace ids and http attributes, so the frontend can link the event with a network request
+type spanPayload struct {
+	TraceID    string            `json:"traceId"`
+	SpanID     string            `json:"spanId"`
+	Name       string            `json:"name"`
+	Service    string            `json:"service,omitempty"`
+	Method     string            `json:"method,omitempty"`
+	URL        string            `json:"url,omitempty"`
+	Status     string            `json:"status,omitempty"`
+	Duration   uint64            `json:"duration"` // milliseconds
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+func newSpanPayload(span *tracepb.Span, service string) *spanPayload {
+	attrs := span.GetAttributes()
+	duration := uint64(0)
+	if span.GetEndTimeUnixNano() > span.GetStartTimeUnixNano() {
+		duration = (span.GetEndTimeUnixNano() - span.GetStartTimeUnixNano()) / uint64(time.Millisecond)
+	}
+	return &spanPayload{
+		TraceID:    hex.EncodeToString(span.GetTraceId()),
+		SpanID:     hex.EncodeToString(span.GetSpanId()),
+		Name:       span.GetName(),
+		Service:    service,
+		Method:     findAttr(attrs, "http.request.method", "http.method"),
+		URL:        findAttr(attrs, "url.full", "http.url", "http.target", "url.path"),
+		Status:     findAttr(attrs, "http.response.status_code", "http.status_code"),
+		Duration:   duration,
+		Attributes: attrsMap(attrs),
+	}
+}
+
+func spanURL(span *tracepb.Span, service string) string {
+	attrs := span.GetAttributes()
+	if u := findAttr(attrs, "url.full", "http.url"); u != "" {
+		return u
+	}
+	scheme := findAttr(attrs, "url.scheme", "http.scheme")
+	if scheme == "" {
+		scheme = "http"
+	}
+	host := findAttr(attrs, "server.address", "http.host", "net.host.name")
+	if host == "" {
+		host = service
+	}
+	return scheme + "://" + host + findAttr(attrs, "url.path", "http.target", "http.route")
+}
+
+// newNetworkEvent converts slow backend span into network request shown in the session timeline
+func newNetworkEvent(s session, span *tracepb.Span, service string) *integration.SessionErrorEvent {
+	attrs := span.GetAttributes()
+	status, _ := strconv.ParseUint(findAttr(attrs, "http.response.status_code", "http.status_code"), 10, 64)
+	return &integration.SessionErrorEvent{
+		SessionID: s.id,
+		Token:     s.token,
+		NetworkRequest: &messages.NetworkRequest{
+			Type:      source,
+			Method:    findAttr(attrs, "http.request.method", "http.method"),
+			URL:       spanURL(span, service),
+			Status:    status,
+			Timestamp: span.GetStartTimeUnixNano() / uint64(time.Millisecond),
+			Duration:  (span.GetEndTimeUnixNano() - span.GetStartTimeUnixNano()) / uint64(time.Millisecond),
+		},
+	}
+}
+
+// spanException returns exception type and message from the span "exception" event
+func spanException(span *tracepb.Span) (string, string, bool) {
+	for _, e := range span.GetEvents() {
+		if e.GetName() == "exception" {
+			return findAttr(e.GetAttributes(), "exception.type"), findAttr(e.GetAttributes(), "exception.message"), true
+		}
+	}
+	return "", "", false
+}
+
+// spansToEvents converts error spans into error events and slow server spans into network requests
+func spansToEvents(req []*tracepb.ResourceSpans, slowThreshold time.Duration) ([]*integration.SessionErrorEvent, error) {
+	var events []*integration.SessionErrorEvent
+	for _, rs := range req {
+		resourceAttrs := rs.GetResource().GetAttributes()
+		service := findAttr(resourceAttrs, "service.name")
+		for _, ss := range rs.GetScopeSpans() {
+			for _, span := range ss.GetSpans() {
+				s, ok := findSession(span.GetAttributes(), resourceAttrs)
+				if !ok {
+					continue
+				}
+				excType, excMessage, hasException := spanException(span)
+				isError := span.GetStatus().GetCode() == tracepb.Status_STATUS_CODE_ERROR || hasException
+				duration := time.Duration(span.GetEndTimeUnixNano() - span.GetStartTimeUnixNano())
+				var (
+					event *integration.SessionErrorEvent
+					err   error
+				)
+				switch {
+				case isError:
+					name := excType
+					if name == "" {
+						name = span.GetName()
+					}
+					message := excMessage
+					if message == "" {
+						message = span.GetStatus().GetMessage()
+					}
+					event, err = newEvent(s, name, message, span.GetStartTimeUnixNano(), newSpanPayload(span, service))
+				case span.GetKind() == tracepb.Span_SPAN_KIND_SERVER && slowThreshold > 0 &&
+					span.GetEndTimeUnixNano() > span.GetStartTimeUnixNano() && duration >= slowThreshold:
+					event = newNetworkEvent(s, span, service)
+				default:
+					continue
+				}
+				if err != nil {
+					return nil, err
+				}
+				events = append(events, event)
+			}
+		}
+	}
+	return events, nil
+}
+
+type logPayload struct {
+	TraceID    string            `json:"traceId,omitempty"`
+	SpanID     string            `json:"spanId,omitempty"`
+	Severity   string            `json:"severity"`
+	Service    string            `json:"service,omitempty"`
+	Body       string            `json:"body"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// logsToEvents converts log records with ERROR severity or higher into error events
+func logsToEvents(req []*logspb.ResourceLogs) ([]*integration.SessionErrorEvent, error) {
+	var events []*integration.SessionErrorEvent
+	for _, rl := range req {
+		resourceAttrs := rl.GetResource().GetAttributes()
+		service := findAttr(resourceAttrs, "service.name")
+		for _, sl := range rl.GetScopeLogs() {
+			for _, record := range sl.GetLogRecords() {
+				if record.GetSeverityNumber() < logspb.SeverityNumber_SEVERITY_NUMBER_ERROR {
+					continue
+				}
+				body := attrValue(record.GetBody())
+				s, ok := findSession(record.GetAttributes(), resourceAttrs)
+				if !ok {
+					// Same as polled integrations, the token could be written into the message
+					token, err := integration.GetToken(body)
+					if err != nil {
+						continue
+					}
+					s = session{token: token}
+				}
+				timestamp := record.GetTimeUnixNano()
+				if timestamp == 0 {
+					timestamp = record.GetObservedTimeUnixNano()
+				}
+				name := findAttr(record.GetAttributes(), "exception.type")
+				if name == "" {
+					name = record.GetSeverityText()
+				}
+				event, err := newEvent(s, name, body, timestamp, &logPayload{
+					TraceID:    hex.EncodeToString(record.GetTraceId()),
+					SpanID:     hex.EncodeToString(record.GetSpanId()),
+					Severity:   record.GetSeverityNumber().String(),
+					Service:    service,
+					Body:       body,
+					Attributes: attrsMap(record.GetAttributes()),
+				})
+				if err != nil {
+					return nil, err
+				}
+				events = append(events, event)
+			}
+		}
+	}
+	return events, nil
+}
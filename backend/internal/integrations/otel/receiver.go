@@ -0,0 +1,202 @@
+package otel
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	config "openreplay/backend/internal/config/integrations"
+	"openreplay/backend/internal/http/util"
+	"openreplay/backend/internal/integrations/integration"
+)
+
+const (
+	contentTypeProtobuf = "application/x-protobuf"
+	contentTypeJSON     = "application/json"
+)
+
+// Receiver implements OTLP/HTTP endpoints (/v1/traces and /v1/logs)
+type Receiver struct {
+	mux           *http.ServeMux
+	authToken     string
+	bodyLimit     int64
+	slowThreshold time.Duration
+	events        chan<- *integration.SessionErrorEvent
+}
+
+func NewReceiver(cfg *config.Config, events chan<- *integration.SessionErrorEvent) (*Receiver, error) {
+	switch {
+	case cfg == nil:
+		return nil, errors.New("config is empty")
+	case events == nil:
+		return nil, errors.New("events channel is empty")
+	case cfg.OTLPAuthToken == "":
+		return nil, errors.New("auth token is empty")
+	}
+	r := &Receiver{
+		mux:           http.NewServeMux(),
+		authToken:     cfg.OTLPAuthToken,
+		bodyLimit:     cfg.OTLPBodyLimit,
+		slowThreshold: cfg.OTLPSlowSpanThreshold,
+		events:        events,
+	}
+	r.mux.Handle("/v1/traces", util.BearerAuth(r.authToken, http.HandlerFunc(r.handleTraces)))
+	r.mux.Handle("/v1/logs", util.BearerAuth(r.authToken, http.HandlerFunc(r.handleLogs)))
+	return r, nil
+}
+
+func (r *Receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mux.ServeHTTP(w, req)
+}
+
+func (r *Receiver) handleTraces(w http.ResponseWriter, req *http.Request) {
+	request := &coltracepb.ExportTraceServiceRequest{}
+	contentType, ok := r.readRequest(w, req, request)
+	if !ok {
+		return
+	}
+	events, err := spansToEvents(request.GetResourceSpans(), r.slowThreshold)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	r.sendEvents(w, req, events, contentType, &coltracepb.ExportTraceServiceResponse{})
+}
+
+func (r *Receiver) handleLogs(w http.ResponseWriter, req *http.Request) {
+	request := &collogspb.ExportLogsServiceRequest{}
+	contentType, ok := r.readRequest(w, req, request)
+	if !ok {
+		return
+	}
+	events, err := logsToEvents(request.GetResourceLogs())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	r.sendEvents(w, req, events, contentType, &collogspb.ExportLogsServiceResponse{})
+}
+
+// readRequest decodes protobuf or json body, writes the error response on failure
+func (r *Receiver) readRequest(w http.ResponseWriter, req *http.Request, msg proto.Message) (string, bool) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return "", false
+	}
+	var body io.Reader = req.Body
+	if req.Header.Get("Content-Encoding") == "gzip" {
+		gr, err := gzip.NewReader(req.Body)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("can't read gzip body: %s", err), http.StatusBadRequest)
+			return "", false
+		}
+		defer gr.Close()
+		body = gr
+	}
+	data, err := io.ReadAll(io.LimitReader(body, r.bodyLimit+1))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("can't read body: %s", err), http.StatusBadRequest)
+		return "", false
+	}
+	if int64(len(data)) > r.bodyLimit {
+		http.Error(w, "request entity too large", http.StatusRequestEntityTooLarge)
+		return "", false
+	}
+
+	contentType := req.Header.Get("Content-Type")
+	switch {
+	case strings.HasPrefix(contentType, contentTypeProtobuf):
+		contentType = contentTypeProtobuf
+		err = proto.Unmarshal(data, msg)
+	case strings.HasPrefix(contentType, contentTypeJSON):
+		contentType = contentTypeJSON
+		err = unmarshalJSON(data, msg)
+	default:
+		http.Error(w, "unsupported content type", http.StatusUnsupportedMediaType)
+		return "", false
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("can't decode request: %s", err), http.StatusBadRequest)
+		return "", false
+	}
+	return contentType, true
+}
+
+func (r *Receiver) sendEvents(w http.ResponseWriter, req *http.Request, events []*integration.SessionErrorEvent,
+	contentType string, response proto.Message) {
+	for _, event := range events {
+		select {
+		case r.events <- event:
+		case <-req.Context().Done():
+			http.Error(w, "service is busy", http.StatusServiceUnavailable)
+			return
+		}
+	}
+	var (
+		data []byte
+		err  error
+	)
+	if contentType == contentTypeJSON {
+		data, err = protojson.Marshal(response)
+	} else {
+		data, err = proto.Marshal(response)
+	}
+	if err != nil {
+		log.Printf("can't encode otlp response: %s", err)
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// OTLP/JSON encodes trace and span ids as hex strings instead of base64 (protojson default)
+var idFields = map[string]bool{"traceId": true, "spanId": true, "parentSpanId": true}
+
+func unmarshalJSON(data []byte, msg proto.Message) error {
+	// UseNumber keeps nanosecond timestamps without float precision loss
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var raw interface{}
+	if err := decoder.Decode(&raw); err != nil {
+		return err
+	}
+	convertIDs(raw)
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return err
+	}
+	return protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(data, msg)
+}
+
+func convertIDs(value interface{}) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			if s, ok := item.(string); ok && idFields[key] {
+				if id, err := hex.DecodeString(s); err == nil {
+					v[key] = base64.StdEncoding.EncodeToString(id)
+				}
+				continue
+			}
+			convertIDs(item)
+		}
+	case []interface{}:
+		for _, item := range v {
+			convertIDs(item)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package otel
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+
+	config "openreplay/backend/internal/config/integrations"
+	"openreplay/backend/internal/integrations/integration"
+)
+
+func newTestReceiver(t *testing.T) (*Receiver, chan *integration.SessionErrorEvent) {
+	events := make(chan *integration.SessionErrorEvent, 10)
+	cfg := &config.Config{
+		OTLPBodyLimit:         1024 * 1024,
+		OTLPAuthToken:         "secret",
+		OTLPSlowSpanThreshold: time.Second,
+	}
+	receiver, err := NewReceiver(cfg, events)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	return receiver, events
+}
+
+func TestTracesJSON(t *testing.T) {
+	receiver, events := newTestReceiver(t)
+	body := `{"resourceSpans":[{"resource":{"attributes":[{"key":"service.name","value":{"stringValue":"api"}}]},
+	"scopeSpans":[{"spans":[
+		{"traceId":"5b8efff798038103d269b633813fc60c","spanId":"eee19b7ec3c1b174","name":"GET /users","kind":2,
+		 "startTimeUnixNano":"1682935200000000000","endTimeUnixNano":"1682935203000000000",
+		 "attributes":[{"key":"openreplay.session.token","value":{"stringValue":"tok.1"}},{"key":"http.method","value":{"stringValue":"GET"}},
+		   {"key":"http.target","value":{"stringValue":"/users?page=2"}}]},
+		{"traceId":"5b8efff798038103d269b633813fc60c","spanId":"eee19b7ec3c1b175","name":"db.query","kind":1,
+		 "startTimeUnixNano":"1682935201000000000","endTimeUnixNano":"1682935201000100000",
+		 "attributes":[{"key":"openreplay.session.id","value":{"intValue":"42"}}],
+		 "status":{"code":2,"message":"timeout"},
+		 "events":[{"name":"exception","attributes":[{"key":"exception.type","value":{"stringValue":"QueryTimeout"}}]}]},
+		{"traceId":"5b8efff798038103d269b633813fc60c","spanId":"eee19b7ec3c1b176","name":"fast","kind":2,
+		 "startTimeUnixNano":"1682935201000000000","endTimeUnixNano":"1682935201000100000",
+		 "attributes":[{"key":"openreplay.session.id","value":{"intValue":"42"}}]}
+	]}]}]}`
+
+	req := httptest.NewRequest("POST", "/v1/traces", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	receiver.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected %d without auth token, but got %d", http.StatusUnauthorized, w.Code)
+	}
+
+	req = httptest.NewRequest("POST", "/v1/traces", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer secret")
+	w = httptest.NewRecorder()
+	receiver.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected %d, but got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	if len(events) != 2 {
+		t.Fatalf("Expected 2 events, but got %d", len(events))
+	}
+	slow := <-events
+	if slow.Token != "tok.1" || slow.IntegrationEvent != nil || slow.NetworkRequest == nil {
+		t.Fatalf("Slow span must be sent as network request: %+v", slow)
+	}
+	if r := slow.NetworkRequest; r.Timestamp != 1682935200000 || r.Duration != 3000 || r.Method != "GET" ||
+		r.URL != "http://api/users?page=2" {
+		t.Errorf("Unexpected slow span request: %+v", r)
+	}
+	failed := <-events
+	if failed.SessionID != 42 || failed.Name != "QueryTimeout" || failed.Message != "timeout" ||
+		!strings.Contains(failed.Payload, `"traceId":"5b8efff798038103d269b633813fc60c"`) {
+		t.Errorf("Unexpected error span event: %+v", failed.IntegrationEvent)
+	}
+}
+
+func TestAuthTokenRequired(t *testing.T) {
+	cfg := &config.Config{OTLPBodyLimit: 1024}
+	if _, err := NewReceiver(cfg, make(chan *integration.SessionErrorEvent)); err == nil {
+		t.Errorf("Receiver must not start without auth token")
+	}
+}
+
+func TestTracesProtobuf(t *testing.T) {
+	receiver, events := newTestReceiver(t)
+	request := &coltracepb.ExportTraceServiceRequest{ResourceSpans: []*tracepb.ResourceSpans{{
+		ScopeSpans: []*tracepb.ScopeSpans{{Spans: []*tracepb.Span{{
+			Name:              "checkout",
+			StartTimeUnixNano: 1682935200000000000,
+			EndTimeUnixNano:   1682935200100000000,
+			Status:            &tracepb.Status{Code: tracepb.Status_STATUS_CODE_ERROR},
+			Attributes: []*commonpb.KeyValue{{Key: "openReplaySessionToken",
+				Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: "tok.2"}}}},
+		}}}},
+	}}}
+	data, err := proto.Marshal(request)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	req := httptest.NewRequest("POST", "/v1/traces", bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+	receiver.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/x-protobuf" {
+		t.Fatalf("Unexpected response: %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	if event := <-events; event.Token != "tok.2" || event.Name != "checkout" {
+		t.Errorf("Unexpected event: %+v", event.IntegrationEvent)
+	}
+}
+
+func TestLogsJSON(t *testing.T) {
+	receiver, events := newTestReceiver(t)
+	body := `{"resourceLogs":[{"scopeLogs":[{"logRecords":[
+		{"timeUnixNano":"1682935200000000000","severityNumber":9,"body":{"stringValue":"openReplaySessionToken=tok.3 ok"}},
+		{"timeUnixNano":"1682935200000000000","severityNumber":17,"severityText":"ERROR","body":{"stringValue":"failed for openReplaySessionToken=tok.3"}}
+	]}]}]}`
+	req := httptest.NewRequest("POST", "/v1/logs", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+	receiver.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected %d, but got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	if len(events) != 1 {
+		t.Fatalf("Expected only error log event, but got %d events", len(events))
+	}
+	if event := <-events; event.Token != "tok.3" || event.Name != "ERROR" || event.Timestamp != 1682935200000 {
+		t.Errorf("Unexpected event: %+v", event.IntegrationEvent)
+	}
+}
@@ -0,0 +1,238 @@
+package webhooks
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"openreplay/backend/internal/integrations/integration"
+	"openreplay/backend/pkg/messages"
+)
+
+// newEvent looks for the session token (or old session id) in the whole body if provider fields are empty.
+// Returns nil if the event can't be linked with a session.
+func newEvent(source, name, message string, timestamp uint64, token string, sessionID uint64, body []byte) *integration.SessionErrorEvent {
+	if token == "" && sessionID == 0 {
+		if t, err := integration.GetToken(string(body)); err == nil {
+			token = t
+		} else if id, err := integration.GetAsayerSessionId(string(body)); err == nil {
+			sessionID = id
+		} else {
+			return nil
+		}
+	}
+	if timestamp == 0 {
+		timestamp = uint64(time.Now().UnixMilli())
+	}
+	return &integration.SessionErrorEvent{
+		SessionID: sessionID,
+		Token:     token,
+		IntegrationEvent: &messages.IntegrationEvent{
+			Source:    source,
+			Timestamp: timestamp,
+			Name:      name,
+			Message:   message,
+			Payload:   string(body),
+		},
+	}
+}
+
+func parseRFC3339(value string) uint64 {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return 0
+	}
+	return uint64(t.UnixMilli())
+}
+
+// Sentry
+
+type sentryWebhookEvent struct {
+	Title    string            `json:"title"`
+	Message  string            `json:"message"`
+	Datetime string            `json:"datetime"`
+	Tags     []json.RawMessage `json:"tags"`
+}
+
+type sentryWebhook struct {
+	Data struct {
+		Event *sentryWebhookEvent `json:"event"` // event_alert
+		Error *sentryWebhookEvent `json:"error"` // error
+	} `json:"data"`
+	Event *sentryWebhookEvent `json:"event"` // legacy webhooks plugin
+}
+
+// sentryTag returns the tag value, tags are sent as ["key", "value"] pairs or as {"key": .., "value": ..} objects
+func (e *sentryWebhookEvent) sentryTag(key string) string {
+	for _, raw := range e.Tags {
+		var pair []string
+		if err := json.Unmarshal(raw, &pair); err == nil {
+			if len(pair) == 2 && pair[0] == key {
+				return pair[1]
+			}
+			continue
+		}
+		var tag struct {
+			Key   string `json:"key"`
+			Value string `json:"value"`
+		}
+		if err := json.Unmarshal(raw, &tag); err == nil && tag.Key == key {
+			return tag.Value
+		}
+	}
+	return ""
+}
+
+func parseSentry(body []byte) (*integration.SessionErrorEvent, error) {
+	var w sentryWebhook
+	if err := json.Unmarshal(body, &w); err != nil {
+		return nil, err
+	}
+	e := w.Data.Event
+	if e == nil {
+		e = w.Data.Error
+	}
+	if e == nil {
+		e = w.Event
+	}
+	if e == nil {
+		return nil, nil
+	}
+	sessionID, _ := strconv.ParseUint(e.sentryTag("asayer_session_id"), 10, 64)
+	return newEvent("sentry", e.Title, e.Message, parseRFC3339(e.Datetime),
+		e.sentryTag("openReplaySessionToken"), sessionID, body), nil
+}
+
+// Bugsnag
+
+type bugsnagWebhook struct {
+	Error *struct {
+		ExceptionClass string `json:"exceptionClass"`
+		Message        string `json:"message"`
+		ReceivedAt     string `json:"receivedAt"`
+		MetaData       struct {
+			SpecialInfo struct {
+				AsayerSessionId        uint64 `json:"asayerSessionId,string"`
+				OpenReplaySessionToken string `json:"openReplaySessionToken"`
+			} `json:"special_info"`
+		} `json:"metaData"`
+	} `json:"error"`
+}
+
+func parseBugsnag(body []byte) (*integration.SessionErrorEvent, error) {
+	var w bugsnagWebhook
+	if err := json.Unmarshal(body, &w); err != nil {
+		return nil, err
+	}
+	if w.Error == nil {
+		return nil, nil
+	}
+	e := w.Error
+	return newEvent("bugsnag", e.ExceptionClass, e.Message, parseRFC3339(e.ReceivedAt),
+		e.MetaData.SpecialInfo.OpenReplaySessionToken, e.MetaData.SpecialInfo.AsayerSessionId, body), nil
+}
+
+// Rollbar
+
+type rollbarWebhook struct {
+	Data struct {
+		Item struct {
+			Title                   string `json:"title"`
+			LastOccurrenceTimestamp uint64 `json:"last_occurrence_timestamp"`
+		} `json:"item"`
+		Occurrence *struct {
+			Timestamp uint64 `json:"timestamp"`
+			Body      struct {
+				Message struct {
+					Body                   string `json:"body"`
+					OpenReplaySessionToken string `json:"openReplaySessionToken"`
+				} `json:"message"`
+			} `json:"body"`
+		} `json:"occurrence"`
+	} `json:"data"`
+}
+
+func parseRollbar(body []byte) (*integration.SessionErrorEvent, error) {
+	var w rollbarWebhook
+	if err := json.Unmarshal(body, &w); err != nil {
+		return nil, err
+	}
+	if w.Data.Item.Title == "" && w.Data.Occurrence == nil {
+		return nil, nil
+	}
+	timestampSec := w.Data.Item.LastOccurrenceTimestamp
+	var message, token string
+	if o := w.Data.Occurrence; o != nil {
+		if o.Timestamp != 0 {
+			timestampSec = o.Timestamp
+		}
+		message = o.Body.Message.Body
+		token = o.Body.Message.OpenReplaySessionToken
+	}
+	return newEvent("rollbar", w.Data.Item.Title, message, timestampSec*1000, token, 0, body), nil
+}
+
+// Datadog monitors send a user-defined payload, recommended template:
+// {"title": "$EVENT_TITLE", "date": "$DATE", "body": "$EVENT_MSG"}
+// Datadog lowercases tag values, so the token is searched in the message.
+
+type datadogWebhook struct {
+	Title string `json:"title"`
+	Date  string `json:"date"` // epoch in milliseconds
+	Body  string `json:"body"`
+}
+
+func parseDatadog(body []byte) (*integration.SessionErrorEvent, error) {
+	var w datadogWebhook
+	if err := json.Unmarshal(body, &w); err != nil {
+		return nil, err
+	}
+	timestamp, _ := strconv.ParseUint(w.Date, 10, 64)
+	return newEvent("datadog", w.Title, w.Body, timestamp, "", 0, body), nil
+}
+
+// Generic JSON schema for any other error tracker
+
+// genericSources are accepted values of the source field (error_source type in db)
+var genericSources = map[string]bool{
+	"webhook": true, "bugsnag": true, "cloudwatch": true, "datadog": true, "elasticsearch": true,
+	"newrelic": true, "rollbar": true, "sentry": true, "stackdriver": true, "sumologic": true,
+}
+
+type genericWebhook struct {
+	Source       string          `json:"source"`
+	SessionToken string          `json:"sessionToken"`
+	SessionID    json.Number     `json:"sessionId"`
+	Name         string          `json:"name"`
+	Message      string          `json:"message"`
+	Timestamp    uint64          `json:"timestamp"` // milliseconds
+	Payload      json.RawMessage `json:"payload"`
+}
+
+func parseGeneric(body []byte) (*integration.SessionErrorEvent, error) {
+	var w genericWebhook
+	if err := json.Unmarshal(body, &w); err != nil {
+		return nil, err
+	}
+	if w.Name == "" {
+		return nil, errors.New("name is empty")
+	}
+	sessionID, _ := strconv.ParseUint(w.SessionID.String(), 10, 64)
+	if w.SessionToken == "" && sessionID == 0 {
+		return nil, errors.New("sessionToken or sessionId is required")
+	}
+	source := w.Source
+	if source == "" {
+		source = "webhook"
+	}
+	if !genericSources[source] {
+		return nil, fmt.Errorf("unknown source: %s", source)
+	}
+	payload := body
+	if len(w.Payload) > 0 {
+		payload = w.Payload
+	}
+	return newEvent(source, w.Name, w.Message, w.Timestamp, w.SessionToken, sessionID, payload), nil
+}
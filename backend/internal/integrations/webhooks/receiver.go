@@ -0,0 +1,155 @@
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	config "openreplay/backend/internal/config/integrations"
+	"openreplay/backend/internal/integrations/integration"
+)
+
+// Secrets returns the webhook secret set in integration options
+type Secrets interface {
+	WebhookSecret(projectID uint32, provider string) string
+}
+
+type verifier func(r *http.Request, body []byte, secret string) bool
+
+type parser func(body []byte) (*integration.SessionErrorEvent, error)
+
+type provider struct {
+	verify verifier
+	parse  parser
+}
+
+// Sentry and generic webhooks are signed with HMAC-SHA256 of the body,
+// other providers don't sign requests, so we check the shared secret (custom header or query param)
+var providers = map[string]*provider{
+	"sentry":  {verifyHMAC("Sentry-Hook-Signature"), parseSentry},
+	"bugsnag": {verifySecret, parseBugsnag},
+	"rollbar": {verifySecret, parseRollbar},
+	"datadog": {verifySecret, parseDatadog},
+	"generic": {verifyHMAC("X-OpenReplay-Signature"), parseGeneric},
+}
+
+type Receiver struct {
+	router         *mux.Router
+	secrets        Secrets
+	defaultSecrets map[string]string
+	bodyLimit      int64
+	events         chan<- *integration.SessionErrorEvent
+}
+
+func NewReceiver(cfg *config.Config, secrets Secrets, events chan<- *integration.SessionErrorEvent) (*Receiver, error) {
+	switch {
+	case cfg == nil:
+		return nil, errors.New("config is empty")
+	case secrets == nil:
+		return nil, errors.New("secrets are empty")
+	case events == nil:
+		return nil, errors.New("events channel is empty")
+	}
+	r := &Receiver{
+		router:         mux.NewRouter(),
+		secrets:        secrets,
+		defaultSecrets: cfg.WebhooksSecrets,
+		bodyLimit:      cfg.WebhooksBodyLimit,
+		events:         events,
+	}
+	r.router.HandleFunc("/v1/webhooks/{provider}/{projectID}", r.handleWebhook).Methods("POST")
+	return r, nil
+}
+
+func (r *Receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.router.ServeHTTP(w, req)
+}
+
+func (r *Receiver) secret(projectID uint32, providerName string) string {
+	if secret := r.secrets.WebhookSecret(projectID, providerName); secret != "" {
+		return secret
+	}
+	return r.defaultSecrets[providerName]
+}
+
+func (r *Receiver) handleWebhook(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	providerName := vars["provider"]
+	p, ok := providers[providerName]
+	if !ok {
+		http.Error(w, "unknown provider", http.StatusNotFound)
+		return
+	}
+	projectID, err := strconv.ParseUint(vars["projectID"], 10, 32)
+	if err != nil {
+		http.Error(w, "wrong project id", http.StatusBadRequest)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(req.Body, r.bodyLimit+1))
+	if err != nil {
+		http.Error(w, "can't read body", http.StatusBadRequest)
+		return
+	}
+	if int64(len(body)) > r.bodyLimit {
+		http.Error(w, "request entity too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	// Unsigned webhooks are never accepted
+	secret := r.secret(uint32(projectID), providerName)
+	if secret == "" || !p.verify(req, body, secret) {
+		log.Printf("webhook signature check failed, provider: %s, projectID: %d", providerName, projectID)
+		http.Error(w, "wrong signature", http.StatusUnauthorized)
+		return
+	}
+
+	event, err := p.parse(body)
+	if err != nil {
+		log.Printf("can't parse %s webhook: %s", providerName, err)
+		http.Error(w, fmt.Sprintf("can't parse webhook: %s", err), http.StatusBadRequest)
+		return
+	}
+	if event == nil {
+		// Event without session token or other notification type (for example, sentry installation)
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	event.ProjectID = uint32(projectID)
+	select {
+	case r.events <- event:
+		w.WriteHeader(http.StatusOK)
+	case <-req.Context().Done():
+		http.Error(w, "service is busy", http.StatusServiceUnavailable)
+	}
+}
+
+func verifyHMAC(header string) verifier {
+	return func(r *http.Request, body []byte, secret string) bool {
+		signature := strings.TrimPrefix(r.Header.Get(header), "sha256=")
+		expected, err := hex.DecodeString(signature)
+		if err != nil || len(expected) == 0 {
+			return false
+		}
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		return hmac.Equal(mac.Sum(nil), expected)
+	}
+}
+
+func verifySecret(r *http.Request, body []byte, secret string) bool {
+	value := r.Header.Get("X-OpenReplay-Webhook-Secret")
+	if value == "" {
+		value = r.URL.Query().Get("secret")
+	}
+	return subtle.ConstantTimeCompare([]byte(value), []byte(secret)) == 1
+}
@@ -0,0 +1,115 @@
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	config "openreplay/backend/internal/config/integrations"
+	"openreplay/backend/internal/integrations/integration"
+)
+
+type testSecrets map[string]string
+
+func (s testSecrets) WebhookSecret(projectID uint32, provider string) string {
+	return s[provider]
+}
+
+func newTestReceiver(t *testing.T) (*Receiver, chan *integration.SessionErrorEvent) {
+	events := make(chan *integration.SessionErrorEvent, 10)
+	cfg := &config.Config{
+		WebhooksBodyLimit: 1024 * 1024,
+		WebhooksSecrets:   map[string]string{"datadog": "dd-secret"},
+	}
+	receiver, err := NewReceiver(cfg, testSecrets{"sentry": "sentry-secret", "generic": "generic-secret"}, events)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	return receiver, events
+}
+
+func sign(body, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestSentryWebhook(t *testing.T) {
+	receiver, events := newTestReceiver(t)
+	body := `{"action":"triggered","data":{"event":{"title":"TypeError: x is undefined","datetime":"2023-05-01T10:00:00Z",` +
+		`"tags":[["level","error"],["openReplaySessionToken","abc.123"]]}}}`
+
+	req := httptest.NewRequest("POST", "/v1/webhooks/sentry/1", strings.NewReader(body))
+	req.Header.Set("Sentry-Hook-Signature", sign(body, "wrong-secret"))
+	w := httptest.NewRecorder()
+	receiver.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected %d for wrong signature, but got %d", http.StatusUnauthorized, w.Code)
+	}
+
+	req = httptest.NewRequest("POST", "/v1/webhooks/sentry/1", strings.NewReader(body))
+	req.Header.Set("Sentry-Hook-Signature", sign(body, "sentry-secret"))
+	w = httptest.NewRecorder()
+	receiver.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected %d, but got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	event := <-events
+	if event.Token != "abc.123" || event.Source != "sentry" || event.Name != "TypeError: x is undefined" ||
+		event.Timestamp != 1682935200000 || event.ProjectID != 1 {
+		t.Errorf("Unexpected event: %+v %+v", event, event.IntegrationEvent)
+	}
+}
+
+func TestSharedSecretWebhook(t *testing.T) {
+	receiver, events := newTestReceiver(t)
+	body := `{"title":"[Triggered] Errors","date":"1682935200000","body":"error at openReplaySessionToken=tok.42 page"}`
+
+	req := httptest.NewRequest("POST", "/v1/webhooks/datadog/1?secret=dd-secret", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	receiver.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected %d, but got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	if event := <-events; event.Token != "tok.42" || event.Timestamp != 1682935200000 {
+		t.Errorf("Unexpected event: %+v %+v", event, event.IntegrationEvent)
+	}
+
+	// No secret configured for the provider
+	req = httptest.NewRequest("POST", "/v1/webhooks/rollbar/1?secret=", strings.NewReader(`{}`))
+	w = httptest.NewRecorder()
+	receiver.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected %d, but got %d", http.StatusUnauthorized, w.Code)
+	}
+}
+
+func TestGenericWebhookSource(t *testing.T) {
+	receiver, events := newTestReceiver(t)
+	for body, expected := range map[string]string{
+		`{"name":"Error","sessionToken":"tok.1"}`:                    "webhook",
+		`{"name":"Error","sessionToken":"tok.1","source":"sentry"}`:  "sentry",
+		`{"name":"Error","sessionToken":"tok.1","source":"unknown"}`: "",
+	} {
+		req := httptest.NewRequest("POST", "/v1/webhooks/generic/1", strings.NewReader(body))
+		req.Header.Set("X-OpenReplay-Signature", "sha256="+sign(body, "generic-secret"))
+		w := httptest.NewRecorder()
+		receiver.ServeHTTP(w, req)
+		if expected == "" {
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("Expected %d for %s, but got %d", http.StatusBadRequest, body, w.Code)
+			}
+			continue
+		}
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected %d, but got %d: %s", http.StatusOK, w.Code, w.Body.String())
+		}
+		if event := <-events; event.Source != expected {
+			t.Errorf("Expected source %s, but got %s", expected, event.Source)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package replayer
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	config "openreplay/backend/internal/config/replayer"
+	"openreplay/backend/pkg/deadletter"
+	"openreplay/backend/pkg/messages"
+	"openreplay/backend/pkg/queue/types"
+)
+
+/*
+	Replayer re-injects batches from the dead-letter topic after the decoder is fixed.
+	Every batch is replayed to the replay topic of the consumer group which rejected it (see deadletter.ReplayTopic),
+	so other services don't process it twice. Batches which still can't be decoded are skipped (unless REPLAY_FORCE is set).
+	Keys of replayed batches are kept in REPLAY_STATE_FILE, so the same batch isn't replayed again after restart.
+*/
+
+type Stats struct {
+	Read       int
+	Filtered   int
+	Duplicates int
+	Invalid    int
+	Replayed   int
+	Failed     int
+}
+
+func (s Stats) String() string {
+	return fmt.Sprintf("read: %d, filtered: %d, duplicates: %d, still broken: %d, replayed: %d, failed: %d",
+		s.Read, s.Filtered, s.Duplicates, s.Invalid, s.Replayed, s.Failed)
+}
+
+type Replayer struct {
+	cfg      *config.Config
+	producer types.Producer
+	services map[string]bool
+	replayed *replayedSet
+	lastRead time.Time
+	stats    Stats
+}
+
+func New(cfg *config.Config, producer types.Producer) (*Replayer, error) {
+	replayed, err := openReplayedSet(cfg.StateFile)
+	if err != nil {
+		return nil, err
+	}
+	r := &Replayer{
+		cfg:      cfg,
+		producer: producer,
+		replayed: replayed,
+		lastRead: time.Now(),
+	}
+	if len(cfg.Services) > 0 {
+		r.services = make(map[string]bool, len(cfg.Services))
+		for _, service := range cfg.Services {
+			r.services[service] = true
+		}
+	}
+	return r, nil
+}
+
+// Iterate handles one record of the dead-letter topic
+func (r *Replayer) Iterate(data []byte, _ *messages.BatchInfo) {
+	r.lastRead = time.Now()
+	r.stats.Read++
+	batch, err := deadletter.Decode(data)
+	if err != nil {
+		log.Printf("can't decode dead-letter record: %s", err)
+		r.stats.Failed++
+		return
+	}
+	if r.services != nil && !r.services[batch.Service] {
+		r.stats.Filtered++
+		return
+	}
+	key := fmt.Sprintf("%s/%s/%d/%d/%d", batch.Group, batch.Topic, batch.Partition, batch.BatchID, batch.SessionID)
+	if r.replayed.contains(key) {
+		r.stats.Duplicates++
+		return
+	}
+	if err := batch.Validate(); err != nil && !r.cfg.Force {
+		log.Printf("batch is still broken: %s, first error: %s, info: %s", err, batch.Reason, batch.BatchInfo().Info())
+		r.stats.Invalid++
+		return
+	}
+	topic := r.cfg.TargetTopic
+	if topic == "" {
+		topic = deadletter.ReplayTopic(r.cfg.TopicDeadLetter, batch.Group)
+	}
+	if r.cfg.DryRun {
+		log.Printf("batch can be replayed to %s, rejected by %s (%s), info: %s", topic, batch.Service, batch.Reason, batch.BatchInfo().Info())
+		r.stats.Replayed++
+		return
+	}
+	if err := r.producer.Produce(topic, batch.SessionID, batch.Data); err != nil {
+		log.Printf("can't replay batch to %s: %s, info: %s", topic, err, batch.BatchInfo().Info())
+		r.stats.Failed++
+		return
+	}
+	if err := r.replayed.add(key); err != nil {
+		log.Printf("can't save replayed batch: %s, info: %s", err, batch.BatchInfo().Info())
+	}
+	r.stats.Replayed++
+}
+
+// Sync saves keys of replayed batches, it must be called after the producer flush and before the commit
+func (r *Replayer) Sync() error {
+	return r.replayed.sync()
+}
+
+func (r *Replayer) Close() error {
+	return r.replayed.close()
+}
+
+// Idle returns time since the last read record
+func (r *Replayer) Idle() time.Duration {
+	return time.Since(r.lastRead)
+}
+
+func (r *Replayer) Stats() Stats {
+	return r.stats
+}
@@ -0,0 +1,64 @@
+package replayer
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	config "openreplay/backend/internal/config/replayer"
+	"openreplay/backend/pkg/deadletter"
+	"openreplay/backend/pkg/memqueue"
+	"openreplay/backend/pkg/messages"
+)
+
+type recorder struct {
+	batches []*messages.BatchInfo
+}
+
+func (r *recorder) Iterate(_ []byte, info *messages.BatchInfo) {
+	r.batches = append(r.batches, info)
+}
+
+func TestReplayOnce(t *testing.T) {
+	broker, err := memqueue.NewBroker("", 1, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+	cfg := &config.Config{TopicDeadLetter: "dead-letter", StateFile: filepath.Join(t.TempDir(), "replayer.state")}
+	data := (&messages.BatchMetadata{Version: 1, Timestamp: 1}).Encode()
+	record, _ := (&deadletter.Batch{Service: "sink", Group: "sink", SessionID: 1, Topic: "raw", Data: data}).Encode()
+	other, _ := (&deadletter.Batch{Service: "db", Group: "db", SessionID: 1, Topic: "raw", Data: data}).Encode()
+
+	r, err := New(cfg, broker.NewProducer())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Iterate(record, nil)
+	r.Iterate(record, nil)
+	r.Iterate(other, nil)
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Replayed batches are remembered after restart
+	r, err = New(cfg, broker.NewProducer())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	r.Iterate(record, nil)
+	if stats := r.Stats(); stats.Duplicates != 1 || stats.Replayed != 0 {
+		t.Errorf("expected duplicate after restart, got %s", stats)
+	}
+
+	// Batch is replayed to the group which rejected it only
+	for group, expected := range map[string]int{"sink": 1, "db": 1, "ender": 0} {
+		rec := &recorder{}
+		consumer := broker.NewConsumer(group, []string{deadletter.ReplayTopic(cfg.TopicDeadLetter, group)}, rec, false)
+		consumer.ConsumeNext()
+		consumer.Close()
+		if len(rec.batches) != expected {
+			t.Errorf("expected %d replayed batches for %s, got %d", expected, group, len(rec.batches))
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package replayer
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+)
+
+// replayedSet keeps keys of replayed batches in a file, so batches aren't replayed twice after restart
+type replayedSet struct {
+	file   *os.File
+	writer *bufio.Writer
+	keys   map[string]struct{}
+}
+
+func openReplayedSet(path string) (*replayedSet, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("can't open replay state: %w", err)
+	}
+	s := &replayedSet{
+		file:   file,
+		writer: bufio.NewWriter(file),
+		keys:   make(map[string]struct{}),
+	}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		s.keys[scanner.Text()] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("can't read replay state: %w", err)
+	}
+	return s, nil
+}
+
+func (s *replayedSet) contains(key string) bool {
+	_, ok := s.keys[key]
+	return ok
+}
+
+func (s *replayedSet) add(key string) error {
+	s.keys[key] = struct{}{}
+	_, err := s.writer.WriteString(key + "\n")
+	return err
+}
+
+// sync writes added keys to disk, it's called before the consumer commit
+func (s *replayedSet) sync() error {
+	if err := s.writer.Flush(); err != nil {
+		return err
+	}
+	return s.file.Sync()
+}
+
+func (s *replayedSet) close() error {
+	if err := s.sync(); err != nil {
+		s.file.Close()
+		return err
+	}
+	return s.file.Close()
+}
@@ -0,0 +1,24 @@
+package eraser
+
+import (
+	config "openreplay/backend/internal/config/retention"
+)
+
+// Sessions and events are stored in postgres only
+type analyticsImpl struct{}
+
+func NewAnalytics(cfg *config.Config) (Analytics, error) {
+	return &analyticsImpl{}, nil
+}
+
+func (a *analyticsImpl) DeleteSessions(projectIDs []uint32, sessionIDs []uint64) ([]string, error) {
+	return nil, nil
+}
+
+func (a *analyticsImpl) DeleteUser(projectID uint32, userID string) ([]string, error) {
+	return nil, nil
+}
+
+func (a *analyticsImpl) Close() error {
+	return nil
+}
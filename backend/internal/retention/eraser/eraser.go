@@ -0,0 +1,281 @@
+package eraser
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	config "openreplay/backend/internal/config/retention"
+	"openreplay/backend/pkg/db/redis"
+	metrics "openreplay/backend/pkg/metrics/retention"
+	"openreplay/backend/pkg/objectstorage"
+)
+
+/*
+	Eraser deletes all data of sessions: files in object storages (all files with the session id prefix:
+	dom.mobs, dom.mobe, devtools.mob, canvas and video replays), rows in analytics tables, cached sessions
+	and at last rows in postgres. Postgres is the list of existing sessions, so if any step fails,
+	the session is found and deleted again on the next run. Every deleted session is saved to the audit log.
+	In-memory caches of other services aren't cleared, but they expire in a few minutes.
+	Analytics deletes are mutations which rewrite whole table parts, so retention collects expired sessions
+	of all projects (up to RETENTION_RUN_SIZE) and deletes them from analytics with one mutation per table,
+	the rest is deleted in batches.
+*/
+
+const (
+	ReasonRetention = "retention"
+	ReasonErasure   = "erasure"
+)
+
+// Analytics is a database with copies of sessions and events (clickhouse in EE)
+type Analytics interface {
+	// DeleteSessions returns the list of tables the sessions are deleted from, sessions can belong to any of projects
+	DeleteSessions(projectIDs []uint32, sessionIDs []uint64) ([]string, error)
+	DeleteUser(projectID uint32, userID string) ([]string, error)
+	Close() error
+}
+
+type Eraser struct {
+	cfg       *config.Config
+	storage   Storage
+	objStores []objectstorage.ObjectStorage
+	analytics Analytics
+	cache     *redis.Client
+}
+
+func New(cfg *config.Config, storage Storage, objStores []objectstorage.ObjectStorage, analytics Analytics, cache *redis.Client) *Eraser {
+	return &Eraser{
+		cfg:       cfg,
+		storage:   storage,
+		objStores: objStores,
+		analytics: analytics,
+		cache:     cache,
+	}
+}
+
+// Erase deletes the sessions in all storages
+func (e *Eraser) Erase(projectID uint32, sessionIDs []uint64, reason string, requestID uint64) error {
+	if len(sessionIDs) == 0 {
+		return nil
+	}
+	if e.cfg.DryRun {
+		log.Printf("dry run, %d sessions of project %d would be deleted (%s): %v", len(sessionIDs), projectID, reason, sessionIDs)
+		return nil
+	}
+	analyticsTables, err := e.analytics.DeleteSessions([]uint32{projectID}, sessionIDs)
+	if err != nil {
+		metrics.IncreaseErrors("analytics")
+		return fmt.Errorf("can't delete sessions from analytics: %s", err)
+	}
+	return e.erase(projectID, sessionIDs, reason, requestID, analyticsTables)
+}
+
+// erase deletes the sessions everywhere except analytics
+func (e *Eraser) erase(projectID uint32, sessionIDs []uint64, reason string, requestID uint64, analyticsTables []string) error {
+	records := make([]*Record, 0, len(sessionIDs))
+	for _, sessionID := range sessionIDs {
+		files, err := e.deleteFiles(sessionID)
+		if err != nil {
+			metrics.IncreaseErrors("objectstorage")
+			return fmt.Errorf("can't delete files of session %d: %s", sessionID, err)
+		}
+		records = append(records, &Record{
+			ProjectID: projectID,
+			SessionID: sessionID,
+			RequestID: requestID,
+			Reason:    reason,
+			Files:     files,
+		})
+	}
+	if err := e.deleteCache(sessionIDs); err != nil {
+		metrics.IncreaseErrors("cache")
+		return fmt.Errorf("can't delete cached sessions: %s", err)
+	}
+	tables, err := e.storage.DeleteSessions(projectID, sessionIDs)
+	if err != nil {
+		metrics.IncreaseErrors("postgres")
+		return fmt.Errorf("can't delete sessions: %s", err)
+	}
+	tables = append(tables, analyticsTables...)
+	for _, r := range records {
+		r.Tables = tables
+	}
+	if err := e.storage.SaveRecords(records); err != nil {
+		// Sessions are deleted already, so the audit log is printed instead
+		metrics.IncreaseErrors("audit")
+		for _, r := range records {
+			log.Printf("deleted session %d of project %d (%s), files: %v, tables: %v", r.SessionID, r.ProjectID, r.Reason, r.Files, r.Tables)
+		}
+		return fmt.Errorf("can't save audit log: %s", err)
+	}
+	metrics.IncreaseDeletedSessions(float64(len(sessionIDs)), reason)
+	return nil
+}
+
+func (e *Eraser) deleteFiles(sessionID uint64) ([]string, error) {
+	deleted := make([]string, 0)
+	prefix := strconv.FormatUint(sessionID, 10) + "/"
+	for _, store := range e.objStores {
+		keys, err := store.List(prefix)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			if err := store.Delete(key); err != nil {
+				return nil, err
+			}
+			deleted = append(deleted, key)
+		}
+	}
+	metrics.IncreaseDeletedFiles(float64(len(deleted)))
+	return deleted, nil
+}
+
+func (e *Eraser) deleteCache(sessionIDs []uint64) error {
+	if e.cache == nil {
+		return nil
+	}
+	keys := make([]string, 0, len(sessionIDs)*2)
+	for _, sessionID := range sessionIDs {
+		keys = append(keys, fmt.Sprintf("session:id:%d", sessionID), fmt.Sprintf("session:cache:id:%d", sessionID))
+	}
+	return e.cache.Redis.Del(keys...).Err()
+}
+
+// ApplyRetention deletes sessions which are older than retention periods of their projects,
+// sessions left over the run size are deleted by the next run
+func (e *Eraser) ApplyRetention(now time.Time) {
+	projects, err := e.storage.GetProjects(e.cfg.DefaultRetention)
+	if err != nil {
+		log.Printf("can't get projects: %s", err)
+		return
+	}
+	expired := make(map[uint32][]uint64)
+	projectIDs, sessionIDs := make([]uint32, 0), make([]uint64, 0)
+	for _, p := range projects {
+		left := e.cfg.RunSize - len(sessionIDs)
+		if p.RetentionDays <= 0 || left <= 0 {
+			continue
+		}
+		before := now.Add(-time.Duration(p.RetentionDays) * 24 * time.Hour).UnixMilli()
+		ids, err := e.storage.GetExpiredSessions(p.ID, before, left)
+		if err != nil {
+			log.Printf("can't get expired sessions of project %d: %s", p.ID, err)
+			continue
+		}
+		if len(ids) == 0 {
+			continue
+		}
+		if e.cfg.DryRun {
+			log.Printf("dry run, %d sessions of project %d would be deleted (%s): %v", len(ids), p.ID, ReasonRetention, ids)
+			continue
+		}
+		expired[p.ID] = ids
+		projectIDs = append(projectIDs, p.ID)
+		sessionIDs = append(sessionIDs, ids...)
+	}
+	if len(sessionIDs) == 0 {
+		return
+	}
+	analyticsTables, err := e.analytics.DeleteSessions(projectIDs, sessionIDs)
+	if err != nil {
+		metrics.IncreaseErrors("analytics")
+		log.Printf("can't delete expired sessions from analytics: %s", err)
+		return
+	}
+	for _, p := range projects {
+		ids := expired[p.ID]
+		for i := 0; i < len(ids); i += e.cfg.BatchSize {
+			batch := ids[i:min(i+e.cfg.BatchSize, len(ids))]
+			if err := e.erase(p.ID, batch, ReasonRetention, 0, analyticsTables); err != nil {
+				log.Printf("can't delete expired sessions of project %d: %s", p.ID, err)
+				ids = ids[:i]
+				break
+			}
+		}
+		if len(ids) > 0 {
+			log.Printf("deleted %d sessions of project %d older than %d days", len(ids), p.ID, p.RetentionDays)
+		}
+	}
+}
+
+// ProcessRequests handles pending erasure requests, failed requests are repeated a few times
+func (e *Eraser) ProcessRequests() {
+	requests, err := e.storage.GetPendingRequests(e.cfg.BatchSize)
+	if err != nil {
+		log.Printf("can't get erasure requests: %s", err)
+		return
+	}
+	for _, req := range requests {
+		err := e.processRequest(req)
+		req.Attempts++
+		switch {
+		case err == nil:
+			req.Status, req.Error = StatusDone, ""
+		case req.Attempts >= e.cfg.RequestAttempts:
+			req.Status, req.Error = StatusFailed, err.Error()
+		default:
+			req.Error = err.Error()
+		}
+		if e.cfg.DryRun {
+			continue
+		}
+		if err := e.storage.UpdateRequest(req); err != nil {
+			log.Printf("can't update erasure request %d: %s", req.ID, err)
+			continue
+		}
+		if req.Status != StatusPending {
+			metrics.IncreaseRequests(req.Status)
+			log.Printf("erasure request %d is %s, deleted sessions: %d", req.ID, req.Status, req.Sessions)
+		}
+	}
+}
+
+func (e *Eraser) processRequest(req *Request) error {
+	if req.SessionID != 0 {
+		sessionIDs, err := e.storage.GetSession(req.ProjectID, req.SessionID)
+		if err != nil {
+			return err
+		}
+		if err := e.Erase(req.ProjectID, sessionIDs, ReasonErasure, req.ID); err != nil {
+			return err
+		}
+		req.Sessions += len(sessionIDs)
+		return nil
+	}
+	if req.UserID == "" {
+		return fmt.Errorf("user id and session id are empty")
+	}
+	for {
+		sessionIDs, err := e.storage.GetUserSessions(req.ProjectID, req.UserID, e.cfg.BatchSize)
+		if err != nil {
+			return err
+		}
+		if err := e.Erase(req.ProjectID, sessionIDs, ReasonErasure, req.ID); err != nil {
+			return err
+		}
+		req.Sessions += len(sessionIDs)
+		if len(sessionIDs) < e.cfg.BatchSize || e.cfg.DryRun {
+			break
+		}
+	}
+	if e.cfg.DryRun {
+		return nil
+	}
+	// User's values which don't belong to sessions (autocomplete)
+	tables, err := e.storage.DeleteUser(req.ProjectID, req.UserID)
+	if err != nil {
+		return err
+	}
+	analyticsTables, err := e.analytics.DeleteUser(req.ProjectID, req.UserID)
+	if err != nil {
+		return err
+	}
+	return e.storage.SaveRecords([]*Record{{
+		ProjectID: req.ProjectID,
+		RequestID: req.ID,
+		Reason:    ReasonErasure,
+		Tables:    append(tables, analyticsTables...),
+	}})
+}
@@ -0,0 +1,232 @@
+package eraser
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	config "openreplay/backend/internal/config/retention"
+	"openreplay/backend/pkg/objectstorage"
+)
+
+type testSession struct {
+	projectID uint32
+	userID    string
+	startTs   int64
+}
+
+type testStorage struct {
+	Storage
+	sessions map[uint64]*testSession
+	requests []*Request
+	records  []*Record
+}
+
+func (s *testStorage) find(limit int, match func(sess *testSession) bool) []uint64 {
+	ids := make([]uint64, 0)
+	for id, sess := range s.sessions {
+		if match(sess) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+	return ids
+}
+
+func (s *testStorage) GetProjects(defaultDays int) ([]*Project, error) {
+	return []*Project{{ID: 1, RetentionDays: 30}, {ID: 2, RetentionDays: defaultDays}}, nil
+}
+
+func (s *testStorage) GetExpiredSessions(projectID uint32, before int64, limit int) ([]uint64, error) {
+	return s.find(limit, func(sess *testSession) bool { return sess.projectID == projectID && sess.startTs < before }), nil
+}
+
+func (s *testStorage) GetUserSessions(projectID uint32, userID string, limit int) ([]uint64, error) {
+	return s.find(limit, func(sess *testSession) bool { return sess.projectID == projectID && sess.userID == userID }), nil
+}
+
+func (s *testStorage) GetSession(projectID uint32, sessionID uint64) ([]uint64, error) {
+	return s.find(1, func(sess *testSession) bool { return sess == s.sessions[sessionID] && sess.projectID == projectID }), nil
+}
+
+func (s *testStorage) DeleteSessions(projectID uint32, sessionIDs []uint64) ([]string, error) {
+	for _, id := range sessionIDs {
+		delete(s.sessions, id)
+	}
+	return []string{"public.sessions"}, nil
+}
+
+func (s *testStorage) DeleteUser(projectID uint32, userID string) ([]string, error) {
+	return []string{"public.autocomplete"}, nil
+}
+
+func (s *testStorage) GetPendingRequests(limit int) ([]*Request, error) {
+	pending := make([]*Request, 0)
+	for _, req := range s.requests {
+		if req.Status == StatusPending {
+			pending = append(pending, req)
+		}
+	}
+	return pending, nil
+}
+
+func (s *testStorage) UpdateRequest(req *Request) error {
+	return nil
+}
+
+func (s *testStorage) SaveRecords(records []*Record) error {
+	s.records = append(s.records, records...)
+	return nil
+}
+
+type testObjStorage struct {
+	objectstorage.ObjectStorage
+	files map[string]bool
+	fail  bool
+}
+
+func (s *testObjStorage) List(prefix string) ([]string, error) {
+	keys := make([]string, 0)
+	for key := range s.files {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func (s *testObjStorage) Delete(key string) error {
+	if s.fail {
+		return errors.New("access denied")
+	}
+	delete(s.files, key)
+	return nil
+}
+
+type testAnalytics struct {
+	calls int
+}
+
+func (a *testAnalytics) DeleteSessions(projectIDs []uint32, sessionIDs []uint64) ([]string, error) {
+	a.calls++
+	return []string{"experimental.sessions"}, nil
+}
+
+func (a *testAnalytics) DeleteUser(projectID uint32, userID string) ([]string, error) {
+	return nil, nil
+}
+
+func (a *testAnalytics) Close() error {
+	return nil
+}
+
+func newTestEraser(storage *testStorage, files *testObjStorage) *Eraser {
+	cfg := &config.Config{BatchSize: 2, RunSize: 10, RequestAttempts: 2}
+	return New(cfg, storage, []objectstorage.ObjectStorage{files}, &testAnalytics{}, nil)
+}
+
+func TestErasureRequests(t *testing.T) {
+	storage := &testStorage{
+		sessions: map[uint64]*testSession{
+			11: {projectID: 1, userID: "john"},
+			12: {projectID: 1, userID: "john"},
+			13: {projectID: 1, userID: "john"},
+			14: {projectID: 1, userID: "jane"},
+			21: {projectID: 2, userID: "john"},
+		},
+		requests: []*Request{
+			{ID: 1, ProjectID: 1, UserID: "john", Status: StatusPending},
+			{ID: 2, ProjectID: 1, SessionID: 21, Status: StatusPending}, // session of another project
+		},
+	}
+	files := &testObjStorage{files: map[string]bool{
+		"11/dom.mobs": true, "11/dom.mobe": true, "12/devtools.mob": true, "13/replay.mp4": true,
+		"14/dom.mobs": true, "21/dom.mobs": true, "111/dom.mobs": true,
+	}}
+	e := newTestEraser(storage, files)
+	e.ProcessRequests()
+
+	for _, req := range storage.requests {
+		if req.Status != StatusDone {
+			t.Errorf("Expected done request %d, got %s: %s", req.ID, req.Status, req.Error)
+		}
+	}
+	if storage.requests[0].Sessions != 3 || storage.requests[1].Sessions != 0 {
+		t.Errorf("Wrong number of deleted sessions: %d, %d", storage.requests[0].Sessions, storage.requests[1].Sessions)
+	}
+	if len(storage.sessions) != 2 || storage.sessions[14] == nil || storage.sessions[21] == nil {
+		t.Errorf("Wrong sessions are deleted, left: %v", storage.sessions)
+	}
+	if len(files.files) != 3 || !files.files["111/dom.mobs"] {
+		t.Errorf("Wrong files are deleted, left: %v", files.files)
+	}
+	// 3 sessions + user's values
+	if len(storage.records) != 4 {
+		t.Fatalf("Expected 4 audit records, got %d", len(storage.records))
+	}
+	for _, r := range storage.records {
+		if r.RequestID != 1 || r.Reason != ReasonErasure {
+			t.Errorf("Wrong audit record: %+v", r)
+		}
+		if r.SessionID == 11 && (len(r.Files) != 2 || len(r.Tables) != 2) {
+			t.Errorf("Wrong audit record of the session: %+v", r)
+		}
+	}
+}
+
+func TestFailedErasure(t *testing.T) {
+	storage := &testStorage{
+		sessions: map[uint64]*testSession{1: {projectID: 1}},
+		requests: []*Request{{ID: 1, ProjectID: 1, SessionID: 1, Status: StatusPending}},
+	}
+	files := &testObjStorage{files: map[string]bool{"1/dom.mobs": true}, fail: true}
+	e := newTestEraser(storage, files)
+	e.ProcessRequests()
+	if req := storage.requests[0]; req.Status != StatusPending || req.Attempts != 1 || req.Error == "" {
+		t.Errorf("Expected pending request after the first failure, got %+v", req)
+	}
+	// The session isn't deleted until its files are deleted
+	if len(storage.sessions) != 1 || len(storage.records) != 0 {
+		t.Errorf("Expected untouched session")
+	}
+	e.ProcessRequests()
+	if req := storage.requests[0]; req.Status != StatusFailed {
+		t.Errorf("Expected failed request after all attempts, got %s", req.Status)
+	}
+}
+
+func TestApplyRetention(t *testing.T) {
+	now := time.Now()
+	day := int64(24 * time.Hour / time.Millisecond)
+	storage := &testStorage{sessions: map[uint64]*testSession{
+		1: {projectID: 1, startTs: now.UnixMilli() - 40*day},
+		2: {projectID: 1, startTs: now.UnixMilli() - 31*day},
+		3: {projectID: 1, startTs: now.UnixMilli() - 29*day},
+		4: {projectID: 2, startTs: now.UnixMilli() - 400*day}, // project without retention
+		5: {projectID: 1, startTs: now.UnixMilli() - 35*day},
+	}}
+	e := newTestEraser(storage, &testObjStorage{files: map[string]bool{}})
+	e.ApplyRetention(now)
+	if len(storage.sessions) != 2 || storage.sessions[3] == nil || storage.sessions[4] == nil {
+		t.Errorf("Wrong sessions are deleted, left: %v", storage.sessions)
+	}
+	if len(storage.records) != 3 || storage.records[0].Reason != ReasonRetention {
+		t.Errorf("Expected 3 retention records, got %d", len(storage.records))
+	}
+	// 2 batches, but a single analytics delete
+	if calls := e.analytics.(*testAnalytics).calls; calls != 1 {
+		t.Errorf("Expected 1 analytics delete, got %d", calls)
+	}
+
+	storage.sessions[5] = &testSession{projectID: 1, startTs: now.UnixMilli() - 40*day}
+	e.cfg.DryRun = true
+	e.ApplyRetention(now)
+	if storage.sessions[5] == nil {
+		t.Errorf("Expected no deletions in dry run")
+	}
+}
@@ -0,0 +1,246 @@
+package eraser
+
+import (
+	"github.com/jackc/pgx/v4"
+
+	"openreplay/backend/pkg/db/postgres/pool"
+)
+
+// Project is a project with its retention period
+type Project struct {
+	ID            uint32
+	RetentionDays int
+}
+
+// Request is a row of public.erasure_requests, one of UserID and SessionID is set
+type Request struct {
+	ID        uint64
+	ProjectID uint32
+	UserID    string
+	SessionID uint64
+	Attempts  int
+	Status    string
+	Error     string
+	Sessions  int
+}
+
+const (
+	StatusPending = "pending"
+	StatusDone    = "done"
+	StatusFailed  = "failed"
+)
+
+// Record is a row of the audit log, SessionID is empty for data which doesn't belong to sessions
+type Record struct {
+	ProjectID uint32
+	SessionID uint64
+	RequestID uint64
+	Reason    string
+	Files     []string
+	Tables    []string
+}
+
+type Storage interface {
+	GetProjects(defaultDays int) ([]*Project, error)
+	GetExpiredSessions(projectID uint32, before int64, limit int) ([]uint64, error)
+	GetUserSessions(projectID uint32, userID string, limit int) ([]uint64, error)
+	GetSession(projectID uint32, sessionID uint64) ([]uint64, error)
+	DeleteSessions(projectID uint32, sessionIDs []uint64) ([]string, error)
+	DeleteUser(projectID uint32, userID string) ([]string, error)
+	GetPendingRequests(limit int) ([]*Request, error)
+	UpdateRequest(req *Request) error
+	SaveRecords(records []*Record) error
+}
+
+type storageImpl struct {
+	db pool.Pool
+}
+
+func NewStorage(db pool.Pool) Storage {
+	return &storageImpl{db: db}
+}
+
+func (s *storageImpl) GetProjects(defaultDays int) ([]*Project, error) {
+	rows, err := s.db.Query(`
+		SELECT project_id, COALESCE(retention_days, $1)
+		FROM public.projects
+		WHERE deleted_at IS NULL`,
+		defaultDays,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := make([]*Project, 0)
+	for rows.Next() {
+		p := &Project{}
+		if err := rows.Scan(&p.ID, &p.RetentionDays); err != nil {
+			return nil, err
+		}
+		list = append(list, p)
+	}
+	return list, rows.Err()
+}
+
+func (s *storageImpl) sessionIDs(sql string, args ...interface{}) ([]uint64, error) {
+	rows, err := s.db.Query(sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := make([]uint64, 0)
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, uint64(id))
+	}
+	return ids, rows.Err()
+}
+
+func (s *storageImpl) GetExpiredSessions(projectID uint32, before int64, limit int) ([]uint64, error) {
+	return s.sessionIDs(`
+		SELECT session_id
+		FROM public.sessions
+		WHERE project_id = $1 AND start_ts < $2
+		LIMIT $3`,
+		projectID, before, limit,
+	)
+}
+
+func (s *storageImpl) GetUserSessions(projectID uint32, userID string, limit int) ([]uint64, error) {
+	return s.sessionIDs(`
+		SELECT session_id
+		FROM public.sessions
+		WHERE project_id = $1 AND user_id = $2
+		LIMIT $3`,
+		projectID, userID, limit,
+	)
+}
+
+// GetSession returns the session id only if the session belongs to the project
+func (s *storageImpl) GetSession(projectID uint32, sessionID uint64) ([]uint64, error) {
+	return s.sessionIDs(`
+		SELECT session_id
+		FROM public.sessions
+		WHERE project_id = $1 AND session_id = $2`,
+		projectID, sessionID,
+	)
+}
+
+// DeleteSessions deletes sessions, rows of events, issues, notes, etc. are deleted by cascade.
+// Webhook deliveries and digest samples have no foreign key to sessions, so they are deleted
+// explicitly in the same batch (a single transaction).
+func (s *storageImpl) DeleteSessions(projectID uint32, sessionIDs []uint64) ([]string, error) {
+	batch := &pgx.Batch{}
+	batch.Queue(`DELETE FROM public.session_webhook_pending WHERE session_id = ANY ($1)`, sessionIDs)
+	batch.Queue(`DELETE FROM public.session_webhook_deliveries WHERE session_id = ANY ($1)`, sessionIDs)
+	batch.Queue(`
+		DELETE FROM public.issue_digests_sessions
+		WHERE project_id = $1 AND session_id = ANY ($2)`,
+		projectID, sessionIDs,
+	)
+	batch.Queue(`
+		DELETE FROM public.sessions
+		WHERE project_id = $1 AND session_id = ANY ($2)`,
+		projectID, sessionIDs,
+	)
+	results := s.db.SendBatch(batch)
+	defer results.Close()
+	for i := 0; i < batch.Len(); i++ {
+		if _, err := results.Exec(); err != nil {
+			return nil, err
+		}
+	}
+	return []string{
+		"public.session_webhook_pending",
+		"public.session_webhook_deliveries",
+		"public.issue_digests_sessions",
+		"public.sessions",
+	}, nil
+}
+
+// DeleteUser deletes user's values which don't belong to sessions
+func (s *storageImpl) DeleteUser(projectID uint32, userID string) ([]string, error) {
+	err := s.db.Exec(`
+		DELETE FROM public.autocomplete
+		WHERE project_id = $1 AND type IN ('USERID', 'USERID_IOS') AND value = $2`,
+		projectID, userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return []string{"public.autocomplete"}, nil
+}
+
+func (s *storageImpl) GetPendingRequests(limit int) ([]*Request, error) {
+	rows, err := s.db.Query(`
+		SELECT request_id, project_id, COALESCE(user_id, ''), COALESCE(session_id, 0), attempts
+		FROM public.erasure_requests
+		WHERE status = 'pending'
+		ORDER BY created_at
+		LIMIT $1`,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := make([]*Request, 0)
+	for rows.Next() {
+		req := &Request{Status: StatusPending}
+		var sessionID int64
+		if err := rows.Scan(&req.ID, &req.ProjectID, &req.UserID, &sessionID, &req.Attempts); err != nil {
+			return nil, err
+		}
+		req.SessionID = uint64(sessionID)
+		list = append(list, req)
+	}
+	return list, rows.Err()
+}
+
+func (s *storageImpl) UpdateRequest(req *Request) error {
+	var errMsg *string
+	if req.Error != "" {
+		errMsg = &req.Error
+	}
+	return s.db.Exec(`
+		UPDATE public.erasure_requests
+		SET status         = $2,
+		    attempts       = $3,
+		    error          = $4,
+		    sessions_count = $5,
+		    processed_at   = CASE WHEN $2 = 'pending' THEN NULL ELSE timezone('utc'::text, now()) END
+		WHERE request_id = $1`,
+		req.ID, req.Status, req.Attempts, errMsg, req.Sessions,
+	)
+}
+
+func (s *storageImpl) SaveRecords(records []*Record) error {
+	batch := &pgx.Batch{}
+	for _, r := range records {
+		var sessionID, requestID *int64
+		if r.SessionID != 0 {
+			id := int64(r.SessionID)
+			sessionID = &id
+		}
+		if r.RequestID != 0 {
+			id := int64(r.RequestID)
+			requestID = &id
+		}
+		batch.Queue(`
+			INSERT INTO public.deletion_audit_log (project_id, session_id, request_id, reason, files, tables)
+			VALUES ($1, $2, $3, $4, $5, $6)`,
+			r.ProjectID, sessionID, requestID, r.Reason, r.Files, r.Tables,
+		)
+	}
+	results := s.db.SendBatch(batch)
+	defer results.Close()
+	for range records {
+		if _, err := results.Exec(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package retention
+
+import (
+	"log"
+	"time"
+
+	config "openreplay/backend/internal/config/retention"
+	"openreplay/backend/internal/retention/eraser"
+	"openreplay/backend/internal/service"
+)
+
+type retentionImpl struct {
+	cfg      *config.Config
+	eraser   *eraser.Eraser
+	done     chan struct{}
+	finished chan struct{}
+}
+
+// New runs the service. Retention periods are applied on start and then every RETENTION_CHECK_INTERVAL,
+// erasure requests are checked every ERASURE_REQUESTS_INTERVAL.
+func New(cfg *config.Config, eraser *eraser.Eraser) service.Interface {
+	s := &retentionImpl{
+		cfg:      cfg,
+		eraser:   eraser,
+		done:     make(chan struct{}),
+		finished: make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+func (r *retentionImpl) run() {
+	r.eraser.ProcessRequests()
+	r.eraser.ApplyRetention(time.Now())
+	retentionTick := time.Tick(r.cfg.RetentionInterval)
+	requestsTick := time.Tick(r.cfg.RequestsInterval)
+	for {
+		select {
+		case <-requestsTick:
+			r.eraser.ProcessRequests()
+		case now := <-retentionTick:
+			r.eraser.ApplyRetention(now)
+		case <-r.done:
+			log.Println("stopping retention service")
+			r.finished <- struct{}{}
+			return
+		}
+	}
+}
+
+func (r *retentionImpl) Stop() {
+	r.done <- struct{}{}
+	<-r.finished
+}
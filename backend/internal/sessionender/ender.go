@@ -1,11 +1,13 @@
 package sessionender
 
 import (
+	"fmt"
 	"log"
 	"time"
 
 	"openreplay/backend/pkg/messages"
 	"openreplay/backend/pkg/metrics/ender"
+	"openreplay/backend/pkg/queue/types"
 )
 
 // EndedSessionHandler handler for ended sessions
@@ -30,6 +32,9 @@ type SessionEnder struct {
 }
 
 func New(timeout int64, parts int) (*SessionEnder, error) {
+	if parts <= 0 {
+		return nil, fmt.Errorf("wrong number of partitions: %d", parts)
+	}
 	return &SessionEnder{
 		timeout:  timeout,
 		sessions: make(map[uint64]*session),
@@ -55,7 +60,7 @@ func (se *SessionEnder) ActivePartitions(parts []uint64) {
 	removedSessions := 0
 	activeSessions := 0
 	for sessID, _ := range se.sessions {
-		if !activeParts[sessID%se.parts] {
+		if !activeParts[types.SessionPartition(sessID, se.parts)] {
 			delete(se.sessions, sessID)
 			ender.DecreaseActiveSessions()
 			removedSessions++
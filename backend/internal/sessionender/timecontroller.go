@@ -1,5 +1,7 @@
 package sessionender
 
+import "openreplay/backend/pkg/queue/types"
+
 type timeController struct {
 	parts               uint64
 	lastBatchTimestamp  map[uint64]int64 // map[partition]consumerTimeOfLastMessage
@@ -15,14 +17,14 @@ func NewTimeController(parts int) *timeController {
 }
 
 func (tc *timeController) UpdateTime(sessionID uint64, batchTimestamp, updateTimestamp int64) {
-	tc.lastBatchTimestamp[sessionID%tc.parts] = batchTimestamp
-	tc.lastUpdateTimestamp[sessionID%tc.parts] = updateTimestamp
+	tc.lastBatchTimestamp[types.SessionPartition(sessionID, tc.parts)] = batchTimestamp
+	tc.lastUpdateTimestamp[types.SessionPartition(sessionID, tc.parts)] = updateTimestamp
 }
 
 func (tc *timeController) LastBatchTimestamp(sessionID uint64) int64 {
-	return tc.lastBatchTimestamp[sessionID%tc.parts]
+	return tc.lastBatchTimestamp[types.SessionPartition(sessionID, tc.parts)]
 }
 
 func (tc *timeController) LastUpdateTimestamp(sessionID uint64) int64 {
-	return tc.lastUpdateTimestamp[sessionID%tc.parts]
+	return tc.lastUpdateTimestamp[types.SessionPartition(sessionID, tc.parts)]
 }
@@ -0,0 +1,244 @@
+package sessionexport
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+	Archive is a tar.gz file with a signed manifest and session files:
+		manifest.json        - session id, source installation, list of files with their sha256 hashes
+		manifest.sig         - ed25519 signature of manifest.json
+		session.json         - session metadata (sessions.Session)
+		mob/*                - decrypted and decompressed replay files
+		assets/*             - cached assets (css, fonts) referenced by the replay, named by their cache keys
+		events/<table>.jsonl - rows of events tables
+		references/<table>.jsonl - rows of issues, errors and crashes referenced by the events
+	The signature covers the manifest and the manifest covers every file, so any change of the archive is detected.
+*/
+
+const (
+	formatVersion = 1
+	manifestName  = "manifest.json"
+	signatureName = "manifest.sig"
+	sessionName   = "session.json"
+	mobDir        = "mob/"
+	assetsDir     = "assets/"
+	eventsDir     = "events/"
+	referencesDir = "references/"
+)
+
+type File struct {
+	Name   string `json:"name"`
+	Size   int    `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+type Manifest struct {
+	Version      int               `json:"version"`
+	SessionID    uint64            `json:"sessionID"`
+	ProjectID    uint32            `json:"projectID"`
+	Metadata     map[string]string `json:"metadata,omitempty"` // metadata key name -> value, slots differ between projects
+	AssetsOrigin string            `json:"assetsOrigin"`
+	CreatedAt    time.Time         `json:"createdAt"`
+	PublicKey    string            `json:"publicKey"` // to verify the signature, must be one of the trusted keys of the importer
+	Files        []*File           `json:"files"`
+}
+
+// Archive is the content of a verified archive
+type Archive struct {
+	Manifest *Manifest
+	Files    map[string][]byte
+}
+
+// ParsePrivateKey parses base64 ed25519 private key or its 32 bytes seed
+func ParsePrivateKey(key string) (ed25519.PrivateKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, fmt.Errorf("can't decode private key: %s", err)
+	}
+	switch len(raw) {
+	case ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(raw), nil
+	case ed25519.PrivateKeySize:
+		return ed25519.PrivateKey(raw), nil
+	}
+	return nil, fmt.Errorf("wrong private key size: %d", len(raw))
+}
+
+func ParsePublicKey(key string) (ed25519.PublicKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, fmt.Errorf("can't decode public key: %s", err)
+	}
+	if len(raw) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("wrong public key size: %d", len(raw))
+	}
+	return ed25519.PublicKey(raw), nil
+}
+
+func hash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// WriteArchive fills the list of files of the manifest, signs it and writes the archive
+func WriteArchive(w io.Writer, manifest *Manifest, files map[string][]byte, key ed25519.PrivateKey) error {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	manifest.Version = formatVersion
+	manifest.PublicKey = base64.StdEncoding.EncodeToString(key.Public().(ed25519.PublicKey))
+	manifest.Files = make([]*File, 0, len(names))
+	for _, name := range names {
+		manifest.Files = append(manifest.Files, &File{Name: name, Size: len(files[name]), SHA256: hash(files[name])})
+	}
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("can't marshal manifest: %s", err)
+	}
+	signature := []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(key, manifestData)))
+
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	write := func(name string, data []byte) error {
+		header := &tar.Header{
+			Name:    name,
+			Mode:    0644,
+			Size:    int64(len(data)),
+			ModTime: manifest.CreatedAt,
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+	// Manifest goes first to be readable with a quick look into the archive
+	if err := write(manifestName, manifestData); err != nil {
+		return err
+	}
+	if err := write(signatureName, signature); err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := write(name, files[name]); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+func validName(name string) bool {
+	return name != "" && !strings.HasPrefix(name, "/") && path.Clean(name) == name && !strings.HasPrefix(name, "..")
+}
+
+// ReadArchive reads the archive and checks its signature with the trusted keys and hashes of all files
+func ReadArchive(r io.Reader, trusted []ed25519.PublicKey, maxFileSize int64) (*Archive, error) {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("not a gzip archive: %s", err)
+	}
+	defer gr.Close()
+	files := make(map[string][]byte)
+	tr := tar.NewReader(gr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("can't read archive: %s", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			return nil, fmt.Errorf("unexpected entry in archive: %s", header.Name)
+		}
+		if !validName(header.Name) {
+			return nil, fmt.Errorf("wrong file name in archive: %s", header.Name)
+		}
+		if _, ok := files[header.Name]; ok {
+			return nil, fmt.Errorf("duplicate file in archive: %s", header.Name)
+		}
+		if header.Size > maxFileSize {
+			return nil, fmt.Errorf("file %s is too big: %d", header.Name, header.Size)
+		}
+		data, err := io.ReadAll(io.LimitReader(tr, maxFileSize))
+		if err != nil {
+			return nil, fmt.Errorf("can't read %s: %s", header.Name, err)
+		}
+		files[header.Name] = data
+	}
+
+	manifestData, signature := files[manifestName], files[signatureName]
+	if manifestData == nil || signature == nil {
+		return nil, errors.New("archive doesn't have a signed manifest")
+	}
+	manifest := &Manifest{}
+	if err := json.Unmarshal(manifestData, manifest); err != nil {
+		return nil, fmt.Errorf("can't parse manifest: %s", err)
+	}
+	if err := verifySignature(manifest, manifestData, signature, trusted); err != nil {
+		return nil, err
+	}
+	if manifest.Version != formatVersion {
+		return nil, fmt.Errorf("unsupported archive version: %d", manifest.Version)
+	}
+	delete(files, manifestName)
+	delete(files, signatureName)
+	if len(files) != len(manifest.Files) {
+		return nil, fmt.Errorf("archive has %d files, manifest lists %d", len(files), len(manifest.Files))
+	}
+	for _, f := range manifest.Files {
+		data, ok := files[f.Name]
+		if !ok {
+			return nil, fmt.Errorf("file %s is missing", f.Name)
+		}
+		if len(data) != f.Size || hash(data) != f.SHA256 {
+			return nil, fmt.Errorf("file %s is modified", f.Name)
+		}
+	}
+	return &Archive{Manifest: manifest, Files: files}, nil
+}
+
+func verifySignature(manifest *Manifest, manifestData, signature []byte, trusted []ed25519.PublicKey) error {
+	key, err := ParsePublicKey(manifest.PublicKey)
+	if err != nil {
+		return err
+	}
+	isTrusted := false
+	for _, t := range trusted {
+		if t.Equal(key) {
+			isTrusted = true
+			break
+		}
+	}
+	if !isTrusted {
+		return fmt.Errorf("archive is signed with untrusted key: %s", manifest.PublicKey)
+	}
+	sig, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(signature)))
+	if err != nil {
+		return fmt.Errorf("can't decode signature: %s", err)
+	}
+	if !ed25519.Verify(key, manifestData, sig) {
+		return errors.New("wrong signature of manifest")
+	}
+	return nil
+}
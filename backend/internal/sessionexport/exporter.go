@@ -0,0 +1,161 @@
+package sessionexport
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	config "openreplay/backend/internal/config/sessionexport"
+	"openreplay/backend/internal/storage"
+	"openreplay/backend/pkg/objectstorage"
+	"openreplay/backend/pkg/projects"
+)
+
+const (
+	domStart = "dom.mobs"
+	domEnd   = "dom.mobe"
+	devtools = "devtools.mob"
+)
+
+type Exporter struct {
+	cfg      *config.Config
+	storage  Storage
+	projects projects.Projects
+	files    objectstorage.ObjectStorage // sessions bucket
+	assets   objectstorage.ObjectStorage
+	key      ed25519.PrivateKey
+}
+
+func NewExporter(cfg *config.Config, storage Storage, projects projects.Projects, files, assets objectstorage.ObjectStorage, key ed25519.PrivateKey) *Exporter {
+	return &Exporter{
+		cfg:      cfg,
+		storage:  storage,
+		projects: projects,
+		files:    files,
+		assets:   assets,
+		key:      key,
+	}
+}
+
+// Export writes the archive with all data of the session required to replay it
+func (e *Exporter) Export(sessionID uint64, w io.Writer) (*Manifest, error) {
+	sess, err := e.storage.GetSession(sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("can't get session: %s", err)
+	}
+	fileKey, err := e.storage.GetFileKey(sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("can't get encryption key: %s", err)
+	}
+	manifest := &Manifest{
+		SessionID:    sessionID,
+		ProjectID:    sess.ProjectID,
+		Metadata:     make(map[string]string),
+		AssetsOrigin: e.cfg.AssetsOrigin,
+		CreatedAt:    time.Now().UTC(),
+	}
+	if proj, err := e.projects.GetProject(sess.ProjectID); err == nil {
+		for i, name := range proj.GetMetadataKeys() {
+			if value := sess.GetMetadata(uint(i + 1)); name != nil && value != nil {
+				manifest.Metadata[*name] = *value
+			}
+		}
+	} else {
+		log.Printf("can't get project %d, metadata isn't exported: %s", sess.ProjectID, err)
+	}
+	files := make(map[string][]byte)
+
+	// Replay files, files are stored decrypted, so the key isn't exported
+	prefix := strconv.FormatUint(sessionID, 10) + "/"
+	for _, name := range []string{domStart, domEnd, devtools} {
+		if !e.files.Exists(prefix + name) {
+			continue
+		}
+		data, err := e.readFile(e.files, prefix+name)
+		if err != nil {
+			return nil, fmt.Errorf("can't read %s: %s", name, err)
+		}
+		if fileKey != "" {
+			if data, err = storage.DecryptData(data, []byte(fileKey)); err != nil {
+				return nil, fmt.Errorf("can't decrypt %s: %s", name, err)
+			}
+		}
+		if data, err = decompress(data, e.cfg.MaxFileSize); err != nil {
+			return nil, fmt.Errorf("can't decompress %s: %s", name, err)
+		}
+		files[mobDir+name] = data
+	}
+	if files[mobDir+domStart] == nil {
+		return nil, fmt.Errorf("replay of session %d not found", sessionID)
+	}
+
+	// Assets, dom file is split into two parts at any byte, so urls are searched in the whole file
+	dom := append(append([]byte{}, files[mobDir+domStart]...), files[mobDir+domEnd]...)
+	for _, key := range findAssets(dom, e.cfg.AssetsOrigin) {
+		if !e.assets.Exists(key) {
+			log.Printf("asset %s of session %d isn't cached", key, sessionID)
+			continue
+		}
+		data, err := e.readFile(e.assets, key)
+		if err == nil {
+			data, err = decompress(data, e.cfg.MaxFileSize)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("can't read asset %s: %s", key, err)
+		}
+		files[assetsDir+strings.TrimPrefix(key, "/")] = data
+	}
+
+	// Events and rows they reference
+	for _, ref := range referenceTables {
+		rows, err := e.storage.GetReferences(ref.name, sessionID)
+		if err != nil {
+			return nil, fmt.Errorf("can't get %s: %s", ref.name, err)
+		}
+		if len(rows) > 0 {
+			files[referencesDir+ref.name+".jsonl"] = jsonLines(rows)
+		}
+	}
+	for _, table := range eventTables {
+		rows, err := e.storage.GetEvents(table, sessionID)
+		if err != nil {
+			return nil, fmt.Errorf("can't get %s: %s", table, err)
+		}
+		if len(rows) > 0 {
+			files[eventsDir+table+".jsonl"] = jsonLines(rows)
+		}
+	}
+
+	sess.EncryptionKey = ""
+	if files[sessionName], err = json.MarshalIndent(sess, "", "  "); err != nil {
+		return nil, fmt.Errorf("can't marshal session: %s", err)
+	}
+	if err := WriteArchive(w, manifest, files, e.key); err != nil {
+		return nil, fmt.Errorf("can't write archive: %s", err)
+	}
+	return manifest, nil
+}
+
+func (e *Exporter) readFile(store objectstorage.ObjectStorage, key string) ([]byte, error) {
+	reader, err := store.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return readAll(reader, e.cfg.MaxFileSize)
+}
+
+func jsonLines(rows []json.RawMessage) []byte {
+	buf := &bytes.Buffer{}
+	for _, row := range rows {
+		buf.Write(row)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
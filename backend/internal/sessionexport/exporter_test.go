@@ -0,0 +1,251 @@
+package sessionexport
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	config "openreplay/backend/internal/config/sessionexport"
+	"openreplay/backend/pkg/messages"
+	"openreplay/backend/pkg/objectstorage"
+	"openreplay/backend/pkg/projects"
+	"openreplay/backend/pkg/sessions"
+	"openreplay/backend/pkg/url/assets"
+)
+
+const sessionID uint64 = 7120436923183972352
+
+type testStorage struct {
+	Storage
+	session    *sessions.Session
+	events     map[string][]json.RawMessage
+	imported   *sessions.Session
+	references []*Table
+	tables     []*Table
+}
+
+func (s *testStorage) GetSession(sessionID uint64) (*sessions.Session, error) {
+	if s.session == nil {
+		return nil, errors.New("no rows in result set")
+	}
+	sess := *s.session
+	return &sess, nil
+}
+
+func (s *testStorage) GetFileKey(sessionID uint64) (string, error) {
+	return "", nil
+}
+
+func (s *testStorage) GetEvents(table string, sessionID uint64) ([]json.RawMessage, error) {
+	return s.events[table], nil
+}
+
+func (s *testStorage) GetReferences(table string, sessionID uint64) ([]json.RawMessage, error) {
+	return s.events[table], nil
+}
+
+func (s *testStorage) SessionExists(sessionID uint64) (bool, error) {
+	return s.imported != nil, nil
+}
+
+func (s *testStorage) Import(sess *sessions.Session, references, events []*Table) error {
+	s.imported, s.references, s.tables = sess, references, events
+	return nil
+}
+
+type testProjects struct {
+	projects.Projects
+	project *projects.Project
+}
+
+func (p *testProjects) GetProject(projectID uint32) (*projects.Project, error) {
+	proj := *p.project
+	proj.ProjectID = projectID
+	return &proj, nil
+}
+
+type testObjStorage struct {
+	objectstorage.ObjectStorage
+	files        map[string][]byte
+	contentTypes map[string]string
+}
+
+func newTestObjStorage() *testObjStorage {
+	return &testObjStorage{files: make(map[string][]byte), contentTypes: make(map[string]string)}
+}
+
+func (s *testObjStorage) Upload(reader io.Reader, key string, contentType string, compression objectstorage.CompressionType) error {
+	data, err := io.ReadAll(reader)
+	s.files[key], s.contentTypes[key] = data, contentType
+	return err
+}
+
+func (s *testObjStorage) Get(key string) (io.ReadCloser, error) {
+	return io.NopCloser(bytes.NewReader(s.files[key])), nil
+}
+
+func (s *testObjStorage) Exists(key string) bool {
+	_, ok := s.files[key]
+	return ok
+}
+
+func gzipped(data []byte) []byte {
+	buf := &bytes.Buffer{}
+	w := gzip.NewWriter(buf)
+	w.Write(data)
+	w.Close()
+	return buf.Bytes()
+}
+
+func str(s string) *string {
+	return &s
+}
+
+// exportTestSession returns the archive and the url path of the cached css file
+func exportTestSession(t *testing.T, key ed25519.PrivateKey) ([]byte, string, string) {
+	oldOrigin := "https://old.example.com/sessions-assets"
+	cssURL := assets.NewRewriter(oldOrigin).RewriteURL(sessionID, "https://site.com/page", "/static/main.css")
+	mob := append([]byte{}, sortedMobHeader...)
+	mob = append(mob, (&messages.Timestamp{Timestamp: 1700000000000}).Encode()...)
+	mob = append(mob, (&messages.SetNodeAttribute{ID: 5, Name: "href", Value: cssURL}).Encode()...)
+	mob = append(mob, (&messages.SetCSSData{ID: 6, Data: "body { background: url(" + cssURL + "); }"}).Encode()...)
+
+	files, assetFiles := newTestObjStorage(), newTestObjStorage()
+	files.files["7120436923183972352/dom.mobs"] = gzipped(mob[:20])
+	files.files["7120436923183972352/dom.mobe"] = gzipped(mob[20:])
+	files.files["7120436923183972352/devtools.mob"] = gzipped([]byte("devtools"))
+	cacheKey := assets.GetCachePathForAssets(sessionID, "https://site.com/static/main.css")
+	assetFiles.files[cacheKey] = gzipped([]byte("body { color: red; }"))
+
+	storage := &testStorage{
+		session: &sessions.Session{SessionID: sessionID, ProjectID: 1, Metadata1: str("premium"), EncryptionKey: "secret"},
+		events: map[string][]json.RawMessage{
+			"events.pages":         {json.RawMessage(`{"session_id":7120436923183972352,"timestamp":1700000000001,"path":"/page"}`)},
+			"events_common.issues": {json.RawMessage(`{"session_id":1,"issue_id":"1abc","seq_index":1}`)},
+			"public.issues":        {json.RawMessage(`{"issue_id":"1abc","project_id":1,"type":"click_rage"}`)},
+		},
+	}
+	cfg := &config.Config{AssetsOrigin: oldOrigin, MaxFileSize: 1 << 20}
+	exporter := NewExporter(cfg, storage, &testProjects{project: &projects.Project{Metadata1: str("plan")}}, files, assetFiles, key)
+	archive := &bytes.Buffer{}
+	manifest, err := exporter.Export(sessionID, archive)
+	if err != nil {
+		t.Fatalf("Can't export session: %s", err)
+	}
+	if len(manifest.Files) != 8 || manifest.Metadata["plan"] != "premium" {
+		t.Fatalf("Wrong manifest: %+v", manifest)
+	}
+	return archive.Bytes(), cacheKey, strings.TrimPrefix(cssURL, oldOrigin)
+}
+
+func TestExportImport(t *testing.T) {
+	public, private, _ := ed25519.GenerateKey(nil)
+	archive, cacheKey, cssPath := exportTestSession(t, private)
+
+	newOrigin := "https://new.example.com/assets"
+	cfg := &config.Config{AssetsOrigin: newOrigin, MaxFileSize: 1 << 20}
+	storage := &testStorage{}
+	files, assetFiles := newTestObjStorage(), newTestObjStorage()
+	target := &testProjects{project: &projects.Project{Metadata3: str("plan")}}
+	importer := NewImporter(cfg, storage, target, files, assetFiles, []ed25519.PublicKey{public})
+	if _, err := importer.Import(bytes.NewReader(archive), 42); err != nil {
+		t.Fatalf("Can't import session: %s", err)
+	}
+
+	sess := storage.imported
+	if sess == nil || sess.SessionID != sessionID || sess.ProjectID != 42 || sess.EncryptionKey != "" {
+		t.Fatalf("Wrong imported session: %+v", sess)
+	}
+	if sess.Metadata1 != nil || sess.Metadata3 == nil || *sess.Metadata3 != "premium" {
+		t.Errorf("Metadata isn't moved to the key of the new project")
+	}
+	if string(assetFiles.files[cacheKey]) != "body { color: red; }" || assetFiles.contentTypes[cacheKey] != "text/css; charset=utf-8" {
+		t.Errorf("Wrong imported asset %s: %s", cacheKey, assetFiles.contentTypes[cacheKey])
+	}
+	if string(files.files["7120436923183972352/devtools.mob"]) != "devtools" {
+		t.Errorf("Wrong imported devtools file")
+	}
+	dom := append(files.files["7120436923183972352/dom.mobs"], files.files["7120436923183972352/dom.mobe"]...)
+	if bytes.Contains(dom, []byte("old.example.com")) || bytes.Count(dom, []byte(newOrigin+cssPath)) != 2 {
+		t.Errorf("Assets urls aren't rewritten: %q", dom)
+	}
+	// Issue ids of the source project are moved to the target project (42 = 0x2a)
+	if len(storage.references) != 1 || !strings.Contains(string(storage.references[0].Rows[0]), `"project_id":42`) ||
+		!strings.Contains(string(storage.references[0].Rows[0]), `"issue_id":"2aabc"`) {
+		t.Errorf("Wrong imported references: %s", storage.references[0].Rows)
+	}
+	if len(storage.tables) != 2 || !strings.Contains(string(storage.tables[0].Rows[0]), "7120436923183972352") {
+		t.Errorf("Wrong imported events: %v", storage.tables)
+	}
+	for _, table := range storage.tables {
+		row := string(table.Rows[0])
+		if !strings.Contains(row, `"session_id":7120436923183972352`) {
+			t.Errorf("Session id of %s isn't set: %s", table.Name, row)
+		}
+		if table.Name == "events_common.issues" && !strings.Contains(row, `"issue_id":"2aabc"`) {
+			t.Errorf("Issue id of the event isn't moved to the target project: %s", row)
+		}
+	}
+
+	// The same session can't be imported twice
+	if _, err := importer.Import(bytes.NewReader(archive), 42); err == nil {
+		t.Errorf("Expected error on second import")
+	}
+}
+
+func TestModifiedArchive(t *testing.T) {
+	public, private, _ := ed25519.GenerateKey(nil)
+	archive, _, _ := exportTestSession(t, private)
+
+	// Replace session.json keeping the signed manifest
+	gr, _ := gzip.NewReader(bytes.NewReader(archive))
+	tr := tar.NewReader(gr)
+	modified := &bytes.Buffer{}
+	gw := gzip.NewWriter(modified)
+	tw := tar.NewWriter(gw)
+	for {
+		header, err := tr.Next()
+		if err != nil {
+			break
+		}
+		data, _ := io.ReadAll(tr)
+		if header.Name == sessionName {
+			data = bytes.Replace(data, []byte(`"ProjectID": 1`), []byte(`"ProjectID": 2`), 1)
+			header.Size = int64(len(data))
+		}
+		tw.WriteHeader(header)
+		tw.Write(data)
+	}
+	tw.Close()
+	gw.Close()
+
+	if _, err := ReadArchive(bytes.NewReader(archive), []ed25519.PublicKey{public}, 1<<20); err != nil {
+		t.Fatalf("Can't read original archive: %s", err)
+	}
+	if _, err := ReadArchive(bytes.NewReader(modified.Bytes()), []ed25519.PublicKey{public}, 1<<20); err == nil || !strings.Contains(err.Error(), "modified") {
+		t.Errorf("Expected error about modified file, got: %v", err)
+	}
+	other, _, _ := ed25519.GenerateKey(nil)
+	if _, err := ReadArchive(bytes.NewReader(archive), []ed25519.PublicKey{other}, 1<<20); err == nil || !strings.Contains(err.Error(), "untrusted") {
+		t.Errorf("Expected error about untrusted key, got: %v", err)
+	}
+}
+
+func TestIDScope(t *testing.T) {
+	scope := newIDScope(1, 42)
+	if id := scope.id("1abc"); id != "2aabc" {
+		t.Errorf("Wrong id of the target project: %s", id)
+	}
+	if id := scope.id("legacy"); !strings.HasPrefix(id, "2a") || len(id) != 34 || id == scope.id("legacy2") {
+		t.Errorf("Wrong hashed id: %s", id)
+	}
+	if id := newIDScope(42, 42).id("2aabc"); id != "2aabc" {
+		t.Errorf("Id of the same project is changed: %s", id)
+	}
+}
@@ -0,0 +1,253 @@
+package sessionexport
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+
+	config "openreplay/backend/internal/config/sessionexport"
+	"openreplay/backend/pkg/objectstorage"
+	"openreplay/backend/pkg/projects"
+	"openreplay/backend/pkg/sessions"
+)
+
+type Importer struct {
+	cfg      *config.Config
+	storage  Storage
+	projects projects.Projects
+	files    objectstorage.ObjectStorage // sessions bucket
+	assets   objectstorage.ObjectStorage
+	trusted  []ed25519.PublicKey
+}
+
+func NewImporter(cfg *config.Config, storage Storage, projects projects.Projects, files, assets objectstorage.ObjectStorage, trusted []ed25519.PublicKey) *Importer {
+	return &Importer{
+		cfg:      cfg,
+		storage:  storage,
+		projects: projects,
+		files:    files,
+		assets:   assets,
+		trusted:  trusted,
+	}
+}
+
+// Import loads the archive into the project. Files are uploaded first and the session is inserted at last,
+// so the session isn't visible until everything is imported and a failed import can be repeated.
+func (i *Importer) Import(r io.Reader, projectID uint32) (*Manifest, error) {
+	archive, err := ReadArchive(r, i.trusted, i.cfg.MaxFileSize)
+	if err != nil {
+		return nil, err
+	}
+	manifest := archive.Manifest
+	sess := &sessions.Session{}
+	if err := json.Unmarshal(archive.Files[sessionName], sess); err != nil {
+		return nil, fmt.Errorf("can't parse session: %s", err)
+	}
+	if sess.SessionID != manifest.SessionID {
+		return nil, fmt.Errorf("archive contains session %d instead of %d", sess.SessionID, manifest.SessionID)
+	}
+	proj, err := i.projects.GetProject(projectID)
+	if err != nil {
+		return nil, fmt.Errorf("can't get project %d: %s", projectID, err)
+	}
+	exists, err := i.storage.SessionExists(sess.SessionID)
+	if err != nil {
+		return nil, fmt.Errorf("can't check session: %s", err)
+	}
+	if exists {
+		return nil, fmt.Errorf("session %d already exists", sess.SessionID)
+	}
+	sess.ProjectID, sess.EncryptionKey = projectID, ""
+	setMetadata(sess, proj, manifest.Metadata)
+
+	references, events, err := i.tables(archive, projectID, sess.SessionID, newIDScope(manifest.ProjectID, projectID))
+	if err != nil {
+		return nil, err
+	}
+
+	for name, data := range archive.Files {
+		if !strings.HasPrefix(name, assetsDir) {
+			continue
+		}
+		key := "/" + strings.TrimPrefix(name, assetsDir)
+		if err := i.assets.Upload(bytes.NewReader(data), key, assetContentType(key), objectstorage.NoCompression); err != nil {
+			return nil, fmt.Errorf("can't upload asset %s: %s", key, err)
+		}
+	}
+	if err := i.uploadReplay(archive); err != nil {
+		return nil, err
+	}
+
+	if err := i.storage.Import(sess, references, events); err != nil {
+		return nil, fmt.Errorf("can't import session: %s", err)
+	}
+	return manifest, nil
+}
+
+// setMetadata moves metadata values to the slots of the same keys in the new project, values without keys are skipped
+func setMetadata(sess *sessions.Session, proj *projects.Project, metadata map[string]string) {
+	sess.Metadata1, sess.Metadata2, sess.Metadata3, sess.Metadata4, sess.Metadata5 = nil, nil, nil, nil, nil
+	sess.Metadata6, sess.Metadata7, sess.Metadata8, sess.Metadata9, sess.Metadata10 = nil, nil, nil, nil, nil
+	for name, value := range metadata {
+		keyNo := proj.GetMetadataNo(name)
+		if keyNo == 0 {
+			log.Printf("project %d doesn't have metadata key %s, value of session %d is skipped", proj.ProjectID, name, sess.SessionID)
+			continue
+		}
+		sess.SetMetadata(keyNo, value)
+	}
+}
+
+// idScope moves ids of project level rows (issues, errors, crashes) to the target project. Ids start with
+// the hex id of the project, so existing rows of another project aren't referenced by the imported events.
+type idScope struct {
+	from, to string
+}
+
+func newIDScope(from, to uint32) *idScope {
+	return &idScope{from: strconv.FormatUint(uint64(from), 16), to: strconv.FormatUint(uint64(to), 16)}
+}
+
+func (s *idScope) id(id string) string {
+	if strings.HasPrefix(id, s.from) {
+		return s.to + strings.TrimPrefix(id, s.from)
+	}
+	// Ids of another format get a hash to not collide with ids of the target project
+	hash := fnv.New128a()
+	hash.Write([]byte(id))
+	return s.to + hex.EncodeToString(hash.Sum(nil))
+}
+
+// tables parses rows of the archive, only known tables are accepted
+func (i *Importer) tables(archive *Archive, projectID uint32, sessionID uint64, scope *idScope) ([]*Table, []*Table, error) {
+	references := make([]*Table, 0)
+	refColumns := make(map[string]string) // events table -> reference column
+	for _, ref := range referenceTables {
+		refColumns[ref.events] = ref.column
+		data, ok := archive.Files[referencesDir+ref.name+".jsonl"]
+		if !ok {
+			continue
+		}
+		columns := map[string]interface{}{"project_id": projectID}
+		if ref.name == "public.errors" {
+			columns["parent_error_id"] = nil // parent errors aren't exported
+		}
+		table, err := parseTable(ref.name, data, columns, ref.column, scope)
+		if err != nil {
+			return nil, nil, err
+		}
+		references = append(references, table)
+	}
+	events := make([]*Table, 0)
+	known := make(map[string]bool)
+	for _, name := range eventTables {
+		known[eventsDir+name+".jsonl"] = true
+		data, ok := archive.Files[eventsDir+name+".jsonl"]
+		if !ok {
+			continue
+		}
+		table, err := parseTable(name, data, map[string]interface{}{"session_id": sessionID}, refColumns[name], scope)
+		if err != nil {
+			return nil, nil, err
+		}
+		events = append(events, table)
+	}
+	for name := range archive.Files {
+		if (strings.HasPrefix(name, eventsDir) && !known[name]) ||
+			(strings.HasPrefix(name, referencesDir) && !isReference(name)) {
+			return nil, nil, fmt.Errorf("unknown table in archive: %s", name)
+		}
+	}
+	return references, events, nil
+}
+
+func isReference(name string) bool {
+	for _, ref := range referenceTables {
+		if name == referencesDir+ref.name+".jsonl" {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTable sets columns of every row and moves ids of the reference column to the target project
+func parseTable(name string, data []byte, columns map[string]interface{}, refColumn string, scope *idScope) (*Table, error) {
+	table := &Table{Name: name, Rows: make([]json.RawMessage, 0)}
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		row, err := setColumns(json.RawMessage(line), columns, refColumn, scope)
+		if err != nil {
+			return nil, fmt.Errorf("wrong row of %s: %s", name, err)
+		}
+		table.Rows = append(table.Rows, row)
+	}
+	return table, nil
+}
+
+func setColumns(row json.RawMessage, columns map[string]interface{}, refColumn string, scope *idScope) (json.RawMessage, error) {
+	// Values are kept raw to not lose precision of big numbers
+	values := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(row, &values); err != nil {
+		return nil, err
+	}
+	for column, value := range columns {
+		data, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		values[column] = data
+	}
+	if data, ok := values[refColumn]; ok && refColumn != "" {
+		var id *string
+		if err := json.Unmarshal(data, &id); err != nil {
+			return nil, fmt.Errorf("wrong %s: %s", refColumn, err)
+		}
+		if id != nil {
+			values[refColumn], _ = json.Marshal(scope.id(*id))
+		}
+	}
+	return json.Marshal(values)
+}
+
+// uploadReplay uploads replay files with assets urls of this installation
+func (i *Importer) uploadReplay(archive *Archive) error {
+	prefix := strconv.FormatUint(archive.Manifest.SessionID, 10) + "/"
+	doms, dome := archive.Files[mobDir+domStart], archive.Files[mobDir+domEnd]
+	if doms == nil {
+		return fmt.Errorf("archive doesn't have a replay")
+	}
+	dom := append(append([]byte{}, doms...), dome...)
+	rewritten, err := rewriteOrigin(dom, archive.Manifest.AssetsOrigin, i.cfg.AssetsOrigin)
+	if err != nil {
+		// Assets are still loaded from the original installation if it's accessible
+		log.Printf("can't rewrite assets urls of session %d: %s", archive.Manifest.SessionID, err)
+		rewritten = dom
+	}
+	// Player reads both parts as one file, so the split point doesn't matter
+	split := len(doms)
+	if dome == nil || split > len(rewritten) {
+		split = len(rewritten)
+	}
+	files := map[string][]byte{domStart: rewritten[:split], devtools: archive.Files[mobDir+devtools]}
+	if split < len(rewritten) {
+		files[domEnd] = rewritten[split:]
+	}
+	for name, data := range files {
+		if data == nil {
+			continue
+		}
+		if err := i.files.Upload(bytes.NewReader(data), prefix+name, "application/octet-stream", objectstorage.NoCompression); err != nil {
+			return fmt.Errorf("can't upload %s: %s", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package sessionexport
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"mime"
+	"net/url"
+	"path"
+	"reflect"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+
+	"openreplay/backend/pkg/messages"
+)
+
+// Sorted mob files start with the maximum index value, messages of such files don't have indexes
+var sortedMobHeader = []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+// decompress detects the compression of stored file (it's set by storage and assets services)
+func decompress(data []byte, maxSize int64) ([]byte, error) {
+	var reader io.Reader
+	switch {
+	case bytes.HasPrefix(data, sortedMobHeader):
+		return data, nil
+	case len(data) > 2 && data[0] == 0x1f && data[1] == 0x8b:
+		gzReader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		reader = gzReader
+	case bytes.HasPrefix(data, zstdMagic):
+		zstdReader, err := zstd.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer zstdReader.Close()
+		reader = zstdReader
+	default:
+		// Brotli doesn't have magic bytes, so just try to decompress
+		if decoded, err := readAll(brotli.NewReader(bytes.NewReader(data)), maxSize); err == nil {
+			return decoded, nil
+		}
+		return data, nil
+	}
+	return readAll(reader, maxSize)
+}
+
+func readAll(reader io.Reader, maxSize int64) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(reader, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxSize {
+		return nil, fmt.Errorf("file is bigger than %d bytes", maxSize)
+	}
+	return data, nil
+}
+
+// isCacheKeyChar reports whether the char can be a part of escaped cache key in the url
+func isCacheKeyChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+		c == '-' || c == '_' || c == '.' || c == '~' || c == '!' || c == '+' || c == '%'
+}
+
+// findAssets returns cache keys of all assets the rewriter has put into the replay.
+// Rewritten urls are <assets origin><cache key>, so it's enough to find the origin in the raw file.
+// Cache keys are query escaped urls with "!" instead of "%", in urls they are path escaped once again.
+func findAssets(mob []byte, origin string) []string {
+	prefix := []byte(strings.TrimSuffix(origin, "/") + "/")
+	keys := make([]string, 0)
+	seen := make(map[string]bool)
+	for {
+		pos := bytes.Index(mob, prefix)
+		if pos < 0 {
+			break
+		}
+		mob = mob[pos+len(prefix):]
+		end := 0
+		for end < len(mob) && isCacheKeyChar(mob[end]) {
+			end++
+		}
+		if end == 0 {
+			continue
+		}
+		key, err := url.PathUnescape("/" + string(mob[:end]))
+		if err == nil && !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+		mob = mob[end:]
+	}
+	return keys
+}
+
+// assetContentType restores the content type of the asset from the extension of its original url
+func assetContentType(key string) string {
+	rawURL, err := url.QueryUnescape(strings.ReplaceAll(strings.TrimPrefix(key, "/"), "!", "%"))
+	if err == nil {
+		if u, err := url.Parse(rawURL); err == nil {
+			// Assets keys have the day suffix after the url (.css.17), js cache keys don't
+			ext := path.Ext(u.Path)
+			if ext != "" && strings.Trim(ext, ".0123456789") == "" {
+				ext = path.Ext(strings.TrimSuffix(u.Path, ext))
+			}
+			if contentType := mime.TypeByExtension(ext); contentType != "" {
+				return contentType
+			}
+		}
+	}
+	return "application/octet-stream"
+}
+
+// rewriteOrigin replaces the assets origin in all string fields of dom messages.
+// Messages without the origin are copied as is, others are decoded, changed and encoded again.
+func rewriteOrigin(mob []byte, from, to string) ([]byte, error) {
+	from, to = strings.TrimSuffix(from, "/")+"/", strings.TrimSuffix(to, "/")+"/"
+	if from == to || !bytes.Contains(mob, []byte(from)) {
+		return mob, nil
+	}
+	res := bytes.NewBuffer(make([]byte, 0, len(mob)))
+	withIndexes := !bytes.HasPrefix(mob, sortedMobHeader)
+	reader := messages.NewBytesReader(mob)
+	if !withIndexes {
+		res.Write(sortedMobHeader)
+		reader.SetPointer(int64(len(sortedMobHeader)))
+	}
+	for int(reader.Pointer()) < len(mob) {
+		if withIndexes {
+			if _, err := reader.ReadIndex(); err != nil {
+				return nil, fmt.Errorf("read message index err: %s", err)
+			}
+			res.Write(mob[reader.Pointer()-8 : reader.Pointer()])
+		}
+		msgStart := reader.Pointer()
+		msgType, err := reader.ReadUint()
+		if err != nil {
+			return nil, fmt.Errorf("read message type err: %s", err)
+		}
+		msg, err := messages.ReadMessage(msgType, reader)
+		if err != nil {
+			return nil, fmt.Errorf("read message body err: %s", err)
+		}
+		raw := mob[msgStart:reader.Pointer()]
+		if !bytes.Contains(raw, []byte(from)) {
+			res.Write(raw)
+			continue
+		}
+		fields := reflect.ValueOf(msg).Elem()
+		for i := 0; i < fields.NumField(); i++ {
+			if field := fields.Field(i); field.Kind() == reflect.String && field.CanSet() {
+				field.SetString(strings.ReplaceAll(field.String(), from, to))
+			}
+		}
+		res.Write(msg.Encode())
+	}
+	return res.Bytes(), nil
+}
@@ -0,0 +1,182 @@
+package sessionexport
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jackc/pgx/v4"
+
+	"openreplay/backend/pkg/db/postgres/pool"
+	"openreplay/backend/pkg/sessions"
+)
+
+// Table is a list of rows of the table, every row is a json object with column names as keys
+type Table struct {
+	Name string
+	Rows []json.RawMessage
+}
+
+// eventTables are tables with rows of one session
+var eventTables = []string{
+	"events.pages", "events.clicks", "events.inputs", "events.errors", "events.graphql", "events.state_actions",
+	"events.resources", "events.performance", "events.canvas_recordings",
+	"events_common.customs", "events_common.issues", "events_common.requests", "events_common.crashes",
+	"events_ios.views", "events_ios.taps", "events_ios.inputs", "events_ios.swipes",
+}
+
+// referenceTables are project level tables referenced by the events, they have to be imported before the events.
+// Their ids are moved to the target project on import, so existing rows are skipped only within the project.
+var referenceTables = []struct {
+	name, column, events string
+}{
+	{"public.issues", "issue_id", "events_common.issues"},
+	{"public.errors", "error_id", "events.errors"},
+	{"public.crashes_ios", "crash_ios_id", "events_common.crashes"},
+}
+
+type Storage interface {
+	GetSession(sessionID uint64) (*sessions.Session, error)
+	GetFileKey(sessionID uint64) (string, error)
+	GetEvents(table string, sessionID uint64) ([]json.RawMessage, error)
+	GetReferences(table string, sessionID uint64) ([]json.RawMessage, error)
+	SessionExists(sessionID uint64) (bool, error)
+	// Import inserts the session, references (skipping existing ones) and events in one transaction
+	Import(sess *sessions.Session, references, events []*Table) error
+}
+
+type storageImpl struct {
+	db       pool.Pool
+	sessions sessions.Storage
+}
+
+func NewStorage(db pool.Pool) Storage {
+	return &storageImpl{
+		db:       db,
+		sessions: sessions.NewStorage(db),
+	}
+}
+
+func (s *storageImpl) GetSession(sessionID uint64) (*sessions.Session, error) {
+	return s.sessions.Get(sessionID)
+}
+
+func (s *storageImpl) GetFileKey(sessionID uint64) (string, error) {
+	var key string
+	err := s.db.QueryRow(`SELECT COALESCE(file_key, '') FROM public.sessions WHERE session_id = $1`, sessionID).Scan(&key)
+	return key, err
+}
+
+func (s *storageImpl) rows(sql string, args ...interface{}) ([]json.RawMessage, error) {
+	rows, err := s.db.Query(sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := make([]json.RawMessage, 0)
+	for rows.Next() {
+		var row string
+		if err := rows.Scan(&row); err != nil {
+			return nil, err
+		}
+		list = append(list, json.RawMessage(row))
+	}
+	return list, rows.Err()
+}
+
+func (s *storageImpl) GetEvents(table string, sessionID uint64) ([]json.RawMessage, error) {
+	return s.rows(fmt.Sprintf(`SELECT row_to_json(t)::text FROM %s t WHERE session_id = $1`, table), sessionID)
+}
+
+func (s *storageImpl) GetReferences(table string, sessionID uint64) ([]json.RawMessage, error) {
+	for _, ref := range referenceTables {
+		if ref.name != table {
+			continue
+		}
+		return s.rows(fmt.Sprintf(`
+			SELECT row_to_json(t)::text
+			FROM %s t
+			WHERE %s IN (SELECT %s FROM %s WHERE session_id = $1)`,
+			table, ref.column, ref.column, ref.events,
+		), sessionID)
+	}
+	return nil, fmt.Errorf("unknown reference table: %s", table)
+}
+
+func (s *storageImpl) SessionExists(sessionID uint64) (bool, error) {
+	var exists bool
+	err := s.db.QueryRow(`SELECT EXISTS(SELECT 1 FROM public.sessions WHERE session_id = $1)`, sessionID).Scan(&exists)
+	return exists, err
+}
+
+// insertRow builds insert of json row, only columns present in the row are set, others get their defaults
+func insertRow(table string, row json.RawMessage, onConflict string) (string, error) {
+	values := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(row, &values); err != nil {
+		return "", fmt.Errorf("can't parse row of %s: %s", table, err)
+	}
+	columns := make([]string, 0, len(values))
+	for column := range values {
+		columns = append(columns, pgx.Identifier{column}.Sanitize())
+	}
+	sort.Strings(columns)
+	list := strings.Join(columns, ", ")
+	return fmt.Sprintf(`INSERT INTO %s (%s) SELECT %s FROM jsonb_populate_record(NULL::%s, $1::jsonb) %s`,
+		table, list, list, table, onConflict), nil
+}
+
+func (s *storageImpl) Import(sess *sessions.Session, references, events []*Table) error {
+	// Batch is executed in an implicit transaction, so nothing is saved if any query fails
+	batch := &pgx.Batch{}
+	batch.Queue(`
+		INSERT INTO public.sessions (
+			session_id, project_id, start_ts, duration, timezone, platform, tracker_version, rev_id,
+			user_uuid, user_id, user_anonymous_id, user_os, user_os_version, user_browser, user_browser_version,
+			user_device, user_device_type, user_country, user_state, user_city,
+			user_device_memory_size, user_device_heap_size, referrer,
+			pages_count, events_count, errors_count, issue_types, issue_score,
+			metadata_1, metadata_2, metadata_3, metadata_4, metadata_5,
+			metadata_6, metadata_7, metadata_8, metadata_9, metadata_10
+		) VALUES (
+			$1, $2, $3, $4, NULLIF($5, ''), $6, $7, NULLIF($8, ''),
+			$9, $10, $11, $12, NULLIF($13, ''), NULLIF($14, ''), NULLIF($15, ''),
+			$16, $17, $18, NULLIF($19, ''), NULLIF($20, ''),
+			NULLIF($21, 0), NULLIF($22, 0::bigint), $23,
+			$24, $25, $26, $27::text[]::issue_type[], $28,
+			$29, $30, $31, $32, $33,
+			$34, $35, $36, $37, $38
+		)`,
+		sess.SessionID, sess.ProjectID, sess.Timestamp, sess.Duration, sess.Timezone, sess.Platform, sess.TrackerVersion, sess.RevID,
+		sess.UserUUID, sess.UserID, sess.UserAnonymousID, sess.UserOS, sess.UserOSVersion, sess.UserBrowser, sess.UserBrowserVersion,
+		sess.UserDevice, sess.UserDeviceType, sess.UserCountry, sess.UserState, sess.UserCity,
+		sess.UserDeviceMemorySize, sess.UserDeviceHeapSize, sess.Referrer,
+		sess.PagesCount, sess.EventsCount, sess.ErrorsCount, sess.IssueTypes, sess.IssueScore,
+		sess.Metadata1, sess.Metadata2, sess.Metadata3, sess.Metadata4, sess.Metadata5,
+		sess.Metadata6, sess.Metadata7, sess.Metadata8, sess.Metadata9, sess.Metadata10,
+	)
+	queries := 1
+	for _, tables := range []struct {
+		list       []*Table
+		onConflict string
+	}{{references, "ON CONFLICT DO NOTHING"}, {events, ""}} {
+		for _, table := range tables.list {
+			for _, row := range table.Rows {
+				sql, err := insertRow(table.Name, row, tables.onConflict)
+				if err != nil {
+					return err
+				}
+				batch.Queue(sql, string(row))
+				queries++
+			}
+		}
+	}
+	results := s.db.SendBatch(batch)
+	defer results.Close()
+	for i := 0; i < queries; i++ {
+		if _, err := results.Exec(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,247 @@
+package sink
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	config "openreplay/backend/internal/config/sink"
+	"openreplay/backend/internal/service"
+	"openreplay/backend/internal/sink/assetscache"
+	"openreplay/backend/internal/sink/sessionwriter"
+	"openreplay/backend/internal/storage"
+	"openreplay/backend/pkg/messages"
+	sinkMetrics "openreplay/backend/pkg/metrics/sink"
+	"openreplay/backend/pkg/queue"
+	"openreplay/backend/pkg/queue/types"
+	"openreplay/backend/pkg/url/assets"
+)
+
+type sinkImpl struct {
+	cfg          *config.Config
+	writer       *sessionwriter.SessionWriter
+	producer     types.Producer
+	consumer     types.Consumer
+	assets       *assetscache.AssetsCache
+	counter      *storage.LogCounter
+	sessionID    uint64
+	messageIndex []byte
+	domBuffer    *bytes.Buffer
+	devBuffer    *bytes.Buffer
+	done         chan struct{}
+	finished     chan struct{}
+}
+
+func New(cfg *config.Config) (service.Interface, error) {
+	if _, err := os.Stat(cfg.FsDir); os.IsNotExist(err) {
+		return nil, fmt.Errorf("%v doesn't exist. %v", cfg.FsDir, err)
+	}
+	producer := queue.NewProducer(cfg.MessageSizeLimit, true)
+	s := &sinkImpl{
+		cfg:          cfg,
+		writer:       sessionwriter.NewWriter(cfg.FsUlimit, cfg.FsDir, cfg.FileBuffer, cfg.SyncTimeout),
+		producer:     producer,
+		assets:       assetscache.New(cfg, assets.NewRewriter(cfg.AssetsOrigin), producer),
+		counter:      storage.NewLogCounter(),
+		messageIndex: make([]byte, 8),
+		domBuffer:    bytes.NewBuffer(make([]byte, 1024)),
+		devBuffer:    bytes.NewBuffer(make([]byte, 1024)),
+		done:         make(chan struct{}),
+		finished:     make(chan struct{}),
+	}
+	// Reset buffers
+	s.domBuffer.Reset()
+	s.devBuffer.Reset()
+
+	s.consumer = queue.NewConsumer(
+		cfg.GroupSink,
+		[]string{
+			cfg.TopicRawWeb,
+			cfg.TopicRawIOS,
+		},
+		messages.NewSinkMessageIterator(s.handleMessage, nil, false),
+		false,
+		cfg.MessageSizeLimit,
+	)
+	go s.run()
+	return s, nil
+}
+
+func (s *sinkImpl) handleMessage(msg messages.Message) {
+	// Check batchEnd signal (nil message)
+	if msg == nil {
+		// Skip empty buffers
+		if s.domBuffer.Len() <= 0 && s.devBuffer.Len() <= 0 {
+			return
+		}
+		sinkMetrics.RecordWrittenBytes(float64(s.domBuffer.Len()), "dom")
+		sinkMetrics.RecordWrittenBytes(float64(s.devBuffer.Len()), "devtools")
+
+		// Write buffered batches to the session
+		if err := s.writer.Write(s.sessionID, s.domBuffer.Bytes(), s.devBuffer.Bytes()); err != nil {
+			log.Printf("writer error: %s", err)
+		}
+
+		// Prepare buffer for the next batch
+		s.domBuffer.Reset()
+		s.devBuffer.Reset()
+		s.sessionID = 0
+		return
+	}
+
+	sinkMetrics.IncreaseTotalMessages()
+
+	// Send SessionEnd trigger to storage service
+	if msg.TypeID() == messages.MsgSessionEnd || msg.TypeID() == messages.MsgIOSSessionEnd {
+		if err := s.producer.Produce(s.cfg.TopicTrigger, msg.SessionID(), msg.Encode()); err != nil {
+			log.Printf("can't send SessionEnd to trigger topic: %s; sessID: %d", err, msg.SessionID())
+		}
+		// duplicate session end message to mobile trigger topic to build video replay for mobile sessions
+		if msg.TypeID() == messages.MsgIOSSessionEnd {
+			if err := s.producer.Produce(s.cfg.TopicMobileTrigger, msg.SessionID(), msg.Encode()); err != nil {
+				log.Printf("can't send iOSSessionEnd to mobile trigger topic: %s; sessID: %d", err, msg.SessionID())
+			}
+		}
+		s.writer.Close(msg.SessionID())
+		return
+	}
+
+	// Process assets
+	if msg.TypeID() == messages.MsgSetNodeAttributeURLBased ||
+		msg.TypeID() == messages.MsgSetCSSDataURLBased ||
+		msg.TypeID() == messages.MsgCSSInsertRuleURLBased ||
+		msg.TypeID() == messages.MsgAdoptedSSReplaceURLBased ||
+		msg.TypeID() == messages.MsgAdoptedSSInsertRuleURLBased {
+		m := msg.Decode()
+		if m == nil {
+			log.Printf("assets decode err, info: %s", msg.Meta().Batch().Info())
+			return
+		}
+		msg = s.assets.ParseAssets(m)
+	}
+
+	// Filter message
+	if !messages.IsReplayerType(msg.TypeID()) {
+		return
+	}
+
+	// If message timestamp is empty, use at least ts of session start
+	ts := msg.Meta().Timestamp
+	if ts == 0 {
+		log.Printf("zero ts; sessID: %d, msgType: %d", msg.SessionID(), msg.TypeID())
+	} else {
+		// Log ts of last processed message
+		s.counter.Update(msg.SessionID(), time.UnixMilli(int64(ts)))
+	}
+
+	// Try to encode message to avoid null data inserts
+	data := msg.Encode()
+	if data == nil {
+		return
+	}
+
+	// Write message to the batch buffer
+	if s.sessionID == 0 {
+		s.sessionID = msg.SessionID()
+	}
+
+	// Encode message index
+	binary.LittleEndian.PutUint64(s.messageIndex, msg.Meta().Index)
+
+	var (
+		n   int
+		err error
+	)
+
+	// Add message to dom buffer
+	if messages.IsDOMType(msg.TypeID()) {
+		// Write message index
+		n, err = s.domBuffer.Write(s.messageIndex)
+		if err != nil {
+			log.Printf("domBuffer index write err: %s", err)
+		}
+		if n != len(s.messageIndex) {
+			log.Printf("domBuffer index not full write: %d/%d", n, len(s.messageIndex))
+		}
+		// Write message body
+		n, err = s.domBuffer.Write(msg.Encode())
+		if err != nil {
+			log.Printf("domBuffer message write err: %s", err)
+		}
+		if n != len(msg.Encode()) {
+			log.Printf("domBuffer message not full write: %d/%d", n, len(s.messageIndex))
+		}
+	}
+
+	// Add message to dev buffer
+	if !messages.IsDOMType(msg.TypeID()) || msg.TypeID() == messages.MsgTimestamp || msg.TypeID() == messages.MsgTabData {
+		// Write message index
+		n, err = s.devBuffer.Write(s.messageIndex)
+		if err != nil {
+			log.Printf("devBuffer index write err: %s", err)
+		}
+		if n != len(s.messageIndex) {
+			log.Printf("devBuffer index not full write: %d/%d", n, len(s.messageIndex))
+		}
+		// Write message body
+		n, err = s.devBuffer.Write(msg.Encode())
+		if err != nil {
+			log.Printf("devBuffer message write err: %s", err)
+		}
+		if n != len(msg.Encode()) {
+			log.Printf("devBuffer message not full write: %d/%d", n, len(s.messageIndex))
+		}
+	}
+
+	sinkMetrics.IncreaseWrittenMessages()
+	sinkMetrics.RecordMessageSize(float64(len(msg.Encode())))
+}
+
+func (s *sinkImpl) run() {
+	tick := time.Tick(10 * time.Second)
+	tickInfo := time.Tick(30 * time.Second)
+	for {
+		select {
+		case <-s.done:
+			// Sync and stop writer
+			s.writer.Stop()
+			// Commit and stop consumer
+			if err := s.consumer.Commit(); err != nil {
+				log.Printf("can't commit messages: %s", err)
+			}
+			s.consumer.Close()
+			s.producer.Close(s.cfg.ProducerCloseTimeout)
+			s.finished <- struct{}{}
+			return
+		case <-tick:
+			if err := s.consumer.Commit(); err != nil {
+				log.Printf("can't commit messages: %s", err)
+			}
+		case <-tickInfo:
+			s.counter.Print()
+			log.Printf("writer: %s", s.writer.Info())
+		case <-s.consumer.Rebalanced():
+			start := time.Now()
+			// Commit now to avoid duplicate reads
+			if err := s.consumer.Commit(); err != nil {
+				log.Printf("can't commit messages: %s", err)
+			}
+			// Sync all files
+			s.writer.Sync()
+			log.Printf("manual sync finished, dur: %d", time.Now().Sub(start).Milliseconds())
+		default:
+			err := s.consumer.ConsumeNext()
+			if err != nil {
+				log.Fatalf("Error on consumption: %v", err)
+			}
+		}
+	}
+}
+
+func (s *sinkImpl) Stop() {
+	s.done <- struct{}{}
+	<-s.finished
+}
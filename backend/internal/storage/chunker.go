@@ -0,0 +1,496 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	config "openreplay/backend/internal/config/storage"
+	"openreplay/backend/pkg/messages"
+	metrics "openreplay/backend/pkg/metrics/storage"
+	"openreplay/backend/pkg/objectstorage"
+)
+
+/*
+	Chunker uploads parts of session files while sessions are live. Every CHUNK_CHECK_INTERVAL it looks through
+	session files updated during the last CHUNK_IDLE_TIMEOUT and uploads new data as a chunk when there are
+	CHUNK_SIZE new bytes or the last chunk is older than CHUNK_INTERVAL. Chunks are cut at message boundaries,
+	so every chunk is a valid mob file. Uploaded chunks are listed in <sessionID>/chunks.json.
+
+	Files are shared by all storage instances, so every session is handled by the instance which holds
+	<sessionID>.chunks lock file. The lock is refreshed on every check and it's taken over when it's older
+	than CHUNK_LOCK_TIMEOUT. Session end leaves <sessionID>.end file for the lock holder:
+	  - whole files are uploaded as usual, so chunks are deleted,
+	  - or files are bigger than MAX_FILE_SIZE, so the rest of data is uploaded as chunks.
+	Handled session end is replaced with empty <sessionID>.done file, so the ended session isn't chunked again.
+	It's removed when the session file is idle for CHUNK_IDLE_TIMEOUT or deleted.
+	The encryption key is known only at the session end, so live sessions aren't chunked with USE_ENCRYPTION,
+	chunks of big sessions are uploaded encrypted at the end.
+*/
+
+type Chunk struct {
+	Type        string `json:"type"` // dom or devtools
+	Key         string `json:"key"`
+	Start       int64  `json:"start"` // offsets of the chunk data in the session file
+	End         int64  `json:"end"`
+	Compression string `json:"compression"`
+	Encrypted   bool   `json:"encrypted"`
+}
+
+type ChunkIndex struct {
+	SessionID uint64   `json:"sessionID"`
+	Final     bool     `json:"final"` // session is ended and all data is uploaded
+	Chunks    []*Chunk `json:"chunks"`
+}
+
+func (i *ChunkIndex) offset(tp FileType) (int64, int) {
+	var offset int64
+	count := 0
+	for _, c := range i.Chunks {
+		if c.Type == tp.String() {
+			offset = c.End
+			count++
+		}
+	}
+	return offset, count
+}
+
+// sessionEnd is the content of <sessionID>.end file
+type sessionEnd struct {
+	Key  string `json:"key,omitempty"`  // encryption key
+	Full bool   `json:"full,omitempty"` // whole files are uploaded
+}
+
+type chunkedSession struct {
+	id        string
+	index     *ChunkIndex
+	lastChunk time.Time
+}
+
+type Chunker struct {
+	cfg      *config.Config
+	storage  *Storage
+	owner    string
+	sessions map[string]*chunkedSession
+	done     chan struct{}
+	finished chan struct{}
+}
+
+func NewChunker(cfg *config.Config, storage *Storage) *Chunker {
+	owner := make([]byte, 8)
+	rand.Read(owner)
+	c := &Chunker{
+		cfg:      cfg,
+		storage:  storage,
+		owner:    hex.EncodeToString(owner),
+		sessions: make(map[string]*chunkedSession),
+		done:     make(chan struct{}),
+		finished: make(chan struct{}),
+	}
+	go c.run()
+	return c
+}
+
+func (c *Chunker) run() {
+	tick := time.Tick(c.cfg.ChunkCheckInterval)
+	for {
+		select {
+		case <-tick:
+			c.check(time.Now())
+		case <-c.done:
+			for id := range c.sessions {
+				c.release(id)
+			}
+			c.finished <- struct{}{}
+			return
+		}
+	}
+}
+
+func (c *Chunker) Stop() {
+	c.done <- struct{}{}
+	<-c.finished
+}
+
+func (c *Chunker) path(id, suffix string) string {
+	return c.cfg.FSDir + "/" + id + suffix
+}
+
+// End is called on session end, the rest of work is done by the lock holder
+func (c *Chunker) End(sessionID uint64, key string, full bool) error {
+	id := strconv.FormatUint(sessionID, 10)
+	if _, err := os.Stat(c.path(id, ".chunks")); os.IsNotExist(err) && full && !c.storage.objStorage.Exists(c.indexKey(id)) {
+		// Session wasn't chunked, only chunking of the ended session is prevented
+		return c.markDone(id)
+	}
+	return c.writeEnd(id, &sessionEnd{Key: key, Full: full})
+}
+
+// markDone replaces session end with the mark which is checked without reading
+func (c *Chunker) markDone(id string) error {
+	file, err := os.Create(c.path(id, ".done"))
+	if err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Remove(c.path(id, ".end")); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (c *Chunker) readEnd(id string) *sessionEnd {
+	data, err := os.ReadFile(c.path(id, ".end"))
+	if err != nil {
+		return nil
+	}
+	end := &sessionEnd{}
+	if err := json.Unmarshal(data, end); err != nil {
+		log.Printf("wrong session end file of session %s: %s", id, err)
+		return nil
+	}
+	return end
+}
+
+func (c *Chunker) writeEnd(id string, end *sessionEnd) error {
+	data, err := json.Marshal(end)
+	if err != nil {
+		return err
+	}
+	tmp := c.path(id, ".end.tmp")
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.path(id, ".end"))
+}
+
+// lock creates or takes over the stale lock of the session
+func (c *Chunker) lock(id string, now time.Time) bool {
+	path := c.path(id, ".chunks")
+	if info, err := os.Stat(path); err == nil {
+		if now.Sub(info.ModTime()) < c.cfg.ChunkLockTimeout {
+			return false
+		}
+		log.Printf("taking over stale chunks lock of session %s", id)
+		os.Remove(path)
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	_, err = file.WriteString(c.owner)
+	return err == nil
+}
+
+// refresh updates the lock, false means the lock was taken over by another instance
+func (c *Chunker) refresh(id string, now time.Time) bool {
+	path := c.path(id, ".chunks")
+	owner, err := os.ReadFile(path)
+	if err != nil || string(owner) != c.owner {
+		return false
+	}
+	return os.Chtimes(path, now, now) == nil
+}
+
+func (c *Chunker) release(id string) {
+	delete(c.sessions, id)
+	if c.refresh(id, time.Now()) {
+		os.Remove(c.path(id, ".chunks"))
+	}
+}
+
+func isSessionFile(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, ch := range name {
+		if ch < '0' || ch > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *Chunker) check(now time.Time) {
+	entries, err := os.ReadDir(c.cfg.FSDir)
+	if err != nil {
+		log.Printf("can't read sessions dir: %s", err)
+		return
+	}
+	names := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		names[entry.Name()] = true
+	}
+	for _, entry := range entries {
+		id := entry.Name()
+		if done := strings.TrimSuffix(id, ".done"); done != id && !names[done] {
+			// Session file is deleted
+			os.Remove(c.path(done, ".done"))
+			continue
+		}
+		if !isSessionFile(id) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		idle := now.Sub(info.ModTime()) > c.cfg.ChunkIdleTimeout
+		if names[id+".done"] {
+			if idle {
+				// Idle sessions aren't chunked anyway
+				os.Remove(c.path(id, ".done"))
+			}
+			continue
+		}
+		var end *sessionEnd
+		if names[id+".end"] {
+			end = c.readEnd(id)
+		}
+		sess, tracked := c.sessions[id]
+		if !tracked {
+			if end == nil && (idle || c.cfg.UseEncryption) {
+				continue
+			}
+			if !c.lock(id, now) {
+				continue
+			}
+			index, err := c.loadIndex(id)
+			if err != nil {
+				log.Printf("can't load chunks index of session %s: %s", id, err)
+				c.release(id)
+				continue
+			}
+			sess = &chunkedSession{id: id, index: index, lastChunk: now}
+			c.sessions[id] = sess
+		} else if !c.refresh(id, now) {
+			log.Printf("chunks lock of session %s is taken over", id)
+			delete(c.sessions, id)
+			continue
+		}
+
+		if end != nil {
+			if err := c.finish(sess, end); err != nil {
+				log.Printf("can't finish chunks of session %s: %s", id, err)
+				continue
+			}
+			if err := c.markDone(id); err != nil {
+				log.Printf("can't save session end of session %s: %s", id, err)
+			}
+			c.release(id)
+			continue
+		}
+		if err := c.upload(sess, now, false, ""); err != nil {
+			log.Printf("can't upload chunks of session %s: %s", id, err)
+		}
+		if idle {
+			c.release(id)
+		}
+	}
+}
+
+func (c *Chunker) indexKey(id string) string {
+	return id + "/chunks.json"
+}
+
+func (c *Chunker) loadIndex(id string) (*ChunkIndex, error) {
+	sessionID, _ := strconv.ParseUint(id, 10, 64)
+	index := &ChunkIndex{SessionID: sessionID, Chunks: make([]*Chunk, 0)}
+	if !c.storage.objStorage.Exists(c.indexKey(id)) {
+		return index, nil
+	}
+	reader, err := c.storage.objStorage.Get(c.indexKey(id))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	if err := json.NewDecoder(reader).Decode(index); err != nil {
+		return nil, err
+	}
+	return index, nil
+}
+
+func (c *Chunker) saveIndex(sess *chunkedSession) error {
+	data, err := json.Marshal(sess.index)
+	if err != nil {
+		return err
+	}
+	return c.storage.objStorage.Upload(bytes.NewReader(data), c.indexKey(sess.id), "application/json", objectstorage.NoCompression)
+}
+
+// upload uploads new data of both files, all data is uploaded (and encrypted with the key) at the session end
+func (c *Chunker) upload(sess *chunkedSession, now time.Time, ended bool, key string) error {
+	uploaded := false
+	for _, tp := range []FileType{DOM, DEV} {
+		path := c.cfg.FSDir + "/" + sess.id
+		if tp == DEV {
+			path += "devtools"
+		}
+		for {
+			offset, count := sess.index.offset(tp)
+			data, err := c.readNext(path, offset, ended || now.Sub(sess.lastChunk) >= c.cfg.ChunkInterval)
+			if err != nil {
+				return err
+			}
+			if len(data) == 0 {
+				break
+			}
+			chunk := &Chunk{
+				Type:        tp.String(),
+				Key:         fmt.Sprintf("%s/chunks/%s.%04d.mob", sess.id, tp.String(), count+1),
+				Start:       offset,
+				End:         offset + int64(len(data)),
+				Compression: c.cfg.CompressionAlgo,
+			}
+			if err := c.uploadChunk(sess.id, chunk, data, key); err != nil {
+				return err
+			}
+			sess.index.Chunks = append(sess.index.Chunks, chunk)
+			uploaded = true
+		}
+	}
+	if !uploaded {
+		return nil
+	}
+	sess.lastChunk = now
+	return c.saveIndex(sess)
+}
+
+// readNext returns data of the next chunk, chunk is smaller than CHUNK_SIZE only if it's the last one
+func (c *Chunker) readNext(path string, offset int64, last bool) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+	// Enough to find the end of a message which starts before CHUNK_SIZE
+	data := make([]byte, c.cfg.ChunkSize+int64(c.cfg.MessageSizeLimit)+8)
+	n, err := file.ReadAt(data, offset)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	data = data[:n]
+	if int64(n) < c.cfg.ChunkSize && !last {
+		return nil, nil
+	}
+	size := cutMessages(data, int(c.cfg.ChunkSize))
+	if size == 0 && len(data) == cap(data) {
+		// There are no complete messages in the max message size, so the file can't be parsed
+		log.Printf("can't find message boundary in %s at %d", path, offset)
+		size = int(c.cfg.ChunkSize)
+	}
+	return data[:size], nil
+}
+
+// cutMessages returns the size of complete messages which aren't bigger than limit in total,
+// but at least one message if there is any
+func cutMessages(data []byte, limit int) int {
+	reader := messages.NewBytesReader(data)
+	size := 0
+	for int(reader.Pointer()) < len(data) {
+		if _, err := reader.ReadIndex(); err != nil {
+			break
+		}
+		msgType, err := reader.ReadUint()
+		if err != nil {
+			break
+		}
+		if _, err := messages.ReadMessage(msgType, reader); err != nil {
+			break
+		}
+		end := int(reader.Pointer())
+		if end > limit && size > 0 {
+			break
+		}
+		size = end
+	}
+	return size
+}
+
+func (c *Chunker) uploadChunk(id string, chunk *Chunk, data []byte, key string) error {
+	if c.cfg.UseSort {
+		sorted, err := c.storage.sortSessionMessages(id, data)
+		if err != nil {
+			return err
+		}
+		data = sorted
+	}
+	compression := c.storage.setTaskCompression()
+	packed := c.storage.compress(data, compression).Bytes()
+	if key != "" {
+		packed = c.storage.encryptSession(packed, key)
+		chunk.Encrypted = true
+	}
+	if err := c.storage.objStorage.Upload(bytes.NewReader(packed), chunk.Key, "application/octet-stream", compression); err != nil {
+		return err
+	}
+	metrics.IncreaseStorageTotalChunks(chunk.Type)
+	return nil
+}
+
+// finish deletes chunks of uploaded session or uploads the rest of data of the big session
+func (c *Chunker) finish(sess *chunkedSession, end *sessionEnd) error {
+	if end.Full {
+		for _, chunk := range sess.index.Chunks {
+			if err := c.storage.objStorage.Delete(chunk.Key); err != nil {
+				return err
+			}
+		}
+		if len(sess.index.Chunks) > 0 || sess.index.Final {
+			return c.storage.objStorage.Delete(c.indexKey(sess.id))
+		}
+		return nil
+	}
+	if err := c.upload(sess, time.Now(), true, end.Key); err != nil {
+		return err
+	}
+	if end.Key != "" {
+		// Live chunks are uploaded only if encryption was enabled after the session start
+		for _, chunk := range sess.index.Chunks {
+			if chunk.Encrypted {
+				continue
+			}
+			if err := c.encryptChunk(sess.id, chunk, end.Key); err != nil {
+				return err
+			}
+		}
+	}
+	if !sess.index.Final {
+		metrics.IncreaseStorageTotalChunkedSessions()
+	}
+	sess.index.Final = true
+	return c.saveIndex(sess)
+}
+
+// encryptChunk uploads the live chunk again with encryption
+func (c *Chunker) encryptChunk(id string, chunk *Chunk, key string) error {
+	path := c.cfg.FSDir + "/" + id
+	if chunk.Type == DEV.String() {
+		path += "devtools"
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	data := make([]byte, chunk.End-chunk.Start)
+	if _, err := file.ReadAt(data, chunk.Start); err != nil {
+		return err
+	}
+	return c.uploadChunk(id, chunk, data, key)
+}
@@ -0,0 +1,213 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"openreplay/backend/internal/config/common"
+	config "openreplay/backend/internal/config/storage"
+	"openreplay/backend/pkg/messages"
+	"openreplay/backend/pkg/objectstorage"
+)
+
+const testSessionID = "7120436923183972352"
+
+type testObjStorage struct {
+	objectstorage.ObjectStorage
+	files map[string][]byte
+}
+
+func (s *testObjStorage) Upload(reader io.Reader, key string, contentType string, compression objectstorage.CompressionType) error {
+	data, err := io.ReadAll(reader)
+	s.files[key] = data
+	return err
+}
+
+func (s *testObjStorage) Get(key string) (io.ReadCloser, error) {
+	return io.NopCloser(bytes.NewReader(s.files[key])), nil
+}
+
+func (s *testObjStorage) Exists(key string) bool {
+	_, ok := s.files[key]
+	return ok
+}
+
+func (s *testObjStorage) Delete(key string) error {
+	delete(s.files, key)
+	return nil
+}
+
+// sessionFile returns messages in the sink file format
+func sessionFile(count int) []byte {
+	file := make([]byte, 0)
+	for i := 0; i < count; i++ {
+		index := make([]byte, 8)
+		binary.LittleEndian.PutUint64(index, uint64(i))
+		file = append(file, index...)
+		file = append(file, (&messages.Timestamp{Timestamp: 1700000000000 + uint64(i)}).Encode()...)
+	}
+	return file
+}
+
+func newTestChunker(t *testing.T) (*Chunker, *testObjStorage) {
+	cfg := &config.Config{
+		Config:             common.Config{MessageSizeLimit: 1024},
+		FSDir:              t.TempDir(),
+		FileSplitSize:      1000,
+		CompressionAlgo:    "none",
+		UseChunks:          true,
+		ChunkSize:          100,
+		ChunkInterval:      time.Hour,
+		ChunkCheckInterval: time.Hour,
+		ChunkIdleTimeout:   time.Hour,
+		ChunkLockTimeout:   time.Minute,
+	}
+	objStorage := &testObjStorage{files: make(map[string][]byte)}
+	s, err := New(cfg, objStorage)
+	if err != nil {
+		t.Fatalf("can't create storage: %s", err)
+	}
+	t.Cleanup(s.Stop)
+	return s.chunker, objStorage
+}
+
+func loadTestIndex(t *testing.T, objStorage *testObjStorage) *ChunkIndex {
+	index := &ChunkIndex{}
+	if err := json.Unmarshal(objStorage.files[testSessionID+"/chunks.json"], index); err != nil {
+		t.Fatalf("can't parse chunks index: %s", err)
+	}
+	return index
+}
+
+func TestCutMessages(t *testing.T) {
+	file := sessionFile(3)
+	msgSize := len(file) / 3
+	if size := cutMessages(file, msgSize*2+1); size != msgSize*2 {
+		t.Errorf("expected %d bytes, got %d", msgSize*2, size)
+	}
+	if size := cutMessages(file, 1); size != msgSize {
+		t.Errorf("expected at least one message, got %d bytes", size)
+	}
+	if size := cutMessages(file[:len(file)-1], len(file)); size != msgSize*2 {
+		t.Errorf("expected only complete messages, got %d bytes", size)
+	}
+}
+
+func TestLiveSessionChunks(t *testing.T) {
+	c, objStorage := newTestChunker(t)
+	file := sessionFile(30)
+	if err := os.WriteFile(c.cfg.FSDir+"/"+testSessionID, file, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only full chunks are uploaded while the session is live
+	c.check(time.Now())
+	index := loadTestIndex(t, objStorage)
+	if index.Final || len(index.Chunks) == 0 {
+		t.Fatalf("wrong index of live session: %+v", index)
+	}
+	uploaded := make([]byte, 0)
+	for _, chunk := range index.Chunks {
+		data := objStorage.files[chunk.Key]
+		if int64(len(data)) > c.cfg.ChunkSize || cutMessages(data, len(data)) != len(data) {
+			t.Errorf("chunk %s isn't cut at message boundary: %d bytes", chunk.Key, len(data))
+		}
+		uploaded = append(uploaded, data...)
+	}
+	if !bytes.HasPrefix(file, uploaded) || len(file)-len(uploaded) >= int(c.cfg.ChunkSize) {
+		t.Errorf("wrong uploaded data: %d of %d bytes", len(uploaded), len(file))
+	}
+
+	// Big session ends, the rest is uploaded as the last chunk
+	if err := c.End(7120436923183972352, "", false); err != nil {
+		t.Fatal(err)
+	}
+	c.check(time.Now())
+	index = loadTestIndex(t, objStorage)
+	last := index.Chunks[len(index.Chunks)-1]
+	if !index.Final || last.End != int64(len(file)) {
+		t.Errorf("session isn't finished: %+v", index)
+	}
+	if _, err := os.Stat(c.path(testSessionID, ".done")); err != nil || c.readEnd(testSessionID) != nil {
+		t.Errorf("session end isn't done: %v", err)
+	}
+	if _, err := os.Stat(c.path(testSessionID, ".chunks")); !os.IsNotExist(err) {
+		t.Errorf("lock isn't removed: %v", err)
+	}
+}
+
+func TestUploadedSessionChunks(t *testing.T) {
+	c, objStorage := newTestChunker(t)
+	if err := os.WriteFile(c.cfg.FSDir+"/"+testSessionID, sessionFile(30), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c.check(time.Now())
+	if len(objStorage.files) == 0 {
+		t.Fatalf("chunks aren't uploaded")
+	}
+
+	// Whole files are uploaded, so chunks are deleted
+	if err := c.End(7120436923183972352, "", true); err != nil {
+		t.Fatal(err)
+	}
+	c.check(time.Now())
+	if len(objStorage.files) != 0 {
+		t.Errorf("chunks aren't deleted: %d files", len(objStorage.files))
+	}
+}
+
+func TestDoneSessionChunks(t *testing.T) {
+	c, objStorage := newTestChunker(t)
+	if err := os.WriteFile(c.cfg.FSDir+"/"+testSessionID, sessionFile(30), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Session wasn't chunked, so it's marked as done without the session end
+	if err := c.End(7120436923183972352, "", true); err != nil {
+		t.Fatal(err)
+	}
+	c.check(time.Now())
+	if len(objStorage.files) != 0 {
+		t.Errorf("ended session is chunked: %d files", len(objStorage.files))
+	}
+	// The mark is removed when the session file is idle
+	c.check(time.Now().Add(2 * c.cfg.ChunkIdleTimeout))
+	if _, err := os.Stat(c.path(testSessionID, ".done")); !os.IsNotExist(err) {
+		t.Errorf("done mark isn't removed: %v", err)
+	}
+	if len(objStorage.files) != 0 {
+		t.Errorf("idle session is chunked: %d files", len(objStorage.files))
+	}
+}
+
+func TestEncryptedSessionChunks(t *testing.T) {
+	c, objStorage := newTestChunker(t)
+	c.cfg.UseEncryption = true
+	file := sessionFile(30)
+	if err := os.WriteFile(c.cfg.FSDir+"/"+testSessionID, file, 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Live sessions aren't chunked without the encryption key
+	c.check(time.Now())
+	if len(objStorage.files) != 0 {
+		t.Fatalf("live session is chunked: %d files", len(objStorage.files))
+	}
+	key := "0123456789abcdef0123456789abcdef"
+	if err := c.End(7120436923183972352, key, false); err != nil {
+		t.Fatal(err)
+	}
+	c.check(time.Now())
+	index := loadTestIndex(t, objStorage)
+	if !index.Final || len(index.Chunks) == 0 || index.Chunks[len(index.Chunks)-1].End != int64(len(file)) {
+		t.Fatalf("session isn't finished: %+v", index)
+	}
+	for _, chunk := range index.Chunks {
+		if !chunk.Encrypted {
+			t.Errorf("chunk %s isn't encrypted", chunk.Key)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"log"
+	"time"
+
+	config "openreplay/backend/internal/config/storage"
+	"openreplay/backend/internal/service"
+	"openreplay/backend/pkg/messages"
+	"openreplay/backend/pkg/queue"
+	"openreplay/backend/pkg/queue/types"
+)
+
+// SessionFinder looks for sessions which weren't uploaded because of missing files (see failover package)
+type SessionFinder interface {
+	Find(sessionID, timestamp uint64)
+	Stop()
+}
+
+type storageImpl struct {
+	cfg      *config.Config
+	storage  *Storage
+	finder   SessionFinder
+	consumer types.Consumer
+	counter  *LogCounter
+	done     chan struct{}
+	finished chan struct{}
+}
+
+func NewService(cfg *config.Config, storage *Storage, finder SessionFinder) service.Interface {
+	s := &storageImpl{
+		cfg:      cfg,
+		storage:  storage,
+		finder:   finder,
+		counter:  NewLogCounter(),
+		done:     make(chan struct{}),
+		finished: make(chan struct{}),
+	}
+	s.consumer = queue.NewConsumer(
+		cfg.GroupStorage,
+		[]string{
+			cfg.TopicTrigger,
+		},
+		messages.NewMessageIterator(
+			s.handleMessage,
+			[]int{messages.MsgSessionEnd, messages.MsgIOSSessionEnd},
+			true,
+		),
+		false,
+		cfg.MessageSizeLimit,
+	)
+	go s.run()
+	return s
+}
+
+func (s *storageImpl) handleMessage(msg messages.Message) {
+	// Convert IOSSessionEnd to SessionEnd
+	if msg.TypeID() == messages.MsgIOSSessionEnd {
+		mobileEnd, oldMeta := msg.(*messages.IOSSessionEnd), msg.Meta()
+		msg = &messages.SessionEnd{
+			Timestamp: mobileEnd.Timestamp,
+		}
+		msg.Meta().SetMeta(oldMeta)
+	}
+	// Process session to save mob files to s3
+	sesEnd := msg.(*messages.SessionEnd)
+	if err := s.storage.Process(sesEnd); err != nil {
+		log.Printf("upload session err: %s, sessID: %d", err, msg.SessionID())
+		s.finder.Find(msg.SessionID(), sesEnd.Timestamp)
+	}
+	// Log timestamp of last processed session
+	s.counter.Update(msg.SessionID(), time.UnixMilli(msg.Meta().Batch().Timestamp()))
+}
+
+func (s *storageImpl) run() {
+	counterTick := time.Tick(time.Second * 30)
+	for {
+		select {
+		case <-s.done:
+			s.finder.Stop()
+			s.storage.Wait()
+			s.storage.Stop()
+			s.consumer.Close()
+			s.finished <- struct{}{}
+			return
+		case <-counterTick:
+			go s.counter.Print()
+			s.storage.Wait()
+			if err := s.consumer.Commit(); err != nil {
+				log.Printf("can't commit messages: %s", err)
+			}
+		case msg := <-s.consumer.Rebalanced():
+			log.Println(msg)
+		default:
+			err := s.consumer.ConsumeNext()
+			if err != nil {
+				log.Fatalf("Error on consumption: %v", err)
+			}
+		}
+	}
+}
+
+func (s *storageImpl) Stop() {
+	s.done <- struct{}{}
+	<-s.finished
+}
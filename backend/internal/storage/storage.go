@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"bufio"
 	"bytes"
 	"fmt"
 	"github.com/andybalholm/brotli"
@@ -77,6 +78,8 @@ type Storage struct {
 	compressionTasks chan *Task // brotli compression or gzip compression with encryption
 	uploadingTasks   chan *Task // upload to s3
 	workersStopped   chan struct{}
+	chunker          *Chunker
+	sorter           *messages.SessionSorter
 }
 
 func New(cfg *config.Config, objStorage objectstorage.ObjectStorage) (*Storage, error) {
@@ -93,6 +96,10 @@ func New(cfg *config.Config, objStorage objectstorage.ObjectStorage) (*Storage,
 		compressionTasks: make(chan *Task, 1),
 		uploadingTasks:   make(chan *Task, 1),
 		workersStopped:   make(chan struct{}),
+		sorter:           messages.NewSessionSorter(cfg.SortTmpDir, cfg.SortRunSize, cfg.MessageSizeLimit),
+	}
+	if cfg.UseChunks {
+		newStorage.chunker = NewChunker(cfg, newStorage)
 	}
 	go newStorage.compressionWorker()
 	go newStorage.uploadingWorker()
@@ -106,6 +113,13 @@ func (s *Storage) Wait() {
 	<-s.workersStopped
 }
 
+// Stop stops uploading of live sessions chunks
+func (s *Storage) Stop() {
+	if s.chunker != nil {
+		s.chunker.Stop()
+	}
+}
+
 func (s *Storage) Process(msg *messages.SessionEnd) (err error) {
 	// Generate file path
 	sessionID := strconv.FormatUint(msg.SessionID(), 10)
@@ -134,6 +148,10 @@ func (s *Storage) Process(msg *messages.SessionEnd) (err error) {
 	wg.Wait()
 	if err != nil {
 		if strings.Contains(err.Error(), "big file") {
+			if s.chunker != nil {
+				// The rest of the session will be uploaded as chunks
+				return s.chunker.End(msg.SessionID(), msg.EncryptionKey, false)
+			}
 			log.Printf("%s, sess: %d", err, msg.SessionID())
 			metrics.IncreaseStorageTotalSkippedSessions()
 			return nil
@@ -156,31 +174,37 @@ func (s *Storage) openSession(sessID, filePath string, tp FileType) ([]byte, err
 		metrics.RecordSkippedSessionSize(float64(info.Size()), tp.String())
 		return nil, fmt.Errorf("big file, size: %d", info.Size())
 	}
-	// Read file into memory
-	raw, err := os.ReadFile(filePath)
+	if !s.cfg.UseSort {
+		// Read file into memory
+		return os.ReadFile(filePath)
+	}
+	// Sort messages reading the file, so only sorted session is kept in memory
+	file, err := os.Open(filePath)
 	if err != nil {
 		return nil, err
 	}
-	if !s.cfg.UseSort {
-		return raw, nil
-	}
+	defer file.Close()
 	start := time.Now()
-	res, err := s.sortSessionMessages(sessID, raw)
-	if err != nil {
-		return nil, fmt.Errorf("can't sort session, err: %s", err)
+	res := new(bytes.Buffer)
+	if info != nil {
+		res.Grow(int(info.Size()) + 8)
+	}
+	if err := s.sorter.Sort(sessID, bufio.NewReader(file), res); err != nil {
+		log.Printf("can't sort session, err: %s", err)
+		return os.ReadFile(filePath)
 	}
 	metrics.RecordSessionSortDuration(float64(time.Now().Sub(start).Milliseconds()), tp.String())
-	return res, nil
+	return res.Bytes(), nil
 }
 
 func (s *Storage) sortSessionMessages(sessID string, raw []byte) ([]byte, error) {
 	// Parse messages, sort by index and save result into slice of bytes
-	unsortedMessages, err := messages.SplitMessages(sessID, raw)
-	if err != nil {
+	res := bytes.NewBuffer(make([]byte, 0, len(raw)+8))
+	if err := s.sorter.Sort(sessID, bytes.NewReader(raw), res); err != nil {
 		log.Printf("can't sort session, err: %s", err)
 		return raw, nil
 	}
-	return messages.MergeMessages(raw, messages.SortMessages(unsortedMessages)), nil
+	return res.Bytes(), nil
 }
 
 func (s *Storage) prepareSession(path string, tp FileType, task *Task) error {
@@ -410,6 +434,13 @@ func (s *Storage) uploadSession(task *Task) {
 	metrics.RecordSessionUploadDuration(float64(uploadDoms+uploadDome), DOM.String())
 	metrics.RecordSessionUploadDuration(float64(uploadDev), DEV.String())
 	metrics.IncreaseStorageTotalSessions()
+	if s.chunker != nil {
+		// Chunks of the session aren't needed anymore
+		id, _ := strconv.ParseUint(task.id, 10, 64)
+		if err := s.chunker.End(id, "", true); err != nil {
+			log.Printf("can't save session end, sessID: %s, err: %s", task.id, err)
+		}
+	}
 }
 
 func (s *Storage) doCompression(task *Task) {
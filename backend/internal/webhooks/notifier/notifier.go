@@ -0,0 +1,205 @@
+package notifier
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+
+	config "openreplay/backend/internal/config/webhooks"
+	"openreplay/backend/pkg/messages"
+	"openreplay/backend/pkg/projects"
+	"openreplay/backend/pkg/sessions"
+)
+
+const (
+	JS_EXCEPTION_ISSUE = "js_exception"
+	STATE_TTL          = 6 * time.Hour // for sessions without SessionEnd
+)
+
+type Notifier interface {
+	Handle(msg messages.Message)
+	Flush()
+	Stop()
+}
+
+type sessionState struct {
+	issueTypes []string
+	ended      bool
+	endTs      uint64
+	readyAt    time.Time
+	lastSeen   time.Time
+}
+
+func (s *sessionState) hasIssue(issueType string) bool {
+	return contains(s.issueTypes, issueType)
+}
+
+type notifierImpl struct {
+	cfg      *config.Config
+	sessions sessions.Sessions
+	projects projects.Projects
+	storage  Storage
+	sender   *Sender
+	states   map[uint64]*sessionState
+}
+
+func New(cfg *config.Config, sessions sessions.Sessions, projects projects.Projects, storage Storage, sender *Sender) Notifier {
+	return &notifierImpl{
+		cfg:      cfg,
+		sessions: sessions,
+		projects: projects,
+		storage:  storage,
+		sender:   sender,
+		states:   make(map[uint64]*sessionState),
+	}
+}
+
+func (n *notifierImpl) state(sessionID uint64) *sessionState {
+	state, ok := n.states[sessionID]
+	if !ok {
+		state = &sessionState{}
+		n.states[sessionID] = state
+	}
+	state.lastSeen = time.Now()
+	return state
+}
+
+func (n *notifierImpl) Handle(msg messages.Message) {
+	sessionID := msg.SessionID()
+	switch m := msg.(type) {
+	case *messages.SessionEnd:
+		// Heuristics and db service are still processing the end of the session, so we give them some time
+		state := n.state(sessionID)
+		state.ended, state.endTs = true, m.Timestamp
+		state.readyAt = time.Now().Add(n.cfg.SessionEndDelay)
+	case *messages.IssueEvent:
+		n.handleIssue(sessionID, &IssueInfo{
+			Type:          m.Type,
+			Timestamp:     m.Timestamp,
+			ContextString: m.ContextString,
+			URL:           m.URL,
+		})
+	case *messages.JSException:
+		n.handleIssue(sessionID, &IssueInfo{
+			Type:          JS_EXCEPTION_ISSUE,
+			Timestamp:     m.Meta().Timestamp,
+			ContextString: fmt.Sprintf("%s: %s", m.Name, m.Message),
+		})
+	}
+}
+
+// handleIssue notifies only about the first issue of each type in the session
+func (n *notifierImpl) handleIssue(sessionID uint64, issue *IssueInfo) {
+	state := n.state(sessionID)
+	if state.hasIssue(issue.Type) {
+		return
+	}
+	state.issueTypes = append(state.issueTypes, issue.Type)
+	sess, subs, err := n.subscriptions(sessionID, EventIssue)
+	if err != nil || len(subs) == 0 {
+		return
+	}
+	project, err := n.projects.GetProject(sess.ProjectID)
+	if err != nil {
+		log.Printf("can't get project %d: %s", sess.ProjectID, err)
+		return
+	}
+	payload := newPayload(EventIssue, issue.Timestamp, sess, project, state.issueTypes)
+	payload.Issue = issue
+	n.notify(subs, payload, []string{issue.Type}, sess, project)
+}
+
+func (n *notifierImpl) handleSessionEnd(sessionID uint64, state *sessionState) {
+	sess, subs, err := n.subscriptions(sessionID, EventSessionEnd)
+	if err != nil || len(subs) == 0 {
+		return
+	}
+	// Take the final state of the session (duration, issue types, user and metadata)
+	if updated, err := n.sessions.GetUpdated(sessionID); err == nil {
+		sess = updated
+	} else {
+		log.Printf("can't get updated session %d: %s", sessionID, err)
+	}
+	project, err := n.projects.GetProject(sess.ProjectID)
+	if err != nil {
+		log.Printf("can't get project %d: %s", sess.ProjectID, err)
+		return
+	}
+	issueTypes := make([]string, 0, len(sess.IssueTypes)+len(state.issueTypes))
+	for _, issueType := range append(sess.IssueTypes, state.issueTypes...) {
+		if !contains(issueTypes, issueType) {
+			issueTypes = append(issueTypes, issueType)
+		}
+	}
+	payload := newPayload(EventSessionEnd, state.endTs, sess, project, issueTypes)
+	n.notify(subs, payload, issueTypes, sess, project)
+}
+
+// subscriptions returns project subscriptions for the event type before further filtering
+func (n *notifierImpl) subscriptions(sessionID uint64, eventType string) (*sessions.Session, []*Subscription, error) {
+	sess, err := n.sessions.Get(sessionID)
+	if err != nil {
+		log.Printf("can't get session %d: %s", sessionID, err)
+		return nil, nil, err
+	}
+	all, err := n.storage.GetSubscriptions(sess.ProjectID)
+	if err != nil {
+		log.Printf("can't get webhook subscriptions for project %d: %s", sess.ProjectID, err)
+		return nil, nil, err
+	}
+	subs := make([]*Subscription, 0, len(all))
+	for _, sub := range all {
+		if contains(sub.EventTypes, eventType) {
+			subs = append(subs, sub)
+		}
+	}
+	return sess, subs, nil
+}
+
+func (n *notifierImpl) notify(subs []*Subscription, payload *Payload, issueTypes []string, sess *sessions.Session, project *projects.Project) {
+	for _, sub := range subs {
+		if !sub.Match(payload.Type, issueTypes, sess, project) {
+			continue
+		}
+		payload.DeliveryID = uuid.New().String()
+		body, err := json.Marshal(payload)
+		if err != nil {
+			log.Printf("can't marshal webhook payload: %s", err)
+			return
+		}
+		n.sender.Send(&Delivery{
+			ID:           payload.DeliveryID,
+			Subscription: sub,
+			SessionID:    sess.SessionID,
+			EventType:    payload.Type,
+			Body:         body,
+		})
+	}
+}
+
+// Flush sends delayed session end notifications and removes old states
+func (n *notifierImpl) Flush() {
+	now := time.Now()
+	for sessionID, state := range n.states {
+		if state.ended && now.After(state.readyAt) {
+			n.handleSessionEnd(sessionID, state)
+			delete(n.states, sessionID)
+		} else if !state.ended && now.Sub(state.lastSeen) > STATE_TTL {
+			delete(n.states, sessionID)
+		}
+	}
+}
+
+// Stop sends delayed notifications without waiting, because consumer offsets are already committed
+func (n *notifierImpl) Stop() {
+	for sessionID, state := range n.states {
+		if state.ended {
+			n.handleSessionEnd(sessionID, state)
+		}
+	}
+	n.states = make(map[uint64]*sessionState)
+	n.sender.Stop()
+}
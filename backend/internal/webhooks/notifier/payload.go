@@ -0,0 +1,84 @@
+package notifier
+
+import (
+	"strconv"
+
+	"openreplay/backend/pkg/projects"
+	"openreplay/backend/pkg/sessions"
+)
+
+type Payload struct {
+	DeliveryID string       `json:"deliveryId"`
+	Type       string       `json:"type"`
+	ProjectID  uint32       `json:"projectId"`
+	SessionID  string       `json:"sessionId"` // string, because uint64 doesn't fit into js number
+	Timestamp  uint64       `json:"timestamp"`
+	Session    *SessionInfo `json:"session"`
+	Issue      *IssueInfo   `json:"issue,omitempty"`
+}
+
+type SessionInfo struct {
+	StartTs         uint64            `json:"startTs"`
+	Duration        *uint64           `json:"duration"`
+	UserID          *string           `json:"userId"`
+	UserAnonymousID *string           `json:"userAnonymousId"`
+	UserUUID        string            `json:"userUuid"`
+	UserOS          string            `json:"userOs"`
+	UserBrowser     string            `json:"userBrowser"`
+	UserDevice      string            `json:"userDevice"`
+	UserDeviceType  string            `json:"userDeviceType"`
+	UserCountry     string            `json:"userCountry"`
+	Platform        string            `json:"platform"`
+	PagesCount      int               `json:"pagesCount"`
+	EventsCount     int               `json:"eventsCount"`
+	ErrorsCount     int               `json:"errorsCount"`
+	IssueTypes      []string          `json:"issueTypes"`
+	Metadata        map[string]string `json:"metadata"`
+}
+
+type IssueInfo struct {
+	Type          string `json:"type"`
+	Timestamp     uint64 `json:"timestamp"`
+	ContextString string `json:"contextString"`
+	URL           string `json:"url,omitempty"`
+}
+
+func newSessionInfo(sess *sessions.Session, project *projects.Project, issueTypes []string) *SessionInfo {
+	info := &SessionInfo{
+		StartTs:         sess.Timestamp,
+		Duration:        sess.Duration,
+		UserID:          sess.UserID,
+		UserAnonymousID: sess.UserAnonymousID,
+		UserUUID:        sess.UserUUID,
+		UserOS:          sess.UserOS,
+		UserBrowser:     sess.UserBrowser,
+		UserDevice:      sess.UserDevice,
+		UserDeviceType:  sess.UserDeviceType,
+		UserCountry:     sess.UserCountry,
+		Platform:        sess.Platform,
+		PagesCount:      sess.PagesCount,
+		EventsCount:     sess.EventsCount,
+		ErrorsCount:     sess.ErrorsCount,
+		IssueTypes:      issueTypes,
+		Metadata:        make(map[string]string),
+	}
+	for i, key := range project.GetMetadataKeys() {
+		if key == nil {
+			continue
+		}
+		if value := sess.GetMetadata(uint(i + 1)); value != nil {
+			info.Metadata[*key] = *value
+		}
+	}
+	return info
+}
+
+func newPayload(eventType string, timestamp uint64, sess *sessions.Session, project *projects.Project, issueTypes []string) *Payload {
+	return &Payload{
+		Type:      eventType,
+		ProjectID: sess.ProjectID,
+		SessionID: strconv.FormatUint(sess.SessionID, 10),
+		Timestamp: timestamp,
+		Session:   newSessionInfo(sess, project, issueTypes),
+	}
+}
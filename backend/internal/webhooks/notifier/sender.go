@@ -0,0 +1,227 @@
+package notifier
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	config "openreplay/backend/internal/config/webhooks"
+	metrics "openreplay/backend/pkg/metrics/webhooks"
+)
+
+const MAX_ERROR_LENGTH = 1000
+
+// Delivery is a single payload for a single subscription, it can be attempted several times
+type Delivery struct {
+	ID           string
+	Subscription *Subscription
+	SessionID    uint64
+	EventType    string
+	Body         []byte
+	attempt      int
+}
+
+// Sign returns the value of X-OpenReplay-Signature header.
+// Receivers should compute HMAC-SHA256 of "<X-OpenReplay-Timestamp>.<body>" with the subscription secret and compare.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Sender keeps pending deliveries in the storage until they are delivered or failed, so retries
+// survive restarts. Due retries are polled from the storage by any service instance.
+type Sender struct {
+	cfg     *config.Config
+	storage Storage
+	client  *http.Client
+	queue   chan *Delivery
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+func NewSender(cfg *config.Config, storage Storage) *Sender {
+	workers := cfg.DeliveryWorkers
+	if workers <= 0 {
+		workers = 1
+	}
+	s := &Sender{
+		cfg:     cfg,
+		storage: storage,
+		client:  &http.Client{Timeout: cfg.DeliveryTimeout},
+		queue:   make(chan *Delivery, workers*100),
+		done:    make(chan struct{}),
+	}
+	s.wg.Add(workers + 1)
+	for i := 0; i < workers; i++ {
+		go s.worker()
+	}
+	go s.poll()
+	return s
+}
+
+// Send saves delivery as pending and puts it in the queue, blocks if all workers are busy and the queue is full
+func (s *Sender) Send(d *Delivery) {
+	// The lease protects the first attempt from other instances polling the storage
+	if err := s.storage.SavePending(d, time.Now().Add(s.cfg.DeliveryLease)); err != nil {
+		log.Printf("can't save pending webhook delivery %s: %s", d.ID, err)
+	}
+	s.enqueue(d)
+}
+
+func (s *Sender) enqueue(d *Delivery) {
+	metrics.IncreasePending()
+	select {
+	case s.queue <- d:
+	case <-s.done:
+		metrics.DecreasePending()
+		log.Printf("webhook delivery %s is postponed: sender is stopped", d.ID)
+	}
+}
+
+// Stop finishes already queued attempts, scheduled retries stay in the storage
+func (s *Sender) Stop() {
+	close(s.done)
+	s.wg.Wait()
+}
+
+// poll takes due retries from the storage while there is free space in the queue
+func (s *Sender) poll() {
+	defer s.wg.Done()
+	tick := time.NewTicker(s.cfg.DeliveryPollInterval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-tick.C:
+			limit := cap(s.queue) - len(s.queue)
+			if limit <= 0 {
+				continue
+			}
+			deliveries, err := s.storage.TakePending(limit, s.cfg.DeliveryLease)
+			if err != nil {
+				log.Printf("can't get pending webhook deliveries: %s", err)
+				continue
+			}
+			for _, d := range deliveries {
+				s.enqueue(d)
+			}
+		}
+	}
+}
+
+func (s *Sender) worker() {
+	defer s.wg.Done()
+	for {
+		select {
+		case d := <-s.queue:
+			s.deliver(d)
+		case <-s.done:
+			for {
+				select {
+				case d := <-s.queue:
+					s.deliver(d)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (s *Sender) deliver(d *Delivery) {
+	d.attempt++
+	start := time.Now()
+	code, err := s.post(d)
+	attempt := &Attempt{
+		DeliveryID:     d.ID,
+		SubscriptionID: d.Subscription.ID,
+		SessionID:      d.SessionID,
+		EventType:      d.EventType,
+		Attempt:        d.attempt,
+		StatusCode:     code,
+		Duration:       time.Since(start),
+	}
+	metrics.RecordDeliveryDuration(float64(attempt.Duration.Milliseconds()), code)
+	if err != nil {
+		attempt.Error = err.Error()
+		if len(attempt.Error) > MAX_ERROR_LENGTH {
+			attempt.Error = attempt.Error[:MAX_ERROR_LENGTH]
+		}
+	}
+	if err := s.storage.LogAttempt(attempt); err != nil {
+		log.Printf("can't log webhook delivery attempt: %s", err)
+	}
+
+	metrics.DecreasePending()
+	switch {
+	case err == nil:
+		metrics.IncreaseDeliveries(d.EventType, "delivered")
+	case !retryable(code) || d.attempt >= s.cfg.DeliveryAttempts:
+		metrics.IncreaseDeliveries(d.EventType, "failed")
+		log.Printf("webhook delivery %s to subscription %d failed after %d attempts: %s",
+			d.ID, d.Subscription.ID, d.attempt, err)
+	default:
+		// The next attempt is taken from the storage by the poller
+		metrics.IncreaseRetries()
+		if err := s.storage.SavePending(d, time.Now().Add(s.backoff(d.attempt))); err != nil {
+			log.Printf("can't save webhook delivery %s for retry: %s", d.ID, err)
+		}
+		return
+	}
+	if err := s.storage.DeletePending(d.ID); err != nil {
+		log.Printf("can't delete pending webhook delivery %s: %s", d.ID, err)
+	}
+}
+
+func (s *Sender) post(d *Delivery) (int, error) {
+	req, err := http.NewRequest("POST", d.Subscription.Endpoint, bytes.NewReader(d.Body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "OpenReplay-Webhooks")
+	req.Header.Set("X-OpenReplay-Event", d.EventType)
+	req.Header.Set("X-OpenReplay-Delivery", d.ID)
+	req.Header.Set("X-OpenReplay-Timestamp", strconv.FormatInt(timestamp, 10))
+	req.Header.Set("X-OpenReplay-Signature", Sign(d.Subscription.Secret, timestamp, d.Body))
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64*1024)) // Read the body to reuse connection
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("endpoint responded with the code %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// retryable returns false for client errors which won't be fixed by repeating the same request
+func retryable(code int) bool {
+	return code == 0 || code == http.StatusRequestTimeout || code == http.StatusTooManyRequests || code >= 500
+}
+
+func (s *Sender) backoff(attempt int) time.Duration {
+	delay := s.cfg.DeliveryBackoff
+	for i := 1; i < attempt && delay < s.cfg.DeliveryMaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > s.cfg.DeliveryMaxBackoff {
+		delay = s.cfg.DeliveryMaxBackoff
+	}
+	return delay
+}
@@ -0,0 +1,245 @@
+package notifier
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	config "openreplay/backend/internal/config/webhooks"
+	"openreplay/backend/pkg/projects"
+	"openreplay/backend/pkg/sessions"
+)
+
+type testPending struct {
+	delivery    Delivery
+	nextAttempt time.Time
+}
+
+type testStorage struct {
+	mutex    sync.Mutex
+	attempts []*Attempt
+	pending  map[string]*testPending
+	logged   chan struct{}
+}
+
+func newTestStorage() *testStorage {
+	return &testStorage{pending: make(map[string]*testPending), logged: make(chan struct{}, 10)}
+}
+
+func (s *testStorage) GetSubscriptions(projectID uint32) ([]*Subscription, error) {
+	return nil, nil
+}
+
+func (s *testStorage) LogAttempt(attempt *Attempt) error {
+	s.mutex.Lock()
+	s.attempts = append(s.attempts, attempt)
+	s.mutex.Unlock()
+	s.logged <- struct{}{}
+	return nil
+}
+
+func (s *testStorage) SavePending(d *Delivery, nextAttempt time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.pending[d.ID] = &testPending{delivery: *d, nextAttempt: nextAttempt}
+	return nil
+}
+
+func (s *testStorage) DeletePending(deliveryID string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.pending, deliveryID)
+	return nil
+}
+
+func (s *testStorage) TakePending(limit int, lease time.Duration) ([]*Delivery, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	list := make([]*Delivery, 0)
+	for _, p := range s.pending {
+		if len(list) < limit && !time.Now().Before(p.nextAttempt) {
+			p.nextAttempt = time.Now().Add(lease)
+			d := p.delivery
+			list = append(list, &d)
+		}
+	}
+	return list, nil
+}
+
+func (s *testStorage) pendingCount() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.pending)
+}
+
+func newTestSender(storage Storage, attempts int) *Sender {
+	return NewSender(&config.Config{
+		DeliveryWorkers:      2,
+		DeliveryTimeout:      time.Second,
+		DeliveryAttempts:     attempts,
+		DeliveryBackoff:      10 * time.Millisecond,
+		DeliveryMaxBackoff:   50 * time.Millisecond,
+		DeliveryLease:        time.Second,
+		DeliveryPollInterval: 5 * time.Millisecond,
+	}, storage)
+}
+
+func waitAttempts(t *testing.T, storage *testStorage, count int) {
+	for i := 0; i < count; i++ {
+		select {
+		case <-storage.logged:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Expected %d attempts, got %d", count, i)
+		}
+	}
+}
+
+func TestSenderRetries(t *testing.T) {
+	body := []byte(`{"type":"session_end"}`)
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		data, _ := ioutil.ReadAll(r.Body)
+		timestamp, _ := strconv.ParseInt(r.Header.Get("X-OpenReplay-Timestamp"), 10, 64)
+		if r.Header.Get("X-OpenReplay-Signature") != Sign("secret", timestamp, data) {
+			t.Errorf("Wrong signature: %s", r.Header.Get("X-OpenReplay-Signature"))
+		}
+		if r.Header.Get("X-OpenReplay-Delivery") != "delivery-1" {
+			t.Errorf("Wrong delivery id: %s", r.Header.Get("X-OpenReplay-Delivery"))
+		}
+		if requests < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	storage := newTestStorage()
+	sender := newTestSender(storage, 5)
+	sender.Send(&Delivery{
+		ID:           "delivery-1",
+		Subscription: &Subscription{ID: 7, Endpoint: server.URL, Secret: "secret"},
+		SessionID:    42,
+		EventType:    EventSessionEnd,
+		Body:         body,
+	})
+	waitAttempts(t, storage, 3)
+	sender.Stop()
+
+	if len(storage.attempts) != 3 {
+		t.Fatalf("Expected 3 attempts, got %d", len(storage.attempts))
+	}
+	for i, attempt := range storage.attempts {
+		if attempt.Attempt != i+1 || attempt.SubscriptionID != 7 || attempt.SessionID != 42 {
+			t.Errorf("Wrong attempt: %+v", attempt)
+		}
+	}
+	if last := storage.attempts[2]; last.StatusCode != http.StatusOK || last.Error != "" {
+		t.Errorf("Expected successful last attempt, got %+v", last)
+	}
+	if first := storage.attempts[0]; first.StatusCode != http.StatusServiceUnavailable || first.Error == "" {
+		t.Errorf("Expected failed first attempt, got %+v", first)
+	}
+	if storage.pendingCount() != 0 {
+		t.Errorf("Delivered webhook is still pending")
+	}
+}
+
+func TestSenderRetryAfterRestart(t *testing.T) {
+	var mutex sync.Mutex
+	available := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if !available {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	storage := newTestStorage()
+	sender := newTestSender(storage, 5)
+	sender.Send(&Delivery{
+		ID:           "delivery-3",
+		Subscription: &Subscription{ID: 1, Endpoint: server.URL, Secret: "secret"},
+		EventType:    EventIssue,
+		Body:         []byte(`{}`),
+	})
+	waitAttempts(t, storage, 1)
+	sender.Stop()
+	if storage.pendingCount() != 1 {
+		t.Fatalf("Retry isn't kept after stop")
+	}
+
+	mutex.Lock()
+	available = true
+	mutex.Unlock()
+	sender = newTestSender(storage, 5)
+	for deadline := time.Now().Add(5 * time.Second); storage.pendingCount() > 0; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Webhook isn't delivered after restart")
+		}
+	}
+	sender.Stop()
+	if last := storage.attempts[len(storage.attempts)-1]; last.Attempt < 2 || last.StatusCode != http.StatusOK {
+		t.Errorf("Expected successful retry, got %+v", last)
+	}
+}
+
+func TestSenderNoRetryOnClientError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusGone)
+	}))
+	defer server.Close()
+
+	storage := newTestStorage()
+	sender := newTestSender(storage, 5)
+	sender.Send(&Delivery{
+		ID:           "delivery-2",
+		Subscription: &Subscription{ID: 1, Endpoint: server.URL, Secret: "secret"},
+		EventType:    EventIssue,
+		Body:         []byte(`{}`),
+	})
+	waitAttempts(t, storage, 1)
+	time.Sleep(100 * time.Millisecond)
+	sender.Stop()
+	if len(storage.attempts) != 1 {
+		t.Errorf("Expected 1 attempt, got %d", len(storage.attempts))
+	}
+}
+
+func TestSubscriptionMatch(t *testing.T) {
+	plan, userID, other := "plan", "user-1", "user-2"
+	project := &projects.Project{ProjectID: 1, Metadata2: &plan}
+	sess := &sessions.Session{SessionID: 42, ProjectID: 1, UserID: &userID}
+	sess.SetMetadata(2, "pro")
+
+	for _, tc := range []struct {
+		name       string
+		sub        *Subscription
+		eventType  string
+		issueTypes []string
+		match      bool
+	}{
+		{"event type", &Subscription{EventTypes: []string{EventSessionEnd}}, EventIssue, nil, false},
+		{"any issue", &Subscription{EventTypes: []string{EventSessionEnd}}, EventSessionEnd, nil, true},
+		{"issue type", &Subscription{EventTypes: []string{EventSessionEnd}, IssueTypes: []string{"click_rage", "js_exception"}}, EventSessionEnd, []string{"js_exception"}, true},
+		{"no issue", &Subscription{EventTypes: []string{EventSessionEnd}, IssueTypes: []string{"click_rage"}}, EventSessionEnd, []string{"dead_click"}, false},
+		{"user id", &Subscription{EventTypes: []string{EventIssue}, UserID: &userID}, EventIssue, []string{"cpu"}, true},
+		{"other user id", &Subscription{EventTypes: []string{EventIssue}, UserID: &other}, EventIssue, []string{"cpu"}, false},
+		{"metadata", &Subscription{EventTypes: []string{EventIssue}, Metadata: map[string]string{"plan": "pro"}}, EventIssue, nil, true},
+		{"other metadata", &Subscription{EventTypes: []string{EventIssue}, Metadata: map[string]string{"plan": "free"}}, EventIssue, nil, false},
+		{"unknown metadata", &Subscription{EventTypes: []string{EventIssue}, Metadata: map[string]string{"team": "a"}}, EventIssue, nil, false},
+	} {
+		if res := tc.sub.Match(tc.eventType, tc.issueTypes, sess, project); res != tc.match {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.match, res)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package notifier
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"openreplay/backend/pkg/db/postgres/pool"
+)
+
+// Attempt is a row of the delivery log
+type Attempt struct {
+	DeliveryID     string
+	SubscriptionID uint32
+	SessionID      uint64
+	EventType      string
+	Attempt        int
+	StatusCode     int
+	Error          string
+	Duration       time.Duration
+}
+
+type Storage interface {
+	GetSubscriptions(projectID uint32) ([]*Subscription, error)
+	LogAttempt(attempt *Attempt) error
+	SavePending(d *Delivery, nextAttempt time.Time) error
+	DeletePending(deliveryID string) error
+	TakePending(limit int, lease time.Duration) ([]*Delivery, error)
+}
+
+type cachedSubscriptions struct {
+	list      []*Subscription
+	expiresAt time.Time
+}
+
+type storageImpl struct {
+	db    pool.Pool
+	ttl   time.Duration
+	mutex sync.Mutex
+	cache map[uint32]*cachedSubscriptions
+}
+
+func NewStorage(db pool.Pool, ttl time.Duration) Storage {
+	return &storageImpl{
+		db:    db,
+		ttl:   ttl,
+		cache: make(map[uint32]*cachedSubscriptions),
+	}
+}
+
+func (s *storageImpl) GetSubscriptions(projectID uint32) ([]*Subscription, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if cached, ok := s.cache[projectID]; ok && time.Now().Before(cached.expiresAt) {
+		return cached.list, nil
+	}
+	rows, err := s.db.Query(`
+		SELECT subscription_id, endpoint, secret, event_types, issue_types, user_id, metadata
+		FROM public.session_webhooks
+		WHERE project_id = $1 AND deleted_at IS NULL`,
+		projectID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := make([]*Subscription, 0)
+	for rows.Next() {
+		sub := &Subscription{ProjectID: projectID}
+		var metadata []byte
+		if err := rows.Scan(&sub.ID, &sub.Endpoint, &sub.Secret, &sub.EventTypes, &sub.IssueTypes, &sub.UserID, &metadata); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(metadata, &sub.Metadata); err != nil {
+			log.Printf("wrong metadata filter in webhook subscription %d: %s", sub.ID, err)
+			continue
+		}
+		list = append(list, sub)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	s.cache[projectID] = &cachedSubscriptions{list: list, expiresAt: time.Now().Add(s.ttl)}
+	return list, nil
+}
+
+func (s *storageImpl) LogAttempt(a *Attempt) error {
+	var (
+		statusCode *int
+		errMsg     *string
+	)
+	if a.StatusCode != 0 {
+		statusCode = &a.StatusCode
+	}
+	if a.Error != "" {
+		errMsg = &a.Error
+	}
+	return s.db.Exec(`
+		INSERT INTO public.session_webhook_deliveries
+			(delivery_id, subscription_id, session_id, event_type, attempt, status_code, error, duration)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		a.DeliveryID, a.SubscriptionID, a.SessionID, a.EventType, a.Attempt, statusCode, errMsg, a.Duration.Milliseconds(),
+	)
+}
+
+// SavePending inserts or updates the pending delivery, it will be taken by TakePending after nextAttempt
+func (s *storageImpl) SavePending(d *Delivery, nextAttempt time.Time) error {
+	return s.db.Exec(`
+		INSERT INTO public.session_webhook_pending
+			(delivery_id, subscription_id, session_id, event_type, body, attempt, next_attempt_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (delivery_id) DO UPDATE SET attempt = EXCLUDED.attempt, next_attempt_at = EXCLUDED.next_attempt_at`,
+		d.ID, d.Subscription.ID, d.SessionID, d.EventType, string(d.Body), d.attempt, nextAttempt.UTC(),
+	)
+}
+
+func (s *storageImpl) DeletePending(deliveryID string) error {
+	return s.db.Exec(`DELETE FROM public.session_webhook_pending WHERE delivery_id = $1`, deliveryID)
+}
+
+// TakePending returns due deliveries and postpones them for the lease time, so they aren't taken
+// by other instances while they are attempted
+func (s *storageImpl) TakePending(limit int, lease time.Duration) ([]*Delivery, error) {
+	rows, err := s.db.Query(`
+		UPDATE public.session_webhook_pending AS pending
+		SET next_attempt_at = $2
+		FROM public.session_webhooks AS webhooks
+		WHERE pending.delivery_id IN (SELECT delivery_id
+		                              FROM public.session_webhook_pending
+		                              WHERE next_attempt_at <= timezone('utc'::text, now())
+		                              ORDER BY next_attempt_at
+		                              LIMIT $1 FOR UPDATE SKIP LOCKED)
+		  AND webhooks.subscription_id = pending.subscription_id
+		  AND webhooks.deleted_at IS NULL
+		RETURNING pending.delivery_id, pending.session_id, pending.event_type, pending.body, pending.attempt,
+		          webhooks.subscription_id, webhooks.project_id, webhooks.endpoint, webhooks.secret`,
+		limit, time.Now().Add(lease).UTC(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := make([]*Delivery, 0)
+	for rows.Next() {
+		d := &Delivery{Subscription: &Subscription{}}
+		var body string
+		if err := rows.Scan(&d.ID, &d.SessionID, &d.EventType, &body, &d.attempt,
+			&d.Subscription.ID, &d.Subscription.ProjectID, &d.Subscription.Endpoint, &d.Subscription.Secret); err != nil {
+			return nil, err
+		}
+		d.Body = []byte(body)
+		list = append(list, d)
+	}
+	return list, rows.Err()
+}
@@ -0,0 +1,65 @@
+package notifier
+
+import (
+	"openreplay/backend/pkg/projects"
+	"openreplay/backend/pkg/sessions"
+)
+
+const (
+	EventSessionEnd = "session_end"
+	EventIssue      = "issue"
+)
+
+// Subscription is a project webhook from the session_webhooks table
+type Subscription struct {
+	ID         uint32
+	ProjectID  uint32
+	Endpoint   string
+	Secret     string
+	EventTypes []string
+	IssueTypes []string          // any issue type is accepted if empty
+	UserID     *string           // exact match
+	Metadata   map[string]string // metadata key -> exact value
+}
+
+// Match checks subscription filters. issueTypes are all issues of the ended session for session_end
+// and the type of the current issue for issue event.
+func (s *Subscription) Match(eventType string, issueTypes []string, sess *sessions.Session, project *projects.Project) bool {
+	if !contains(s.EventTypes, eventType) {
+		return false
+	}
+	if len(s.IssueTypes) > 0 && !containsAny(s.IssueTypes, issueTypes) {
+		return false
+	}
+	if s.UserID != nil && (sess.UserID == nil || *sess.UserID != *s.UserID) {
+		return false
+	}
+	for key, value := range s.Metadata {
+		keyNo := project.GetMetadataNo(key)
+		if keyNo == 0 {
+			return false
+		}
+		if sessValue := sess.GetMetadata(keyNo); sessValue == nil || *sessValue != value {
+			return false
+		}
+	}
+	return true
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAny(list []string, values []string) bool {
+	for _, v := range values {
+		if contains(list, v) {
+			return true
+		}
+	}
+	return false
+}
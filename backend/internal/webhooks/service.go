@@ -0,0 +1,67 @@
+package webhooks
+
+import (
+	"log"
+	"time"
+
+	config "openreplay/backend/internal/config/webhooks"
+	"openreplay/backend/internal/service"
+	"openreplay/backend/internal/webhooks/notifier"
+	"openreplay/backend/pkg/memory"
+	"openreplay/backend/pkg/queue/types"
+)
+
+type webhooksImpl struct {
+	cfg      *config.Config
+	consumer types.Consumer
+	notifier notifier.Notifier
+	mm       memory.Manager
+	done     chan struct{}
+	finished chan struct{}
+}
+
+func New(cfg *config.Config, consumer types.Consumer, notifier notifier.Notifier, mm memory.Manager) service.Interface {
+	s := &webhooksImpl{
+		cfg:      cfg,
+		consumer: consumer,
+		notifier: notifier,
+		mm:       mm,
+		done:     make(chan struct{}),
+		finished: make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+func (w *webhooksImpl) run() {
+	flushTick := time.Tick(time.Second)
+	commitTick := time.Tick(10 * time.Second)
+	for {
+		select {
+		case <-flushTick:
+			w.notifier.Flush()
+		case <-commitTick:
+			w.consumer.Commit()
+		case msg := <-w.consumer.Rebalanced():
+			log.Println(msg)
+		case <-w.done:
+			log.Println("stopping webhooks service")
+			w.consumer.Commit()
+			w.consumer.Close()
+			w.notifier.Stop()
+			w.finished <- struct{}{}
+		default:
+			if !w.mm.HasFreeMemory() {
+				continue
+			}
+			if err := w.consumer.ConsumeNext(); err != nil {
+				log.Fatalf("Error on consuming: %v", err)
+			}
+		}
+	}
+}
+
+func (w *webhooksImpl) Stop() {
+	w.done <- struct{}{}
+	<-w.finished
+}
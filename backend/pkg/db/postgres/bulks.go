@@ -22,6 +22,7 @@ type BulkSet struct {
 	webInputDurations Bulk
 	webGraphQL        Bulk
 	webErrors         Bulk
+	failedErrors      *FailedErrorGroups
 	webErrorEvents    Bulk
 	webErrorTags      Bulk
 	webIssues         Bulk
@@ -37,10 +38,11 @@ type BulkSet struct {
 
 func NewBulkSet(c pool.Pool) *BulkSet {
 	bs := &BulkSet{
-		c:          c,
-		workerTask: make(chan *bulksTask, 1),
-		done:       make(chan struct{}),
-		finished:   make(chan struct{}),
+		c:            c,
+		failedErrors: &FailedErrorGroups{},
+		workerTask:   make(chan *bulksTask, 1),
+		done:         make(chan struct{}),
+		finished:     make(chan struct{}),
 	}
 	bs.initBulks()
 	go bs.worker()
@@ -138,11 +140,7 @@ func (conn *BulkSet) initBulks() {
 	if err != nil {
 		log.Fatalf("can't create webPageEvents bulk: %s", err)
 	}
-	conn.webErrors, err = NewBulk(conn.c,
-		"errors",
-		"(error_id, project_id, source, name, message, payload)",
-		"($%d, $%d, $%d, $%d, $%d, $%d::jsonb)",
-		6, 200)
+	conn.webErrors, err = NewErrorGroups(conn.c, 200, conn.failedErrors)
 	if err != nil {
 		log.Fatalf("can't create webErrors bulk: %s", err)
 	}
@@ -2,6 +2,9 @@ package postgres
 
 import (
 	"log"
+	"time"
+
+	"openreplay/backend/pkg/cache"
 	"openreplay/backend/pkg/db/postgres/batch"
 	"openreplay/backend/pkg/db/postgres/pool"
 	"openreplay/backend/pkg/sessions"
@@ -17,6 +20,8 @@ type Conn struct {
 	batches *batch.BatchSet
 	bulks   *BulkSet
 	chConn  CH // hack for autocomplete inserts, TODO: rewrite
+	// error ids of existing error groups by legacy id and fingerprint
+	errorIDs cache.Cache
 }
 
 func (conn *Conn) SetClickHouse(ch CH) {
@@ -28,9 +33,10 @@ func NewConn(pool pool.Pool) *Conn {
 		log.Fatalf("pool is nil")
 	}
 	return &Conn{
-		Pool:    pool,
-		bulks:   NewBulkSet(pool),
-		batches: batch.NewBatchSet(pool),
+		Pool:     pool,
+		bulks:    NewBulkSet(pool),
+		batches:  batch.NewBatchSet(pool),
+		errorIDs: cache.New(time.Minute*5, time.Minute*30),
 	}
 }
 
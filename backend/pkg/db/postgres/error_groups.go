@@ -0,0 +1,271 @@
+package postgres
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+
+	"openreplay/backend/pkg/db/postgres/pool"
+	"openreplay/backend/pkg/metrics/database"
+)
+
+const (
+	errorGroupsColumns = `INSERT INTO errors (error_id, project_id, source, name, message, payload, fingerprint, ` +
+		`first_seen_at, last_seen_at, occurrences) VALUES `
+	errorGroupsTemplate = `($%d, $%d, $%d, $%d, $%d, $%d::jsonb, $%d, $%d, $%d, $%d)`
+	// Legacy error groups (created before fingerprints) get the fingerprint of the first new event
+	errorGroupsSuffix = ` ON CONFLICT (error_id) DO UPDATE SET
+	first_seen_at = LEAST(COALESCE(errors.first_seen_at, EXCLUDED.first_seen_at), EXCLUDED.first_seen_at),
+	last_seen_at = GREATEST(COALESCE(errors.last_seen_at, EXCLUDED.last_seen_at), EXCLUDED.last_seen_at),
+	occurrences = errors.occurrences + EXCLUDED.occurrences,
+	fingerprint = COALESCE(errors.fingerprint, EXCLUDED.fingerprint);`
+	// Resolved error becomes unresolved again if it happens after it was resolved (regression),
+	// regressed_at is the timestamp of the first event after resolution
+	errorRegressionsRequest = `UPDATE public.errors
+SET status = 'unresolved'::error_status, regressed_at = regressions.timestamp
+FROM (SELECT errors.error_id, MIN(events.timestamp) AS timestamp
+      FROM unnest($1::text[], $2::bigint[]) AS events(error_id, timestamp)
+               INNER JOIN public.errors USING (error_id)
+      WHERE errors.status = 'resolved' AND events.timestamp > COALESCE(errors.resolved_at, 0)
+      GROUP BY errors.error_id) AS regressions
+WHERE errors.error_id = regressions.error_id
+RETURNING errors.error_id, errors.project_id;`
+	errorGroupsSetSize = 10
+	// Error group is dropped after this number of failed sends
+	errorGroupsMaxAttempts = 3
+	// Event timestamps kept per error group to find the regression time
+	errorGroupMaxTimestamps = 1000
+)
+
+type errorGroup struct {
+	errorID     string
+	projectID   uint32
+	source      string
+	name        string
+	message     string
+	payload     string
+	fingerprint string
+	firstSeen   uint64
+	lastSeen    uint64
+	occurrences uint64
+	timestamps  []int64
+	attempts    int
+}
+
+func (g *errorGroup) merge(other *errorGroup) {
+	if other.firstSeen < g.firstSeen {
+		g.firstSeen = other.firstSeen
+	}
+	if other.lastSeen > g.lastSeen {
+		g.lastSeen = other.lastSeen
+	}
+	g.occurrences += other.occurrences
+	for _, ts := range other.timestamps {
+		if len(g.timestamps) < errorGroupMaxTimestamps {
+			g.timestamps = append(g.timestamps, ts)
+		} else if last := len(g.timestamps) - 1; ts > g.timestamps[last] {
+			// The latest event is kept to detect regression anyway
+			g.timestamps[last] = ts
+		}
+	}
+	if other.attempts > g.attempts {
+		g.attempts = other.attempts
+	}
+}
+
+// FailedErrorGroups keeps error groups which weren't sent, they are retried with the next errorGroups bulk
+type FailedErrorGroups struct {
+	mutex  sync.Mutex
+	groups []*errorGroup
+}
+
+func (f *FailedErrorGroups) add(group *errorGroup) {
+	f.mutex.Lock()
+	f.groups = append(f.groups, group)
+	f.mutex.Unlock()
+}
+
+func (f *FailedErrorGroups) take() []*errorGroup {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	groups := f.groups
+	f.groups = nil
+	return groups
+}
+
+// errorGroups aggregates error events by error_id and upserts first-seen/last-seen/occurrence counters.
+// It implements Bulk interface to be sent in the same order as other bulks (before error events).
+type errorGroups struct {
+	conn      pool.Pool
+	sizeLimit int
+	failed    *FailedErrorGroups
+	groups    map[string]*errorGroup
+	order     []string
+}
+
+func NewErrorGroups(conn pool.Pool, sizeLimit int, failed *FailedErrorGroups) (Bulk, error) {
+	switch {
+	case conn == nil:
+		return nil, errors.New("db conn is empty")
+	case sizeLimit <= 0:
+		return nil, errors.New("size limit is wrong")
+	case failed == nil:
+		return nil, errors.New("failed groups storage is empty")
+	}
+	return &errorGroups{
+		conn:      conn,
+		sizeLimit: sizeLimit,
+		failed:    failed,
+		groups:    make(map[string]*errorGroup),
+	}, nil
+}
+
+// Append args: error_id, project_id, source, name, message, payload, fingerprint, timestamp
+func (g *errorGroups) Append(args ...interface{}) error {
+	if len(args) != 8 {
+		return fmt.Errorf("wrong number of arguments, waited: %d, got: %d", 8, len(args))
+	}
+	errorID, ok1 := args[0].(string)
+	projectID, ok2 := args[1].(uint32)
+	source, ok3 := args[2].(string)
+	name, ok4 := args[3].(string)
+	message, ok5 := args[4].(string)
+	payload, ok6 := args[5].(string)
+	fingerprint, ok7 := args[6].(string)
+	timestamp, ok8 := args[7].(uint64)
+	if !(ok1 && ok2 && ok3 && ok4 && ok5 && ok6 && ok7 && ok8) {
+		return errors.New("wrong type of arguments")
+	}
+	g.add(&errorGroup{
+		errorID:     errorID,
+		projectID:   projectID,
+		source:      source,
+		name:        name,
+		message:     message,
+		payload:     payload,
+		fingerprint: fingerprint,
+		firstSeen:   timestamp,
+		lastSeen:    timestamp,
+		occurrences: 1,
+		timestamps:  []int64{int64(timestamp)},
+	})
+	return nil
+}
+
+func (g *errorGroups) add(group *errorGroup) {
+	if existing, ok := g.groups[group.errorID]; ok {
+		existing.merge(group)
+		return
+	}
+	g.groups[group.errorID] = group
+	g.order = append(g.order, group.errorID)
+}
+
+func (g *errorGroups) Send() error {
+	for _, group := range g.failed.take() {
+		g.add(group)
+	}
+	var lastErr error
+	for len(g.order) > 0 {
+		size := len(g.order)
+		if size > g.sizeLimit {
+			size = g.sizeLimit
+		}
+		if err := g.send(g.order[:size]); err != nil {
+			lastErr = err
+			g.retry(g.order[:size], err)
+		}
+		g.order = g.order[size:]
+	}
+	g.groups = make(map[string]*errorGroup)
+	return lastErr
+}
+
+// retry keeps not sent groups for the next bulk. If the request was rejected by postgres, groups are sent
+// one by one, so a wrong group doesn't block others.
+func (g *errorGroups) retry(ids []string, err error) {
+	var pgErr *pgconn.PgError
+	rejected := errors.As(err, &pgErr)
+	for _, id := range ids {
+		if rejected && len(ids) > 1 {
+			if err := g.send([]string{id}); err == nil {
+				continue
+			}
+		}
+		group := g.groups[id]
+		group.attempts++
+		if group.attempts >= errorGroupsMaxAttempts {
+			log.Printf("can't send error group, errorID: %s, projectID: %d, occurrences: %d",
+				group.errorID, group.projectID, group.occurrences)
+			continue
+		}
+		g.failed.add(group)
+	}
+}
+
+func (g *errorGroups) Table() string {
+	return "errors"
+}
+
+func (g *errorGroups) send(ids []string) error {
+	start := time.Now()
+	request := bytes.NewBufferString(errorGroupsColumns)
+	values := make([]interface{}, 0, len(ids)*errorGroupsSetSize)
+	args := make([]interface{}, errorGroupsSetSize)
+	eventIDs, eventTimestamps := make([]string, 0, len(ids)), make([]int64, 0, len(ids))
+	for i, id := range ids {
+		for j := 0; j < errorGroupsSetSize; j++ {
+			args[j] = i*errorGroupsSetSize + j + 1
+		}
+		if i > 0 {
+			request.WriteByte(',')
+		}
+		request.WriteString(fmt.Sprintf(errorGroupsTemplate, args...))
+		group := g.groups[id]
+		values = append(values, group.errorID, group.projectID, group.source, group.name, group.message,
+			group.payload, group.fingerprint, group.firstSeen, group.lastSeen, group.occurrences)
+		for _, ts := range group.timestamps {
+			eventIDs = append(eventIDs, group.errorID)
+			eventTimestamps = append(eventTimestamps, ts)
+		}
+	}
+	request.WriteString(errorGroupsSuffix)
+
+	// Counters and regressions are updated in one implicit transaction, so failed request can be retried
+	batch := &pgx.Batch{}
+	batch.Queue(request.String(), values...)
+	batch.Queue(errorRegressionsRequest, eventIDs, eventTimestamps)
+	br := g.conn.SendBatch(batch)
+	defer br.Close()
+	if _, err := br.Exec(); err != nil {
+		return fmt.Errorf("send error groups err: %w", err)
+	}
+	rows, err := br.Query()
+	if err != nil {
+		return fmt.Errorf("update error regressions err: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			errorID   string
+			projectID uint32
+		)
+		if err := rows.Scan(&errorID, &projectID); err != nil {
+			return fmt.Errorf("can't scan error regression: %w", err)
+		}
+		log.Printf("resolved error happened again, errorID: %s, projectID: %d", errorID, projectID)
+		database.IncreaseErrorRegressions()
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("update error regressions err: %w", err)
+	}
+	database.RecordBulkElements(float64(len(ids)), "pg", g.Table())
+	database.RecordBulkInsertDuration(float64(time.Now().Sub(start).Milliseconds()), "pg", g.Table())
+	return nil
+}
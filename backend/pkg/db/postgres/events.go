@@ -155,9 +155,39 @@ func (conn *Conn) InsertInputChangeEvent(sess *sessions.Session, e *messages.Inp
 	return nil
 }
 
+const errorIDQuery = `SELECT error_id FROM public.errors
+WHERE project_id = $1 AND (error_id = $2 OR fingerprint = $3)
+ORDER BY error_id = $2 DESC, first_seen_at ASC NULLS LAST LIMIT 1`
+
+// ResolveErrorID sets id of the existing error group for the error event: groups created before fingerprints
+// keep their legacy ids, new errors get fingerprint-based ids
+func (conn *Conn) ResolveErrorID(projectID uint32, e *types.ErrorEvent) {
+	legacyID, fingerprint := e.LegacyID(projectID), e.Fingerprint()
+	key := legacyID + fingerprint
+	if id, ok := conn.errorIDs.GetAndRefresh(key); ok {
+		e.SetID(id.(string))
+		return
+	}
+	var id string
+	err := conn.Pool.QueryRow(errorIDQuery, projectID, legacyID, fingerprint).Scan(&id)
+	switch {
+	case err == nil:
+		e.SetID(id)
+	case IsNoRowsErr(err):
+		id = e.ID(projectID)
+	default:
+		// Don't cache the id, the next event will try again
+		log.Printf("can't get error id, projectID: %d, err: %s", projectID, err)
+		return
+	}
+	conn.errorIDs.Set(key, id)
+}
+
 func (conn *Conn) InsertWebErrorEvent(sess *sessions.Session, e *types.ErrorEvent) error {
+	conn.ResolveErrorID(sess.ProjectID, e)
 	errorID := e.ID(sess.ProjectID)
-	if err := conn.bulks.Get("webErrors").Append(errorID, sess.ProjectID, e.Source, e.Name, e.Message, e.Payload); err != nil {
+	if err := conn.bulks.Get("webErrors").Append(errorID, sess.ProjectID, e.Source, e.Name, e.Message, e.Payload,
+		e.Fingerprint(), e.Timestamp); err != nil {
 		log.Printf("insert web error err: %s", err)
 	}
 	if err := conn.bulks.Get("webErrorEvents").Append(sess.SessionID, truncSqIdx(e.MessageID), e.Timestamp, errorID); err != nil {
@@ -21,6 +21,9 @@ type ErrorEvent struct {
 	Message   string
 	Payload   string
 	Tags      map[string]*string
+
+	fingerprint string
+	id          string
 }
 
 func unquote(s string) string {
@@ -102,7 +105,8 @@ func parseFirstFrame(payload string) (*stackFrame, error) {
 	return frames[0], nil
 }
 
-func (e *ErrorEvent) ID(projectID uint32) string {
+// LegacyID returns error id used before fingerprints (source, name, message and the first stack frame)
+func (e *ErrorEvent) LegacyID(projectID uint32) string {
 	hash := fnv.New128a()
 	hash.Write([]byte(e.Source))
 	hash.Write([]byte(e.Name))
@@ -121,6 +125,19 @@ func (e *ErrorEvent) ID(projectID uint32) string {
 	return strconv.FormatUint(uint64(projectID), 16) + hex.EncodeToString(hash.Sum(nil))
 }
 
+// ID returns error group id (errors with the same fingerprint have the same id) or the id of existing group set by SetID
+func (e *ErrorEvent) ID(projectID uint32) string {
+	if e.id != "" {
+		return e.id
+	}
+	return strconv.FormatUint(uint64(projectID), 16) + e.Fingerprint()
+}
+
+// SetID sets error group id found in the database
+func (e *ErrorEvent) SetID(id string) {
+	e.id = id
+}
+
 func WrapCustomEvent(m *CustomEvent) *IssueEvent {
 	msg := &IssueEvent{
 		Type:          "custom",
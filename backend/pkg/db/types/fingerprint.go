@@ -0,0 +1,122 @@
+package types
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"hash/fnv"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Number of top stack frames used for the error fingerprint
+const maxFingerprintFrames = 5
+
+type normalizer struct {
+	re   *regexp.Regexp
+	repl string
+}
+
+// Dynamic values in error messages, order matters (urls and ids before numbers)
+var messageNormalizers = []normalizer{
+	{regexp.MustCompile(`[a-zA-Z][a-zA-Z0-9+.-]*://[^\s'"<>()]+`), "<url>"},
+	{regexp.MustCompile(`[\w.+-]+@[\w-]+\.[\w.-]+`), "<email>"},
+	{regexp.MustCompile(`(?i)\b[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}\b`), "<uuid>"},
+	{regexp.MustCompile(`(?i)\b0x[0-9a-f]+\b`), "<hex>"},
+	{regexp.MustCompile(`(?i)\b[0-9a-f]{8,}\b`), "<hex>"},
+	{regexp.MustCompile(`-?\b\d+(\.\d+)?\b`), "<num>"},
+}
+
+var (
+	// Content hash in bundle names: main.3f2a9c1b.js, chunk-5f8a21.js
+	bundleHashRe  = regexp.MustCompile(`([.~-])[0-9a-fA-F]{6,}(\.[a-z]+)$`)
+	vendorMarkers = []string{
+		"/node_modules/", "/vendor/", "/vendors", "vendors~", "chunk-vendors", "/bower_components/",
+		"cdn.jsdelivr.net", "unpkg.com", "cdnjs.cloudflare.com", "ajax.googleapis.com", "www.gstatic.com",
+		"www.googletagmanager.com", "connect.facebook.net",
+	}
+	extensionSchemes = []string{"chrome-extension://", "moz-extension://", "safari-extension://", "safari-web-extension://"}
+)
+
+// NormalizeMessage replaces dynamic values (ids, numbers, urls, emails) with placeholders
+func NormalizeMessage(message string) string {
+	for _, n := range messageNormalizers {
+		message = n.re.ReplaceAllString(message, n.repl)
+	}
+	return strings.TrimSpace(message)
+}
+
+type fingerprintFrame struct {
+	FileName     string `json:"fileName"`
+	FunctionName string `json:"functionName"`
+	LineNo       int    `json:"lineNumber"`
+}
+
+func isVendorFile(fileName string) bool {
+	for _, scheme := range extensionSchemes {
+		if strings.HasPrefix(fileName, scheme) {
+			return true
+		}
+	}
+	for _, marker := range vendorMarkers {
+		if strings.Contains(fileName, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeFileName removes origin, query, fragment and content hash from file name
+func normalizeFileName(fileName string) string {
+	if idx := strings.IndexAny(fileName, "?#"); idx != -1 {
+		fileName = fileName[:idx]
+	}
+	if idx := strings.Index(fileName, "://"); idx != -1 {
+		path := fileName[idx+3:]
+		if slash := strings.IndexByte(path, '/'); slash != -1 {
+			fileName = path[slash:]
+		}
+	}
+	return bundleHashRe.ReplaceAllString(fileName, "$1<hash>$2")
+}
+
+// normalizeFrames returns list of frames without dynamic parts (line numbers are kept only for app code)
+func normalizeFrames(payload string) []string {
+	var frames []*fingerprintFrame
+	if err := json.Unmarshal([]byte(payload), &frames); err != nil {
+		return nil
+	}
+	res := make([]string, 0, maxFingerprintFrames)
+	for _, f := range frames {
+		if f == nil || f.FileName == "" {
+			continue
+		}
+		frame := normalizeFileName(f.FileName) + ":" + f.FunctionName
+		if !isVendorFile(f.FileName) {
+			frame += ":" + strconv.Itoa(f.LineNo)
+		}
+		res = append(res, frame)
+		if len(res) == maxFingerprintFrames {
+			break
+		}
+	}
+	return res
+}
+
+// Fingerprint returns a stable hash used for errors grouping
+func (e *ErrorEvent) Fingerprint() string {
+	if e.fingerprint != "" {
+		return e.fingerprint
+	}
+	hash := fnv.New128a()
+	hash.Write([]byte(e.Source))
+	hash.Write([]byte(e.Name))
+	hash.Write([]byte(NormalizeMessage(e.Message)))
+	if e.Source == SOURCE_JS {
+		for _, frame := range normalizeFrames(e.Payload) {
+			hash.Write([]byte(frame))
+		}
+	}
+	e.fingerprint = hex.EncodeToString(hash.Sum(nil))
+	return e.fingerprint
+}
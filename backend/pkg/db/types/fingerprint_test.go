@@ -0,0 +1,67 @@
+package types
+
+import "testing"
+
+func TestNormalizeMessage(t *testing.T) {
+	cases := map[string]string{
+		"Request failed with status code 404":                          "Request failed with status code <num>",
+		"Failed to fetch https://api.example.com/users/42?x=1":         "Failed to fetch <url>",
+		"Order 3f2a9c1b-0d4e-4a5b-9c8d-7e6f5a4b3c2d not found":         "Order <uuid> not found",
+		"Cannot read properties of undefined (reading 'id')":           "Cannot read properties of undefined (reading 'id')",
+		"Unexpected token at 0x1f in session 6fa459eab9f14c0d8bd56f21": "Unexpected token at <hex> in session <hex>",
+	}
+	for message, expected := range cases {
+		if got := NormalizeMessage(message); got != expected {
+			t.Errorf("Expected %q, but got %q", expected, got)
+		}
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	newEvent := func(message, payload string) *ErrorEvent {
+		return &ErrorEvent{Source: SOURCE_JS, Name: "TypeError", Message: message, Payload: payload}
+	}
+	base := newEvent("Item 12 is undefined",
+		`[{"fileName":"https://example.com/static/main.3f2a9c1b.js?v=1","functionName":"render","lineNumber":10,"columnNumber":5},`+
+			`{"fileName":"https://example.com/node_modules/react/index.js","functionName":"call","lineNumber":100,"columnNumber":7}]`)
+
+	// Dynamic values in the message, bundle hash and vendor line numbers don't change the group
+	same := newEvent("Item 345 is undefined",
+		`[{"fileName":"https://example.com/static/main.77aa88bb.js","functionName":"render","lineNumber":10,"columnNumber":9},`+
+			`{"fileName":"https://example.com/node_modules/react/index.js","functionName":"call","lineNumber":230,"columnNumber":1}]`)
+	if base.Fingerprint() != same.Fingerprint() {
+		t.Errorf("Expected the same fingerprint for similar errors")
+	}
+
+	// Another line in app code is another error
+	other := newEvent("Item 12 is undefined",
+		`[{"fileName":"https://example.com/static/main.3f2a9c1b.js","functionName":"render","lineNumber":11,"columnNumber":5},`+
+			`{"fileName":"https://example.com/node_modules/react/index.js","functionName":"call","lineNumber":100,"columnNumber":7}]`)
+	if base.Fingerprint() == other.Fingerprint() {
+		t.Errorf("Expected different fingerprints for errors in different lines of app code")
+	}
+
+	if base.ID(1) == base.ID(2) {
+		t.Errorf("Expected different error ids for different projects")
+	}
+}
+
+func TestErrorID(t *testing.T) {
+	e := &ErrorEvent{Source: SOURCE_JS, Name: "TypeError", Message: "Item 12 is undefined",
+		Payload: `[{"fileName":"https://example.com/main.js","lineNumber":10,"columnNumber":5}]`}
+	// Ids of error groups created before fingerprints
+	if id := e.LegacyID(42); id != "2a961a4d06b0400bed8978be8e3f591373" {
+		t.Errorf("wrong legacy id: %s", id)
+	}
+	if e.LegacyID(42) == e.ID(42) {
+		t.Errorf("legacy id must differ from fingerprint id")
+	}
+	if id := e.ID(42); id != "2a"+e.Fingerprint() {
+		t.Errorf("wrong id: %s", id)
+	}
+	// Existing error group keeps its id
+	e.SetID(e.LegacyID(42))
+	if e.ID(42) != e.LegacyID(42) {
+		t.Errorf("id of existing group isn't used")
+	}
+}
@@ -0,0 +1,137 @@
+package deadletter
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"openreplay/backend/pkg/messages"
+	"openreplay/backend/pkg/queue/types"
+)
+
+/*
+	Dead-letter record format: header size (4 bytes) | json header | original batch data
+	The batch data isn't a part of json to keep the record size close to the size of the original batch.
+	Batches are replayed to the replay topic of the consumer group which rejected them, so services which
+	processed the batch successfully don't get it again.
+*/
+
+const HEADER_SIZE_LEN = 4
+
+// ReplayTopic returns the topic with replayed batches of the consumer group
+func ReplayTopic(deadLetterTopic, group string) string {
+	return deadLetterTopic + "-" + group
+}
+
+// Batch is a failed batch with everything we need for investigation and replay
+type Batch struct {
+	Service   string `json:"service"`
+	Group     string `json:"group"`
+	Reason    string `json:"reason"`
+	SessionID uint64 `json:"sessionID"`
+	Topic     string `json:"topic"`
+	Partition uint64 `json:"partition"`
+	BatchID   uint64 `json:"batchID"`
+	Timestamp int64  `json:"timestamp"` // timestamp of the batch in the original topic
+	Version   uint64 `json:"version"`
+	FailedAt  int64  `json:"failedAt"`
+	Data      []byte `json:"-"`
+}
+
+func (b *Batch) Encode() ([]byte, error) {
+	header, err := json.Marshal(b)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, HEADER_SIZE_LEN, HEADER_SIZE_LEN+len(header)+len(b.Data))
+	binary.BigEndian.PutUint32(data, uint32(len(header)))
+	data = append(data, header...)
+	return append(data, b.Data...), nil
+}
+
+func Decode(data []byte) (*Batch, error) {
+	if len(data) < HEADER_SIZE_LEN {
+		return nil, errors.New("dead-letter record is too short")
+	}
+	size := int(binary.BigEndian.Uint32(data))
+	if len(data)-HEADER_SIZE_LEN < size {
+		return nil, fmt.Errorf("wrong dead-letter header size: %d", size)
+	}
+	b := &Batch{}
+	if err := json.Unmarshal(data[HEADER_SIZE_LEN:HEADER_SIZE_LEN+size], b); err != nil {
+		return nil, fmt.Errorf("can't parse dead-letter header: %s", err)
+	}
+	b.Data = data[HEADER_SIZE_LEN+size:]
+	return b, nil
+}
+
+// BatchInfo returns information about the batch in the original topic
+func (b *Batch) BatchInfo() *messages.BatchInfo {
+	return messages.NewBatchInfo(b.SessionID, b.Topic, b.BatchID, b.Partition, b.Timestamp)
+}
+
+type validator struct {
+	reason string
+}
+
+func (v *validator) Send(_ []byte, _ *messages.BatchInfo, reason string) {
+	if v.reason == "" {
+		v.reason = reason
+	}
+}
+
+// Validate checks that all messages of the batch can be decoded by the current version of decoder
+func (b *Batch) Validate() error {
+	v := &validator{}
+	iterator := messages.NewMessageIterator(func(messages.Message) {}, nil, true)
+	iterator.(messages.DeadLetterReporter).SetDeadLetterQueue(v)
+	iterator.Iterate(append([]byte(nil), b.Data...), b.BatchInfo())
+	if v.reason != "" {
+		return errors.New(v.reason)
+	}
+	return nil
+}
+
+// Queue sends failed batches of one consumer group to the dead-letter topic
+type Queue struct {
+	producer types.Producer
+	topic    string
+	service  string
+	group    string
+}
+
+func New(producer types.Producer, topic, service, group string) *Queue {
+	return &Queue{
+		producer: producer,
+		topic:    topic,
+		service:  service,
+		group:    group,
+	}
+}
+
+func (q *Queue) Send(batchData []byte, batchInfo *messages.BatchInfo, reason string) {
+	batch := &Batch{
+		Service:   q.service,
+		Group:     q.group,
+		Reason:    reason,
+		SessionID: batchInfo.SessionID(),
+		Topic:     batchInfo.Topic(),
+		Partition: batchInfo.Partition(),
+		BatchID:   batchInfo.ID(),
+		Timestamp: batchInfo.Timestamp(),
+		Version:   batchInfo.Version(),
+		FailedAt:  time.Now().UnixMilli(),
+		Data:      batchData,
+	}
+	data, err := batch.Encode()
+	if err != nil {
+		log.Printf("can't encode dead-letter batch: %s, info: %s", err, batchInfo.Info())
+		return
+	}
+	if err := q.producer.Produce(q.topic, batch.SessionID, data); err != nil {
+		log.Printf("can't send batch to dead-letter topic: %s, info: %s", err, batchInfo.Info())
+	}
+}
@@ -0,0 +1,98 @@
+package deadletter
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"openreplay/backend/pkg/memqueue"
+	"openreplay/backend/pkg/messages"
+)
+
+// sized encodes message in the format of the 1st version of the batch protocol
+func sized(msg messages.Message) []byte {
+	data := msg.Encode()
+	size := len(data) - 1
+	return append([]byte{data[0], byte(size), byte(size >> 8), byte(size >> 16)}, data[1:]...)
+}
+
+func batch(version uint64, msgs ...[]byte) []byte {
+	data := (&messages.BatchMetadata{Version: version, Timestamp: 1}).Encode()
+	for _, msg := range msgs {
+		data = append(data, msg...)
+	}
+	return data
+}
+
+type recorder struct {
+	records [][]byte
+}
+
+func (r *recorder) Iterate(data []byte, _ *messages.BatchInfo) {
+	r.records = append(r.records, data)
+}
+
+func TestDeadLetter(t *testing.T) {
+	broker, err := memqueue.NewBroker("", 1, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+	queue := New(broker.NewProducer(), "dead-letter", "sink", "sink-group")
+
+	handled := 0
+	iterator := messages.NewMessageIterator(func(messages.Message) { handled++ }, nil, true)
+	iterator.(messages.DeadLetterReporter).SetDeadLetterQueue(queue)
+
+	timestamp := sized(&messages.Timestamp{Timestamp: 100})
+	valid := batch(1, timestamp, timestamp)
+	cases := []struct {
+		name   string
+		data   []byte
+		reason string
+	}{
+		{"unsupported version", batch(2, timestamp), "incorrect batch version"},
+		{"truncated message", batch(1, timestamp, timestamp[:len(timestamp)-1]), "pre-decode batch err"},
+		{"valid batch", valid, ""},
+	}
+	for i, c := range cases {
+		original := append([]byte(nil), c.data...)
+		iterator.Iterate(c.data, messages.NewBatchInfo(uint64(i), "raw", uint64(i), 0, 1000))
+		cases[i].data = original
+	}
+	if handled != 3 {
+		t.Errorf("expected 3 handled messages (with batch metadata), got %d", handled)
+	}
+
+	rec := &recorder{}
+	consumer := broker.NewConsumer("replayer", []string{"dead-letter"}, rec, false)
+	consumer.ConsumeNext()
+	if len(rec.records) != 2 {
+		t.Fatalf("expected 2 dead-letter records, got %d", len(rec.records))
+	}
+	for i, record := range rec.records {
+		b, err := Decode(record)
+		if err != nil {
+			t.Fatalf("can't decode dead-letter record: %s", err)
+		}
+		c := cases[b.SessionID]
+		if !strings.Contains(b.Reason, c.reason) {
+			t.Errorf("%s: expected reason %q, got %q", c.name, c.reason, b.Reason)
+		}
+		if !bytes.Equal(b.Data, c.data) {
+			t.Errorf("%s: batch data is modified", c.name)
+		}
+		if b.Service != "sink" || b.Group != "sink-group" || b.Topic != "raw" || b.BatchID != uint64(i) {
+			t.Errorf("%s: wrong batch info: %+v", c.name, b)
+		}
+		if err := b.Validate(); err == nil {
+			t.Errorf("%s: broken batch passed validation", c.name)
+		}
+	}
+
+	fixed := &Batch{SessionID: 2, Topic: "raw", Data: valid}
+	if err := fixed.Validate(); err != nil {
+		t.Errorf("valid batch failed validation: %s", err)
+	}
+}
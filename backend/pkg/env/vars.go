@@ -5,6 +5,7 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"time"
 )
 
 func String(key string) string {
@@ -47,6 +48,25 @@ func Int(key string) int {
 	return int(val)
 }
 
+func Uint64Optional(key string, defaultValue uint64) uint64 {
+	if StringOptional(key) == "" {
+		return defaultValue
+	}
+	return Uint64(key)
+}
+
+func DurationOptional(key string, defaultValue time.Duration) time.Duration {
+	v := StringOptional(key)
+	if v == "" {
+		return defaultValue
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Fatalln(key+" has a wrong value. ", err)
+	}
+	return d
+}
+
 func Bool(key string) bool {
 	v := String(key)
 	if v != "true" && v != "false" {
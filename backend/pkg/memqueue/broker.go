@@ -0,0 +1,340 @@
+package memqueue
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"openreplay/backend/pkg/env"
+)
+
+/*
+	In-process message broker for single-binary deployments and tests.
+	Every topic has a fixed number of partitions (key % partitions), consumer groups keep committed offsets
+	per partition, and partitions are distributed between group members like in redis and nats queues.
+	With MEMQUEUE_DIR all records and committed offsets are written to a write-ahead log and restored on start.
+	Records are removed when they are committed by all consumer groups of the topic. Topics without consumer groups
+	keep records for MEMQUEUE_RETENTION.
+*/
+
+const (
+	TRIM_INTERVAL  = 10 * time.Second
+	FLUSH_INTERVAL = time.Second
+)
+
+type record struct {
+	offset uint64
+	key    uint64
+	ts     int64 // append time in milliseconds
+	value  []byte
+}
+
+type partition struct {
+	records []*record
+	start   uint64 // offset of the first record in memory
+	next    uint64 // offset of the next record
+	log     *partitionLog
+}
+
+func (p *partition) get(offset uint64) *record {
+	if offset < p.start || offset >= p.next {
+		return nil
+	}
+	return p.records[offset-p.start]
+}
+
+// trim removes records with offsets less than cut
+func (p *partition) trim(cut uint64) {
+	if cut > p.next {
+		cut = p.next
+	}
+	if cut <= p.start {
+		return
+	}
+	n := cut - p.start
+	for i := uint64(0); i < n; i++ {
+		p.records[i] = nil // release values for gc
+	}
+	p.records, p.start = p.records[n:], cut
+}
+
+type topic struct {
+	name       string
+	partitions []*partition
+}
+
+type group struct {
+	name       string
+	members    map[string]bool
+	generation uint64
+	offsets    map[string][]uint64 // committed offsets of topic partitions
+}
+
+func (g *group) memberNames() []string {
+	names := make([]string, 0, len(g.members))
+	for name := range g.members {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+type Broker struct {
+	mutex       sync.Mutex
+	dir         string // empty for memory only mode
+	partitions  uint64
+	retention   time.Duration
+	segmentSize int64
+	topics      map[string]*topic
+	groups      map[string]*group
+	notify      chan struct{} // closed on every append
+	members     uint64        // counter for unique member names
+	done        chan struct{}
+	wg          sync.WaitGroup
+}
+
+var (
+	defaultBroker *Broker
+	defaultOnce   sync.Once
+)
+
+// Default returns the broker shared by all producers and consumers of the process
+func Default() *Broker {
+	defaultOnce.Do(func() {
+		b, err := NewBroker(
+			env.StringOptional("MEMQUEUE_DIR"),
+			env.Uint64Optional("MEMQUEUE_PARTITIONS", 1),
+			env.DurationOptional("MEMQUEUE_RETENTION", time.Hour),
+		)
+		if err != nil {
+			log.Fatalf("can't init memory queue: %s", err)
+		}
+		defaultBroker = b
+	})
+	return defaultBroker
+}
+
+// Partitions is the same for all topics
+func (b *Broker) Partitions() uint64 {
+	return b.partitions
+}
+
+func NewBroker(dir string, partitions uint64, retention time.Duration) (*Broker, error) {
+	if partitions == 0 {
+		partitions = 1
+	}
+	b := &Broker{
+		dir:         dir,
+		partitions:  partitions,
+		retention:   retention,
+		segmentSize: int64(env.Uint64Optional("MEMQUEUE_SEGMENT_SIZE", 64*1024*1024)),
+		topics:      make(map[string]*topic),
+		groups:      make(map[string]*group),
+		notify:      make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	if dir != "" {
+		if err := b.restore(); err != nil {
+			b.closeLogs()
+			return nil, err
+		}
+	}
+	b.wg.Add(1)
+	go b.background()
+	return b, nil
+}
+
+func (b *Broker) getTopic(name string) (*topic, error) {
+	t, ok := b.topics[name]
+	if ok {
+		return t, nil
+	}
+	t = &topic{name: name, partitions: make([]*partition, b.partitions)}
+	for i := range t.partitions {
+		t.partitions[i] = &partition{}
+		if b.dir != "" {
+			l, err := openPartitionLog(b.partitionDir(name, uint64(i)), 0, b.segmentSize)
+			if err != nil {
+				return nil, err
+			}
+			t.partitions[i].log = l
+		}
+	}
+	b.topics[name] = t
+	return t, nil
+}
+
+func (b *Broker) append(topicName string, part, key uint64, value []byte) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	t, err := b.getTopic(topicName)
+	if err != nil {
+		return err
+	}
+	p := t.partitions[part%b.partitions]
+	rec := &record{
+		offset: p.next,
+		key:    key,
+		ts:     time.Now().UnixMilli(),
+		value:  append([]byte(nil), value...),
+	}
+	if p.log != nil {
+		if err := p.log.write(rec); err != nil {
+			return err
+		}
+	}
+	p.records = append(p.records, rec)
+	p.next++
+	close(b.notify)
+	b.notify = make(chan struct{})
+	return nil
+}
+
+func (b *Broker) getGroup(name string) *group {
+	g, ok := b.groups[name]
+	if !ok {
+		g = &group{name: name, members: make(map[string]bool), offsets: make(map[string][]uint64)}
+		b.groups[name] = g
+	}
+	return g
+}
+
+// committed returns committed offset of the topic partition for the group
+func (b *Broker) committed(g *group, t *topic, part uint64) uint64 {
+	offsets := g.offsets[t.name]
+	if offsets == nil {
+		offsets = make([]uint64, b.partitions)
+		for i, p := range t.partitions {
+			offsets[i] = p.start // new group reads all available records
+		}
+		g.offsets[t.name] = offsets
+	}
+	return offsets[part]
+}
+
+func (b *Broker) commit(g *group, topicName string, part, offset uint64) {
+	offsets := g.offsets[topicName]
+	if offsets == nil {
+		offsets = make([]uint64, b.partitions)
+		g.offsets[topicName] = offsets
+	}
+	offsets[part] = offset
+}
+
+func (b *Broker) join(groupName, member string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	g := b.getGroup(groupName)
+	g.members[member] = true
+	g.generation++
+}
+
+func (b *Broker) leave(groupName, member string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	g := b.getGroup(groupName)
+	delete(g.members, member)
+	g.generation++
+}
+
+// trim removes records committed by all groups of the topic and old records of topics without groups
+func (b *Broker) trim() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	minTs := time.Now().Add(-b.retention).UnixMilli()
+	for _, t := range b.topics {
+		for i, p := range t.partitions {
+			cut, subscribed := p.next, false
+			for _, g := range b.groups {
+				if offsets := g.offsets[t.name]; offsets != nil {
+					subscribed = true
+					if offsets[i] < cut {
+						cut = offsets[i]
+					}
+				}
+			}
+			// Uncommitted records are never removed, records of topics without groups are kept for retention
+			if !subscribed {
+				cut = p.start
+				if b.retention > 0 {
+					for expired := cut; expired < p.next && p.get(expired).ts < minTs; expired++ {
+						cut = expired + 1
+					}
+				}
+			}
+			p.trim(cut)
+			if p.log != nil {
+				if err := p.log.trim(cut); err != nil {
+					log.Printf("can't remove old segments of %s/%d: %s", t.name, i, err)
+				}
+			}
+		}
+	}
+}
+
+// Sync writes buffered records to disk, committed offsets are saved on every commit
+func (b *Broker) Sync() error {
+	if b.dir == "" {
+		return nil
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for _, t := range b.topics {
+		for _, p := range t.partitions {
+			if err := p.log.sync(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (b *Broker) background() {
+	defer b.wg.Done()
+	trimTick := time.NewTicker(TRIM_INTERVAL)
+	defer trimTick.Stop()
+	flushTick := time.NewTicker(FLUSH_INTERVAL)
+	defer flushTick.Stop()
+	for {
+		select {
+		case <-trimTick.C:
+			b.trim()
+		case <-flushTick.C:
+			if b.dir == "" {
+				continue
+			}
+			b.mutex.Lock()
+			for _, t := range b.topics {
+				for _, p := range t.partitions {
+					if err := p.log.flush(); err != nil {
+						log.Printf("can't flush memory queue log: %s", err)
+					}
+				}
+			}
+			b.mutex.Unlock()
+		case <-b.done:
+			return
+		}
+	}
+}
+
+func (b *Broker) Close() error {
+	close(b.done)
+	b.wg.Wait()
+	err := b.Sync()
+	b.closeLogs()
+	return err
+}
+
+func (b *Broker) closeLogs() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for _, t := range b.topics {
+		for _, p := range t.partitions {
+			if p.log != nil {
+				p.log.close()
+			}
+		}
+	}
+}
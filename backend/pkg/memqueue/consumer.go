@@ -0,0 +1,230 @@
+package memqueue
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"openreplay/backend/pkg/messages"
+	"openreplay/backend/pkg/queue/types"
+)
+
+const (
+	READ_COUNT = 100
+	READ_BLOCK = 200 * time.Millisecond
+)
+
+type topicPartition struct {
+	topic     string
+	partition uint64
+}
+
+type Consumer struct {
+	broker          *Broker
+	group           string
+	name            string
+	topics          []string
+	messageIterator messages.MessageIterator
+	autoCommit      bool
+	generation      uint64
+	assigned        []uint64                  // nil before the first assignment
+	positions       map[topicPartition]uint64 // next offset to read
+	lastTs          map[topicPartition]int64  // timestamp of the last read record
+	next            int                       // round-robin index of assigned topic partitions
+	event           chan *types.PartitionsRebalancedEvent
+}
+
+func NewConsumer(group string, topics []string, messageIterator messages.MessageIterator, autoCommit bool) *Consumer {
+	return Default().NewConsumer(group, topics, messageIterator, autoCommit)
+}
+
+func (b *Broker) NewConsumer(group string, topics []string, messageIterator messages.MessageIterator, autoCommit bool) *Consumer {
+	b.mutex.Lock()
+	b.members++
+	name := fmt.Sprintf("%s-%d", group, b.members)
+	b.mutex.Unlock()
+	c := &Consumer{
+		broker:          b,
+		group:           group,
+		name:            name,
+		topics:          topics,
+		messageIterator: messageIterator,
+		autoCommit:      autoCommit,
+		positions:       make(map[topicPartition]uint64),
+		lastTs:          make(map[topicPartition]int64),
+		event:           make(chan *types.PartitionsRebalancedEvent, 32),
+	}
+	b.join(group, name)
+	return c
+}
+
+func (c *Consumer) Rebalanced() <-chan *types.PartitionsRebalancedEvent {
+	return c.event
+}
+
+func (c *Consumer) emit(rebalanceType types.RebalanceType, partitions []uint64) {
+	select {
+	case c.event <- &types.PartitionsRebalancedEvent{Type: rebalanceType, Partitions: partitions}:
+	default:
+		log.Printf("rebalance event (%s: %v) is dropped, channel is full", rebalanceType, partitions)
+	}
+}
+
+// rebalance recalculates partitions of the consumer after group membership changes, must be called under lock
+func (c *Consumer) rebalance(g *group) {
+	c.generation = g.generation
+	assigned := types.AssignPartitions(g.memberNames(), c.name, c.broker.partitions)
+	if c.assigned != nil && types.EqualPartitions(assigned, c.assigned) {
+		return
+	}
+	// Single partition is consumed without rebalance events, like in redis queue
+	withEvents := c.broker.partitions > 1
+	if c.assigned != nil && withEvents {
+		c.emit(types.RebalanceTypeRevoke, c.assigned)
+	}
+	c.assigned = assigned
+	c.positions = make(map[topicPartition]uint64)
+	c.lastTs = make(map[topicPartition]int64)
+	for _, name := range c.topics {
+		t, err := c.broker.getTopic(name)
+		if err != nil {
+			log.Printf("memory queue: can't create topic %s: %s", name, err)
+			continue
+		}
+		for _, p := range assigned {
+			c.positions[topicPartition{name, p}] = c.broker.committed(g, t, p)
+		}
+	}
+	if withEvents {
+		c.emit(types.RebalanceTypeAssign, assigned)
+	}
+}
+
+// fetch returns next records of one of the assigned topic partitions
+func (c *Consumer) fetch() (topicPartition, []*record, chan struct{}) {
+	b := c.broker
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if g := b.getGroup(c.group); c.generation != g.generation {
+		c.rebalance(g)
+	}
+	count := len(c.topics) * len(c.assigned)
+	for i := 0; i < count; i++ {
+		idx := (c.next + i) % count
+		tp := topicPartition{c.topics[idx/len(c.assigned)], c.assigned[idx%len(c.assigned)]}
+		t, ok := b.topics[tp.topic]
+		if !ok {
+			continue
+		}
+		p := t.partitions[tp.partition]
+		pos := c.positions[tp]
+		if pos < p.start {
+			log.Printf("memory queue: records %d-%d of %s/%d were removed before consumption by group %s",
+				pos, p.start-1, tp.topic, tp.partition, c.group)
+			pos = p.start
+			c.positions[tp] = pos
+		}
+		if pos >= p.next {
+			continue
+		}
+		end := pos + READ_COUNT
+		if end > p.next {
+			end = p.next
+		}
+		records := make([]*record, end-pos)
+		copy(records, p.records[pos-p.start:end-p.start])
+		c.next = idx + 1
+		return tp, records, nil
+	}
+	return topicPartition{}, nil, b.notify
+}
+
+func (c *Consumer) ConsumeNext() error {
+	tp, records, notify := c.fetch()
+	if len(records) == 0 {
+		select {
+		case <-notify:
+		case <-time.After(READ_BLOCK):
+		}
+		return nil
+	}
+	for _, rec := range records {
+		c.messageIterator.Iterate(rec.value, messages.NewBatchInfo(rec.key, tp.topic, rec.offset, tp.partition, rec.ts))
+		c.positions[tp] = rec.offset + 1
+		c.lastTs[tp] = rec.ts
+	}
+	if c.autoCommit {
+		return c.Commit()
+	}
+	return nil
+}
+
+func (c *Consumer) Commit() error {
+	return c.commit(func(tp topicPartition, t *topic, committed uint64) uint64 {
+		return c.positions[tp]
+	})
+}
+
+// CommitBack commits records which are older than the last read record of the partition by gap milliseconds
+func (c *Consumer) CommitBack(gap int64) error {
+	return c.commit(func(tp topicPartition, t *topic, committed uint64) uint64 {
+		p, maxTs := t.partitions[tp.partition], c.lastTs[tp]-gap
+		offset := committed
+		if offset < p.start {
+			offset = p.start
+		}
+		for ; offset < c.positions[tp]; offset++ {
+			if p.get(offset).ts > maxTs {
+				break
+			}
+		}
+		return offset
+	})
+}
+
+func (c *Consumer) commit(offset func(tp topicPartition, t *topic, committed uint64) uint64) error {
+	b := c.broker
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	g := b.getGroup(c.group)
+	owned := c.assigned
+	if c.generation != g.generation {
+		// Don't commit partitions which could be assigned to another member already
+		owned = types.AssignPartitions(g.memberNames(), c.name, b.partitions)
+	}
+	changed := false
+	for tp, pos := range c.positions {
+		t, ok := b.topics[tp.topic]
+		if !ok || !containsPartition(owned, tp.partition) {
+			continue
+		}
+		committed := b.committed(g, t, tp.partition)
+		if pos <= committed {
+			continue
+		}
+		if next := offset(tp, t, committed); next > committed {
+			b.commit(g, tp.topic, tp.partition, next)
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	if err := b.saveOffsets(g); err != nil {
+		return fmt.Errorf("memory queue: can't save offsets of group %s: %s", c.group, err)
+	}
+	return nil
+}
+
+func containsPartition(partitions []uint64, partition uint64) bool {
+	for _, p := range partitions {
+		if p == partition {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Consumer) Close() {
+	c.broker.leave(c.group, c.name)
+}
@@ -0,0 +1,188 @@
+package memqueue
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"openreplay/backend/pkg/messages"
+	"openreplay/backend/pkg/queue/types"
+)
+
+type testIterator struct {
+	batches []*messages.BatchInfo
+	data    []string
+}
+
+func (t *testIterator) Iterate(data []byte, batchInfo *messages.BatchInfo) {
+	t.batches = append(t.batches, batchInfo)
+	t.data = append(t.data, string(data))
+}
+
+func consumeAll(t *testing.T, c *Consumer) {
+	for i := 0; i < 5; i++ { // one partition per call
+		if err := c.ConsumeNext(); err != nil {
+			t.Fatalf("consume error: %s", err)
+		}
+	}
+}
+
+func TestProduceConsume(t *testing.T) {
+	b, err := NewBroker("", 4, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	producer := b.NewProducer()
+	for id := uint64(0); id < 8; id++ {
+		producer.Produce("raw", id, []byte{byte(id)})
+	}
+	iterator := &testIterator{}
+	consumer := b.NewConsumer("sink", []string{"raw"}, iterator, false)
+	consumeAll(t, consumer)
+	if len(iterator.batches) != 8 {
+		t.Fatalf("expected 8 batches, got %d", len(iterator.batches))
+	}
+	for i, batch := range iterator.batches {
+		if iterator.data[i] != string([]byte{byte(batch.SessionID())}) {
+			t.Errorf("wrong data of session %d", batch.SessionID())
+		}
+	}
+	consumer.Commit()
+	consumer.Close()
+
+	// Another group reads all records, the same group continues from committed offsets
+	other := &testIterator{}
+	consumeAll(t, b.NewConsumer("db", []string{"raw"}, other, false))
+	producer.Produce("raw", 8, []byte{8})
+	iterator = &testIterator{}
+	consumeAll(t, b.NewConsumer("sink", []string{"raw"}, iterator, false))
+	if len(other.batches) != 8 || len(iterator.batches) != 1 {
+		t.Errorf("expected 8 and 1 batches, got %d and %d", len(other.batches), len(iterator.batches))
+	}
+}
+
+func TestRebalance(t *testing.T) {
+	b, _ := NewBroker("", 4, time.Hour)
+	defer b.Close()
+	first := b.NewConsumer("ender", []string{"raw"}, &testIterator{}, true)
+	first.ConsumeNext()
+	if event := <-first.Rebalanced(); event.Type != types.RebalanceTypeAssign || len(event.Partitions) != 4 {
+		t.Fatalf("expected assignment of all partitions, got %s %v", event.Type, event.Partitions)
+	}
+	second := b.NewConsumer("ender", []string{"raw"}, &testIterator{}, true)
+	second.ConsumeNext()
+	first.ConsumeNext()
+	if event := <-first.Rebalanced(); event.Type != types.RebalanceTypeRevoke {
+		t.Fatalf("expected revoke event, got %s", event.Type)
+	}
+	if event := <-first.Rebalanced(); len(event.Partitions) != 2 {
+		t.Fatalf("expected assignment of 2 partitions, got %v", event.Partitions)
+	}
+	if event := <-second.Rebalanced(); len(event.Partitions) != 2 || types.EqualPartitions(event.Partitions, first.assigned) {
+		t.Fatalf("expected assignment of other 2 partitions, got %v", event.Partitions)
+	}
+}
+
+func TestCommitBack(t *testing.T) {
+	b, _ := NewBroker("", 1, time.Hour)
+	defer b.Close()
+	producer := b.NewProducer()
+	for i := uint64(0); i < 4; i++ {
+		producer.Produce("raw", i, []byte{byte(i)})
+	}
+	// Make first two records old
+	p := b.topics["raw"].partitions[0]
+	p.records[0].ts -= 60000
+	p.records[1].ts -= 60000
+
+	consumer := b.NewConsumer("ender", []string{"raw"}, &testIterator{}, false)
+	consumeAll(t, consumer)
+	consumer.CommitBack(30000)
+	if offset := b.groups["ender"].offsets["raw"][0]; offset != 2 {
+		t.Errorf("expected committed offset 2, got %d", offset)
+	}
+	// Committed records are removed from memory
+	b.trim()
+	if p.start != 2 || len(p.records) != 2 {
+		t.Errorf("expected 2 records from offset 2, got %d from %d", len(p.records), p.start)
+	}
+}
+
+func TestRetention(t *testing.T) {
+	b, _ := NewBroker("", 1, time.Minute)
+	defer b.Close()
+	producer := b.NewProducer()
+	for i := uint64(0); i < 4; i++ {
+		producer.Produce("raw", i, []byte{byte(i)})
+		producer.Produce("unread", i, []byte{byte(i)})
+	}
+	for _, name := range []string{"raw", "unread"} {
+		for _, r := range b.topics[name].partitions[0].records {
+			r.ts -= 2 * 60000
+		}
+	}
+	consumer := b.NewConsumer("sink", []string{"raw"}, &testIterator{}, false)
+	consumeAll(t, consumer)
+
+	// Old records aren't removed until they are committed
+	b.trim()
+	if p := b.topics["raw"].partitions[0]; p.start != 0 || len(p.records) != 4 {
+		t.Errorf("expected 4 uncommitted records, got %d from %d", len(p.records), p.start)
+	}
+	if p := b.topics["unread"].partitions[0]; len(p.records) != 0 {
+		t.Errorf("expected old records of topic without groups to be removed, got %d", len(p.records))
+	}
+	consumer.Commit()
+	b.trim()
+	if p := b.topics["raw"].partitions[0]; p.start != 4 || len(p.records) != 0 {
+		t.Errorf("expected committed records to be removed, got %d from %d", len(p.records), p.start)
+	}
+}
+
+func TestRestore(t *testing.T) {
+	dir := t.TempDir()
+	b, err := NewBroker(dir, 2, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.segmentSize = 100 // roll segments often
+	producer := b.NewProducer()
+	for i := uint64(0); i < 10; i++ {
+		producer.Produce("raw", i, []byte("batch data"))
+	}
+	consumer := b.NewConsumer("sink", []string{"raw"}, &testIterator{}, false)
+	for i := 0; i < 3; i++ {
+		consumer.ConsumeNext() // one partition per call
+	}
+	consumer.Commit()
+	producer.Close(0)
+	b.Close()
+
+	// Write broken tail of partition log
+	segments, _ := filepath.Glob(filepath.Join(dir, "topics", "raw", "1", "*"+SEGMENT_EXT))
+	file, _ := os.OpenFile(segments[len(segments)-1], os.O_WRONLY|os.O_APPEND, 0644)
+	file.Write([]byte{1, 2, 3})
+	file.Close()
+
+	b, err = NewBroker(dir, 2, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	if n := b.topics["raw"].partitions[1].next; n != 5 {
+		t.Errorf("expected 5 records in restored partition, got %d", n)
+	}
+	producer = b.NewProducer()
+	producer.Produce("raw", 11, []byte("batch data"))
+	producer.Produce("raw", 12, []byte("batch data"))
+	iterator := &testIterator{}
+	consumeAll(t, b.NewConsumer("sink", []string{"raw"}, iterator, false))
+	if len(iterator.batches) != 2 || iterator.batches[0].SessionID() != 12 || iterator.batches[1].SessionID() != 11 {
+		for _, batch := range iterator.batches {
+			t.Logf("batch: %s", batch.Info())
+		}
+		t.Errorf("expected only new records after restore, got %d", len(iterator.batches))
+	}
+}
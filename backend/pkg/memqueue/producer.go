@@ -0,0 +1,38 @@
+package memqueue
+
+import (
+	"log"
+
+	"openreplay/backend/pkg/queue/types"
+)
+
+type Producer struct {
+	broker *Broker
+}
+
+func NewProducer() *Producer {
+	return Default().NewProducer()
+}
+
+func (b *Broker) NewProducer() *Producer {
+	return &Producer{broker: b}
+}
+
+func (p *Producer) Produce(topic string, key uint64, value []byte) error {
+	return p.broker.append(topic, types.SessionPartition(key, p.broker.partitions), key, value)
+}
+
+func (p *Producer) ProduceToPartition(topic string, partition, key uint64, value []byte) error {
+	return p.broker.append(topic, partition, key, value)
+}
+
+// Flush makes all produced records durable (only for disk-backed queue)
+func (p *Producer) Flush(_ int) {
+	if err := p.broker.Sync(); err != nil {
+		log.Printf("memory queue: can't sync log: %s", err)
+	}
+}
+
+func (p *Producer) Close(timeout int) {
+	p.Flush(timeout)
+}
@@ -0,0 +1,318 @@
+package memqueue
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+	Every partition is stored as a sequence of segment files named by the offset of the first record.
+	Record format: crc32 (4) | value size (4) | offset (8) | key (8) | timestamp (8) | value
+	Committed offsets of every group are stored as json map of topic partitions.
+*/
+
+const (
+	RECORD_HEADER_SIZE = 32
+	SEGMENT_EXT        = ".log"
+)
+
+var errCorrupted = errors.New("corrupted record")
+
+type segment struct {
+	base uint64
+	path string
+	size int64
+}
+
+type partitionLog struct {
+	dir         string
+	segmentSize int64
+	segments    []*segment // the last one is active
+	file        *os.File
+	writer      *bufio.Writer
+	header      [RECORD_HEADER_SIZE]byte
+}
+
+func (b *Broker) partitionDir(topic string, part uint64) string {
+	return filepath.Join(b.dir, "topics", url.PathEscape(topic), strconv.FormatUint(part, 10))
+}
+
+func (b *Broker) offsetsPath(group string) string {
+	return filepath.Join(b.dir, "groups", url.PathEscape(group)+".json")
+}
+
+func segmentPath(dir string, base uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%020d%s", base, SEGMENT_EXT))
+}
+
+func openPartitionLog(dir string, next uint64, segmentSize int64) (*partitionLog, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	l := &partitionLog{dir: dir, segmentSize: segmentSize}
+	return l, l.openSegment(&segment{base: next, path: segmentPath(dir, next)})
+}
+
+func (l *partitionLog) openSegment(s *segment) error {
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	l.segments = append(l.segments, s)
+	l.file, l.writer = file, bufio.NewWriterSize(file, 64*1024)
+	return nil
+}
+
+func (l *partitionLog) active() *segment {
+	return l.segments[len(l.segments)-1]
+}
+
+func (l *partitionLog) write(rec *record) error {
+	if l.active().size >= l.segmentSize {
+		if err := l.sync(); err != nil {
+			return err
+		}
+		l.file.Close()
+		if err := l.openSegment(&segment{base: rec.offset, path: segmentPath(l.dir, rec.offset)}); err != nil {
+			return err
+		}
+	}
+	binary.LittleEndian.PutUint32(l.header[4:], uint32(len(rec.value)))
+	binary.LittleEndian.PutUint64(l.header[8:], rec.offset)
+	binary.LittleEndian.PutUint64(l.header[16:], rec.key)
+	binary.LittleEndian.PutUint64(l.header[24:], uint64(rec.ts))
+	crc := crc32.NewIEEE()
+	crc.Write(l.header[4:])
+	crc.Write(rec.value)
+	binary.LittleEndian.PutUint32(l.header[0:], crc.Sum32())
+	if _, err := l.writer.Write(l.header[:]); err != nil {
+		return err
+	}
+	if _, err := l.writer.Write(rec.value); err != nil {
+		return err
+	}
+	l.active().size += int64(RECORD_HEADER_SIZE + len(rec.value))
+	return nil
+}
+
+func (l *partitionLog) flush() error {
+	return l.writer.Flush()
+}
+
+func (l *partitionLog) sync() error {
+	if err := l.writer.Flush(); err != nil {
+		return err
+	}
+	return l.file.Sync()
+}
+
+// trim removes segments which contain only records with offsets less than cut
+func (l *partitionLog) trim(cut uint64) error {
+	for len(l.segments) > 1 && l.segments[1].base <= cut {
+		if err := os.Remove(l.segments[0].path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		l.segments = l.segments[1:]
+	}
+	return nil
+}
+
+func (l *partitionLog) close() {
+	if err := l.sync(); err != nil {
+		log.Printf("can't sync %s: %s", l.active().path, err)
+	}
+	l.file.Close()
+}
+
+func readRecord(r io.Reader, header []byte) (*record, error) {
+	if _, err := io.ReadFull(r, header); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, errCorrupted
+		}
+		return nil, err
+	}
+	rec := &record{
+		offset: binary.LittleEndian.Uint64(header[8:]),
+		key:    binary.LittleEndian.Uint64(header[16:]),
+		ts:     int64(binary.LittleEndian.Uint64(header[24:])),
+		value:  make([]byte, binary.LittleEndian.Uint32(header[4:])),
+	}
+	if _, err := io.ReadFull(r, rec.value); err != nil {
+		return nil, errCorrupted
+	}
+	crc := crc32.NewIEEE()
+	crc.Write(header[4:])
+	crc.Write(rec.value)
+	if crc.Sum32() != binary.LittleEndian.Uint32(header[0:]) {
+		return nil, errCorrupted
+	}
+	return rec, nil
+}
+
+// readSegment returns records of the segment and size of its valid part
+func readSegment(path string, next uint64) ([]*record, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer file.Close()
+	var (
+		reader  = bufio.NewReaderSize(file, 64*1024)
+		header  = make([]byte, RECORD_HEADER_SIZE)
+		records []*record
+		size    int64
+	)
+	for {
+		rec, err := readRecord(reader, header)
+		if err == io.EOF {
+			return records, size, nil
+		}
+		if err == nil && rec.offset != next {
+			err = errCorrupted
+		}
+		if err != nil {
+			return records, size, err
+		}
+		records = append(records, rec)
+		size += int64(RECORD_HEADER_SIZE + len(rec.value))
+		next++
+	}
+}
+
+func loadPartition(dir string, segmentSize int64) (*partition, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	var segments []*segment
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, SEGMENT_EXT) {
+			continue
+		}
+		base, err := strconv.ParseUint(strings.TrimSuffix(name, SEGMENT_EXT), 10, 64)
+		if err != nil {
+			continue
+		}
+		segments = append(segments, &segment{base: base, path: filepath.Join(dir, name)})
+	}
+	if len(segments) == 0 {
+		l, err := openPartitionLog(dir, 0, segmentSize)
+		if err != nil {
+			return nil, err
+		}
+		return &partition{log: l}, nil
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i].base < segments[j].base })
+
+	p := &partition{start: segments[0].base, next: segments[0].base}
+	for i, s := range segments {
+		records, size, err := readSegment(s.path, p.next)
+		p.records = append(p.records, records...)
+		p.next += uint64(len(records))
+		s.size = size
+		if err == errCorrupted && i == len(segments)-1 {
+			// Tail of the log wasn't written completely before the crash
+			log.Printf("memory queue: truncate corrupted tail of %s at %d", s.path, size)
+			if err := os.Truncate(s.path, size); err != nil {
+				return nil, err
+			}
+		} else if err != nil {
+			return nil, fmt.Errorf("can't read %s: %s", s.path, err)
+		}
+	}
+	l := &partitionLog{dir: dir, segmentSize: segmentSize}
+	last := segments[len(segments)-1]
+	l.segments = segments[:len(segments)-1]
+	if err := l.openSegment(last); err != nil {
+		return nil, err
+	}
+	p.log = l
+	return p, nil
+}
+
+// restore loads records and committed offsets from disk
+func (b *Broker) restore() error {
+	topicsDir := filepath.Join(b.dir, "topics")
+	entries, err := os.ReadDir(topicsDir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		name, err := url.PathUnescape(entry.Name())
+		if err != nil {
+			continue
+		}
+		t := &topic{name: name, partitions: make([]*partition, b.partitions)}
+		b.topics[name] = t
+		for i := range t.partitions {
+			if t.partitions[i], err = loadPartition(b.partitionDir(name, uint64(i)), b.segmentSize); err != nil {
+				return err
+			}
+		}
+		if _, err := os.Stat(b.partitionDir(name, b.partitions)); err == nil {
+			log.Printf("memory queue: topic %s has more than %d partitions on disk, extra partitions are ignored", name, b.partitions)
+		}
+	}
+
+	groupsDir := filepath.Join(b.dir, "groups")
+	entries, err = os.ReadDir(groupsDir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, entry := range entries {
+		name, err := url.PathUnescape(strings.TrimSuffix(entry.Name(), ".json"))
+		if err != nil || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(groupsDir, entry.Name()))
+		if err != nil {
+			return err
+		}
+		g := b.getGroup(name)
+		if err := json.Unmarshal(data, &g.offsets); err != nil {
+			return fmt.Errorf("can't parse offsets of group %s: %s", name, err)
+		}
+		for topicName, offsets := range g.offsets {
+			fixed := make([]uint64, b.partitions)
+			copy(fixed, offsets)
+			g.offsets[topicName] = fixed
+		}
+	}
+	return nil
+}
+
+// saveOffsets atomically replaces committed offsets of the group on disk
+func (b *Broker) saveOffsets(g *group) error {
+	if b.dir == "" {
+		return nil
+	}
+	data, err := json.Marshal(g.offsets)
+	if err != nil {
+		return err
+	}
+	path := b.offsetsPath(g.name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
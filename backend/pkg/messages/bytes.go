@@ -3,10 +3,12 @@ package messages
 import (
 	"encoding/binary"
 	"errors"
-	"fmt"
 	"io"
 )
 
+// ErrOutOfRange means the data ends before the value, so the message is incomplete
+var ErrOutOfRange = errors.New("out of range")
+
 type BytesReader interface {
 	ReadSize() (uint64, error)
 	ReadByte() (byte, error)
@@ -33,7 +35,7 @@ func NewBytesReader(data []byte) BytesReader {
 
 func (m *bytesReaderImpl) ReadSize() (uint64, error) {
 	if len(m.data)-int(m.curr) < 3 {
-		return 0, fmt.Errorf("out of range")
+		return 0, ErrOutOfRange
 	}
 	var size uint64
 	for i, b := range m.data[m.curr : m.curr+3] {
@@ -101,7 +103,7 @@ func (m *bytesReaderImpl) ReadString() (string, error) {
 		return "", errors.New("too long string")
 	}
 	if len(m.data)-int(m.curr) < int(l) {
-		return "", fmt.Errorf("out of range")
+		return "", ErrOutOfRange
 	}
 	str := string(m.data[m.curr : int(m.curr)+int(l)])
 	m.curr += int64(l)
@@ -110,7 +112,7 @@ func (m *bytesReaderImpl) ReadString() (string, error) {
 
 func (m *bytesReaderImpl) ReadIndex() (uint64, error) {
 	if len(m.data)-int(m.curr) < 8 {
-		return 0, fmt.Errorf("out of range")
+		return 0, ErrOutOfRange
 	}
 	size := binary.LittleEndian.Uint64(m.data[m.curr : m.curr+8])
 	m.curr += 8
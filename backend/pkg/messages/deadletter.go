@@ -0,0 +1,12 @@
+package messages
+
+// DeadLetterQueue receives batches which can't be processed: broken batch data, unsupported batch versions
+// and messages which can't be decoded. The batch data is the original data of the queue message.
+type DeadLetterQueue interface {
+	Send(batchData []byte, batchInfo *BatchInfo, reason string)
+}
+
+// DeadLetterReporter is implemented by iterators which can send failed batches to the dead-letter queue
+type DeadLetterReporter interface {
+	SetDeadLetterQueue(queue DeadLetterQueue)
+}
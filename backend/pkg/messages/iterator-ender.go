@@ -28,3 +28,9 @@ func (e *enderIteratorImpl) Iterate(batchData []byte, batchInfo *BatchInfo) {
 		e.handler(e.lastMessage)
 	}
 }
+
+func (e *enderIteratorImpl) SetDeadLetterQueue(queue DeadLetterQueue) {
+	if reporter, ok := e.coreIterator.(DeadLetterReporter); ok {
+		reporter.SetDeadLetterQueue(queue)
+	}
+}
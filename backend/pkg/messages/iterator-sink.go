@@ -29,3 +29,9 @@ func (i *sinkIteratorImpl) Iterate(batchData []byte, batchInfo *BatchInfo) {
 	// Send batch end signal
 	i.handler(nil)
 }
+
+func (i *sinkIteratorImpl) SetDeadLetterQueue(queue DeadLetterQueue) {
+	if reporter, ok := i.coreIterator.(DeadLetterReporter); ok {
+		reporter.SetDeadLetterQueue(queue)
+	}
+}
@@ -25,6 +25,7 @@ type messageIteratorImpl struct {
 	messageInfo *message
 	batchInfo   *BatchInfo
 	urls        *pageLocations
+	deadLetter  DeadLetterQueue
 }
 
 func NewMessageIterator(messageHandler MessageHandler, messageFilter []int, autoDecode bool) MessageIterator {
@@ -57,6 +58,18 @@ func (i *messageIteratorImpl) prepareVars(batchInfo *BatchInfo) {
 	i.size = 0
 }
 
+func (i *messageIteratorImpl) SetDeadLetterQueue(queue DeadLetterQueue) {
+	i.deadLetter = queue
+}
+
+// reject sends the original batch to the dead-letter queue, the data is restored from the reader,
+// because it modifies data in place
+func (i *messageIteratorImpl) reject(reader MessageReader, batchInfo *BatchInfo, reason string) {
+	if i.deadLetter != nil {
+		i.deadLetter.Send(reader.Original(), batchInfo, reason)
+	}
+}
+
 func (i *messageIteratorImpl) Iterate(batchData []byte, batchInfo *BatchInfo) {
 	// Create new message reader
 	reader := NewMessageReader(batchData)
@@ -64,6 +77,7 @@ func (i *messageIteratorImpl) Iterate(batchData []byte, batchInfo *BatchInfo) {
 	// Pre-decode batch data
 	if err := reader.Parse(); err != nil {
 		log.Printf("pre-decode batch err: %s, info: %s", err, batchInfo.Info())
+		i.reject(reader, batchInfo, fmt.Sprintf("pre-decode batch err: %s", err))
 		return
 	}
 
@@ -82,11 +96,13 @@ func (i *messageIteratorImpl) Iterate(batchData []byte, batchInfo *BatchInfo) {
 			msg = msg.Decode()
 			if msg == nil {
 				log.Printf("decode error, type: %d, info: %s", msgType, i.batchInfo.Info())
+				i.reject(reader, batchInfo, fmt.Sprintf("decode error, type: %d", msgType))
 				return
 			}
 			msg = transformDeprecated(msg)
 			if err := i.preprocessing(msg); err != nil {
 				log.Printf("message preprocessing err: %s", err)
+				i.reject(reader, batchInfo, fmt.Sprintf("message preprocessing err: %s", err))
 				return
 			}
 		}
@@ -102,6 +118,7 @@ func (i *messageIteratorImpl) Iterate(batchData []byte, batchInfo *BatchInfo) {
 			msg = msg.Decode()
 			if msg == nil {
 				log.Printf("decode error, type: %d, info: %s", msgType, i.batchInfo.Info())
+				i.reject(reader, batchInfo, fmt.Sprintf("decode error, type: %d", msgType))
 				return
 			}
 		}
@@ -118,6 +135,12 @@ func (i *messageIteratorImpl) Iterate(batchData []byte, batchInfo *BatchInfo) {
 		// Process message
 		i.handler(msg)
 	}
+
+	// Message could be broken during decoding in handler
+	if err := reader.Err(); err != nil {
+		log.Printf("read batch err: %s, info: %s", err, batchInfo.Info())
+		i.reject(reader, batchInfo, fmt.Sprintf("read batch err: %s", err))
+	}
 }
 
 func (i *messageIteratorImpl) getIOSTimestamp(msg Message) uint64 {
@@ -135,7 +158,7 @@ func (i *messageIteratorImpl) preprocessing(msg Message) error {
 			return fmt.Errorf("batchMetadata found at the end of the batch, info: %s", i.batchInfo.Info())
 		}
 		if m.Version > 1 {
-			return fmt.Errorf("incorrect batch version: %d, skip current batch, info: %s", i.version, i.batchInfo.Info())
+			return fmt.Errorf("incorrect batch version: %d, skip current batch, info: %s", m.Version, i.batchInfo.Info())
 		}
 		i.messageInfo.Index = m.PageNo<<32 + m.FirstIndex // 2^32  is the maximum count of messages per page (ha-ha)
 		i.messageInfo.Timestamp = uint64(m.Timestamp)
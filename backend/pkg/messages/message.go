@@ -44,6 +44,18 @@ func (b *BatchInfo) Timestamp() int64 {
 	return b.timestamp
 }
 
+func (b *BatchInfo) Topic() string {
+	return b.topic
+}
+
+func (b *BatchInfo) Partition() uint64 {
+	return b.partition
+}
+
+func (b *BatchInfo) Version() uint64 {
+	return b.version
+}
+
 func (b *BatchInfo) Info() string {
 	return fmt.Sprintf("session: %d, partition: %d, offset: %d, ver: %d", b.sessionID, b.partition, b.id, b.version)
 }
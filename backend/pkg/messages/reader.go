@@ -1,6 +1,7 @@
 package messages
 
 import (
+	"errors"
 	"fmt"
 	"io"
 )
@@ -9,6 +10,8 @@ type MessageReader interface {
 	Parse() (err error)
 	Next() bool
 	Message() Message
+	Err() error
+	Original() []byte
 }
 
 func NewMessageReader(data []byte) MessageReader {
@@ -166,3 +169,29 @@ func (m *messageReaderImpl) Next() bool {
 func (m *messageReaderImpl) Message() Message {
 	return m.message
 }
+
+// Err returns the error which stopped reading of the batch
+func (m *messageReaderImpl) Err() error {
+	if m.broken {
+		return errors.New("message decode err")
+	}
+	return m.err
+}
+
+// Original returns a copy of the batch data without changes made by Parse (message types written over sizes)
+func (m *messageReaderImpl) Original() []byte {
+	data := append([]byte(nil), m.data...)
+	if m.version == 0 {
+		return data
+	}
+	for _, meta := range m.list {
+		if !messageHasSize(meta.msgType) {
+			continue
+		}
+		// Message type and 3 bytes of size were placed before the message body
+		p := WriteUint(meta.msgType, data, int(meta.msgFrom)-3)
+		size := meta.msgSize - 1
+		data[p], data[p+1], data[p+2] = byte(size), byte(size>>8), byte(size>>16)
+	}
+	return data
+}
@@ -0,0 +1,452 @@
+package messages
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+)
+
+// SessionSorter is a streaming version of SplitMessages, SortMessages and MergeMessages with the same result.
+// Messages are collected into runs of runSize bytes, full runs are sorted and spilled to temporary files
+// and all runs are merged by (timestamp, index) at the end, so only one run is kept in memory.
+type SessionSorter struct {
+	tmpDir         string
+	runSize        int
+	maxMessageSize int
+}
+
+func NewSessionSorter(tmpDir string, runSize, maxMessageSize int) *SessionSorter {
+	return &SessionSorter{
+		tmpDir:         tmpDir,
+		runSize:        runSize,
+		maxMessageSize: maxMessageSize,
+	}
+}
+
+// Spilled runs are merged into one run when there are too many of them to keep all files open
+const maxSpilledRuns = 64
+
+// sortItem is a message of the run, seq (position in the file) keeps the order of equal messages
+type sortItem struct {
+	timestamp uint64
+	index     uint64
+	seq       uint64
+	isTs      bool
+	start     int    // position of data in the run buffer
+	data      []byte // message type and body without index
+}
+
+func (i *sortItem) less(other *sortItem) bool {
+	if i.timestamp != other.timestamp {
+		return i.timestamp < other.timestamp
+	}
+	if i.index != other.index {
+		return i.index < other.index
+	}
+	return i.seq < other.seq
+}
+
+// Sort reads the session file and writes sorted messages to w. Nothing is written if the file can't be parsed.
+func (s *SessionSorter) Sort(sessID string, r io.Reader, w io.Writer) error {
+	runs := make([]sortRun, 0)
+	defer func() {
+		for _, run := range runs {
+			run.close()
+		}
+	}()
+
+	reader := newStreamReader(r, s.maxMessageSize)
+	indexes := &indexSet{}
+	hadDuplicates := false
+	var lastTimestamp, seq uint64
+	items := make([]sortItem, 0)
+	buf := make([]byte, 0)
+	for {
+		msgIndex, msgType, body, data, err := reader.next()
+		if err != nil {
+			return err
+		}
+		if data == nil {
+			break
+		}
+		// Only the first duplicate is skipped, the same as in SplitMessages
+		if !hadDuplicates {
+			if indexes.contains(msgIndex) {
+				hadDuplicates = true
+				log.Printf("Session %s has duplicate messages", sessID)
+				continue
+			}
+			indexes.add(msgIndex)
+		}
+		if msgType == MsgTimestamp {
+			lastTimestamp = body.(*Timestamp).Timestamp
+		}
+		items = append(items, sortItem{
+			timestamp: lastTimestamp,
+			index:     msgIndex,
+			seq:       seq,
+			isTs:      msgType == MsgTimestamp,
+			start:     len(buf),
+		})
+		buf = append(buf, data...)
+		seq++
+		if len(buf) >= s.runSize {
+			run, err := s.spill(newRun(items, buf))
+			if err != nil {
+				return err
+			}
+			runs = append(runs, run)
+			items, buf = items[:0], buf[:0]
+			if len(runs) == maxSpilledRuns {
+				if run, err = s.compact(runs); err != nil {
+					return err
+				}
+				runs = []sortRun{run}
+			}
+		}
+	}
+	if len(items) > 0 {
+		run := newRun(items, buf)
+		sortItems(run.items)
+		runs = append(runs, run)
+	}
+	return writeSession(runs, w)
+}
+
+func sortItems(items []sortItem) {
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].less(&items[j])
+	})
+}
+
+// spill sorts the run and saves it to the temporary file
+func (s *SessionSorter) spill(memRun *memoryRun) (sortRun, error) {
+	sortItems(memRun.items)
+	return s.writeRun([]sortRun{memRun})
+}
+
+// compact merges spilled runs into one run and removes them
+func (s *SessionSorter) compact(runs []sortRun) (sortRun, error) {
+	run, err := s.writeRun(runs)
+	for _, r := range runs {
+		r.close()
+	}
+	return run, err
+}
+
+// writeRun saves merged runs to the temporary file
+func (s *SessionSorter) writeRun(runs []sortRun) (sortRun, error) {
+	file, err := os.CreateTemp(s.tmpDir, "sort-run-")
+	if err != nil {
+		return nil, fmt.Errorf("can't create run file: %s", err)
+	}
+	run := &fileRun{file: file}
+	writer := bufio.NewWriter(file)
+	header := make([]byte, 29)
+	err = mergeRuns(runs, func(item *sortItem) {
+		binary.LittleEndian.PutUint64(header[0:], item.timestamp)
+		binary.LittleEndian.PutUint64(header[8:], item.index)
+		binary.LittleEndian.PutUint64(header[16:], item.seq)
+		header[24] = 0
+		if item.isTs {
+			header[24] = 1
+		}
+		binary.LittleEndian.PutUint32(header[25:], uint32(len(item.data)))
+		writer.Write(header)
+		writer.Write(item.data)
+	})
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err != nil {
+		run.close()
+		return nil, fmt.Errorf("can't write run file: %s", err)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		run.close()
+		return nil, err
+	}
+	run.reader = bufio.NewReader(file)
+	return run, nil
+}
+
+type sortRun interface {
+	next() (*sortItem, error) // nil item at the end of the run
+	close()
+}
+
+type memoryRun struct {
+	items []sortItem
+	pos   int
+}
+
+// newRun sets data of collected messages, the buffer isn't changed after that
+func newRun(items []sortItem, buf []byte) *memoryRun {
+	for i := range items {
+		end := len(buf)
+		if i+1 < len(items) {
+			end = items[i+1].start
+		}
+		items[i].data = buf[items[i].start:end]
+	}
+	return &memoryRun{items: items}
+}
+
+func (r *memoryRun) next() (*sortItem, error) {
+	if r.pos >= len(r.items) {
+		return nil, nil
+	}
+	r.pos++
+	return &r.items[r.pos-1], nil
+}
+
+func (r *memoryRun) close() {}
+
+type fileRun struct {
+	file   *os.File
+	reader *bufio.Reader
+	header [29]byte
+}
+
+func (r *fileRun) next() (*sortItem, error) {
+	if _, err := io.ReadFull(r.reader, r.header[:]); err != nil {
+		if err == io.EOF {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("can't read run file: %s", err)
+	}
+	item := &sortItem{
+		timestamp: binary.LittleEndian.Uint64(r.header[0:]),
+		index:     binary.LittleEndian.Uint64(r.header[8:]),
+		seq:       binary.LittleEndian.Uint64(r.header[16:]),
+		isTs:      r.header[24] == 1,
+		data:      make([]byte, binary.LittleEndian.Uint32(r.header[25:])),
+	}
+	if _, err := io.ReadFull(r.reader, item.data); err != nil {
+		return nil, fmt.Errorf("can't read run file: %s", err)
+	}
+	return item, nil
+}
+
+func (r *fileRun) close() {
+	r.file.Close()
+	os.Remove(r.file.Name())
+}
+
+type runHead struct {
+	item *sortItem
+	run  sortRun
+}
+
+type runHeap []*runHead
+
+func (h runHeap) Len() int            { return len(h) }
+func (h runHeap) Less(i, j int) bool  { return h[i].item.less(h[j].item) }
+func (h runHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *runHeap) Push(x interface{}) { *h = append(*h, x.(*runHead)) }
+func (h *runHeap) Pop() interface{} {
+	old := *h
+	head := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return head
+}
+
+// mergeRuns passes messages of all runs to write in sorted order
+func mergeRuns(runs []sortRun, write func(item *sortItem)) error {
+	h := make(runHeap, 0, len(runs))
+	for _, run := range runs {
+		item, err := run.next()
+		if err != nil {
+			return err
+		}
+		if item != nil {
+			h = append(h, &runHead{item: item, run: run})
+		}
+	}
+	heap.Init(&h)
+	for h.Len() > 0 {
+		head := h[0]
+		item := head.item
+		next, err := head.run.next()
+		if err != nil {
+			return err
+		}
+		if next != nil {
+			head.item = next
+			heap.Fix(&h, 0)
+		} else {
+			heap.Pop(&h)
+		}
+		write(item)
+	}
+	return nil
+}
+
+// writeSession writes messages of all runs in the same way as MergeMessages
+func writeSession(runs []sortRun, w io.Writer) error {
+	writer := bufio.NewWriter(w)
+	// Add maximum possible index value to the start of the session to inform player about new version of mob file
+	writer.Write([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	var lastTs *sortItem
+	err := mergeRuns(runs, func(item *sortItem) {
+		if item.isTs {
+			// Save last timestamp message and continue to read next message
+			lastTs = item
+			return
+		}
+		if lastTs != nil {
+			writer.Write(lastTs.data)
+			lastTs = nil
+		}
+		writer.Write(item.data)
+	})
+	if err != nil {
+		return err
+	}
+	return writer.Flush()
+}
+
+// streamReader parses messages of the session file keeping only a small window of the file in memory
+type streamReader struct {
+	r       io.Reader
+	buf     []byte
+	maxSize int // the window doesn't grow over the max message size
+	start   int // first byte of the next message
+	end     int // end of read data
+	eof     bool
+}
+
+// Message index and type are added to the message in the session file
+const messageHeaderSize = 8 + binary.MaxVarintLen64
+
+func newStreamReader(r io.Reader, maxMessageSize int) *streamReader {
+	maxSize := maxMessageSize + messageHeaderSize
+	size := 64 * 1024
+	if size > maxSize {
+		size = maxSize
+	}
+	return &streamReader{r: r, buf: make([]byte, size), maxSize: maxSize}
+}
+
+// fill reads more data, the buffer grows if the message doesn't fit into it
+func (s *streamReader) fill() error {
+	if s.start > 0 {
+		s.end = copy(s.buf, s.buf[s.start:s.end])
+		s.start = 0
+	}
+	if s.end == len(s.buf) {
+		if len(s.buf) >= s.maxSize {
+			return fmt.Errorf("message is bigger than %d bytes", s.maxSize-messageHeaderSize)
+		}
+		size := len(s.buf) * 2
+		if size > s.maxSize {
+			size = s.maxSize
+		}
+		buf := make([]byte, size)
+		copy(buf, s.buf[:s.end])
+		s.buf = buf
+	}
+	n, err := io.ReadFull(s.r, s.buf[s.end:])
+	s.end += n
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		s.eof = true
+		return nil
+	}
+	return err
+}
+
+// isIncomplete reports whether the error is caused by the end of the read data
+func isIncomplete(err error) bool {
+	return errors.Is(err, io.EOF) || errors.Is(err, ErrOutOfRange)
+}
+
+// next returns the next message, data is the message type with body and it's valid until the next call
+func (s *streamReader) next() (uint64, uint64, Message, []byte, error) {
+	for {
+		if s.start == s.end {
+			if s.eof {
+				return 0, 0, nil, nil, nil
+			}
+			if err := s.fill(); err != nil {
+				return 0, 0, nil, nil, err
+			}
+			continue
+		}
+		msgIndex, msgType, body, size, err := s.parse()
+		if err == nil {
+			data := s.buf[s.start+8 : s.start+size]
+			s.start += size
+			return msgIndex, msgType, body, data, nil
+		}
+		if s.eof || !isIncomplete(err) {
+			return 0, 0, nil, nil, err
+		}
+		if err := s.fill(); err != nil {
+			return 0, 0, nil, nil, err
+		}
+	}
+}
+
+func (s *streamReader) parse() (uint64, uint64, Message, int, error) {
+	reader := NewBytesReader(s.buf[s.start:s.end])
+	msgIndex, err := reader.ReadIndex()
+	if err != nil {
+		return 0, 0, nil, 0, fmt.Errorf("read message index err: %w", err)
+	}
+	msgType, err := reader.ReadUint()
+	if err != nil {
+		return 0, 0, nil, 0, fmt.Errorf("read message type err: %w", err)
+	}
+	body, err := ReadMessage(msgType, reader)
+	if err != nil {
+		return 0, 0, nil, 0, fmt.Errorf("read message body err: %w", err)
+	}
+	return msgIndex, msgType, body, int(reader.Pointer()), nil
+}
+
+// indexSet keeps message indexes as sorted ranges, indexes are mostly sequential so it stays small
+type indexSet struct {
+	ranges [][2]uint64 // inclusive ranges
+}
+
+// find returns the position of the first range which ends at or after the index
+func (s *indexSet) find(index uint64) int {
+	return sort.Search(len(s.ranges), func(i int) bool {
+		return s.ranges[i][1] >= index
+	})
+}
+
+func (s *indexSet) contains(index uint64) bool {
+	i := s.find(index)
+	return i < len(s.ranges) && s.ranges[i][0] <= index
+}
+
+func (s *indexSet) add(index uint64) {
+	i := s.find(index)
+	if i < len(s.ranges) && s.ranges[i][0] <= index {
+		return
+	}
+	// Extend the previous range
+	if i > 0 && s.ranges[i-1][1]+1 == index {
+		s.ranges[i-1][1] = index
+		if i < len(s.ranges) && s.ranges[i][0] == index+1 {
+			s.ranges[i-1][1] = s.ranges[i][1]
+			s.ranges = append(s.ranges[:i], s.ranges[i+1:]...)
+		}
+		return
+	}
+	// Extend the next range
+	if i < len(s.ranges) && s.ranges[i][0] == index+1 {
+		s.ranges[i][0] = index
+		return
+	}
+	s.ranges = append(s.ranges, [2]uint64{})
+	copy(s.ranges[i+1:], s.ranges[i:])
+	s.ranges[i] = [2]uint64{index, index}
+}
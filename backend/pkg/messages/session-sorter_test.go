@@ -0,0 +1,159 @@
+package messages
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testSession returns the session file with batches of messages in random order and a few duplicates
+func testSession(batches int) []byte {
+	rnd := rand.New(rand.NewSource(1))
+	file := make([]byte, 0)
+	index := make([]byte, 8)
+	var msgIndex uint64
+	for b := 0; b < batches; b++ {
+		start := msgIndex
+		if rnd.Intn(10) == 0 {
+			// Batch is sent once again
+			start -= uint64(rnd.Intn(5))
+		}
+		msgs := []Message{&Timestamp{Timestamp: 1700000000000 + uint64(rnd.Intn(batches*10))}}
+		for i := rnd.Intn(20); i > 0; i-- {
+			switch rnd.Intn(3) {
+			case 0:
+				msgs = append(msgs, &SetNodeAttribute{ID: uint64(rnd.Intn(1000)), Name: "class", Value: "item-" + string(rune('a'+i))})
+			case 1:
+				msgs = append(msgs, &MouseMove{X: uint64(rnd.Intn(1000)), Y: uint64(rnd.Intn(1000))})
+			default:
+				msgs = append(msgs, &Timestamp{Timestamp: 1700000000000 + uint64(rnd.Intn(batches*10))})
+			}
+		}
+		for i, msg := range msgs {
+			binary.LittleEndian.PutUint64(index, start+uint64(i))
+			file = append(file, index...)
+			file = append(file, msg.Encode()...)
+		}
+		if start+uint64(len(msgs)) > msgIndex {
+			msgIndex = start + uint64(len(msgs))
+		}
+	}
+	return file
+}
+
+func sortOld(data []byte) []byte {
+	msgs, err := SplitMessages("1", data)
+	if err != nil {
+		return data
+	}
+	return MergeMessages(data, SortMessages(msgs))
+}
+
+func TestSessionSorter(t *testing.T) {
+	data := testSession(2000)
+	expected := sortOld(data)
+	for _, runSize := range []int{100, 1000, 50000, len(data) + 1} {
+		res := &bytes.Buffer{}
+		if err := NewSessionSorter(t.TempDir(), runSize, 1<<20).Sort("1", bytes.NewReader(data), res); err != nil {
+			t.Fatalf("can't sort session with run size %d: %s", runSize, err)
+		}
+		if !bytes.Equal(res.Bytes(), expected) {
+			t.Errorf("wrong result with run size %d: %d bytes instead of %d", runSize, res.Len(), len(expected))
+		}
+	}
+
+	// Broken files aren't sorted
+	res := &bytes.Buffer{}
+	if err := NewSessionSorter(t.TempDir(), 1000, 1<<20).Sort("1", bytes.NewReader(data[:len(data)-3]), res); err == nil || res.Len() > 0 {
+		t.Errorf("expected error on truncated file")
+	}
+
+	// The reader doesn't grow over the max message size
+	index := make([]byte, 8)
+	big := append(index, (&SetCSSData{ID: 1, Data: strings.Repeat("a", 10000)}).Encode()...)
+	res.Reset()
+	if err := NewSessionSorter(t.TempDir(), 1000, 1000).Sort("1", bytes.NewReader(append(data, big...)), res); err == nil || res.Len() > 0 {
+		t.Errorf("expected error on too big message")
+	}
+	if err := NewSessionSorter(t.TempDir(), 1000, 10100).Sort("1", bytes.NewReader(big), res); err != nil {
+		t.Errorf("can't sort message of the max size: %s", err)
+	}
+}
+
+func TestIndexSet(t *testing.T) {
+	set := &indexSet{}
+	for _, index := range []uint64{5, 3, 4, 10, 0, 8, 9, 1} {
+		set.add(index)
+	}
+	for index := uint64(0); index < 12; index++ {
+		expected := index != 2 && index != 6 && index != 7 && index != 11
+		if set.contains(index) != expected {
+			t.Errorf("wrong result for %d", index)
+		}
+	}
+	if len(set.ranges) != 3 {
+		t.Errorf("ranges aren't merged: %v", set.ranges)
+	}
+}
+
+// reportPeakHeap reports the peak of the heap in use while f is running over the heap before the run
+func reportPeakHeap(b *testing.B, f func()) {
+	var stats runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&stats)
+	base, peak := stats.HeapInuse, stats.HeapInuse
+	done, sampled := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(sampled)
+		var stats runtime.MemStats
+		tick := time.NewTicker(time.Millisecond)
+		defer tick.Stop()
+		for {
+			runtime.ReadMemStats(&stats)
+			if stats.HeapInuse > peak {
+				peak = stats.HeapInuse
+			}
+			select {
+			case <-done:
+				return
+			case <-tick.C:
+			}
+		}
+	}()
+	f()
+	close(done)
+	<-sampled
+	b.ReportMetric(float64(peak-base), "peak-heap-B")
+}
+
+func BenchmarkSortMessages(b *testing.B) {
+	data := testSession(100000)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	reportPeakHeap(b, func() {
+		for i := 0; i < b.N; i++ {
+			sortOld(data)
+		}
+	})
+}
+
+func BenchmarkSessionSorter(b *testing.B) {
+	data := testSession(100000)
+	sorter := NewSessionSorter(b.TempDir(), 1<<20, 1<<20)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	reportPeakHeap(b, func() {
+		for i := 0; i < b.N; i++ {
+			res := bytes.NewBuffer(make([]byte, 0, len(data)))
+			if err := sorter.Sort("1", bytes.NewReader(data), res); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
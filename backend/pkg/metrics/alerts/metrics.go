@@ -0,0 +1,50 @@
+package alerts
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var alertsIssues = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "alerts",
+		Name:      "issues_total",
+		Help:      "A counter displaying the number of aggregated issues by type.",
+	},
+	[]string{"issue_type"},
+)
+
+func IncreaseIssues(issueType string) {
+	alertsIssues.WithLabelValues(issueType).Inc()
+}
+
+var alertsSentDigests = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "alerts",
+		Name:      "digests_sent_total",
+		Help:      "A counter displaying the number of sent digests by channel type.",
+	},
+	[]string{"channel_type"},
+)
+
+func IncreaseSentDigests(channelType string) {
+	alertsSentDigests.WithLabelValues(channelType).Inc()
+}
+
+var alertsDigestErrors = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "alerts",
+		Name:      "digest_errors_total",
+		Help:      "A counter displaying the number of digests which weren't delivered by channel type.",
+	},
+	[]string{"channel_type"},
+)
+
+func IncreaseDigestErrors(channelType string) {
+	alertsDigestErrors.WithLabelValues(channelType).Inc()
+}
+
+func List() []prometheus.Collector {
+	return []prometheus.Collector{
+		alertsIssues,
+		alertsSentDigests,
+		alertsDigestErrors,
+	}
+}
@@ -62,11 +62,25 @@ func RecordUploadDuration(durMillis float64, isFailed bool) {
 	assetsUploadDuration.WithLabelValues(failed).Observe(durMillis / 1000.0)
 }
 
+var assetsTLSErrors = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "assets",
+		Name:      "tls_errors_total",
+		Help:      "A counter displaying the total number of failed tls verifications by the reason.",
+	},
+	[]string{"reason"},
+)
+
+func IncreaseTLSErrors(reason string) {
+	assetsTLSErrors.WithLabelValues(reason).Inc()
+}
+
 func List() []prometheus.Collector {
 	return []prometheus.Collector{
 		assetsProcessedSessions,
 		assetsSavedSessions,
 		assetsDownloadDuration,
 		assetsUploadDuration,
+		assetsTLSErrors,
 	}
 }
@@ -127,6 +127,83 @@ func RecordRedisRequestDuration(durMillis float64, method, table string) {
 	cacheRedisRequestDuration.WithLabelValues(method, table).Observe(durMillis / 1000.0)
 }
 
+var dbErrorRegressions = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Namespace: "db",
+		Name:      "error_regressions_total",
+		Help:      "A counter displaying the total number of resolved errors which happened again.",
+	},
+)
+
+func IncreaseErrorRegressions() {
+	dbErrorRegressions.Inc()
+}
+
+var dbBulkInsertErrors = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "db",
+		Name:      "bulk_insert_errors_total",
+		Help:      "A counter displaying the total number of failed bulk inserts.",
+	},
+	[]string{"db", "table"},
+)
+
+func IncreaseBulkInsertErrors(db, table string) {
+	dbBulkInsertErrors.WithLabelValues(db, table).Inc()
+}
+
+var dbBulkRetries = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "db",
+		Name:      "bulk_retries_total",
+		Help:      "A counter displaying the total number of repeated bulk inserts.",
+	},
+	[]string{"db", "table"},
+)
+
+func IncreaseBulkRetries(db, table string) {
+	dbBulkRetries.WithLabelValues(db, table).Inc()
+}
+
+var dbBulkRejectedRows = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "db",
+		Name:      "bulk_rejected_rows_total",
+		Help:      "A counter displaying the total number of rows which were rejected by the database driver.",
+	},
+	[]string{"db", "table"},
+)
+
+func IncreaseBulkRejectedRows(number float64, db, table string) {
+	dbBulkRejectedRows.WithLabelValues(db, table).Add(number)
+}
+
+var dbBulkSpilledRows = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "db",
+		Name:      "bulk_spilled_rows_total",
+		Help:      "A counter displaying the total number of rows which were saved to disk while the database was unavailable.",
+	},
+	[]string{"db", "table"},
+)
+
+func IncreaseBulkSpilledRows(number float64, db, table string) {
+	dbBulkSpilledRows.WithLabelValues(db, table).Add(number)
+}
+
+var dbBulkResentRows = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "db",
+		Name:      "bulk_resent_rows_total",
+		Help:      "A counter displaying the total number of spilled rows which were inserted later.",
+	},
+	[]string{"db", "table"},
+)
+
+func IncreaseBulkResentRows(number float64, db, table string) {
+	dbBulkResentRows.WithLabelValues(db, table).Add(number)
+}
+
 func List() []prometheus.Collector {
 	return []prometheus.Collector{
 		dbBatchElements,
@@ -138,5 +215,11 @@ func List() []prometheus.Collector {
 		dbTotalRequests,
 		cacheRedisRequests,
 		cacheRedisRequestDuration,
+		dbErrorRegressions,
+		dbBulkInsertErrors,
+		dbBulkRetries,
+		dbBulkRejectedRows,
+		dbBulkSpilledRows,
+		dbBulkResentRows,
 	}
 }
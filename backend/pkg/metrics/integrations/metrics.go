@@ -0,0 +1,87 @@
+package integrations
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"openreplay/backend/pkg/metrics/common"
+	"strconv"
+)
+
+var integrationsRequestDuration = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Namespace: "integrations",
+		Name:      "request_duration_seconds",
+		Help:      "A histogram displaying the duration of each request to integration provider in seconds.",
+		Buckets:   common.DefaultDurationBuckets,
+	},
+	[]string{"provider", "response_code"},
+)
+
+func RecordRequestDuration(durMillis float64, provider string, code int) {
+	integrationsRequestDuration.WithLabelValues(provider, strconv.Itoa(code)).Observe(durMillis / 1000.0)
+}
+
+var integrationsRequestErrors = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "integrations",
+		Name:      "request_errors_total",
+		Help:      "A counter displaying the total number of failed requests (network errors and 4xx/5xx responses) to integration provider.",
+	},
+	[]string{"provider"},
+)
+
+func IncreaseRequestErrors(provider string) {
+	integrationsRequestErrors.WithLabelValues(provider).Inc()
+}
+
+var integrationsRequestFailures = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "integrations",
+		Name:      "poll_failures_total",
+		Help:      "A counter displaying the total number of failed integration polls.",
+	},
+	[]string{"provider"},
+)
+
+func IncreaseRequestFailures(provider string) {
+	integrationsRequestFailures.WithLabelValues(provider).Inc()
+}
+
+var integrationsConsecutiveFailures = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Namespace: "integrations",
+		Name:      "consecutive_failures",
+		Help:      "A gauge displaying the number of failed polls in a row for each integration.",
+	},
+	[]string{"provider", "project_id"},
+)
+
+var integrationsLastSuccess = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Namespace: "integrations",
+		Name:      "last_success_timestamp_seconds",
+		Help:      "A gauge displaying the time of the last successful poll for each integration.",
+	},
+	[]string{"provider", "project_id"},
+)
+
+func RecordHealth(provider string, projectID uint32, failures int, lastSuccessMillis int64) {
+	project := strconv.FormatUint(uint64(projectID), 10)
+	integrationsConsecutiveFailures.WithLabelValues(provider, project).Set(float64(failures))
+	integrationsLastSuccess.WithLabelValues(provider, project).Set(float64(lastSuccessMillis) / 1000.0)
+}
+
+func DeleteHealth(provider string, projectID uint32) {
+	project := strconv.FormatUint(uint64(projectID), 10)
+	integrationsConsecutiveFailures.DeleteLabelValues(provider, project)
+	integrationsLastSuccess.DeleteLabelValues(provider, project)
+}
+
+func List() []prometheus.Collector {
+	return []prometheus.Collector{
+		integrationsRequestDuration,
+		integrationsRequestErrors,
+		integrationsRequestFailures,
+		integrationsConsecutiveFailures,
+		integrationsLastSuccess,
+	}
+}
@@ -0,0 +1,63 @@
+package retention
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var retentionDeletedSessions = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "retention",
+		Name:      "deleted_sessions_total",
+		Help:      "A counter displaying the number of deleted sessions by reason (retention or erasure).",
+	},
+	[]string{"reason"},
+)
+
+func IncreaseDeletedSessions(number float64, reason string) {
+	retentionDeletedSessions.WithLabelValues(reason).Add(number)
+}
+
+var retentionDeletedFiles = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Namespace: "retention",
+		Name:      "deleted_files_total",
+		Help:      "A counter displaying the number of deleted session files.",
+	},
+)
+
+func IncreaseDeletedFiles(number float64) {
+	retentionDeletedFiles.Add(number)
+}
+
+var retentionRequests = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "retention",
+		Name:      "erasure_requests_total",
+		Help:      "A counter displaying the number of processed erasure requests by status.",
+	},
+	[]string{"status"},
+)
+
+func IncreaseRequests(status string) {
+	retentionRequests.WithLabelValues(status).Inc()
+}
+
+var retentionErrors = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "retention",
+		Name:      "errors_total",
+		Help:      "A counter displaying the number of failed deletions by storage.",
+	},
+	[]string{"storage"},
+)
+
+func IncreaseErrors(storage string) {
+	retentionErrors.WithLabelValues(storage).Inc()
+}
+
+func List() []prometheus.Collector {
+	return []prometheus.Collector{
+		retentionDeletedSessions,
+		retentionDeletedFiles,
+		retentionRequests,
+		retentionErrors,
+	}
+}
@@ -141,6 +141,31 @@ func RecordSessionCompressionRatio(ratio float64, fileType string) {
 	storageSessionCompressionRatio.WithLabelValues(fileType).Observe(ratio)
 }
 
+var storageTotalChunks = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "storage",
+		Name:      "chunks_total",
+		Help:      "A counter displaying the total number of uploaded chunks of live sessions.",
+	},
+	[]string{"file_type"},
+)
+
+func IncreaseStorageTotalChunks(fileType string) {
+	storageTotalChunks.WithLabelValues(fileType).Inc()
+}
+
+var storageTotalChunkedSessions = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Namespace: "storage",
+		Name:      "chunked_sessions_total",
+		Help:      "A counter displaying the total number of sessions uploaded only as chunks because of the size limits.",
+	},
+)
+
+func IncreaseStorageTotalChunkedSessions() {
+	storageTotalChunkedSessions.Inc()
+}
+
 func List() []prometheus.Collector {
 	return []prometheus.Collector{
 		storageSessionSize,
@@ -151,5 +176,7 @@ func List() []prometheus.Collector {
 		storageSessionCompressDuration,
 		storageSessionUploadDuration,
 		storageSessionCompressionRatio,
+		storageTotalChunks,
+		storageTotalChunkedSessions,
 	}
 }
@@ -0,0 +1,72 @@
+package webhooks
+
+import (
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"openreplay/backend/pkg/metrics/common"
+)
+
+var webhooksDeliveryDuration = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Namespace: "webhooks",
+		Name:      "delivery_duration_seconds",
+		Help:      "A histogram displaying the duration of each webhook delivery attempt in seconds.",
+		Buckets:   common.DefaultDurationBuckets,
+	},
+	[]string{"response_code"},
+)
+
+func RecordDeliveryDuration(durMillis float64, code int) {
+	webhooksDeliveryDuration.WithLabelValues(strconv.Itoa(code)).Observe(durMillis / 1000.0)
+}
+
+var webhooksDeliveries = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "webhooks",
+		Name:      "deliveries_total",
+		Help:      "A counter displaying the number of finished webhook deliveries by result (delivered or failed after all attempts).",
+	},
+	[]string{"event_type", "result"},
+)
+
+func IncreaseDeliveries(eventType, result string) {
+	webhooksDeliveries.WithLabelValues(eventType, result).Inc()
+}
+
+var webhooksRetries = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Namespace: "webhooks",
+		Name:      "retries_total",
+		Help:      "A counter displaying the number of scheduled webhook delivery retries.",
+	},
+)
+
+func IncreaseRetries() {
+	webhooksRetries.Inc()
+}
+
+var webhooksPending = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Namespace: "webhooks",
+		Name:      "deliveries_pending",
+		Help:      "A gauge displaying the number of webhook deliveries waiting for the first attempt or for a retry.",
+	},
+)
+
+func IncreasePending() {
+	webhooksPending.Inc()
+}
+
+func DecreasePending() {
+	webhooksPending.Dec()
+}
+
+func List() []prometheus.Collector {
+	return []prometheus.Collector{
+		webhooksDeliveryDuration,
+		webhooksDeliveries,
+		webhooksRetries,
+		webhooksPending,
+	}
+}
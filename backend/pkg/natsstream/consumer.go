@@ -0,0 +1,333 @@
+package natsstream
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"openreplay/backend/pkg/env"
+	"openreplay/backend/pkg/messages"
+	"openreplay/backend/pkg/queue/types"
+)
+
+const (
+	READ_COUNT    = 10
+	READ_BLOCK    = 200 * time.Millisecond
+	MIN_READ_WAIT = 10 * time.Millisecond
+)
+
+type pendingMsg struct {
+	msg *nats.Msg
+	ts  int64
+}
+
+// partitionSub is a subscription to a durable consumer of the topic partition
+type partitionSub struct {
+	topic     string
+	partition uint64
+	sub       *nats.Subscription
+	pending   []pendingMsg
+	lastTs    int64
+}
+
+type Consumer struct {
+	js              nats.JetStreamContext
+	members         nats.KeyValue
+	topics          []string
+	group           string
+	name            string // unique member name inside the group
+	partitions      uint64
+	ackWait         time.Duration
+	maxAckPending   int
+	messageIterator messages.MessageIterator
+	autoCommit      bool
+	assigned        []uint64 // nil before the first assignment
+	subs            []*partitionSub
+	next            int // round-robin index of subs
+	lastRebalance   time.Time
+	event           chan *types.PartitionsRebalancedEvent
+	done            chan struct{}
+	wg              sync.WaitGroup
+}
+
+func memberName() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "consumer"
+	}
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return hostname + "-" + hex.EncodeToString(suffix)
+}
+
+// durableName is the name of the group consumer of the topic partition
+func durableName(group, topic string, partition uint64) string {
+	return streamName(group) + "_" + streamName(topic) + "_" + strconv.FormatUint(partition, 10)
+}
+
+func NewConsumer(group string, topics []string, messageIterator messages.MessageIterator, autoCommit bool) *Consumer {
+	js := getJetStream()
+	members, err := getMembersBucket(js)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	c := &Consumer{
+		js:              js,
+		members:         members,
+		topics:          topics,
+		group:           group,
+		name:            memberName(),
+		partitions:      getPartitionsNumber(),
+		ackWait:         env.DurationOptional("NATS_ACK_WAIT", 10*time.Minute),
+		maxAckPending:   int(env.Uint64Optional("NATS_MAX_ACK_PENDING", 1000000)),
+		messageIterator: messageIterator,
+		autoCommit:      autoCommit,
+		event:           make(chan *types.PartitionsRebalancedEvent, 32),
+		done:            make(chan struct{}),
+	}
+	for _, topic := range topics {
+		if err := ensureStream(js, topic); err != nil {
+			log.Fatalln(err)
+		}
+		for p := uint64(0); p < c.partitions; p++ {
+			if err := c.ensureConsumer(topic, p); err != nil {
+				log.Fatalln(err)
+			}
+		}
+	}
+	if err := c.heartbeat(); err != nil {
+		log.Fatalln(err)
+	}
+	if c.partitions == 1 {
+		// Single partition is shared by all members, messages are distributed by nats
+		if err := c.assign([]uint64{0}); err != nil {
+			log.Fatalln(err)
+		}
+	} else {
+		c.rebalance()
+	}
+	c.wg.Add(1)
+	go c.heartbeats()
+	log.Printf("nats consumer %s joined group %s", c.name, group)
+	return c
+}
+
+func (c *Consumer) ensureConsumer(topic string, partition uint64) error {
+	stream, durable := streamName(topic), durableName(c.group, topic, partition)
+	config := &nats.ConsumerConfig{
+		Durable:       durable,
+		FilterSubject: subject(topic, partition),
+		DeliverPolicy: nats.DeliverAllPolicy,
+		AckPolicy:     nats.AckExplicitPolicy,
+		AckWait:       c.ackWait,
+		MaxAckPending: c.maxAckPending,
+	}
+	info, err := c.js.ConsumerInfo(stream, durable)
+	if err == nats.ErrConsumerNotFound {
+		_, err = c.js.AddConsumer(stream, config)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if info.Config.AckWait != config.AckWait || info.Config.MaxAckPending != config.MaxAckPending {
+		_, err = c.js.UpdateConsumer(stream, config)
+	}
+	return err
+}
+
+func (c *Consumer) heartbeat() error {
+	_, err := c.members.Put(memberKey(c.group, c.name), []byte(strconv.FormatInt(time.Now().UnixMilli(), 10)))
+	return err
+}
+
+func (c *Consumer) heartbeats() {
+	defer c.wg.Done()
+	tick := time.NewTicker(HEARTBEAT_INTERVAL)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+			if err := c.heartbeat(); err != nil {
+				log.Printf("nats consumer heartbeat error: %s", err)
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *Consumer) assign(partitions []uint64) error {
+	subs := make([]*partitionSub, 0, len(c.topics)*len(partitions))
+	for _, topic := range c.topics {
+		for _, p := range partitions {
+			durable := durableName(c.group, topic, p)
+			// Bound subscription doesn't delete the durable consumer on unsubscribe
+			sub, err := c.js.PullSubscribe(subject(topic, p), durable, nats.Bind(streamName(topic), durable))
+			if err != nil {
+				for _, s := range subs {
+					s.sub.Unsubscribe()
+				}
+				return err
+			}
+			subs = append(subs, &partitionSub{topic: topic, partition: p, sub: sub})
+		}
+	}
+	c.assigned, c.subs, c.next = partitions, subs, 0
+	return nil
+}
+
+// revoke returns not acknowledged messages, so the new owner of the partition gets them immediately
+func (c *Consumer) revoke() {
+	for _, s := range c.subs {
+		for _, m := range s.pending {
+			m.msg.Nak()
+		}
+		s.pending = nil
+		if err := s.sub.Unsubscribe(); err != nil {
+			log.Printf("can't unsubscribe from %s: %s", s.sub.Subject, err)
+		}
+	}
+	c.subs = nil
+}
+
+// rebalance recalculates partitions of the member on group membership changes
+func (c *Consumer) rebalance() {
+	c.lastRebalance = time.Now()
+	members, err := aliveMembers(c.members, c.group)
+	if err != nil {
+		log.Printf("can't get members of group %s: %s", c.group, err)
+		return
+	}
+	assigned := types.AssignPartitions(members, c.name, c.partitions)
+	if c.assigned != nil && types.EqualPartitions(assigned, c.assigned) {
+		return
+	}
+	if c.assigned != nil {
+		c.emit(types.RebalanceTypeRevoke, c.assigned)
+		c.revoke()
+	}
+	if err := c.assign(assigned); err != nil {
+		log.Printf("can't subscribe to partitions %v: %s", assigned, err)
+		c.assigned = nil // retry on the next rebalance
+		return
+	}
+	c.emit(types.RebalanceTypeAssign, assigned)
+}
+
+func (c *Consumer) emit(rebalanceType types.RebalanceType, partitions []uint64) {
+	select {
+	case c.event <- &types.PartitionsRebalancedEvent{Type: rebalanceType, Partitions: partitions}:
+	default:
+		log.Printf("rebalance event (%s: %v) is dropped, channel is full", rebalanceType, partitions)
+	}
+}
+
+func (c *Consumer) Rebalanced() <-chan *types.PartitionsRebalancedEvent {
+	return c.event
+}
+
+func (c *Consumer) ConsumeNext() error {
+	if c.partitions > 1 && time.Since(c.lastRebalance) >= HEARTBEAT_INTERVAL {
+		c.rebalance()
+	}
+	if len(c.subs) == 0 {
+		// More members than partitions
+		time.Sleep(READ_BLOCK)
+		return nil
+	}
+	s := c.subs[c.next%len(c.subs)]
+	c.next++
+	wait := READ_BLOCK / time.Duration(len(c.subs))
+	if wait < MIN_READ_WAIT {
+		wait = MIN_READ_WAIT
+	}
+	msgs, err := s.sub.Fetch(READ_COUNT, nats.MaxWait(wait))
+	if err != nil {
+		if err == nats.ErrTimeout || errors.Is(err, context.DeadlineExceeded) {
+			return nil
+		}
+		return err
+	}
+	for _, msg := range msgs {
+		if err := c.handle(s, msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Consumer) handle(s *partitionSub, msg *nats.Msg) error {
+	sessionID, err := strconv.ParseUint(msg.Header.Get(SESSION_ID_HEADER), 10, 64)
+	if err != nil {
+		// Broken message will never be processed, so it's skipped without stopping the service
+		log.Printf("can't parse session id of message %s, skipping: %s", msg.Subject, err)
+		if err := msg.Ack(); err != nil {
+			log.Printf("can't acknowledge broken message %s: %s", msg.Subject, err)
+		}
+		return nil
+	}
+	meta, err := msg.Metadata()
+	if err != nil {
+		return err
+	}
+	ts := meta.Timestamp.UnixMilli()
+	c.messageIterator.Iterate(msg.Data, messages.NewBatchInfo(sessionID, s.topic, meta.Sequence.Stream, s.partition, ts))
+	if c.autoCommit {
+		return msg.Ack()
+	}
+	s.pending = append(s.pending, pendingMsg{msg: msg, ts: ts})
+	if ts > s.lastTs {
+		s.lastTs = ts
+	}
+	return nil
+}
+
+func (c *Consumer) Commit() error {
+	for _, s := range c.subs {
+		for _, m := range s.pending {
+			if err := m.msg.Ack(); err != nil {
+				return fmt.Errorf("nats: acknowledgment error on commit: %s", err)
+			}
+		}
+		s.pending = nil
+	}
+	return nil
+}
+
+// CommitBack acknowledges messages which are older than the last received message of the partition by gap milliseconds
+func (c *Consumer) CommitBack(gap int64) error {
+	for _, s := range c.subs {
+		maxTs := s.lastTs - gap
+		left := s.pending[:0]
+		for _, m := range s.pending {
+			if m.ts > maxTs {
+				left = append(left, m)
+				continue
+			}
+			if err := m.msg.Ack(); err != nil {
+				return fmt.Errorf("nats: acknowledgment error on commit: %s", err)
+			}
+		}
+		s.pending = left
+	}
+	return nil
+}
+
+func (c *Consumer) Close() {
+	close(c.done)
+	c.wg.Wait()
+	c.revoke()
+	if err := c.members.Delete(memberKey(c.group, c.name)); err != nil {
+		log.Printf("can't leave group %s: %s", c.group, err)
+	}
+}
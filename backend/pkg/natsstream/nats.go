@@ -0,0 +1,149 @@
+package natsstream
+
+import (
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"openreplay/backend/pkg/env"
+)
+
+/*
+	Every topic is a JetStream stream with <topic>.<partition> subjects, the partition is session id % NATS_PARTITIONS.
+	Each partition has a durable consumer per group, so the delivery state doesn't depend on group members.
+	Members share partitions between each other (see types.AssignPartitions), so all messages of a session
+	are processed by the same member.
+	With a single partition (default) all members read from the same durable consumer without assignment.
+*/
+
+const (
+	MEMBERS_BUCKET     = "queue_members"
+	HEARTBEAT_INTERVAL = 2 * time.Second
+	MEMBER_TTL         = 10 * time.Second // member is considered dead without heartbeats
+	SESSION_ID_HEADER  = "Session-ID"
+)
+
+var (
+	connMutex sync.Mutex
+	conn      *nats.Conn
+	js        nats.JetStreamContext
+)
+
+func getJetStream() nats.JetStreamContext {
+	connMutex.Lock()
+	defer connMutex.Unlock()
+	if js != nil {
+		return js
+	}
+	opts := []nats.Option{
+		nats.Name("openreplay"),
+		nats.MaxReconnects(-1),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			if err != nil {
+				log.Printf("nats disconnected: %s", err)
+			}
+		}),
+	}
+	if creds := env.StringOptional("NATS_CREDS"); creds != "" {
+		opts = append(opts, nats.UserCredentials(creds))
+	}
+	if token := env.StringOptional("NATS_TOKEN"); token != "" {
+		opts = append(opts, nats.Token(token))
+	}
+	var err error
+	conn, err = nats.Connect(env.String("NATS_URL"), opts...)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	js, err = conn.JetStream(nats.PublishAsyncErrHandler(func(_ nats.JetStream, msg *nats.Msg, err error) {
+		log.Printf("can't publish message to %s: %s", msg.Subject, err)
+	}))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	return js
+}
+
+// PartitionsNumber is the same for all topics
+func PartitionsNumber() uint64 {
+	return getPartitionsNumber()
+}
+
+func getPartitionsNumber() uint64 {
+	partitions := env.Uint64Optional("NATS_PARTITIONS", 1)
+	if partitions == 0 {
+		partitions = 1
+	}
+	return partitions
+}
+
+// streamName replaces symbols which are not allowed in stream names
+func streamName(topic string) string {
+	return strings.NewReplacer(".", "_", "*", "_", ">", "_", " ", "_", "/", "_", "\\", "_").Replace(topic)
+}
+
+func subject(topic string, partition uint64) string {
+	return topic + "." + strconv.FormatUint(partition, 10)
+}
+
+func ensureStream(js nats.JetStreamContext, topic string) error {
+	name := streamName(topic)
+	if _, err := js.StreamInfo(name); err == nil {
+		return nil
+	} else if err != nats.ErrStreamNotFound {
+		return err
+	}
+	maxBytes := int64(env.Uint64Optional("NATS_MAX_BYTES", 0))
+	if maxBytes == 0 {
+		maxBytes = -1 // unlimited
+	}
+	_, err := js.AddStream(&nats.StreamConfig{
+		Name:     name,
+		Subjects: []string{topic + ".*"},
+		Storage:  nats.FileStorage,
+		MaxAge:   env.DurationOptional("NATS_MAX_AGE", 0),
+		MaxBytes: maxBytes,
+		Replicas: int(env.Uint64Optional("NATS_REPLICAS", 1)),
+	})
+	if err == nats.ErrStreamNameAlreadyInUse {
+		return nil
+	}
+	return err
+}
+
+func getMembersBucket(js nats.JetStreamContext) (nats.KeyValue, error) {
+	kv, err := js.KeyValue(MEMBERS_BUCKET)
+	if err == nats.ErrBucketNotFound {
+		kv, err = js.CreateKeyValue(&nats.KeyValueConfig{
+			Bucket: MEMBERS_BUCKET,
+			TTL:    MEMBER_TTL,
+		})
+	}
+	return kv, err
+}
+
+func memberKey(group, member string) string {
+	return group + "." + member
+}
+
+func aliveMembers(kv nats.KeyValue, group string) ([]string, error) {
+	keys, err := kv.Keys()
+	if err == nats.ErrNoKeysFound {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	prefix := group + "."
+	members := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if strings.HasPrefix(key, prefix) {
+			members = append(members, strings.TrimPrefix(key, prefix))
+		}
+	}
+	return members, nil
+}
@@ -0,0 +1,177 @@
+package natsstream
+
+import (
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+
+	"openreplay/backend/pkg/messages"
+	"openreplay/backend/pkg/queue/types"
+)
+
+const TEST_PARTITIONS = 4
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "natsstream")
+	if err != nil {
+		panic(err)
+	}
+	s, err := server.NewServer(&server.Options{Port: -1, JetStream: true, StoreDir: dir})
+	if err != nil {
+		panic(err)
+	}
+	go s.Start()
+	if !s.ReadyForConnections(5 * time.Second) {
+		panic("nats server isn't ready")
+	}
+	os.Setenv("NATS_URL", s.ClientURL())
+	os.Setenv("NATS_PARTITIONS", strconv.Itoa(TEST_PARTITIONS))
+	code := m.Run()
+	s.Shutdown()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+type testIterator struct {
+	mutex    sync.Mutex
+	sessions map[uint64]int
+}
+
+func newTestIterator() *testIterator {
+	return &testIterator{sessions: make(map[uint64]int)}
+}
+
+func (t *testIterator) Iterate(_ []byte, batchInfo *messages.BatchInfo) {
+	t.mutex.Lock()
+	t.sessions[batchInfo.SessionID()]++
+	t.mutex.Unlock()
+}
+
+func (t *testIterator) count() int {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	n := 0
+	for _, c := range t.sessions {
+		n += c
+	}
+	return n
+}
+
+func produce(t *testing.T, topic string, sessions uint64) {
+	producer := NewProducer()
+	for id := uint64(0); id < sessions; id++ {
+		if err := producer.Produce(topic, id, []byte("batch")); err != nil {
+			t.Fatalf("produce error: %s", err)
+		}
+	}
+	producer.Close(5000)
+}
+
+func consume(t *testing.T, consumer *Consumer, iterator *testIterator, expected int) {
+	deadline := time.Now().Add(10 * time.Second)
+	for iterator.count() < expected && time.Now().Before(deadline) {
+		if err := consumer.ConsumeNext(); err != nil {
+			t.Fatalf("consume error: %s", err)
+		}
+	}
+	if n := iterator.count(); n != expected {
+		t.Fatalf("expected %d messages, got %d", expected, n)
+	}
+}
+
+func TestProduceConsume(t *testing.T) {
+	produce(t, "test-consume", 20)
+	iterator := newTestIterator()
+	consumer := NewConsumer("test-consume", []string{"test-consume"}, iterator, false)
+	consume(t, consumer, iterator, 20)
+	for id := uint64(0); id < 20; id++ {
+		if iterator.sessions[id] != 1 {
+			t.Errorf("session %d is consumed %d times", id, iterator.sessions[id])
+		}
+	}
+	if err := consumer.Commit(); err != nil {
+		t.Fatalf("commit error: %s", err)
+	}
+	consumer.Close()
+
+	// Committed messages aren't delivered again
+	iterator = newTestIterator()
+	consumer = NewConsumer("test-consume", []string{"test-consume"}, iterator, false)
+	defer consumer.Close()
+	for i := 0; i < 2*TEST_PARTITIONS; i++ {
+		consumer.ConsumeNext()
+	}
+	if n := iterator.count(); n != 0 {
+		t.Errorf("expected no messages after commit, got %d", n)
+	}
+}
+
+func TestRedeliveryWithoutCommit(t *testing.T) {
+	produce(t, "test-redelivery", 8)
+	iterator := newTestIterator()
+	consumer := NewConsumer("test-redelivery", []string{"test-redelivery"}, iterator, false)
+	consume(t, consumer, iterator, 8)
+	consumer.Close()
+
+	iterator = newTestIterator()
+	consumer = NewConsumer("test-redelivery", []string{"test-redelivery"}, iterator, false)
+	defer consumer.Close()
+	consume(t, consumer, iterator, 8)
+	if err := consumer.Commit(); err != nil {
+		t.Fatalf("commit error: %s", err)
+	}
+}
+
+func TestRebalance(t *testing.T) {
+	first := NewConsumer("test-rebalance", []string{"test-rebalance"}, newTestIterator(), true)
+	defer first.Close()
+	if event := <-first.Rebalanced(); event.Type != types.RebalanceTypeAssign || len(event.Partitions) != TEST_PARTITIONS {
+		t.Fatalf("expected assignment of all partitions, got %s %v", event.Type, event.Partitions)
+	}
+	second := NewConsumer("test-rebalance", []string{"test-rebalance"}, newTestIterator(), true)
+	defer second.Close()
+	if event := <-second.Rebalanced(); event.Type != types.RebalanceTypeAssign || len(event.Partitions) != TEST_PARTITIONS/2 {
+		t.Fatalf("expected assignment of half of partitions, got %s %v", event.Type, event.Partitions)
+	}
+
+	deadline := time.Now().Add(3 * HEARTBEAT_INTERVAL)
+	for len(first.Rebalanced()) < 2 && time.Now().Before(deadline) {
+		first.ConsumeNext()
+	}
+	if event := <-first.Rebalanced(); event.Type != types.RebalanceTypeRevoke || len(event.Partitions) != TEST_PARTITIONS {
+		t.Fatalf("expected revocation of all partitions, got %s %v", event.Type, event.Partitions)
+	}
+	event := <-first.Rebalanced()
+	if event.Type != types.RebalanceTypeAssign || types.EqualPartitions(event.Partitions, second.assigned) || len(event.Partitions) != TEST_PARTITIONS/2 {
+		t.Fatalf("expected assignment of the other half of partitions, got %s %v", event.Type, event.Partitions)
+	}
+}
+
+func TestBrokenMessageIsSkipped(t *testing.T) {
+	produce(t, "test-broken", 4)
+	if _, err := getJetStream().Publish(subject("test-broken", 0), []byte("broken")); err != nil {
+		t.Fatalf("publish error: %s", err)
+	}
+	produce(t, "test-broken", 4)
+	iterator := newTestIterator()
+	consumer := NewConsumer("test-broken", []string{"test-broken"}, iterator, true)
+	consume(t, consumer, iterator, 8)
+	consumer.Close()
+
+	// Broken message is acknowledged, so it isn't delivered again
+	iterator = newTestIterator()
+	consumer = NewConsumer("test-broken", []string{"test-broken"}, iterator, true)
+	defer consumer.Close()
+	for i := 0; i < 2*TEST_PARTITIONS; i++ {
+		if err := consumer.ConsumeNext(); err != nil {
+			t.Fatalf("consume error: %s", err)
+		}
+	}
+	if n := iterator.count(); n != 0 {
+		t.Errorf("expected no messages after broken one, got %d", n)
+	}
+}
@@ -0,0 +1,72 @@
+package natsstream
+
+import (
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"openreplay/backend/pkg/queue/types"
+)
+
+type Producer struct {
+	js         nats.JetStreamContext
+	partitions uint64
+	mutex      sync.Mutex
+	streams    map[string]bool // topics with existing streams
+}
+
+func NewProducer() *Producer {
+	return &Producer{
+		js:         getJetStream(),
+		partitions: getPartitionsNumber(),
+		streams:    make(map[string]bool),
+	}
+}
+
+func (p *Producer) ensureStream(topic string) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.streams[topic] {
+		return nil
+	}
+	if err := ensureStream(p.js, topic); err != nil {
+		return err
+	}
+	p.streams[topic] = true
+	return nil
+}
+
+func (p *Producer) Produce(topic string, key uint64, value []byte) error {
+	return p.produce(topic, types.SessionPartition(key, p.partitions), key, value)
+}
+
+func (p *Producer) ProduceToPartition(topic string, partition, key uint64, value []byte) error {
+	return p.produce(topic, partition%p.partitions, key, value)
+}
+
+func (p *Producer) produce(topic string, partition, key uint64, value []byte) error {
+	if err := p.ensureStream(topic); err != nil {
+		return err
+	}
+	msg := nats.NewMsg(subject(topic, partition))
+	msg.Header.Set(SESSION_ID_HEADER, strconv.FormatUint(key, 10))
+	msg.Data = value
+	_, err := p.js.PublishMsgAsync(msg)
+	return err
+}
+
+// Flush waits for acknowledgements of all published messages
+func (p *Producer) Flush(timeout int) {
+	select {
+	case <-p.js.PublishAsyncComplete():
+	case <-time.After(time.Duration(timeout) * time.Millisecond):
+		log.Printf("nats producer: %d messages are still not acknowledged", p.js.PublishAsyncPending())
+	}
+}
+
+func (p *Producer) Close(timeout int) {
+	p.Flush(timeout)
+}
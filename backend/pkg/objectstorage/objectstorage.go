@@ -19,5 +19,10 @@ type ObjectStorage interface {
 	Get(key string) (io.ReadCloser, error)
 	Exists(key string) bool
 	GetCreationTime(key string) *time.Time
+	// GetContentEncoding returns the encoding set on upload (gzip, br) or empty string for raw files
+	GetContentEncoding(key string) (string, error)
 	GetPreSignedUploadUrl(key string) (string, error)
+	// List returns keys of all objects which start with the prefix
+	List(prefix string) ([]string, error)
+	Delete(key string) error
 }
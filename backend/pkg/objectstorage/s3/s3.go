@@ -168,6 +168,20 @@ func (s *storageImpl) GetCreationTime(key string) *time.Time {
 	return ans.LastModified
 }
 
+func (s *storageImpl) GetContentEncoding(key string) (string, error) {
+	ans, err := s.svc.HeadObject(&s3.HeadObjectInput{
+		Bucket: s.bucket,
+		Key:    &key,
+	})
+	if err != nil {
+		return "", err
+	}
+	if ans.ContentEncoding == nil {
+		return "", nil
+	}
+	return *ans.ContentEncoding, nil
+}
+
 func (s *storageImpl) GetFrequentlyUsedKeys(projectID uint64) ([]string, error) {
 	prefix := strconv.FormatUint(projectID, 10) + "/"
 	output, err := s.svc.ListObjectsV2(&s3.ListObjectsV2Input{
@@ -208,6 +222,31 @@ func (s *storageImpl) GetPreSignedUploadUrl(key string) (string, error) {
 	return urlStr, nil
 }
 
+func (s *storageImpl) List(prefix string) ([]string, error) {
+	keys := make([]string, 0)
+	err := s.svc.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: s.bucket,
+		Prefix: &prefix,
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, obj := range page.Contents {
+			keys = append(keys, *obj.Key)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (s *storageImpl) Delete(key string) error {
+	_, err := s.svc.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: s.bucket,
+		Key:    &key,
+	})
+	return err
+}
+
 func loadFileTag() string {
 	// Load file tag from env
 	key := "retention"
@@ -60,6 +60,12 @@ func (p *Project) GetMetadataNo(key string) uint {
 	return 0
 }
 
+// GetMetadataKeys returns metadata key names in the order of their numbers (1..10)
+func (p *Project) GetMetadataKeys() []*string {
+	return []*string{p.Metadata1, p.Metadata2, p.Metadata3, p.Metadata4, p.Metadata5,
+		p.Metadata6, p.Metadata7, p.Metadata8, p.Metadata9, p.Metadata10}
+}
+
 func (p *Project) IsMobile() bool {
 	return p.Platform == "ios" || p.Platform == "android"
 }
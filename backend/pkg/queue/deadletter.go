@@ -0,0 +1,89 @@
+package queue
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+
+	"openreplay/backend/pkg/deadletter"
+	"openreplay/backend/pkg/env"
+	"openreplay/backend/pkg/messages"
+	"openreplay/backend/pkg/queue/types"
+)
+
+// Dead-letter producer is shared by all consumers of the service and it's closed with the last one
+var (
+	deadLetterMutex    sync.Mutex
+	deadLetterProducer types.Producer
+	deadLetterUsers    int
+)
+
+func serviceName() string {
+	if name := env.StringOptional("SERVICE_NAME"); name != "" {
+		return name
+	}
+	return filepath.Base(os.Args[0])
+}
+
+// setDeadLetter makes the iterator send failed batches to the TOPIC_DEAD_LETTER topic (if it is defined)
+// and returns topics with the replay topic of the group and the dead-letter producer (nil if it isn't used)
+func setDeadLetter(group string, topics []string, iterator messages.MessageIterator, messageSizeLimit int) ([]string, types.Producer) {
+	topic := env.StringOptional("TOPIC_DEAD_LETTER")
+	reporter, ok := iterator.(messages.DeadLetterReporter)
+	if topic == "" || !ok {
+		return topics, nil
+	}
+	deadLetterMutex.Lock()
+	if deadLetterUsers == 0 {
+		deadLetterProducer = NewProducer(messageSizeLimit, true)
+	}
+	deadLetterUsers++
+	producer := deadLetterProducer
+	deadLetterMutex.Unlock()
+	reporter.SetDeadLetterQueue(deadletter.New(producer, topic, serviceName(), group))
+	return append(append([]string(nil), topics...), deadletter.ReplayTopic(topic, group)), producer
+}
+
+func releaseDeadLetter() {
+	deadLetterMutex.Lock()
+	defer deadLetterMutex.Unlock()
+	deadLetterUsers--
+	if deadLetterUsers == 0 {
+		deadLetterProducer.Close(int(env.Uint64Optional("PRODUCER_CLOSE_TIMEOUT", 15000)))
+		deadLetterProducer = nil
+	}
+}
+
+// deadLetterConsumer sends buffered failed batches to the dead-letter topic before offsets are committed,
+// otherwise batches are lost if the service stops after the commit
+type deadLetterConsumer struct {
+	types.Consumer
+	producer types.Producer
+	timeout  int
+}
+
+func withDeadLetter(consumer types.Consumer, producer types.Producer) types.Consumer {
+	if producer == nil {
+		return consumer
+	}
+	return &deadLetterConsumer{
+		Consumer: consumer,
+		producer: producer,
+		timeout:  int(env.Uint64Optional("PRODUCER_TIMEOUT", 2000)),
+	}
+}
+
+func (c *deadLetterConsumer) Commit() error {
+	c.producer.Flush(c.timeout)
+	return c.Consumer.Commit()
+}
+
+func (c *deadLetterConsumer) CommitBack(gap int64) error {
+	c.producer.Flush(c.timeout)
+	return c.Consumer.CommitBack(gap)
+}
+
+func (c *deadLetterConsumer) Close() {
+	c.Consumer.Close()
+	releaseDeadLetter()
+}
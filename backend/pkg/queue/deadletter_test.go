@@ -0,0 +1,51 @@
+package queue
+
+import (
+	"testing"
+
+	"openreplay/backend/pkg/queue/types"
+)
+
+type testProducer struct {
+	types.Producer
+	calls *[]string
+}
+
+func (p *testProducer) Flush(timeout int) { *p.calls = append(*p.calls, "flush") }
+func (p *testProducer) Close(timeout int) { *p.calls = append(*p.calls, "close") }
+
+type testConsumer struct {
+	types.Consumer
+	calls *[]string
+}
+
+func (c *testConsumer) Commit() error { *c.calls = append(*c.calls, "commit"); return nil }
+func (c *testConsumer) CommitBack(gap int64) error {
+	*c.calls = append(*c.calls, "commit back")
+	return nil
+}
+func (c *testConsumer) Close() { *c.calls = append(*c.calls, "consumer close") }
+
+func TestDeadLetterConsumer(t *testing.T) {
+	calls := make([]string, 0)
+	deadLetterProducer, deadLetterUsers = &testProducer{calls: &calls}, 2
+	first := withDeadLetter(&testConsumer{calls: &calls}, deadLetterProducer)
+	second := withDeadLetter(&testConsumer{calls: &calls}, deadLetterProducer)
+	first.Commit()
+	first.CommitBack(10)
+	first.Close()
+	second.Close()
+
+	expected := []string{"flush", "commit", "flush", "commit back", "consumer close", "consumer close", "close"}
+	if len(calls) != len(expected) {
+		t.Fatalf("wrong calls: %v", calls)
+	}
+	for i := range expected {
+		if calls[i] != expected[i] {
+			t.Fatalf("wrong calls: %v", calls)
+		}
+	}
+	if withDeadLetter(&testConsumer{calls: &calls}, nil).(*testConsumer) == nil {
+		t.Errorf("consumer without dead letter queue has to be unchanged")
+	}
+}
@@ -1,15 +1,47 @@
 package queue
 
 import (
+	"openreplay/backend/pkg/env"
+	"openreplay/backend/pkg/memqueue"
 	"openreplay/backend/pkg/messages"
+	"openreplay/backend/pkg/natsstream"
 	"openreplay/backend/pkg/queue/types"
 	"openreplay/backend/pkg/redisstream"
 )
 
-func NewConsumer(group string, topics []string, iterator messages.MessageIterator, _ bool, _ int) types.Consumer {
-	return redisstream.NewConsumer(group, topics, iterator)
+// QUEUE_TYPE selects the message broker of the OSS version: redis (default), nats (JetStream) or memory (in-process)
+func queueType() string {
+	return env.StringOptional("QUEUE_TYPE")
+}
+
+func NewConsumer(group string, topics []string, iterator messages.MessageIterator, autoCommit bool, messageSizeLimit int) types.Consumer {
+	topics, deadLetter := setDeadLetter(group, topics, iterator, messageSizeLimit)
+	switch queueType() {
+	case "nats":
+		return withDeadLetter(natsstream.NewConsumer(group, topics, iterator, autoCommit), deadLetter)
+	case "memory":
+		return withDeadLetter(memqueue.NewConsumer(group, topics, iterator, autoCommit), deadLetter)
+	}
+	return withDeadLetter(redisstream.NewConsumer(group, topics, iterator, autoCommit), deadLetter)
 }
 
 func NewProducer(_ int, _ bool) types.Producer {
+	switch queueType() {
+	case "nats":
+		return natsstream.NewProducer()
+	case "memory":
+		return memqueue.NewProducer()
+	}
 	return redisstream.NewProducer()
 }
+
+// PartitionsNumber returns the number of topic partitions which is used to map sessions (see types.SessionPartition)
+func PartitionsNumber(_ string) (uint64, error) {
+	switch queueType() {
+	case "nats":
+		return natsstream.PartitionsNumber(), nil
+	case "memory":
+		return memqueue.Default().Partitions(), nil
+	}
+	return redisstream.PartitionsNumber(), nil
+}
@@ -0,0 +1,41 @@
+package types
+
+import "sort"
+
+// AssignPartitions splits partitions between group members for queues without server-side rebalancing.
+// Every member gets the same result for the same list of members.
+func AssignPartitions(members []string, member string, partitions uint64) []uint64 {
+	sorted := append([]string{}, members...)
+	sort.Strings(sorted)
+	index := sort.SearchStrings(sorted, member)
+	if index == len(sorted) || sorted[index] != member {
+		return []uint64{}
+	}
+	res := make([]uint64, 0, partitions/uint64(len(sorted))+1)
+	for p := uint64(0); p < partitions; p++ {
+		if p%uint64(len(sorted)) == uint64(index) {
+			res = append(res, p)
+		}
+	}
+	return res
+}
+
+func EqualPartitions(a, b []uint64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// SessionPartition is the only session to partition mapping, it must be the same for producers, ender and failover
+func SessionPartition(sessionID, partitions uint64) uint64 {
+	if partitions == 0 {
+		return 0
+	}
+	return sessionID % partitions
+}
@@ -0,0 +1,43 @@
+package types
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAssignPartitions(t *testing.T) {
+	members := []string{"db-2", "db-0", "db-1"}
+	owners := make(map[uint64]string)
+	for _, member := range members {
+		for _, p := range AssignPartitions(members, member, 8) {
+			if owner, ok := owners[p]; ok {
+				t.Errorf("Partition %d is assigned to %s and %s", p, owner, member)
+			}
+			owners[p] = member
+		}
+	}
+	if len(owners) != 8 {
+		t.Errorf("Expected all 8 partitions to be assigned, got %d", len(owners))
+	}
+	if parts := AssignPartitions(members, "db-1", 8); !reflect.DeepEqual(parts, []uint64{1, 4, 7}) {
+		t.Errorf("Wrong partitions of db-1: %v", parts)
+	}
+	if parts := AssignPartitions(members, "db-3", 8); len(parts) != 0 {
+		t.Errorf("Expected no partitions for unknown member, got %v", parts)
+	}
+	if parts := AssignPartitions([]string{"a", "b", "c"}, "c", 2); len(parts) != 0 {
+		t.Errorf("Expected no partitions for extra member, got %v", parts)
+	}
+}
+
+func TestSessionPartition(t *testing.T) {
+	// Kafka producer used key & 7 for 8 partitions, the mapping must stay the same
+	for _, sessionID := range []uint64{0, 7, 8, 1234567890123, ^uint64(0)} {
+		if p := SessionPartition(sessionID, 8); p != sessionID&7 {
+			t.Errorf("Wrong partition of session %d: %d", sessionID, p)
+		}
+	}
+	if p := SessionPartition(42, 0); p != 0 {
+		t.Errorf("Expected partition 0 without partitions, got %d", p)
+	}
+}
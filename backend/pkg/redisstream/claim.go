@@ -0,0 +1,75 @@
+package redisstream
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	_redis "github.com/go-redis/redis"
+)
+
+// autoClaim takes pending messages which are idle for minIdle from other consumers (XAUTOCLAIM, Redis 6.2+).
+// Returns the cursor for the next call, "0-0" means the whole pending list has been scanned.
+func autoClaim(redis *_redis.Client, stream, group, consumer string, minIdle time.Duration, start string, count int64) ([]_redis.XMessage, string, error) {
+	res, err := redis.Do("XAUTOCLAIM", stream, group, consumer, minIdle.Milliseconds(), start, "COUNT", count).Result()
+	if err != nil {
+		return nil, "", err
+	}
+	return parseAutoClaim(res)
+}
+
+func parseAutoClaim(res interface{}) ([]_redis.XMessage, string, error) {
+	reply, ok := res.([]interface{})
+	if !ok || len(reply) < 2 {
+		return nil, "", fmt.Errorf("unexpected XAUTOCLAIM reply: %v", res)
+	}
+	cursor, ok := reply[0].(string)
+	if !ok {
+		return nil, "", fmt.Errorf("unexpected XAUTOCLAIM cursor: %v", reply[0])
+	}
+	entries, ok := reply[1].([]interface{})
+	if !ok {
+		return nil, "", fmt.Errorf("unexpected XAUTOCLAIM entries: %v", reply[1])
+	}
+	msgs := make([]_redis.XMessage, 0, len(entries))
+	for _, e := range entries {
+		// Redis 6.2 returns nil for entries which were deleted from the stream (trimmed)
+		entry, ok := e.([]interface{})
+		if !ok || len(entry) != 2 {
+			continue
+		}
+		id, ok := entry[0].(string)
+		if !ok {
+			return nil, "", fmt.Errorf("unexpected XAUTOCLAIM entry id: %v", entry[0])
+		}
+		fields, _ := entry[1].([]interface{})
+		values := make(map[string]interface{}, len(fields)/2)
+		for i := 0; i+1 < len(fields); i += 2 {
+			key, ok := fields[i].(string)
+			if !ok {
+				return nil, "", fmt.Errorf("unexpected XAUTOCLAIM field name: %v", fields[i])
+			}
+			values[key] = fields[i+1]
+		}
+		msgs = append(msgs, _redis.XMessage{ID: id, Values: values})
+	}
+	return msgs, cursor, nil
+}
+
+// messageTs returns the timestamp and the sequence number of the stream message id
+func messageTs(id string) (uint64, uint64, error) {
+	tsPart, idxPart, ok := strings.Cut(id, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("wrong message id: %s", id)
+	}
+	ts, err := strconv.ParseUint(tsPart, 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	idx, err := strconv.ParseUint(idxPart, 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	return ts, idx, nil
+}
@@ -1,82 +1,225 @@
 package redisstream
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"log"
 	"net"
-	"openreplay/backend/pkg/messages"
-	"openreplay/backend/pkg/queue/types"
-	"sort"
+	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	_redis "github.com/go-redis/redis"
 	"github.com/pkg/errors"
+
+	"openreplay/backend/pkg/env"
+	"openreplay/backend/pkg/messages"
+	"openreplay/backend/pkg/queue/types"
+)
+
+const (
+	READ_COUNT     = 10
+	READ_BLOCK     = 200 * time.Millisecond
+	CLAIM_COUNT    = 100
+	CLAIM_INTERVAL = 30 * time.Second
 )
 
 type idsInfo struct {
-	id []string
-	ts []int64
+	id  []string
+	ts  []int64
+	set map[string]struct{}
 }
+
+func newIdsInfo() *idsInfo {
+	return &idsInfo{set: make(map[string]struct{})}
+}
+
+func (i *idsInfo) add(id string, ts int64) {
+	i.id = append(i.id, id)
+	i.ts = append(i.ts, ts)
+	i.set[id] = struct{}{}
+}
+
 type streamPendingIDsMap map[string]*idsInfo
 
+type streamInfo struct {
+	topic     string
+	partition uint64
+}
+
 type Consumer struct {
 	redis           *_redis.Client
-	streams         []string
+	topics          []string
 	group           string
+	name            string // unique consumer name inside the group
+	partitions      uint64
 	messageIterator messages.MessageIterator
-	idsPending      streamPendingIDsMap
-	lastTs          int64
 	autoCommit      bool
+	claimIdle       time.Duration
+	claimEnabled    bool
+	lastClaim       time.Time
+	lastRebalance   time.Time
+	assigned        []uint64 // partitions of the consumer, nil before the first assignment
+	streams         []string // streams of assigned partitions
+	streamsInfo     map[string]streamInfo
+	idsPending      streamPendingIDsMap
+	lastTs          map[string]int64
 	event           chan *types.PartitionsRebalancedEvent
+	done            chan struct{}
+	wg              sync.WaitGroup
 }
 
-func NewConsumer(group string, streams []string, messageIterator messages.MessageIterator) *Consumer {
+func consumerName() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "consumer"
+	}
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return hostname + "-" + hex.EncodeToString(suffix)
+}
+
+func NewConsumer(group string, topics []string, messageIterator messages.MessageIterator, autoCommit bool) *Consumer {
 	redis := getRedisClient()
-	for _, stream := range streams {
-		err := redis.XGroupCreateMkStream(stream, group, "0").Err()
-		if err != nil && err.Error() != "BUSYGROUP Consumer Group name already exists" {
-			log.Fatalln(err)
+	partitions := getPartitionsNumber()
+	streamsInfo := make(map[string]streamInfo)
+	for _, topic := range topics {
+		for p := uint64(0); p < partitions; p++ {
+			stream := streamName(topic, p, partitions)
+			err := redis.XGroupCreateMkStream(stream, group, "0").Err()
+			if err != nil && err.Error() != "BUSYGROUP Consumer Group name already exists" {
+				log.Fatalln(err)
+			}
+			streamsInfo[stream] = streamInfo{topic: topic, partition: p}
 		}
 	}
 
-	idsPending := make(streamPendingIDsMap)
+	c := &Consumer{
+		redis:           redis,
+		topics:          topics,
+		group:           group,
+		name:            consumerName(),
+		partitions:      partitions,
+		messageIterator: messageIterator,
+		autoCommit:      autoCommit,
+		claimIdle:       env.DurationOptional("REDIS_STREAMS_CLAIM_IDLE", time.Minute),
+		claimEnabled:    true,
+		streamsInfo:     streamsInfo,
+		idsPending:      make(streamPendingIDsMap),
+		lastTs:          make(map[string]int64),
+		event:           make(chan *types.PartitionsRebalancedEvent, 32),
+		done:            make(chan struct{}),
+	}
+	if err := heartbeat(redis, group, c.name); err != nil {
+		log.Fatalln(err)
+	}
+	if partitions == 1 {
+		// Single stream per topic, messages are distributed between consumers by redis
+		c.setAssigned([]uint64{0})
+	} else {
+		c.rebalance()
+	}
+	c.wg.Add(1)
+	go c.heartbeats()
+	log.Printf("redis consumer %s joined group %s", c.name, group)
+	return c
+}
 
-	streamsCount := len(streams)
-	for i := 0; i < streamsCount; i++ {
-		// ">" is for never-delivered messages.
-		// Otherwise - never acknoledged only
-		// TODO: understand why in case of "0" it eats 100% cpu
-		streams = append(streams, ">")
+func (c *Consumer) heartbeats() {
+	defer c.wg.Done()
+	tick := time.NewTicker(HEARTBEAT_INTERVAL)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+			if err := heartbeat(c.redis, c.group, c.name); err != nil {
+				log.Printf("redis consumer heartbeat error: %s", err)
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
 
-		idsPending[streams[i]] = new(idsInfo)
+func (c *Consumer) setAssigned(partitions []uint64) {
+	c.assigned = partitions
+	c.streams = make([]string, 0, len(c.topics)*len(partitions))
+	for _, topic := range c.topics {
+		for _, p := range partitions {
+			c.streams = append(c.streams, streamName(topic, p, c.partitions))
+		}
 	}
+}
 
-	return &Consumer{
-		redis:           redis,
-		messageIterator: messageIterator,
-		streams:         streams,
-		group:           group,
-		autoCommit:      true,
-		idsPending:      idsPending,
-		event:           make(chan *types.PartitionsRebalancedEvent, 4),
+// rebalance recalculates partitions of the consumer on group membership changes
+func (c *Consumer) rebalance() {
+	c.lastRebalance = time.Now()
+	members, err := aliveMembers(c.redis, c.group)
+	if err != nil {
+		log.Printf("can't get members of group %s: %s", c.group, err)
+		return
+	}
+	assigned := types.AssignPartitions(members, c.name, c.partitions)
+	if c.assigned != nil && types.EqualPartitions(assigned, c.assigned) {
+		return
+	}
+	if c.assigned != nil {
+		c.emit(types.RebalanceTypeRevoke, c.assigned)
+		// Not acknowledged messages of revoked partitions will be claimed by their new consumer
+		for _, p := range c.assigned {
+			for _, topic := range c.topics {
+				stream := streamName(topic, p, c.partitions)
+				delete(c.idsPending, stream)
+				delete(c.lastTs, stream)
+			}
+		}
 	}
+	c.setAssigned(assigned)
+	c.emit(types.RebalanceTypeAssign, assigned)
+	// New partitions may have pending messages of the previous consumer
+	c.lastClaim = time.Time{}
 }
 
-const READ_COUNT = 10
+func (c *Consumer) emit(rebalanceType types.RebalanceType, partitions []uint64) {
+	select {
+	case c.event <- &types.PartitionsRebalancedEvent{Type: rebalanceType, Partitions: partitions}:
+	default:
+		log.Printf("rebalance event (%s: %v) is dropped, channel is full", rebalanceType, partitions)
+	}
+}
 
 func (c *Consumer) Rebalanced() <-chan *types.PartitionsRebalancedEvent {
 	return c.event
 }
 
 func (c *Consumer) ConsumeNext() error {
-	// MBTODO: read in go routine, send messages to channel
+	if c.partitions > 1 && time.Since(c.lastRebalance) >= HEARTBEAT_INTERVAL {
+		c.rebalance()
+	}
+	if c.claimEnabled && time.Since(c.lastClaim) >= CLAIM_INTERVAL {
+		c.lastClaim = time.Now()
+		if err := c.claim(); err != nil {
+			return err
+		}
+	}
+	if len(c.streams) == 0 {
+		// More consumers than partitions
+		time.Sleep(READ_BLOCK)
+		return nil
+	}
+	streams := make([]string, 0, len(c.streams)*2)
+	streams = append(streams, c.streams...)
+	for range c.streams {
+		streams = append(streams, ">") // never delivered messages, pending ones are claimed separately
+	}
 	res, err := c.redis.XReadGroup(&_redis.XReadGroupArgs{
 		Group:    c.group,
-		Consumer: c.group,
-		Streams:  c.streams,
+		Consumer: c.name,
+		Streams:  streams,
 		Count:    int64(READ_COUNT),
-		Block:    200 * time.Millisecond,
+		Block:    READ_BLOCK,
 	}).Result()
 	if err != nil {
 		if err, ok := err.(net.Error); ok && err.Timeout() {
@@ -89,42 +232,143 @@ func (c *Consumer) ConsumeNext() error {
 	}
 	for _, r := range res {
 		for _, m := range r.Messages {
-			sessionIDString, ok := m.Values["sessionID"].(string)
-			if !ok {
-				return errors.Errorf("Can not cast value for messageID %v", m.ID)
+			if err := c.handle(r.Stream, m); err != nil {
+				return err
 			}
-			sessionID, err := strconv.ParseUint(sessionIDString, 10, 64)
+		}
+	}
+	return nil
+}
+
+func (c *Consumer) handle(stream string, m _redis.XMessage) error {
+	sessionIDString, ok := m.Values["sessionID"].(string)
+	if !ok {
+		return errors.Errorf("Can not cast value for messageID %v", m.ID)
+	}
+	sessionID, err := strconv.ParseUint(sessionIDString, 10, 64)
+	if err != nil {
+		return errors.Wrapf(err, "Can not parse sessionID '%v' for messageID %v", sessionID, m.ID)
+	}
+	valueString, ok := m.Values["value"].(string)
+	if !ok {
+		return errors.Errorf("Can not cast value for messageID %v", m.ID)
+	}
+	ts, idx, err := messageTs(m.ID)
+	if err != nil {
+		return err
+	}
+	if idx > 0x1FFF {
+		return errors.New("Too many messages per ms in redis")
+	}
+	bID := ts<<13 | (idx & 0x1FFF) // Max: 4096 messages/ms for 69 years
+	info := c.streamsInfo[stream]
+	c.messageIterator.Iterate([]byte(valueString), messages.NewBatchInfo(sessionID, info.topic, bID, info.partition, int64(ts)))
+	if c.autoCommit {
+		if err = c.redis.XAck(stream, c.group, m.ID).Err(); err != nil {
+			return errors.Wrapf(err, "Acknoledgment error for messageID %v", m.ID)
+		}
+		return nil
+	}
+	pending, ok := c.idsPending[stream]
+	if !ok {
+		pending = newIdsInfo()
+		c.idsPending[stream] = pending
+	}
+	pending.add(m.ID, int64(ts))
+	if int64(ts) > c.lastTs[stream] {
+		c.lastTs[stream] = int64(ts)
+	}
+	return nil
+}
+
+// claim recovers messages which were delivered to crashed consumers (or to the previous consumer
+// of the partition) and haven't been acknowledged during REDIS_STREAMS_CLAIM_IDLE
+func (c *Consumer) claim() error {
+	alive, err := aliveMembers(c.redis, c.group)
+	if err != nil {
+		log.Printf("can't get members of group %s: %s", c.group, err)
+		return nil
+	}
+	isAlive := make(map[string]bool, len(alive))
+	for _, member := range alive {
+		isAlive[member] = true
+	}
+	for _, stream := range c.streams {
+		hasDead, err := c.cleanConsumers(stream, isAlive)
+		if err != nil {
+			log.Printf("can't check consumers of stream %s: %s", stream, err)
+			continue
+		}
+		// In the single stream mode alive consumers keep their messages (e.g. until CommitBack)
+		if c.partitions == 1 && !hasDead {
+			continue
+		}
+		for cursor := "0-0"; ; {
+			msgs, next, err := autoClaim(c.redis, stream, c.group, c.name, c.claimIdle, cursor, CLAIM_COUNT)
 			if err != nil {
-				return errors.Wrapf(err, "Can not parse sessionID '%v' for messageID %v", sessionID, m.ID)
+				if strings.Contains(err.Error(), "unknown command") {
+					log.Printf("XAUTOCLAIM isn't supported (redis 6.2+ is required), pending messages won't be recovered")
+					c.claimEnabled = false
+					return nil
+				}
+				return errors.Wrapf(err, "can't claim pending messages of stream %s", stream)
 			}
-			valueString, ok := m.Values["value"].(string)
-			if !ok {
-				return errors.Errorf("Can not cast value for messageID %v", m.ID)
+			for _, m := range msgs {
+				if pending, ok := c.idsPending[stream]; ok {
+					if _, ok := pending.set[m.ID]; ok {
+						continue // already processed by this consumer, waiting for commit
+					}
+				}
+				if err := c.handle(stream, m); err != nil {
+					return err
+				}
 			}
-			// assumming that ID has a correct format
-			idParts := strings.Split(m.ID, "-")
-			ts, _ := strconv.ParseUint(idParts[0], 10, 64)
-			idx, _ := strconv.ParseUint(idParts[1], 10, 64)
-			if idx > 0x1FFF {
-				return errors.New("Too many messages per ms in redis")
+			if len(msgs) > 0 {
+				log.Printf("claimed %d pending messages of stream %s", len(msgs), stream)
 			}
-			bID := ts<<13 | (idx & 0x1FFF) // Max: 4096 messages/ms for 69 years
-			c.messageIterator.Iterate([]byte(valueString), messages.NewBatchInfo(sessionID, r.Stream, bID, 0, int64(ts)))
-			if c.autoCommit {
-				if err = c.redis.XAck(r.Stream, c.group, m.ID).Err(); err != nil {
-					return errors.Wrapf(err, "Acknoledgment error for messageID %v", m.ID)
-				}
-			} else {
-				c.lastTs = int64(ts)
-				c.idsPending[r.Stream].id = append(c.idsPending[r.Stream].id, m.ID)
-				c.idsPending[r.Stream].ts = append(c.idsPending[r.Stream].ts, int64(ts))
+			if next == "0-0" || next == "" {
+				break
 			}
-
+			cursor = next
 		}
 	}
 	return nil
 }
 
+// cleanConsumers deletes dead consumers without pending messages and reports if there are dead consumers with them
+func (c *Consumer) cleanConsumers(stream string, isAlive map[string]bool) (bool, error) {
+	res, err := c.redis.Do("XINFO", "CONSUMERS", stream, c.group).Result()
+	if err != nil {
+		return false, err
+	}
+	consumers, _ := res.([]interface{})
+	hasDead := false
+	for _, consumer := range consumers {
+		fields, _ := consumer.([]interface{})
+		var name string
+		var pending int64
+		for i := 0; i+1 < len(fields); i += 2 {
+			switch key, _ := fields[i].(string); key {
+			case "name":
+				name, _ = fields[i+1].(string)
+			case "pending":
+				pending, _ = fields[i+1].(int64)
+			}
+		}
+		if name == "" || name == c.name || isAlive[name] {
+			continue
+		}
+		if pending > 0 {
+			hasDead = true
+			continue
+		}
+		if err := c.redis.XGroupDelConsumer(stream, c.group, name).Err(); err != nil {
+			log.Printf("can't delete consumer %s from stream %s: %s", name, stream, err)
+		}
+	}
+	return hasDead, nil
+}
+
 func (c *Consumer) Commit() error {
 	for stream, idsInfo := range c.idsPending {
 		if len(idsInfo.id) == 0 {
@@ -133,34 +377,52 @@ func (c *Consumer) Commit() error {
 		if err := c.redis.XAck(stream, c.group, idsInfo.id...).Err(); err != nil {
 			return errors.Wrapf(err, "Redisstreams: Acknoledgment error on commit %v", err)
 		}
-		c.idsPending[stream].id = nil
-		c.idsPending[stream].ts = nil
+		c.idsPending[stream] = newIdsInfo()
 	}
 	return nil
 }
 
+// CommitBack acknowledges messages which are older than the last received message of the stream by gap milliseconds
 func (c *Consumer) CommitBack(gap int64) error {
-	if c.lastTs == 0 {
-		return nil
-	}
-	maxTs := c.lastTs - gap
-
 	for stream, idsInfo := range c.idsPending {
 		if len(idsInfo.id) == 0 {
 			continue
 		}
-		maxI := sort.Search(len(idsInfo.ts), func(i int) bool {
-			return idsInfo.ts[i] > maxTs
-		})
-		if err := c.redis.XAck(stream, c.group, idsInfo.id[:maxI]...).Err(); err != nil {
+		maxTs := c.lastTs[stream] - gap
+		ack, left := make([]string, 0), newIdsInfo()
+		// Claimed messages can be older than the new ones, so ids aren't sorted by ts
+		for i, id := range idsInfo.id {
+			if idsInfo.ts[i] <= maxTs {
+				ack = append(ack, id)
+			} else {
+				left.add(id, idsInfo.ts[i])
+			}
+		}
+		if len(ack) == 0 {
+			continue
+		}
+		if err := c.redis.XAck(stream, c.group, ack...).Err(); err != nil {
 			return errors.Wrapf(err, "Redisstreams: Acknoledgment error on commit %v", err)
 		}
-		c.idsPending[stream].id = idsInfo.id[maxI:]
-		c.idsPending[stream].ts = idsInfo.ts[maxI:]
+		c.idsPending[stream] = left
 	}
 	return nil
 }
 
 func (c *Consumer) Close() {
-	// noop
+	close(c.done)
+	c.wg.Wait()
+	if err := leave(c.redis, c.group, c.name); err != nil {
+		log.Printf("can't leave group %s: %s", c.group, err)
+	}
+	// Consumer with not acknowledged messages is kept, so they can be claimed by others
+	for stream := range c.streamsInfo {
+		pending, err := c.redis.XPending(stream, c.group).Result()
+		if err != nil || pending.Consumers[c.name] > 0 {
+			continue
+		}
+		if err := c.redis.XGroupDelConsumer(stream, c.group, c.name).Err(); err != nil {
+			log.Printf("can't delete consumer %s from stream %s: %s", c.name, stream, err)
+		}
+	}
 }
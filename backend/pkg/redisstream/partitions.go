@@ -0,0 +1,76 @@
+package redisstream
+
+import (
+	"strconv"
+	"time"
+
+	_redis "github.com/go-redis/redis"
+
+	"openreplay/backend/pkg/env"
+	"openreplay/backend/pkg/queue/types"
+)
+
+/*
+	Redis streams have no partitions, so every topic is split into REDIS_STREAMS_PARTITIONS streams
+	(<topic>:<partition>) by session id, like kafka does it with message keys. Consumers of the same group
+	share partitions between each other (see types.AssignPartitions), so all messages of a session are processed
+	by the same consumer.
+	The default value 1 keeps the single stream per topic without partition assignment.
+*/
+
+const (
+	HEARTBEAT_INTERVAL = 2 * time.Second
+	MEMBER_TTL         = 10 * time.Second // consumer is considered dead without heartbeats
+)
+
+var partitionsNumber uint64
+
+// PartitionsNumber is the same for all topics
+func PartitionsNumber() uint64 {
+	return getPartitionsNumber()
+}
+
+func getPartitionsNumber() uint64 {
+	if partitionsNumber == 0 {
+		partitionsNumber = env.Uint64Optional("REDIS_STREAMS_PARTITIONS", 1)
+		if partitionsNumber == 0 {
+			partitionsNumber = 1
+		}
+	}
+	return partitionsNumber
+}
+
+func partitionOf(key uint64, partitions uint64) uint64 {
+	return types.SessionPartition(key, partitions)
+}
+
+func streamName(topic string, partition uint64, partitions uint64) string {
+	if partitions <= 1 {
+		return topic
+	}
+	return topic + ":" + strconv.FormatUint(partition, 10)
+}
+
+func membersKey(group string) string {
+	return "redisstream:" + group + ":members"
+}
+
+// heartbeat marks the consumer as alive and removes dead members
+func heartbeat(redis *_redis.Client, group, consumer string) error {
+	now := time.Now()
+	key := membersKey(group)
+	if err := redis.ZAdd(key, _redis.Z{Score: float64(now.UnixMilli()), Member: consumer}).Err(); err != nil {
+		return err
+	}
+	deadline := strconv.FormatInt(now.Add(-MEMBER_TTL).UnixMilli(), 10)
+	return redis.ZRemRangeByScore(key, "-inf", "("+deadline).Err()
+}
+
+func aliveMembers(redis *_redis.Client, group string) ([]string, error) {
+	deadline := strconv.FormatInt(time.Now().Add(-MEMBER_TTL).UnixMilli(), 10)
+	return redis.ZRangeByScore(membersKey(group), _redis.ZRangeBy{Min: deadline, Max: "+inf"}).Result()
+}
+
+func leave(redis *_redis.Client, group, consumer string) error {
+	return redis.ZRem(membersKey(group), consumer).Err()
+}
@@ -0,0 +1,42 @@
+package redisstream
+
+import (
+	"testing"
+)
+
+func TestStreamName(t *testing.T) {
+	if name := streamName("raw", 0, 1); name != "raw" {
+		t.Errorf("Expected topic name for single partition, got %s", name)
+	}
+	if name := streamName("raw", partitionOf(7355608, 16), 16); name != "raw:8" {
+		t.Errorf("Wrong stream name: %s", name)
+	}
+}
+
+func TestParseAutoClaim(t *testing.T) {
+	reply := []interface{}{
+		"1700000000000-5",
+		[]interface{}{
+			[]interface{}{"1700000000000-1", []interface{}{"sessionID", "42", "value", "data"}},
+			nil, // deleted entry (redis 6.2)
+		},
+		[]interface{}{"1700000000000-2"}, // deleted ids (redis 7)
+	}
+	msgs, cursor, err := parseAutoClaim(reply)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if cursor != "1700000000000-5" {
+		t.Errorf("Wrong cursor: %s", cursor)
+	}
+	if len(msgs) != 1 || msgs[0].ID != "1700000000000-1" || msgs[0].Values["sessionID"] != "42" || msgs[0].Values["value"] != "data" {
+		t.Errorf("Wrong messages: %+v", msgs)
+	}
+	ts, idx, err := messageTs(msgs[0].ID)
+	if err != nil || ts != 1700000000000 || idx != 1 {
+		t.Errorf("Wrong message id parsing: %d, %d, %v", ts, idx, err)
+	}
+	if _, _, err := parseAutoClaim("OK"); err == nil {
+		t.Errorf("Expected error for wrong reply")
+	}
+}
@@ -1,26 +1,48 @@
 package redisstream
 
 import (
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
 	"github.com/go-redis/redis"
 
 	"openreplay/backend/pkg/env"
 )
 
+const TRIM_INTERVAL = time.Minute
+
 type Producer struct {
 	redis        *redis.Client
 	maxLenApprox int64
+	maxAge       time.Duration // streams aren't trimmed by age if 0
+	partitions   uint64
+	mutex        sync.Mutex
+	lastTrim     map[string]time.Time
 }
 
 func NewProducer() *Producer {
 	return &Producer{
 		redis:        getRedisClient(),
 		maxLenApprox: int64(env.Uint64("REDIS_STREAMS_MAX_LEN")),
+		maxAge:       env.DurationOptional("REDIS_STREAMS_MAX_AGE", 0),
+		partitions:   getPartitionsNumber(),
+		lastTrim:     make(map[string]time.Time),
 	}
 }
 
 func (p *Producer) Produce(topic string, key uint64, value []byte) error {
+	return p.produce(streamName(topic, partitionOf(key, p.partitions), p.partitions), key, value)
+}
+
+func (p *Producer) ProduceToPartition(topic string, partition, key uint64, value []byte) error {
+	return p.produce(streamName(topic, partition%p.partitions, p.partitions), key, value)
+}
+
+func (p *Producer) produce(stream string, key uint64, value []byte) error {
 	args := &redis.XAddArgs{
-		Stream: topic,
+		Stream: stream,
 		Values: map[string]interface{}{
 			"sessionID": key,
 			"value":     value,
@@ -32,12 +54,27 @@ func (p *Producer) Produce(topic string, key uint64, value []byte) error {
 	if err != nil {
 		return err
 	}
+	p.trim(stream)
 	return nil
 }
 
-func (p *Producer) ProduceToPartition(topic string, partition, key uint64, value []byte) error {
-	// not implemented
-	return nil
+// trim removes messages older than REDIS_STREAMS_MAX_AGE (XTRIM MINID, Redis 6.2+)
+func (p *Producer) trim(stream string) {
+	if p.maxAge <= 0 {
+		return
+	}
+	now := time.Now()
+	p.mutex.Lock()
+	if now.Sub(p.lastTrim[stream]) < TRIM_INTERVAL {
+		p.mutex.Unlock()
+		return
+	}
+	p.lastTrim[stream] = now
+	p.mutex.Unlock()
+	minID := strconv.FormatInt(now.Add(-p.maxAge).UnixMilli(), 10)
+	if err := p.redis.Do("XTRIM", stream, "MINID", "~", minID).Err(); err != nil {
+		log.Printf("can't trim stream %s: %s", stream, err)
+	}
 }
 
 func (p *Producer) Close(_ int) {
@@ -70,6 +70,32 @@ func (s *Session) SetMetadata(keyNo uint, value string) {
 	}
 }
 
+func (s *Session) GetMetadata(keyNo uint) *string {
+	switch keyNo {
+	case 1:
+		return s.Metadata1
+	case 2:
+		return s.Metadata2
+	case 3:
+		return s.Metadata3
+	case 4:
+		return s.Metadata4
+	case 5:
+		return s.Metadata5
+	case 6:
+		return s.Metadata6
+	case 7:
+		return s.Metadata7
+	case 8:
+		return s.Metadata8
+	case 9:
+		return s.Metadata9
+	case 10:
+		return s.Metadata10
+	}
+	return nil
+}
+
 type UnStartedSession struct {
 	ProjectKey         string
 	TrackerVersion     string
@@ -0,0 +1,301 @@
+package sourcemaps
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andybalholm/brotli"
+
+	"openreplay/backend/pkg/cache"
+	"openreplay/backend/pkg/objectstorage"
+	"openreplay/backend/pkg/url/assets"
+)
+
+const (
+	maxFileSize  = 50 * 1024 * 1024
+	loadWorkers  = 4
+	maxLoadQueue = 1000
+	// Failed loads are repeated after this interval, js files and maps can be cached by assets service later
+	loadRetryInterval = time.Minute
+)
+
+var errNoSourceMap = errors.New("source map not found")
+
+// cachedMap is a loaded source map or a failed load, only loaded maps are kept while they are used
+type cachedMap struct {
+	smap     *SourceMap
+	failedAt time.Time
+}
+
+type loadTask struct {
+	key       string
+	projectID uint32
+	fileURL   string
+}
+
+// Resolver rewrites minified stack frames to the original ones. Source maps are looked up in the
+// sourcemaps bucket (uploaded by users) and in the js cache bucket (files cached by assets service).
+// Maps are loaded by background workers, the caller waits for a map not longer than waitTimeout,
+// so frames of a not yet loaded map are saved as is.
+type Resolver struct {
+	sourcemaps    objectstorage.ObjectStorage // Optional
+	jsCache       objectstorage.ObjectStorage
+	maps          cache.Cache
+	waitTimeout   time.Duration
+	retryInterval time.Duration
+	tasks         chan *loadTask
+	loadingMu     sync.Mutex
+	loading       map[string]chan struct{}
+}
+
+func NewResolver(sourcemaps, jsCache objectstorage.ObjectStorage, waitTimeout time.Duration) (*Resolver, error) {
+	if jsCache == nil {
+		return nil, errors.New("js cache storage is empty")
+	}
+	r := &Resolver{
+		sourcemaps:    sourcemaps,
+		jsCache:       jsCache,
+		maps:          cache.New(time.Minute*5, time.Minute*30),
+		waitTimeout:   waitTimeout,
+		retryInterval: loadRetryInterval,
+		tasks:         make(chan *loadTask, maxLoadQueue),
+		loading:       make(map[string]chan struct{}),
+	}
+	for i := 0; i < loadWorkers; i++ {
+		go r.loadWorker()
+	}
+	return r, nil
+}
+
+// ResolvePayload rewrites JSException payload (list of error-stack-parser frames)
+func (r *Resolver) ResolvePayload(projectID uint32, payload string) (string, error) {
+	var frames []map[string]interface{}
+	if err := json.Unmarshal([]byte(payload), &frames); err != nil {
+		return payload, err
+	}
+	resolved := false
+	for _, frame := range frames {
+		if r.resolveFrame(projectID, frame) {
+			resolved = true
+		}
+	}
+	if !resolved {
+		return payload, nil
+	}
+	newPayload, err := json.Marshal(frames)
+	if err != nil {
+		return payload, err
+	}
+	return string(newPayload), nil
+}
+
+func (r *Resolver) resolveFrame(projectID uint32, frame map[string]interface{}) bool {
+	fileName, _ := frame["fileName"].(string)
+	line, okLine := frame["lineNumber"].(float64)
+	column, okColumn := frame["columnNumber"].(float64)
+	if !strings.HasPrefix(fileName, "http") || !okLine || !okColumn {
+		return false
+	}
+	smap := r.getSourceMap(projectID, fileName)
+	if smap == nil {
+		return false
+	}
+	// Browsers use 1-based lines and columns, source maps use 0-based ones
+	pos, ok := smap.Find(int(line)-1, int(column)-1)
+	if !ok {
+		return false
+	}
+	frame["generated"] = map[string]interface{}{
+		"fileName":     fileName,
+		"lineNumber":   line,
+		"columnNumber": column,
+	}
+	frame["fileName"] = pos.Source
+	frame["lineNumber"] = pos.Line + 1
+	frame["columnNumber"] = pos.Column + 1
+	if name, _ := frame["functionName"].(string); name == "" && pos.Name != "" {
+		frame["functionName"] = pos.Name
+	}
+	return true
+}
+
+func (r *Resolver) getSourceMap(projectID uint32, fileURL string) *SourceMap {
+	key := strconv.FormatUint(uint64(projectID), 10) + fileURL
+	if cached, ok := r.maps.Get(key); ok {
+		entry := cached.(*cachedMap)
+		if entry.smap != nil {
+			r.maps.GetAndRefresh(key)
+			return entry.smap
+		}
+		// Failed load isn't refreshed by reads, so it's repeated even for frequent errors
+		if time.Since(entry.failedAt) < r.retryInterval {
+			return nil
+		}
+	}
+	loaded := r.load(&loadTask{key: key, projectID: projectID, fileURL: fileURL})
+	if loaded == nil {
+		return nil
+	}
+	select {
+	case <-loaded:
+		if cached, ok := r.maps.Get(key); ok {
+			return cached.(*cachedMap).smap
+		}
+	case <-time.After(r.waitTimeout):
+	}
+	return nil
+}
+
+// load adds the task to the loading queue, the returned channel is closed when the map is in the cache
+func (r *Resolver) load(task *loadTask) chan struct{} {
+	r.loadingMu.Lock()
+	defer r.loadingMu.Unlock()
+	if loaded, ok := r.loading[task.key]; ok {
+		return loaded
+	}
+	select {
+	case r.tasks <- task:
+	default:
+		// Queue is full, the map will be requested again with the next error
+		return nil
+	}
+	loaded := make(chan struct{})
+	r.loading[task.key] = loaded
+	return loaded
+}
+
+func (r *Resolver) loadWorker() {
+	for task := range r.tasks {
+		entry := &cachedMap{}
+		smap, err := r.loadSourceMap(task.projectID, task.fileURL)
+		if err != nil || smap == nil {
+			entry.failedAt = time.Now()
+		} else {
+			entry.smap = smap
+		}
+		r.maps.Set(task.key, entry)
+		r.loadingMu.Lock()
+		close(r.loading[task.key])
+		delete(r.loading, task.key)
+		r.loadingMu.Unlock()
+	}
+}
+
+func (r *Resolver) loadSourceMap(projectID uint32, fileURL string) (*SourceMap, error) {
+	// Source map uploaded by user has the highest priority
+	if r.sourcemaps != nil {
+		if data, err := readObject(r.sourcemaps, uploadedFileKey(projectID, fileURL)); err == nil {
+			return Parse(data)
+		}
+	}
+	// Source map referenced from the cached js file
+	jsFile, err := readObject(r.jsCache, assets.GetCachePathForJS(fileURL))
+	if err != nil {
+		return nil, err
+	}
+	mapURL := ExtractURL(jsFile)
+	switch {
+	case mapURL == "":
+		return nil, errNoSourceMap
+	case strings.HasPrefix(mapURL, "data:"):
+		data, err := decodeDataURL(mapURL)
+		if err != nil {
+			return nil, err
+		}
+		return Parse(data)
+	}
+	data, err := readObject(r.jsCache, assets.GetCachePathForJS(assets.ResolveURL(fileURL, mapURL)))
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// uploadedFileKey returns the same key as api uses for uploaded source maps
+func uploadedFileKey(projectID uint32, fileURL string) string {
+	if u, err := url.Parse(fileURL); err == nil {
+		fileURL = u.Scheme + "://" + u.Host + u.Path
+	}
+	hash := md5.Sum([]byte(fileURL))
+	return strconv.FormatUint(uint64(projectID), 10) + "/" + hex.EncodeToString(hash[:])
+}
+
+// ExtractURL returns the last "//# sourceMappingURL=" value from the js file (or from the file's tail)
+func ExtractURL(file []byte) string {
+	for _, prefix := range []string{"//# sourceMappingURL=", "//@ sourceMappingURL="} {
+		idx := bytes.LastIndex(file, []byte(prefix))
+		if idx == -1 {
+			continue
+		}
+		value := file[idx+len(prefix):]
+		if end := bytes.IndexAny(value, " \t\r\n"); end != -1 {
+			value = value[:end]
+		}
+		return string(value)
+	}
+	return ""
+}
+
+func decodeDataURL(dataURL string) ([]byte, error) {
+	idx := strings.IndexByte(dataURL, ',')
+	if idx == -1 {
+		return nil, errors.New("wrong data url")
+	}
+	header, data := dataURL[:idx], dataURL[idx+1:]
+	if strings.HasSuffix(header, ";base64") {
+		return base64.StdEncoding.DecodeString(data)
+	}
+	decoded, err := url.PathUnescape(data)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(decoded), nil
+}
+
+// readObject reads file from object storage, cached files can be compressed by assets service
+func readObject(store objectstorage.ObjectStorage, key string) ([]byte, error) {
+	encoding, err := store.GetContentEncoding(key)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := store.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	var decoded io.Reader
+	switch encoding {
+	case "":
+		decoded = reader
+	case "gzip":
+		gzReader, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, err
+		}
+		decoded = gzReader
+	case "br":
+		decoded = brotli.NewReader(reader)
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %s: %s", encoding, key)
+	}
+	data, err := io.ReadAll(io.LimitReader(decoded, maxFileSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxFileSize {
+		return nil, fmt.Errorf("file is too big: %s", key)
+	}
+	return data, nil
+}
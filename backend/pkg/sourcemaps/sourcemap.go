@@ -0,0 +1,247 @@
+package sourcemaps
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Source map v3 spec: https://sourcemaps.info/spec.html
+
+type rawSection struct {
+	Offset struct {
+		Line   int `json:"line"`
+		Column int `json:"column"`
+	} `json:"offset"`
+	Map json.RawMessage `json:"map"`
+}
+
+type rawSourceMap struct {
+	Version    int          `json:"version"`
+	File       string       `json:"file"`
+	SourceRoot string       `json:"sourceRoot"`
+	Sources    []string     `json:"sources"`
+	Names      []string     `json:"names"`
+	Mappings   string       `json:"mappings"`
+	Sections   []rawSection `json:"sections"`
+}
+
+type segment struct {
+	genColumn int
+	source    int
+	line      int
+	column    int
+	name      int
+}
+
+type section struct {
+	line   int
+	column int
+	smap   *SourceMap
+}
+
+// Position is an original position in the source file (line and column are 0-based)
+type Position struct {
+	Source string
+	Line   int
+	Column int
+	Name   string
+}
+
+type SourceMap struct {
+	sources  []string
+	names    []string
+	lines    [][]segment
+	sections []section // for index maps only
+}
+
+// Parse parses regular and index source maps
+func Parse(data []byte) (*SourceMap, error) {
+	// Source map can start with XSSI protection prefix
+	if strings.HasPrefix(string(data), ")]}") {
+		if idx := strings.IndexByte(string(data), '\n'); idx != -1 {
+			data = data[idx+1:]
+		}
+	}
+	raw := &rawSourceMap{}
+	if err := json.Unmarshal(data, raw); err != nil {
+		return nil, fmt.Errorf("can't parse source map: %s", err)
+	}
+	if raw.Version != 3 {
+		return nil, fmt.Errorf("unsupported source map version: %d", raw.Version)
+	}
+	if len(raw.Sections) > 0 {
+		return parseIndexMap(raw)
+	}
+	smap := &SourceMap{
+		sources: make([]string, len(raw.Sources)),
+		names:   raw.Names,
+	}
+	for i, source := range raw.Sources {
+		smap.sources[i] = joinSourceRoot(raw.SourceRoot, source)
+	}
+	if err := smap.parseMappings(raw.Mappings); err != nil {
+		return nil, err
+	}
+	return smap, nil
+}
+
+func parseIndexMap(raw *rawSourceMap) (*SourceMap, error) {
+	smap := &SourceMap{}
+	for _, rs := range raw.Sections {
+		if len(rs.Map) == 0 {
+			return nil, errors.New("section without map (url sections are not supported)")
+		}
+		sectionMap, err := Parse(rs.Map)
+		if err != nil {
+			return nil, err
+		}
+		smap.sections = append(smap.sections, section{
+			line:   rs.Offset.Line,
+			column: rs.Offset.Column,
+			smap:   sectionMap,
+		})
+	}
+	return smap, nil
+}
+
+func joinSourceRoot(root, source string) string {
+	if root == "" || strings.Contains(source, "://") || strings.HasPrefix(source, "/") {
+		return source
+	}
+	if !strings.HasSuffix(root, "/") {
+		root += "/"
+	}
+	return root + source
+}
+
+func (s *SourceMap) parseMappings(mappings string) error {
+	var (
+		source, line, column, name int
+		values                     = make([]int, 0, 5)
+	)
+	for _, rawLine := range strings.Split(mappings, ";") {
+		var (
+			segments  []segment
+			genColumn int
+		)
+		for _, rawSegment := range strings.Split(rawLine, ",") {
+			if rawSegment == "" {
+				continue
+			}
+			var err error
+			values, err = decodeVLQ(rawSegment, values[:0])
+			if err != nil {
+				return err
+			}
+			genColumn += values[0]
+			seg := segment{genColumn: genColumn, source: -1, name: -1}
+			switch len(values) {
+			case 1:
+			case 4, 5:
+				source += values[1]
+				line += values[2]
+				column += values[3]
+				seg.source, seg.line, seg.column = source, line, column
+				if len(values) == 5 {
+					name += values[4]
+					seg.name = name
+				}
+			default:
+				return fmt.Errorf("wrong segment length: %d", len(values))
+			}
+			segments = append(segments, seg)
+		}
+		sort.SliceStable(segments, func(i, j int) bool {
+			return segments[i].genColumn < segments[j].genColumn
+		})
+		s.lines = append(s.lines, segments)
+	}
+	return nil
+}
+
+// Find returns original position for the position in generated file (line and column are 0-based)
+func (s *SourceMap) Find(line, column int) (*Position, bool) {
+	if len(s.sections) > 0 {
+		return s.findInSections(line, column)
+	}
+	if line < 0 || line >= len(s.lines) {
+		return nil, false
+	}
+	segments := s.lines[line]
+	idx := sort.Search(len(segments), func(i int) bool {
+		return segments[i].genColumn > column
+	}) - 1
+	if idx < 0 {
+		return nil, false
+	}
+	seg := segments[idx]
+	if seg.source < 0 || seg.source >= len(s.sources) {
+		return nil, false
+	}
+	pos := &Position{
+		Source: s.sources[seg.source],
+		Line:   seg.line,
+		Column: seg.column,
+	}
+	if seg.name >= 0 && seg.name < len(s.names) {
+		pos.Name = s.names[seg.name]
+	}
+	return pos, true
+}
+
+func (s *SourceMap) findInSections(line, column int) (*Position, bool) {
+	// Sections are sorted by offset, so we are looking for the last section before the position
+	idx := sort.Search(len(s.sections), func(i int) bool {
+		sec := s.sections[i]
+		return sec.line > line || (sec.line == line && sec.column > column)
+	}) - 1
+	if idx < 0 {
+		return nil, false
+	}
+	sec := s.sections[idx]
+	if line == sec.line {
+		column -= sec.column
+	}
+	return sec.smap.Find(line-sec.line, column)
+}
+
+const base64Chars = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+
+var base64Values = func() [256]int {
+	var values [256]int
+	for i := range values {
+		values[i] = -1
+	}
+	for i := 0; i < len(base64Chars); i++ {
+		values[base64Chars[i]] = i
+	}
+	return values
+}()
+
+func decodeVLQ(str string, values []int) ([]int, error) {
+	var value, shift int
+	for i := 0; i < len(str); i++ {
+		digit := base64Values[str[i]]
+		if digit < 0 {
+			return nil, fmt.Errorf("wrong base64 char in mappings: %q", str[i])
+		}
+		value += (digit & 31) << shift
+		if digit&32 != 0 {
+			shift += 5
+			continue
+		}
+		if value&1 != 0 {
+			values = append(values, -(value >> 1))
+		} else {
+			values = append(values, value>>1)
+		}
+		value, shift = 0, 0
+	}
+	if shift != 0 {
+		return nil, errors.New("unfinished vlq value in mappings")
+	}
+	return values, nil
+}
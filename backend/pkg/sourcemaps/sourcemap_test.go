@@ -0,0 +1,225 @@
+package sourcemaps
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"openreplay/backend/pkg/objectstorage"
+	"openreplay/backend/pkg/url/assets"
+)
+
+// Generated: function add(a,b){return a+b}function fail(){throw new Error("boom")}
+const testSourceMap = `{
+	"version": 3,
+	"file": "app.min.js",
+	"sourceRoot": "webpack:///",
+	"sources": ["src/app.js"],
+	"names": ["add", "fail"],
+	"mappings": "AAAA,SAASA,oBAGT,SAASC,OACP"
+}`
+
+func TestDecodeVLQ(t *testing.T) {
+	values, err := decodeVLQ("oBAGT", nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	expected := []int{20, 0, 3, -9}
+	if !reflect.DeepEqual(values, expected) {
+		t.Errorf("Expected %v, but got %v", expected, values)
+	}
+	if _, err := decodeVLQ("g", nil); err == nil {
+		t.Errorf("Expected error for unfinished value")
+	}
+}
+
+func TestFind(t *testing.T) {
+	smap, err := Parse([]byte(testSourceMap))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	cases := []struct {
+		column   int
+		expected Position
+	}{
+		{column: 0, expected: Position{Source: "webpack:///src/app.js", Line: 0, Column: 0}},
+		{column: 12, expected: Position{Source: "webpack:///src/app.js", Line: 0, Column: 9, Name: "add"}},
+		{column: 40, expected: Position{Source: "webpack:///src/app.js", Line: 3, Column: 9, Name: "fail"}},
+		{column: 50, expected: Position{Source: "webpack:///src/app.js", Line: 4, Column: 2}},
+	}
+	for _, c := range cases {
+		pos, ok := smap.Find(0, c.column)
+		if !ok {
+			t.Errorf("Position not found for column %d", c.column)
+			continue
+		}
+		if *pos != c.expected {
+			t.Errorf("Expected %+v for column %d, but got %+v", c.expected, c.column, *pos)
+		}
+	}
+	if _, ok := smap.Find(1, 0); ok {
+		t.Errorf("Expected no position for the line without mappings")
+	}
+}
+
+func TestFindInIndexMap(t *testing.T) {
+	indexMap := `{"version": 3, "sections": [{"offset": {"line": 2, "column": 10}, "map": ` + testSourceMap + `}]}`
+	smap, err := Parse([]byte(indexMap))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	pos, ok := smap.Find(2, 50)
+	if !ok || pos.Line != 3 || pos.Name != "fail" {
+		t.Errorf("Unexpected position: %+v", pos)
+	}
+	if _, ok := smap.Find(1, 50); ok {
+		t.Errorf("Expected no position before the first section")
+	}
+}
+
+type testStorage struct {
+	files     map[string][]byte
+	encodings map[string]string
+}
+
+func (s *testStorage) Upload(reader io.Reader, key string, contentType string, compression objectstorage.CompressionType) error {
+	return nil
+}
+
+func (s *testStorage) Get(key string) (io.ReadCloser, error) {
+	if data, ok := s.files[key]; ok {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+	return nil, errors.New("not found")
+}
+
+func (s *testStorage) Exists(key string) bool {
+	_, ok := s.files[key]
+	return ok
+}
+
+func (s *testStorage) GetCreationTime(key string) *time.Time {
+	return nil
+}
+
+func (s *testStorage) GetContentEncoding(key string) (string, error) {
+	if _, ok := s.files[key]; !ok {
+		return "", errors.New("not found")
+	}
+	return s.encodings[key], nil
+}
+
+func (s *testStorage) GetPreSignedUploadUrl(key string) (string, error) {
+	return "", nil
+}
+
+func (s *testStorage) List(prefix string) ([]string, error) {
+	return nil, nil
+}
+
+func (s *testStorage) Delete(key string) error {
+	return nil
+}
+
+func TestResolvePayload(t *testing.T) {
+	jsURL := "https://example.com/static/app.min.js?v=1"
+	mapKey := assets.GetCachePathForJS("https://example.com/static/app.min.js.map")
+	jsCache := &testStorage{
+		files: map[string][]byte{
+			assets.GetCachePathForJS(jsURL): []byte("function add(a,b){return a+b}\n//# sourceMappingURL=app.min.js.map\n"),
+			mapKey:                          gzipped([]byte(testSourceMap)),
+		},
+		encodings: map[string]string{mapKey: "gzip"},
+	}
+	resolver, err := NewResolver(nil, jsCache, time.Second)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	payload := `[{"fileName":"` + jsURL + `","lineNumber":1,"columnNumber":41,"functionName":""},` +
+		`{"fileName":"https://example.com/other.js","lineNumber":1,"columnNumber":1}]`
+	resolved, err := resolver.ResolvePayload(1, payload)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	var frames []map[string]interface{}
+	if err := json.Unmarshal([]byte(resolved), &frames); err != nil {
+		t.Fatalf("Can't parse resolved payload: %s", err)
+	}
+	if frames[0]["fileName"] != "webpack:///src/app.js" || frames[0]["lineNumber"] != 4.0 ||
+		frames[0]["columnNumber"] != 10.0 || frames[0]["functionName"] != "fail" {
+		t.Errorf("Unexpected resolved frame: %v", frames[0])
+	}
+	if generated, ok := frames[0]["generated"].(map[string]interface{}); !ok || generated["fileName"] != jsURL {
+		t.Errorf("Expected generated position in frame: %v", frames[0])
+	}
+	if frames[1]["fileName"] != "https://example.com/other.js" {
+		t.Errorf("Frame without source map has to be unchanged: %v", frames[1])
+	}
+}
+
+func gzipped(data []byte) []byte {
+	buf := &bytes.Buffer{}
+	w := gzip.NewWriter(buf)
+	w.Write(data)
+	w.Close()
+	return buf.Bytes()
+}
+
+func TestResolveWithoutWaiting(t *testing.T) {
+	jsURL := "https://example.com/static/app.min.js"
+	jsCache := &testStorage{files: map[string][]byte{
+		assets.GetCachePathForJS(jsURL):                                       []byte("//# sourceMappingURL=app.min.js.map"),
+		assets.GetCachePathForJS("https://example.com/static/app.min.js.map"): []byte(testSourceMap),
+	}}
+	resolver, err := NewResolver(nil, jsCache, 0)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	payload := `[{"fileName":"` + jsURL + `","lineNumber":1,"columnNumber":41}]`
+
+	// The map is loaded in background, so the first payload can be saved as is
+	deadline := time.Now().Add(time.Second)
+	for {
+		resolved, err := resolver.ResolvePayload(1, payload)
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		if resolved != payload {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Source map isn't loaded in background")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRetryFailedLoad(t *testing.T) {
+	jsURL := "https://example.com/static/app.min.js"
+	jsCache := &testStorage{files: map[string][]byte{}}
+	resolver, err := NewResolver(nil, jsCache, time.Second)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	resolver.retryInterval = 50 * time.Millisecond
+	payload := `[{"fileName":"` + jsURL + `","lineNumber":1,"columnNumber":41}]`
+	if resolved, _ := resolver.ResolvePayload(1, payload); resolved != payload {
+		t.Fatalf("Payload without cached js file has to be unchanged: %s", resolved)
+	}
+
+	// Files are cached by assets service later, the failed load is repeated after the retry interval
+	jsCache.files[assets.GetCachePathForJS(jsURL)] = []byte("//# sourceMappingURL=app.min.js.map")
+	jsCache.files[assets.GetCachePathForJS(jsURL+".map")] = []byte(testSourceMap)
+	if resolved, _ := resolver.ResolvePayload(1, payload); resolved != payload {
+		t.Errorf("Failed load has to be kept till the retry interval")
+	}
+	time.Sleep(resolver.retryInterval)
+	if resolved, _ := resolver.ResolvePayload(1, payload); resolved == payload {
+		t.Errorf("Failed load isn't repeated after the retry interval")
+	}
+}
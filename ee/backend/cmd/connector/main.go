@@ -12,36 +12,65 @@ import (
 	saver "openreplay/backend/pkg/connector"
 	"openreplay/backend/pkg/memory"
 	"openreplay/backend/pkg/messages"
+	"openreplay/backend/pkg/metrics"
+	connectorMetrics "openreplay/backend/pkg/metrics/connector"
 	"openreplay/backend/pkg/objectstorage/store"
 	"openreplay/backend/pkg/queue"
 	"openreplay/backend/pkg/terminator"
 )
 
 func main() {
+	m := metrics.New()
+	m.Register(connectorMetrics.List())
+
 	log.SetFlags(log.LstdFlags | log.LUTC | log.Llongfile)
 
 	cfg := config.New()
 
-	objStore, err := store.NewStore(&cfg.ObjectsConfig)
+	// Columns of connector tables
+	tables, err := saver.NewTables(cfg)
 	if err != nil {
-		log.Fatalf("can't init object storage: %s", err)
+		log.Fatalf("can't select columns: %s", err)
 	}
 
 	var db saver.Database
 	switch cfg.ConnectorType {
 	case "redshift":
-		if db, err = saver.NewRedshift(cfg, objStore); err != nil {
+		objStore, err := store.NewStore(&cfg.ObjectsConfig)
+		if err != nil {
+			log.Fatalf("can't init object storage: %s", err)
+		}
+		if db, err = saver.NewRedshift(cfg, tables, objStore); err != nil {
 			log.Fatalf("can't init redshift connection: %s", err)
 		}
 	case "clickhouse":
-		if db, err = saver.NewClickHouse(cfg); err != nil {
+		if db, err = saver.NewClickHouse(cfg, tables); err != nil {
 			log.Fatalf("can't init clickhouse connection: %s", err)
 		}
+	case "postgres":
+		if db, err = saver.NewPostgres(cfg, tables); err != nil {
+			log.Fatalf("can't init postgres connection: %s", err)
+		}
+	case "parquet", "jsonl":
+		if db, err = saver.NewFiles(cfg, tables, newUploader(cfg), cfg.ConnectorType); err != nil {
+			log.Fatalf("can't init %s files: %s", cfg.ConnectorType, err)
+		}
+	case "snowflake":
+		if db, err = saver.NewSnowflake(cfg, tables, newUploader(cfg)); err != nil {
+			log.Fatalf("can't init snowflake connection: %s", err)
+		}
 	default:
 		log.Fatalf("unknown connector type: %s", cfg.ConnectorType)
 	}
 	defer db.Close()
 
+	// Add new columns to the tables
+	if migrator, ok := db.(saver.Migrator); ok {
+		if err := saver.Migrate(migrator, tables); err != nil {
+			log.Fatalf("can't migrate connector tables: %s", err)
+		}
+	}
+
 	// Init postgres connection
 	pgConn, err := pool.New(cfg.Postgres.String())
 	if err != nil {
@@ -60,16 +89,26 @@ func main() {
 	projManager := projects.New(pgConn, redisClient)
 	sessManager := sessions.New(pgConn, projManager, redisClient)
 
-	// Saves messages to Redshift
-	dataSaver := saver.New(cfg, db, sessManager, projManager)
+	// Keeps unsaved sessions between restarts
+	var buffer saver.Buffer
+	bufferType := cfg.BufferType
+	if bufferType == "redis" && redisClient == nil {
+		log.Printf("redis isn't available, sessions are buffered in %s", cfg.BufferDir)
+		bufferType = "file"
+	}
+	switch bufferType {
+	case "redis":
+		buffer = saver.NewRedisBuffer(redisClient, cfg.GroupConnector, tables)
+	case "file":
+		if buffer, err = saver.NewFileBuffer(cfg.BufferDir, tables); err != nil {
+			log.Fatalf("can't init sessions buffer: %s", err)
+		}
+	default:
+		log.Fatalf("unknown buffer type: %s", cfg.BufferType)
+	}
 
-	// Message filter
-	msgFilter := []int{messages.MsgConsoleLog, messages.MsgCustomEvent, messages.MsgJSException,
-		messages.MsgNetworkRequest, messages.MsgIssueEvent, messages.MsgCustomIssue,
-		messages.MsgSessionStart, messages.MsgSessionEnd, messages.MsgConnectionInformation,
-		messages.MsgMetadata, messages.MsgPageEvent, messages.MsgPerformanceTrackAggr, messages.MsgUserID,
-		messages.MsgUserAnonymousID, messages.MsgJSException, messages.MsgJSExceptionDeprecated,
-		messages.MsgInputEvent, messages.MsgMouseClick, messages.MsgIssueEventDeprecated}
+	// Saves messages to the database
+	dataSaver := saver.New(cfg, db, tables, buffer, sessManager, projManager)
 
 	// Init consumer
 	consumer := queue.NewConsumer(
@@ -78,7 +117,7 @@ func main() {
 			cfg.TopicRawWeb,
 			cfg.TopicAnalytics,
 		},
-		messages.NewMessageIterator(dataSaver.Handle, msgFilter, true),
+		messages.NewMessageIterator(dataSaver.Handle, dataSaver.MessageFilter(), true),
 		false,
 		cfg.MessageSizeLimit,
 	)
@@ -95,3 +134,19 @@ func main() {
 	log.Printf("Connector service started\n")
 	terminator.Wait(service)
 }
+
+// newUploader returns object storage or local directory for files
+func newUploader(cfg *config.Config) saver.Uploader {
+	if cfg.FilesDir != "" {
+		uploader, err := saver.NewLocalFiles(cfg.FilesDir)
+		if err != nil {
+			log.Fatalf("can't init local files directory: %s", err)
+		}
+		return uploader
+	}
+	objStore, err := store.NewStore(&cfg.ObjectsConfig)
+	if err != nil {
+		log.Fatalf("can't init object storage: %s", err)
+	}
+	return objStore
+}
@@ -37,6 +37,7 @@ func (d *dbImpl) run() {
 			d.commit()
 		case msg := <-d.consumer.Rebalanced():
 			log.Println(msg)
+			d.saver.Rebalanced()
 		default:
 			if !d.mm.HasFreeMemory() {
 				continue
@@ -48,9 +49,15 @@ func (d *dbImpl) run() {
 	}
 }
 
+// commit moves the queue offsets only after the batch is saved, otherwise it's retried on the next tick
 func (d *dbImpl) commit() {
-	d.saver.Commit()
-	d.consumer.Commit()
+	if err := d.saver.Commit(); err != nil {
+		log.Printf("batch isn't saved, offsets aren't committed: %s", err)
+		return
+	}
+	if err := d.consumer.Commit(); err != nil {
+		log.Printf("can't commit offsets: %s", err)
+	}
 }
 
 func (d *dbImpl) Stop() {
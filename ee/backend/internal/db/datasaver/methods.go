@@ -5,14 +5,13 @@ import (
 
 	"openreplay/backend/pkg/db/clickhouse"
 	"openreplay/backend/pkg/db/types"
-	"openreplay/backend/pkg/env"
 	"openreplay/backend/pkg/messages"
 	"openreplay/backend/pkg/queue"
 	"openreplay/backend/pkg/sessions"
 )
 
 func (s *saverImpl) init() {
-	s.ch = clickhouse.NewConnector(env.String("CLICKHOUSE_STRING"))
+	s.ch = clickhouse.NewConnector(&s.cfg.Clickhouse)
 	if err := s.ch.Prepare(); err != nil {
 		log.Fatalf("can't prepare clickhouse: %s", err)
 	}
@@ -56,9 +55,9 @@ func (s *saverImpl) handleExtraMessage(msg messages.Message) error {
 	case *messages.ResourceTiming:
 		return s.ch.InsertWebResourceEvent(session, m)
 	case *messages.JSException:
-		return s.ch.InsertWebErrorEvent(session, types.WrapJSException(m))
+		return s.insertWebErrorEvent(session, types.WrapJSException(m))
 	case *messages.IntegrationEvent:
-		return s.ch.InsertWebErrorEvent(session, types.WrapIntegrationEvent(m))
+		return s.insertWebErrorEvent(session, types.WrapIntegrationEvent(m))
 	case *messages.IssueEvent:
 		return s.ch.InsertIssue(session, m)
 	case *messages.CustomEvent:
@@ -92,3 +91,9 @@ func (s *saverImpl) handleExtraMessage(msg messages.Message) error {
 	}
 	return nil
 }
+
+// insertWebErrorEvent uses the same error group id as postgres (existing groups can have legacy ids)
+func (s *saverImpl) insertWebErrorEvent(session *sessions.Session, e *types.ErrorEvent) error {
+	s.pg.ResolveErrorID(session.ProjectID, e)
+	return s.ch.InsertWebErrorEvent(session, e)
+}
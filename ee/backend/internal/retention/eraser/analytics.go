@@ -0,0 +1,98 @@
+package eraser
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+
+	config "openreplay/backend/internal/config/retention"
+	chDB "openreplay/backend/pkg/db/clickhouse"
+)
+
+// Clickhouse tables with session rows
+var sessionTables = []string{
+	"experimental.sessions",
+	"experimental.events",
+	"experimental.resources",
+	"experimental.ios_events",
+	"experimental.sessions_feature_flags",
+	"experimental.user_favorite_sessions",
+	"experimental.user_viewed_sessions",
+}
+
+// Copies of the last 7 days, they expire by TTL, so failed deletes don't stop the erasure
+var sessionViews = []string{
+	"experimental.sessions_l7d_mv",
+	"experimental.events_l7d_mv",
+	"experimental.resources_l7d_mv",
+}
+
+type analyticsImpl struct {
+	conn driver.Conn
+}
+
+func NewAnalytics(cfg *config.Config) (Analytics, error) {
+	opts, err := chDB.NewOptions(&cfg.Clickhouse)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := clickhouse.Open(opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.Ping(context.Background()); err != nil {
+		return nil, err
+	}
+	return &analyticsImpl{conn: conn}, nil
+}
+
+// Session ids are inlined into mutations, so a retention run doesn't fit the default limit (256KiB)
+const maxQuerySize = 64 << 20
+
+// DeleteSessions runs one mutation per table, rows disappear from query results when mutations are finished.
+// Every mutation rewrites all parts with the sessions, so retention deletes all expired sessions of a run at once.
+func (a *analyticsImpl) DeleteSessions(projectIDs []uint32, sessionIDs []uint64) ([]string, error) {
+	tables := make([]string, 0, len(sessionTables)+len(sessionViews))
+	for _, table := range sessionTables {
+		if err := a.deleteSessions(table, projectIDs, sessionIDs); err != nil {
+			return nil, fmt.Errorf("can't delete sessions from %s: %s", table, err)
+		}
+		tables = append(tables, table)
+	}
+	for _, view := range sessionViews {
+		if err := a.deleteSessions(view, projectIDs, sessionIDs); err != nil {
+			log.Printf("can't delete sessions from %s: %s", view, err)
+			continue
+		}
+		tables = append(tables, view)
+	}
+	return tables, nil
+}
+
+func (a *analyticsImpl) deleteSessions(table string, projectIDs []uint32, sessionIDs []uint64) error {
+	ctx := clickhouse.Context(context.Background(), clickhouse.WithSettings(clickhouse.Settings{
+		"max_query_size": maxQuerySize,
+	}))
+	return a.conn.Exec(ctx,
+		fmt.Sprintf("ALTER TABLE %s DELETE WHERE project_id IN (?) AND session_id IN (?)", table),
+		projectIDs, sessionIDs,
+	)
+}
+
+func (a *analyticsImpl) DeleteUser(projectID uint32, userID string) ([]string, error) {
+	err := a.conn.Exec(context.Background(),
+		"ALTER TABLE experimental.autocomplete DELETE WHERE project_id = ? AND type IN ('USERID', 'USERID_IOS') AND value = ?",
+		projectID, userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return []string{"experimental.autocomplete"}, nil
+}
+
+func (a *analyticsImpl) Close() error {
+	return a.conn.Close()
+}
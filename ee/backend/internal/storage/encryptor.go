@@ -58,8 +58,16 @@ func DecryptData(data, fullKey []byte) ([]byte, error) {
 	if err != nil {
 		return nil, fmt.Errorf("cbc encryptor failed: %s", err)
 	}
+	if len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return nil, errors.New("wrong size of encrypted data")
+	}
 	cbc := cipher.NewCBCDecrypter(block, iv)
 	res := make([]byte, len(data))
 	cbc.CryptBlocks(res, data)
-	return res, nil
+	// Remove padding added by fillLastBlock
+	padding := int(res[len(res)-1])
+	if padding == 0 || padding > aes.BlockSize {
+		return nil, errors.New("wrong padding of decrypted data")
+	}
+	return res[:len(res)-padding], nil
 }
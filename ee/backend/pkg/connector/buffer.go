@@ -0,0 +1,276 @@
+package connector
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/go-redis/redis"
+
+	redisClient "openreplay/backend/pkg/db/redis"
+)
+
+/*
+	Buffer keeps sessions which aren't saved to the database yet, so they survive restarts.
+	The saver writes changes to the buffer after the database and before the queue commit:
+	rows of the updated sessions, the list of finished sessions and ids of the saved sessions.
+	Redis is used by default, a local write-ahead log is used without redis (CONNECTOR_BUFFER=file).
+*/
+
+type Buffer interface {
+	// Load returns buffered sessions and ids of the finished ones
+	Load() (map[uint64]Row, []uint64, error)
+	Save(updated map[uint64]Row, finished []uint64, deleted []uint64) error
+	Close() error
+}
+
+// Redis buffer
+
+type redisBuffer struct {
+	client      *redis.Client
+	sessionsKey string
+	finishedKey string
+	tables      *Tables
+}
+
+func NewRedisBuffer(client *redisClient.Client, group string, tables *Tables) Buffer {
+	return &redisBuffer{
+		client:      client.Redis,
+		sessionsKey: fmt.Sprintf("connector:%s:sessions", group),
+		finishedKey: fmt.Sprintf("connector:%s:finished", group),
+		tables:      tables,
+	}
+}
+
+func (b *redisBuffer) Load() (map[uint64]Row, []uint64, error) {
+	data, err := b.client.HGetAll(b.sessionsKey).Result()
+	if err != nil {
+		return nil, nil, err
+	}
+	sessions := make(map[uint64]Row, len(data))
+	for field, value := range data {
+		sessionID, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			continue
+		}
+		encoded := make(map[string]string)
+		if err := json.Unmarshal([]byte(value), &encoded); err != nil {
+			log.Printf("can't decode buffered session %d: %s", sessionID, err)
+			continue
+		}
+		sessions[sessionID] = decodeSession(b.tables, encoded)
+	}
+	finished := make([]uint64, 0)
+	ids, err := b.client.SMembers(b.finishedKey).Result()
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, id := range ids {
+		if sessionID, err := strconv.ParseUint(id, 10, 64); err == nil {
+			finished = append(finished, sessionID)
+		}
+	}
+	return sessions, finished, nil
+}
+
+func (b *redisBuffer) Save(updated map[uint64]Row, finished []uint64, deleted []uint64) error {
+	_, err := b.client.TxPipelined(func(pipe redis.Pipeliner) error {
+		if len(updated) > 0 {
+			fields := make(map[string]interface{}, len(updated))
+			for sessionID, sess := range updated {
+				data, err := json.Marshal(encodeSession(sess))
+				if err != nil {
+					return err
+				}
+				fields[strconv.FormatUint(sessionID, 10)] = data
+			}
+			pipe.HMSet(b.sessionsKey, fields)
+		}
+		if len(deleted) > 0 {
+			ids := make([]string, 0, len(deleted))
+			for _, sessionID := range deleted {
+				ids = append(ids, strconv.FormatUint(sessionID, 10))
+			}
+			pipe.HDel(b.sessionsKey, ids...)
+		}
+		pipe.Del(b.finishedKey)
+		if len(finished) > 0 {
+			ids := make([]interface{}, 0, len(finished))
+			for _, sessionID := range finished {
+				ids = append(ids, sessionID)
+			}
+			pipe.SAdd(b.finishedKey, ids...)
+		}
+		return nil
+	})
+	return err
+}
+
+func (b *redisBuffer) Close() error {
+	return nil
+}
+
+// Write-ahead log in a local file, compacted on start and when it grows too much
+
+const walCompactFactor = 4
+
+type walRecord struct {
+	ID       uint64            `json:"id,omitempty"`
+	Row      map[string]string `json:"row,omitempty"`
+	Deleted  bool              `json:"deleted,omitempty"`
+	Finished []uint64          `json:"finished,omitempty"`
+}
+
+type fileBuffer struct {
+	path     string
+	tables   *Tables
+	file     *os.File
+	rows     map[uint64]map[string]string
+	finished []uint64
+	records  int // written since the last compaction
+}
+
+func NewFileBuffer(dir string, tables *Tables) (Buffer, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &fileBuffer{
+		path:   filepath.Join(dir, "sessions.wal"),
+		tables: tables,
+		rows:   make(map[uint64]map[string]string),
+	}, nil
+}
+
+func (b *fileBuffer) Load() (map[uint64]Row, []uint64, error) {
+	file, err := os.Open(b.path)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return nil, nil, err
+	default:
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+		for scanner.Scan() {
+			record := &walRecord{}
+			if err := json.Unmarshal(scanner.Bytes(), record); err != nil {
+				// The last record could be written partially
+				log.Printf("skip broken record of the sessions log: %s", err)
+				continue
+			}
+			b.apply(record)
+		}
+		err = scanner.Err()
+		file.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := b.compact(); err != nil {
+		return nil, nil, err
+	}
+	sessions := make(map[uint64]Row, len(b.rows))
+	for sessionID, encoded := range b.rows {
+		sessions[sessionID] = decodeSession(b.tables, encoded)
+	}
+	return sessions, append([]uint64{}, b.finished...), nil
+}
+
+func (b *fileBuffer) apply(record *walRecord) {
+	switch {
+	case record.Deleted:
+		delete(b.rows, record.ID)
+	case record.Row != nil:
+		b.rows[record.ID] = record.Row
+	case record.ID == 0:
+		b.finished = record.Finished
+	}
+}
+
+// compact rewrites the log with the current state and opens it for appending
+func (b *fileBuffer) compact() error {
+	if b.file != nil {
+		b.file.Close()
+		b.file = nil
+	}
+	tmpPath := b.path + ".tmp"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	for sessionID, row := range b.rows {
+		if err := encoder.Encode(&walRecord{ID: sessionID, Row: row}); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	if err := encoder.Encode(&walRecord{Finished: b.finished}); err != nil {
+		file.Close()
+		return err
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, b.path); err != nil {
+		return err
+	}
+	b.records = 0
+	b.file, err = os.OpenFile(b.path, os.O_WRONLY|os.O_APPEND, 0644)
+	return err
+}
+
+func (b *fileBuffer) Save(updated map[uint64]Row, finished []uint64, deleted []uint64) error {
+	if b.file == nil {
+		if err := b.compact(); err != nil {
+			return err
+		}
+	}
+	records := make([]*walRecord, 0, len(updated)+len(deleted)+1)
+	for sessionID, sess := range updated {
+		records = append(records, &walRecord{ID: sessionID, Row: encodeSession(sess)})
+	}
+	for _, sessionID := range deleted {
+		records = append(records, &walRecord{ID: sessionID, Deleted: true})
+	}
+	records = append(records, &walRecord{Finished: append([]uint64{}, finished...)})
+
+	writer := bufio.NewWriter(b.file)
+	encoder := json.NewEncoder(writer)
+	for _, record := range records {
+		if err := encoder.Encode(record); err != nil {
+			return err
+		}
+		b.apply(record)
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	if err := b.file.Sync(); err != nil {
+		return err
+	}
+	b.records += len(records)
+	if b.records > walCompactFactor*(len(b.rows)+1)+1000 {
+		return b.compact()
+	}
+	return nil
+}
+
+func (b *fileBuffer) Close() error {
+	if b.file == nil {
+		return nil
+	}
+	return b.file.Close()
+}
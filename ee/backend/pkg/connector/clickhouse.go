@@ -2,39 +2,29 @@ package connector
 
 import (
 	"context"
+	"fmt"
 	"log"
-	"strconv"
 	"strings"
 	"time"
 
 	"github.com/ClickHouse/clickhouse-go/v2"
 	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
 	"openreplay/backend/internal/config/connector"
+	chDB "openreplay/backend/pkg/db/clickhouse"
 )
 
 type ClickHouse struct {
-	cfg  *connector.Config
-	conn driver.Conn
+	cfg    *connector.Config
+	tables *Tables
+	conn   driver.Conn
 }
 
-func NewClickHouse(cfg *connector.Config) (*ClickHouse, error) {
-	url := cfg.Clickhouse.URL
-	url = strings.TrimPrefix(url, "tcp://")
-	url = strings.TrimSuffix(url, "/default")
-	conn, err := clickhouse.Open(&clickhouse.Options{
-		Addr: []string{url},
-		Auth: clickhouse.Auth{
-			Database: cfg.Clickhouse.Database,
-			Username: cfg.Clickhouse.UserName,
-			Password: cfg.Clickhouse.Password,
-		},
-		MaxOpenConns:    20,
-		MaxIdleConns:    15,
-		ConnMaxLifetime: 3 * time.Minute,
-		Compression: &clickhouse.Compression{
-			Method: clickhouse.CompressionLZ4,
-		},
-	})
+func NewClickHouse(cfg *connector.Config, tables *Tables) (*ClickHouse, error) {
+	opts, err := chDB.NewOptions(&cfg.Clickhouse)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := clickhouse.Open(opts)
 	if err != nil {
 		return nil, err
 	}
@@ -43,151 +33,105 @@ func NewClickHouse(cfg *connector.Config) (*ClickHouse, error) {
 		return nil, err
 	}
 	c := &ClickHouse{
-		cfg:  cfg,
-		conn: conn,
+		cfg:    cfg,
+		tables: tables,
+		conn:   conn,
 	}
 	return c, nil
 }
 
-const eventsSQL = "INSERT INTO connector_events_buffer (sessionid, consolelog_level, consolelog_value, customevent_name, customevent_payload, jsexception_message, jsexception_name, jsexception_payload, jsexception_metadata, networkrequest_type, networkrequest_method, networkrequest_url, networkrequest_request, networkrequest_response, networkrequest_status, networkrequest_timestamp, networkrequest_duration, issueevent_message_id, issueevent_timestamp, issueevent_type, issueevent_context_string, issueevent_context, issueevent_payload, issueevent_url, customissue_name, customissue_payload, received_at, batch_order_number) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+// Rows are inserted into buffer tables (<table>_buffer) which are flushed by ClickHouse
+func insertSQL(table string, schema Schema) string {
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(schema)), ", ")
+	return fmt.Sprintf("INSERT INTO %s_buffer (%s) VALUES (%s)", table, strings.Join(schema.Names(), ", "), placeholders)
+}
 
-func (c *ClickHouse) InsertEvents(batch []map[string]string) error {
-	bulk, err := c.conn.PrepareBatch(context.Background(), eventsSQL)
+func (c *ClickHouse) insert(table string, schema Schema, batch []Row) error {
+	bulk, err := c.conn.PrepareBatch(context.Background(), insertSQL(table, schema))
 	if err != nil {
 		return err
 	}
-	for _, event := range batch {
-		if err := bulk.Append(
-			Uint64(event["sessionid"]),
-			nullableString(event["consolelog_level"]),
-			nullableString(event["consolelog_value"]),
-			nullableString(event["customevent_name"]),
-			nullableString(event["customevent_payload"]),
-			nullableString(event["jsexception_message"]),
-			nullableString(event["jsexception_name"]),
-			nullableString(event["jsexception_payload"]),
-			nullableString(event["jsexception_metadata"]),
-			nullableString(event["networkrequest_type"]),
-			nullableString(event["networkrequest_method"]),
-			nullableString(event["networkrequest_url"]),
-			nullableString(event["networkrequest_request"]),
-			nullableString(event["networkrequest_response"]),
-			nullableUint64(event["networkrequest_status"]),
-			nullableUint64(event["networkrequest_timestamp"]),
-			nullableUint64(event["networkrequest_duration"]),
-			nullableString(event["issueevent_message_id"]),
-			nullableUint64(event["issueevent_timestamp"]),
-			nullableString(event["issueevent_type"]),
-			nullableString(event["issueevent_context_string"]),
-			nullableString(event["issueevent_context"]),
-			nullableString(event["issueevent_payload"]),
-			nullableString(event["issueevent_url"]),
-			nullableString(event["customissue_name"]),
-			nullableString(event["customissue_payload"]),
-			nullableUint64(event["received_at"]),
-			nullableUint64(event["batch_order_number"]),
-		); err != nil {
+	for _, values := range schema.Rows(table, batch) {
+		if err := bulk.Append(values...); err != nil {
 			log.Printf("can't append value set to batch, err: %s", err)
 		}
 	}
 	return bulk.Send()
 }
 
-const sessionsSQL = "INSERT INTO connector_user_sessions_buffer (sessionid, user_agent, user_browser, user_browser_version, user_country, user_device, user_device_heap_size, user_device_memory_size, user_device_type, user_os, user_os_version, user_uuid, connection_effective_bandwidth, connection_type, referrer, user_anonymous_id, user_id, session_start_timestamp, session_end_timestamp, session_duration, first_contentful_paint, speed_index, visually_complete, timing_time_to_interactive, avg_cpu, avg_fps, max_cpu, max_fps, max_total_js_heap_size, max_used_js_heap_size, js_exceptions_count, inputs_count, clicks_count, issues_count, pages_count, metadata_1, metadata_2, metadata_3, metadata_4, metadata_5, metadata_6, metadata_7, metadata_8, metadata_9, metadata_10) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+func (c *ClickHouse) InsertEvents(batch []Row) error {
+	return c.insert(c.cfg.EventsTableName, c.tables.Events, batch)
+}
 
-func (c *ClickHouse) InsertSessions(batch []map[string]string) error {
-	bulk, err := c.conn.PrepareBatch(context.Background(), sessionsSQL)
-	if err != nil {
-		return err
-	}
-	for _, sess := range batch {
-		if err := bulk.Append(
-			Uint64(sess["sessionid"]),
-			nullableString(sess["user_agent"]),
-			nullableString(sess["user_browser"]),
-			nullableString(sess["user_browser_version"]),
-			nullableString(sess["user_country"]),
-			nullableString(sess["user_device"]),
-			nullableUint64(sess["user_device_heap_size"]),
-			nullableUint64(sess["user_device_memory_size"]),
-			nullableString(sess["user_device_type"]),
-			nullableString(sess["user_os"]),
-			nullableString(sess["user_os_version"]),
-			nullableString(sess["user_uuid"]),
-			nullableUint64(sess["connection_effective_bandwidth"]),
-			nullableString(sess["connection_type"]),
-			nullableString(sess["referrer"]),
-			nullableString(sess["user_anonymous_id"]),
-			nullableString(sess["user_id"]),
-			nullableUint64(sess["session_start_timestamp"]),
-			nullableUint64(sess["session_end_timestamp"]),
-			nullableUint64(sess["session_duration"]),
-			nullableUint64(sess["first_contentful_paint"]),
-			nullableUint64(sess["speed_index"]),
-			nullableUint64(sess["visually_complete"]),
-			nullableUint64(sess["timing_time_to_interactive"]),
-			nullableUint64(sess["avg_cpu"]),
-			nullableUint64(sess["avg_fps"]),
-			nullableUint64(sess["max_cpu"]),
-			nullableUint64(sess["max_fps"]),
-			nullableUint64(sess["max_total_js_heap_size"]),
-			nullableUint64(sess["max_used_js_heap_size"]),
-			nullableUint64(sess["js_exceptions_count"]),
-			nullableUint64(sess["inputs_count"]),
-			nullableUint64(sess["clicks_count"]),
-			nullableUint64(sess["issues_count"]),
-			nullableUint64(sess["pages_count"]),
-			nullableString(sess["metadata_1"]),
-			nullableString(sess["metadata_2"]),
-			nullableString(sess["metadata_3"]),
-			nullableString(sess["metadata_4"]),
-			nullableString(sess["metadata_5"]),
-			nullableString(sess["metadata_6"]),
-			nullableString(sess["metadata_7"]),
-			nullableString(sess["metadata_8"]),
-			nullableString(sess["metadata_9"]),
-			nullableString(sess["metadata_10"]),
-		); err != nil {
-			log.Printf("can't append value set to batch, err: %s", err)
-		}
-	}
-	return bulk.Send()
+func (c *ClickHouse) InsertSessions(batch []Row) error {
+	return c.insert(c.cfg.SessionsTableName, c.tables.Sessions, batch)
 }
 
 func (c *ClickHouse) Close() error {
 	return c.conn.Close()
 }
 
-func Uint64(v string) uint64 {
-	if v == "" {
-		return 0
+// Schema migrations (see Migrator), tables are created by the connector's sql scripts
+
+var clickhouseTypes = map[ColumnType]string{String: "Nullable(String)", UInt64: "Nullable(UInt64)", Int64: "Nullable(Int64)", Bool: "Nullable(Bool)"}
+
+func (c *ClickHouse) SchemaVersion() (int, error) {
+	ctx := context.Background()
+	if err := c.conn.Exec(ctx, fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (version UInt32, applied_at DateTime DEFAULT now()) ENGINE = MergeTree ORDER BY version", schemaVersionTable)); err != nil {
+		return 0, err
 	}
-	res, err := strconv.Atoi(v)
-	if err != nil {
-		log.Printf("can't convert string to uint64, err: %s", err)
-		return 0
+	var version uint32
+	if err := c.conn.QueryRow(ctx, fmt.Sprintf("SELECT max(version) FROM %s", schemaVersionTable)).Scan(&version); err != nil {
+		return 0, err
 	}
-	return uint64(res)
+	return int(version), nil
 }
 
-func nullableString(v string) *string {
-	var p *string = nil
-	if v != "" {
-		p = &v
+// AddColumns alters the table and then its buffer, so buffered rows always fit the table
+func (c *ClickHouse) AddColumns(table string, columns Schema) error {
+	if len(columns) == 0 {
+		return nil
+	}
+	definitions := make([]string, 0, len(columns))
+	for _, column := range columns {
+		definitions = append(definitions, "ADD COLUMN IF NOT EXISTS "+column.Name+" "+clickhouseTypes[column.Type])
+	}
+	for _, name := range []string{table, table + "_buffer"} {
+		if err := c.conn.Exec(context.Background(), fmt.Sprintf("ALTER TABLE %s %s", name, strings.Join(definitions, ", "))); err != nil {
+			return err
+		}
 	}
-	return p
+	return nil
 }
 
-func nullableUint64(v string) *uint64 {
-	var p *uint64 = nil
-	if v != "" {
-		res, err := strconv.Atoi(v)
-		if err != nil {
-			log.Printf("can't convert string to uint64, err: %s", err)
-			return nil
+func (c *ClickHouse) SetSchemaVersion(version int) error {
+	return c.conn.Exec(context.Background(), fmt.Sprintf("INSERT INTO %s (version) VALUES (?)", schemaVersionTable), uint32(version))
+}
+
+// Deduplication (see Deduplicator), buffer tables are read together with their destination tables
+
+func (c *ClickHouse) ids(query string) (map[uint64]bool, error) {
+	rows, err := c.conn.Query(context.Background(), query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := make(map[uint64]bool)
+	for rows.Next() {
+		var id uint64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
 		}
-		a := uint64(res)
-		return &a
+		ids[id] = true
 	}
-	return p
+	return ids, rows.Err()
+}
+
+func (c *ClickHouse) SavedEvents(sessionIDs []uint64) (map[uint64]bool, error) {
+	return c.ids(fmt.Sprintf("SELECT assumeNotNull(event_id) FROM %s_buffer WHERE sessionid IN (%s) AND event_id IS NOT NULL",
+		c.cfg.EventsTableName, inList(sessionIDs)))
+}
+
+func (c *ClickHouse) SavedSessions(sessionIDs []uint64) (map[uint64]bool, error) {
+	return c.ids(savedSessionsSQL(c.cfg.SessionsTableName+"_buffer", sessionIDs))
 }
@@ -1,7 +1,7 @@
 package connector
 
 type Database interface {
-	InsertEvents(batch []map[string]string) error
-	InsertSessions(batch []map[string]string) error
+	InsertEvents(batch []Row) error
+	InsertSessions(batch []Row) error
 	Close() error
 }
@@ -0,0 +1,106 @@
+package connector
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// Deduplicator is implemented by databases which can find rows saved before a restart or a failed commit.
+// Files aren't checked, so their readers should deduplicate rows by session id and event id.
+type Deduplicator interface {
+	// SavedEvents returns event ids of the sessions which are in the events table
+	SavedEvents(sessionIDs []uint64) (map[uint64]bool, error)
+	// SavedSessions returns ids of the sessions which are in the sessions table
+	SavedSessions(sessionIDs []uint64) (map[uint64]bool, error)
+}
+
+// Number of session ids in one check request
+const dedupChunkSize = 1000
+
+func sessionIDs(rows []Row) []uint64 {
+	unique := make(map[uint64]bool, len(rows))
+	ids := make([]uint64, 0, len(rows))
+	for _, row := range rows {
+		if id := row.uint("sessionid"); !unique[id] {
+			unique[id] = true
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func savedIDs(ids []uint64, check func([]uint64) (map[uint64]bool, error)) (map[uint64]bool, error) {
+	saved := make(map[uint64]bool)
+	for start := 0; start < len(ids); start += dedupChunkSize {
+		end := start + dedupChunkSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		chunk, err := check(ids[start:end])
+		if err != nil {
+			return nil, err
+		}
+		for id := range chunk {
+			saved[id] = true
+		}
+	}
+	return saved, nil
+}
+
+// filterSaved removes rows which ids are saved already
+func filterSaved(rows []Row, column string, saved map[uint64]bool) []Row {
+	res := make([]Row, 0, len(rows))
+	for _, row := range rows {
+		if !saved[row.uint(column)] {
+			res = append(res, row)
+		}
+	}
+	if skipped := len(rows) - len(res); skipped > 0 {
+		log.Printf("skip %d rows which are saved already", skipped)
+	}
+	return res
+}
+
+func (s *Saver) newEvents(events []Row) ([]Row, error) {
+	db, ok := s.db.(Deduplicator)
+	if !ok {
+		return events, nil
+	}
+	saved, err := savedIDs(sessionIDs(events), db.SavedEvents)
+	if err != nil {
+		return nil, fmt.Errorf("can't check saved events: %s", err)
+	}
+	return filterSaved(events, "event_id", saved), nil
+}
+
+func (s *Saver) newSessions(sessions []Row) ([]Row, error) {
+	db, ok := s.db.(Deduplicator)
+	if !ok {
+		return sessions, nil
+	}
+	saved, err := savedIDs(sessionIDs(sessions), db.SavedSessions)
+	if err != nil {
+		return nil, fmt.Errorf("can't check saved sessions: %s", err)
+	}
+	return filterSaved(sessions, "sessionid", saved), nil
+}
+
+// Queries of SQL databases, ids are numbers, so they are inlined
+
+func inList(ids []uint64) string {
+	values := make([]string, 0, len(ids))
+	for _, id := range ids {
+		values = append(values, strconv.FormatUint(id, 10))
+	}
+	return strings.Join(values, ", ")
+}
+
+func savedEventsSQL(table string, sessionIDs []uint64) string {
+	return fmt.Sprintf("SELECT event_id FROM %s WHERE sessionid IN (%s) AND event_id IS NOT NULL", table, inList(sessionIDs))
+}
+
+func savedSessionsSQL(table string, sessionIDs []uint64) string {
+	return fmt.Sprintf("SELECT sessionid FROM %s WHERE sessionid IN (%s)", table, inList(sessionIDs))
+}
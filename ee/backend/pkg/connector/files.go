@@ -0,0 +1,273 @@
+package connector
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/compress"
+
+	"openreplay/backend/internal/config/connector"
+	"openreplay/backend/pkg/objectstorage"
+)
+
+/*
+	Files sink writes every batch as files partitioned by date in the hive layout:
+		<prefix>/<table>/dt=2006-01-02/<batch>.<ext>
+	which can be queried by Athena, Trino, DuckDB, etc. or loaded into BigQuery and Snowflake.
+	The batch name is the hash of the first row, so the retry of a partially uploaded batch overwrites
+	already uploaded files instead of duplicating them.
+	Supported formats: parquet and jsonl (newline delimited json).
+*/
+
+type Uploader interface {
+	Upload(reader io.Reader, key string, contentType string, compression objectstorage.CompressionType) error
+}
+
+type fileFormat interface {
+	Extension() string
+	ContentType() string
+	Encode(table string, schema Schema, rows [][]interface{}) ([]byte, error)
+}
+
+type Files struct {
+	cfg      *connector.Config
+	tables   *Tables
+	uploader Uploader
+	format   fileFormat
+}
+
+func NewFiles(cfg *connector.Config, tables *Tables, uploader Uploader, format string) (*Files, error) {
+	f := &Files{
+		cfg:      cfg,
+		tables:   tables,
+		uploader: uploader,
+	}
+	switch format {
+	case "parquet":
+		codec, err := parquetCodec(cfg.ParquetCompression)
+		if err != nil {
+			return nil, err
+		}
+		f.format = &parquetFormat{codec: codec}
+	case "jsonl":
+		f.format = &jsonlFormat{}
+	default:
+		return nil, fmt.Errorf("unknown file format: %s", format)
+	}
+	return f, nil
+}
+
+func (f *Files) InsertEvents(batch []Row) error {
+	keys, err := f.write(f.cfg.EventsTableName, f.tables.Events, "received_at", batch)
+	if err != nil {
+		return err
+	}
+	log.Printf("events batch of %d events is successfully saved to %d files", len(batch), len(keys))
+	return nil
+}
+
+func (f *Files) InsertSessions(batch []Row) error {
+	keys, err := f.write(f.cfg.SessionsTableName, f.tables.Sessions, "session_start_timestamp", batch)
+	if err != nil {
+		return err
+	}
+	log.Printf("sessions batch of %d sessions is successfully saved to %d files", len(batch), len(keys))
+	return nil
+}
+
+// write uploads one file per date partition and returns file keys relative to the prefix
+func (f *Files) write(table string, schema Schema, timestampColumn string, batch []Row) ([]string, error) {
+	partitions := make(map[string][][]interface{})
+	tsIndex := schema.index(timestampColumn)
+	for _, values := range schema.Rows(table, batch) {
+		date := partitionDate(values, tsIndex)
+		partitions[date] = append(partitions[date], values)
+	}
+	dates := make([]string, 0, len(partitions))
+	for date := range partitions {
+		dates = append(dates, date)
+	}
+	sort.Strings(dates)
+
+	keys := make([]string, 0, len(dates))
+	for _, date := range dates {
+		data, err := f.format.Encode(table, schema, partitions[date])
+		if err != nil {
+			return keys, fmt.Errorf("can't encode %s file: %s", table, err)
+		}
+		key := fmt.Sprintf("%s/dt=%s/%s.%s", table, date, batchName(partitions[date]), f.format.Extension())
+		fullKey := key
+		if prefix := strings.Trim(f.cfg.FilesPrefix, "/"); prefix != "" {
+			fullKey = prefix + "/" + key
+		}
+		if err := f.uploader.Upload(bytes.NewReader(data), fullKey, f.format.ContentType(), objectstorage.NoCompression); err != nil {
+			return keys, fmt.Errorf("can't upload %s file: %s", fullKey, err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// batchName is the same for retries of the batch, because rows aren't removed from the failed batch
+func batchName(rows [][]interface{}) string {
+	hash := sha1.New()
+	if len(rows) > 0 {
+		fmt.Fprintf(hash, "%#v", rows[0])
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func (f *Files) Close() error {
+	return nil
+}
+
+// Parquet format
+
+type parquetFormat struct {
+	codec compress.Codec
+}
+
+func parquetCodec(name string) (compress.Codec, error) {
+	switch strings.ToLower(name) {
+	case "", "snappy":
+		return &parquet.Snappy, nil
+	case "zstd":
+		return &parquet.Zstd, nil
+	case "gzip":
+		return &parquet.Gzip, nil
+	case "none":
+		return &parquet.Uncompressed, nil
+	}
+	return nil, fmt.Errorf("unknown parquet compression: %s", name)
+}
+
+func (p *parquetFormat) Extension() string {
+	return "parquet"
+}
+
+func (p *parquetFormat) ContentType() string {
+	return "application/vnd.apache.parquet"
+}
+
+func parquetSchema(table string, schema Schema) *parquet.Schema {
+	group := parquet.Group{}
+	for _, column := range schema {
+		switch column.Type {
+		case UInt64, Int64:
+			// Signed type, most of query engines don't support unsigned integers
+			group[column.Name] = parquet.Optional(parquet.Int(64))
+		case Bool:
+			group[column.Name] = parquet.Optional(parquet.Leaf(parquet.BooleanType))
+		default:
+			group[column.Name] = parquet.Optional(parquet.String())
+		}
+	}
+	return parquet.NewSchema(table, group)
+}
+
+func (p *parquetFormat) Encode(table string, schema Schema, rows [][]interface{}) ([]byte, error) {
+	pSchema := parquetSchema(table, schema)
+	// Group fields are sorted by name, so values are placed by the column index of the parquet schema
+	indexes := make([]int, len(schema))
+	for i, path := range pSchema.Columns() {
+		indexes[schema.index(path[0])] = i
+	}
+	pRows := make([]parquet.Row, 0, len(rows))
+	for _, values := range rows {
+		row := make(parquet.Row, len(values))
+		for i, value := range values {
+			index := indexes[i]
+			switch v := value.(type) {
+			case uint64:
+				row[index] = parquet.Int64Value(int64(v)).Level(0, 1, index)
+			case int64:
+				row[index] = parquet.Int64Value(v).Level(0, 1, index)
+			case bool:
+				row[index] = parquet.BooleanValue(v).Level(0, 1, index)
+			case string:
+				row[index] = parquet.ByteArrayValue([]byte(v)).Level(0, 1, index)
+			default:
+				row[index] = parquet.NullValue().Level(0, 0, index)
+			}
+		}
+		pRows = append(pRows, row)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	writer := parquet.NewWriter(buf, pSchema, parquet.Compression(p.codec))
+	if _, err := writer.WriteRows(pRows); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Newline delimited json format, compatible with BigQuery load jobs
+
+type jsonlFormat struct{}
+
+func (j *jsonlFormat) Extension() string {
+	return "jsonl"
+}
+
+func (j *jsonlFormat) ContentType() string {
+	return "application/x-ndjson"
+}
+
+func (j *jsonlFormat) Encode(_ string, schema Schema, rows [][]interface{}) ([]byte, error) {
+	buf := bytes.NewBuffer(nil)
+	encoder := json.NewEncoder(buf)
+	for _, values := range rows {
+		obj := make(map[string]interface{}, len(values))
+		for i, value := range values {
+			if value != nil {
+				obj[schema[i].Name] = value
+			}
+		}
+		if err := encoder.Encode(obj); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// Local directory instead of object storage (for local runs and tests)
+
+type localFiles struct {
+	dir string
+}
+
+func NewLocalFiles(dir string) (Uploader, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &localFiles{dir: dir}, nil
+}
+
+func (l *localFiles) Upload(reader io.Reader, key string, _ string, _ objectstorage.CompressionType) error {
+	path := filepath.Join(l.dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, reader); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
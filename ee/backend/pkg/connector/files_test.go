@@ -0,0 +1,183 @@
+package connector
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/parquet-go/parquet-go"
+
+	"openreplay/backend/internal/config/connector"
+	"openreplay/backend/pkg/objectstorage"
+)
+
+func testConfig(dir string) *connector.Config {
+	cfg := &connector.Config{
+		SessionsTableName: "connector_user_sessions",
+		EventsTableName:   "connector_events",
+	}
+	cfg.FilesPrefix = "connector_data"
+	cfg.FilesDir = dir
+	return cfg
+}
+
+func testTables(t *testing.T, cfg *connector.Config) *Tables {
+	tables, err := NewTables(cfg)
+	if err != nil {
+		t.Fatalf("can't init tables: %s", err)
+	}
+	return tables
+}
+
+func testEvents() []Row {
+	return []Row{
+		{"sessionid": uint64(1), "consolelog_level": "error", "consolelog_value": `say "hi"`, "received_at": uint64(1697700000000)},
+		{"sessionid": uint64(2), "customevent_name": "buy", "received_at": uint64(1697800000000)},
+	}
+}
+
+func listFiles(t *testing.T, dir string) []string {
+	files, err := filepath.Glob(filepath.Join(dir, "connector_data", "connector_events", "dt=*", "*"))
+	if err != nil {
+		t.Fatalf("can't list files: %s", err)
+	}
+	return files
+}
+
+func TestParquetFiles(t *testing.T) {
+	dir := t.TempDir()
+	cfg := testConfig(dir)
+	uploader, err := NewLocalFiles(dir)
+	if err != nil {
+		t.Fatalf("can't init local files: %s", err)
+	}
+	files, err := NewFiles(cfg, testTables(t, cfg), uploader, "parquet")
+	if err != nil {
+		t.Fatalf("can't init files: %s", err)
+	}
+	if err := files.InsertEvents(testEvents()); err != nil {
+		t.Fatalf("can't insert events: %s", err)
+	}
+	// Events are partitioned by received_at date
+	paths := listFiles(t, dir)
+	if len(paths) != 2 {
+		t.Fatalf("Expected 2 files, got %v", paths)
+	}
+	if filepath.Base(filepath.Dir(paths[0])) != "dt=2023-10-19" {
+		t.Errorf("Wrong partition of the first file: %s", paths[0])
+	}
+
+	data, err := os.ReadFile(paths[0])
+	if err != nil {
+		t.Fatalf("can't read file: %s", err)
+	}
+	file, err := parquet.OpenFile(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("can't open parquet file: %s", err)
+	}
+	if file.NumRows() != 1 {
+		t.Fatalf("Expected 1 row, got %d", file.NumRows())
+	}
+	rows := make([]parquet.Row, 1)
+	reader := parquet.NewReader(file)
+	if n, _ := reader.ReadRows(rows); n != 1 {
+		t.Fatalf("Expected to read 1 row, got %d", n)
+	}
+	values := make(map[string]parquet.Value)
+	for i, path := range file.Schema().Columns() {
+		values[path[0]] = rows[0][i]
+	}
+	if v := values["sessionid"]; v.IsNull() || v.Int64() != 1 {
+		t.Errorf("Wrong sessionid: %v", v)
+	}
+	if v := values["consolelog_value"]; v.String() != `say "hi"` {
+		t.Errorf("Wrong consolelog_value: %v", v)
+	}
+	if v := values["customevent_name"]; !v.IsNull() {
+		t.Errorf("Expected null customevent_name, got %v", v)
+	}
+}
+
+func TestJSONLFiles(t *testing.T) {
+	dir := t.TempDir()
+	uploader, err := NewLocalFiles(dir)
+	if err != nil {
+		t.Fatalf("can't init local files: %s", err)
+	}
+	cfg := testConfig(dir)
+	files, err := NewFiles(cfg, testTables(t, cfg), uploader, "jsonl")
+	if err != nil {
+		t.Fatalf("can't init files: %s", err)
+	}
+	if err := files.InsertEvents(testEvents()); err != nil {
+		t.Fatalf("can't insert events: %s", err)
+	}
+	rows := 0
+	for _, path := range listFiles(t, dir) {
+		file, err := os.Open(path)
+		if err != nil {
+			t.Fatalf("can't open file: %s", err)
+		}
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			row := make(map[string]interface{})
+			if err := json.Unmarshal(scanner.Bytes(), &row); err != nil {
+				t.Errorf("wrong json line: %s", err)
+			}
+			if _, ok := row["sessionid"].(float64); !ok {
+				t.Errorf("Expected numeric sessionid, got %v", row["sessionid"])
+			}
+			if _, ok := row["jsexception_name"]; ok {
+				t.Errorf("Expected no empty columns, got %v", row)
+			}
+			rows++
+		}
+		file.Close()
+	}
+	if rows != 2 {
+		t.Errorf("Expected 2 rows, got %d", rows)
+	}
+}
+
+// flakyUploader fails the second upload
+type flakyUploader struct {
+	Uploader
+	uploads int
+}
+
+func (u *flakyUploader) Upload(reader io.Reader, key string, contentType string, compression objectstorage.CompressionType) error {
+	u.uploads++
+	if u.uploads == 2 {
+		return errors.New("connection reset")
+	}
+	return u.Uploader.Upload(reader, key, contentType, compression)
+}
+
+func TestFilesRetry(t *testing.T) {
+	dir := t.TempDir()
+	local, err := NewLocalFiles(dir)
+	if err != nil {
+		t.Fatalf("can't init local files: %s", err)
+	}
+	cfg := testConfig(dir)
+	files, err := NewFiles(cfg, testTables(t, cfg), &flakyUploader{Uploader: local}, "jsonl")
+	if err != nil {
+		t.Fatalf("can't init files: %s", err)
+	}
+	events := append(testEvents(), Row{"sessionid": "wrong", "received_at": uint64(1697700000000)})
+	if err := files.InsertEvents(events); err == nil {
+		t.Fatalf("Expected upload error")
+	}
+	// Already uploaded file of the batch is overwritten by the retry
+	if err := files.InsertEvents(events); err != nil {
+		t.Fatalf("can't insert events: %s", err)
+	}
+	if paths := listFiles(t, dir); len(paths) != 2 {
+		t.Errorf("Expected 2 files after retry, got %v", paths)
+	}
+}
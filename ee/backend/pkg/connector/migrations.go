@@ -0,0 +1,71 @@
+package connector
+
+import (
+	"fmt"
+	"log"
+)
+
+/*
+	Connector tables are versioned. Every migration only adds columns, so rows written by an older
+	connector stay valid and nothing has to be rewritten. Migrations are applied on start in the
+	version order; only the columns chosen by the columns selection (see Tables) are created.
+	Adding a column is idempotent, so selecting more columns later creates them on the next start.
+	The last applied version is saved into the connector_schema_version table.
+*/
+
+const schemaVersionTable = "connector_schema_version"
+
+type Migration struct {
+	Version        int
+	Sessions       Schema
+	Events         Schema
+	DetailedEvents Schema // used only with EVENT_LEVEL=detailed
+}
+
+var migrations = []Migration{
+	{Version: 1, Sessions: sessionsV1, Events: eventsV1},
+	{Version: 2, Sessions: sessionsV2, Events: eventsV2, DetailedEvents: detailedEventsV2},
+	{Version: 3, Events: eventsV3},
+}
+
+// SchemaVersion returns the last version of connector tables
+func SchemaVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+// Migrator is implemented by databases with a schema (not by files)
+type Migrator interface {
+	// SchemaVersion returns the applied version or 0 for a new database
+	SchemaVersion() (int, error)
+	// AddColumns creates the table if it doesn't exist and adds missing columns
+	AddColumns(table string, columns Schema) error
+	SetSchemaVersion(version int) error
+}
+
+// Migrate creates the selected columns of all schema versions in the connector tables
+func Migrate(db Migrator, tables *Tables) error {
+	current, err := db.SchemaVersion()
+	if err != nil {
+		return fmt.Errorf("can't get schema version: %s", err)
+	}
+	latest := SchemaVersion()
+	if current > latest {
+		log.Printf("schema version %d is newer than connector's one %d, new columns will be empty", current, latest)
+	}
+	for _, m := range migrations {
+		if err := db.AddColumns(tables.sessionsTable, tables.Sessions.filter(m.Sessions)); err != nil {
+			return fmt.Errorf("can't apply migration %d to %s: %s", m.Version, tables.sessionsTable, err)
+		}
+		events := append(append(Schema{}, m.Events...), m.DetailedEvents...)
+		if err := db.AddColumns(tables.eventsTable, tables.Events.filter(events)); err != nil {
+			return fmt.Errorf("can't apply migration %d to %s: %s", m.Version, tables.eventsTable, err)
+		}
+		if m.Version > current {
+			if err := db.SetSchemaVersion(m.Version); err != nil {
+				return fmt.Errorf("can't save schema version %d: %s", m.Version, err)
+			}
+			log.Printf("connector schema is migrated to version %d", m.Version)
+		}
+	}
+	return nil
+}
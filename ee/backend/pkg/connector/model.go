@@ -1,55 +1,57 @@
 package connector
 
-import "strconv"
+import "openreplay/backend/pkg/messages"
 
-var sessionColumns = []string{
-	"sessionid",
-	"user_agent",
-	"user_browser",
-	"user_browser_version",
-	"user_country",
-	"user_device",
-	"user_device_heap_size",
-	"user_device_memory_size",
-	"user_device_type",
-	"user_os",
-	"user_os_version",
-	"user_uuid",
-	"connection_effective_bandwidth",
-	"connection_type",
-	"referrer",
-	"user_anonymous_id",
-	"user_id",
-	"session_start_timestamp",
-	"session_end_timestamp",
-	"session_duration",
-	"first_contentful_paint",
-	"speed_index",
-	"visually_complete",
-	"timing_time_to_interactive",
-	"avg_cpu",
-	"avg_fps",
-	"max_cpu",
-	"max_fps",
-	"max_total_js_heap_size",
-	"max_used_js_heap_size",
-	"js_exceptions_count",
-	"inputs_count",
-	"clicks_count",
-	"issues_count",
-	"pages_count",
-	"metadata_1",
-	"metadata_2",
-	"metadata_3",
-	"metadata_4",
-	"metadata_5",
-	"metadata_6",
-	"metadata_7",
-	"metadata_8",
-	"metadata_9",
-	"metadata_10",
+// Columns of the first schema version, types are kept for existing tables
+var sessionsV1 = Schema{
+	{"sessionid", UInt64},
+	{"user_agent", String},
+	{"user_browser", String},
+	{"user_browser_version", String},
+	{"user_country", String},
+	{"user_device", String},
+	{"user_device_heap_size", UInt64},
+	{"user_device_memory_size", UInt64},
+	{"user_device_type", String},
+	{"user_os", String},
+	{"user_os_version", String},
+	{"user_uuid", String},
+	{"connection_effective_bandwidth", UInt64},
+	{"connection_type", String},
+	{"referrer", String},
+	{"user_anonymous_id", String},
+	{"user_id", String},
+	{"session_start_timestamp", UInt64},
+	{"session_end_timestamp", UInt64},
+	{"session_duration", UInt64},
+	{"first_contentful_paint", UInt64},
+	{"speed_index", UInt64},
+	{"visually_complete", UInt64},
+	{"timing_time_to_interactive", UInt64},
+	{"avg_cpu", UInt64},
+	{"avg_fps", UInt64},
+	{"max_cpu", UInt64},
+	{"max_fps", UInt64},
+	{"max_total_js_heap_size", UInt64},
+	{"max_used_js_heap_size", UInt64},
+	{"js_exceptions_count", UInt64},
+	{"inputs_count", UInt64},
+	{"clicks_count", UInt64},
+	{"issues_count", UInt64},
+	{"pages_count", UInt64},
+	{"metadata_1", String},
+	{"metadata_2", String},
+	{"metadata_3", String},
+	{"metadata_4", String},
+	{"metadata_5", String},
+	{"metadata_6", String},
+	{"metadata_7", String},
+	{"metadata_8", String},
+	{"metadata_9", String},
+	{"metadata_10", String},
 }
 
+// Counters of the session which are saved as zeros instead of NULLs
 var sessionInts = []string{
 	"user_device_heap_size",
 	"user_device_memory_size",
@@ -71,37 +73,77 @@ var sessionInts = []string{
 	"pages_count",
 }
 
-var eventColumns = []string{
-	"sessionid",
-	"consolelog_level",
-	"consolelog_value",
-	"customevent_name",
-	"customevent_payload",
-	"jsexception_message",
-	"jsexception_name",
-	"jsexception_payload",
-	"jsexception_metadata",
-	"networkrequest_type",
-	"networkrequest_method",
-	"networkrequest_url",
-	"networkrequest_request",
-	"networkrequest_response",
-	"networkrequest_status",
-	"networkrequest_timestamp",
-	"networkrequest_duration",
-	"issueevent_message_id",
-	"issueevent_timestamp",
-	"issueevent_type",
-	"issueevent_context_string",
-	"issueevent_context",
-	"issueevent_payload",
-	"issueevent_url",
-	"customissue_name",
-	"customissue_payload",
-	"received_at",
-	"batch_order_number",
+var eventsV1 = Schema{
+	{"sessionid", UInt64},
+	{"consolelog_level", String},
+	{"consolelog_value", String},
+	{"customevent_name", String},
+	{"customevent_payload", String},
+	{"jsexception_message", String},
+	{"jsexception_name", String},
+	{"jsexception_payload", String},
+	{"jsexception_metadata", String},
+	{"networkrequest_type", String},
+	{"networkrequest_method", String},
+	{"networkrequest_url", String},
+	{"networkrequest_request", String},
+	{"networkrequest_response", String},
+	{"networkrequest_status", UInt64},
+	{"networkrequest_timestamp", UInt64},
+	{"networkrequest_duration", UInt64},
+	{"issueevent_message_id", String},
+	{"issueevent_timestamp", UInt64},
+	{"issueevent_type", String},
+	{"issueevent_context_string", String},
+	{"issueevent_context", String},
+	{"issueevent_payload", String},
+	{"issueevent_url", String},
+	{"customissue_name", String},
+	{"customissue_payload", String},
+	{"received_at", UInt64},
+	{"batch_order_number", UInt64},
+}
+
+// Second version: session fields which were collected but not saved, and detailed events
+var sessionsV2 = Schema{
+	{"user_city", String},
+	{"user_state", String},
+	{"tracker_version", String},
+	{"rev_id", String},
+	{"errors_count", UInt64},
+	{"issue_score", UInt64},
 }
 
-func QUOTES(s string) string {
-	return strconv.Quote(s)
+var eventsV2 = Schema{
+	{"networkrequest_transferred_body_size", UInt64},
 }
+
+var detailedEventsV2 = messageSchema(
+	messages.MsgPageEvent,
+	messages.MsgInputEvent,
+	messages.MsgMouseClick,
+	messages.MsgConnectionInformation,
+	messages.MsgSetViewportSize,
+	messages.MsgGraphQL,
+	messages.MsgMouseThrashing,
+)
+
+// messageSchema returns generated columns of the message types (see events.go)
+func messageSchema(types ...int) Schema {
+	var schema Schema
+	for _, typeID := range types {
+		schema = append(schema, messageColumns[typeID]...)
+	}
+	return schema
+}
+
+// Third version: deterministic event id for idempotent inserts (see eventID)
+var eventsV3 = Schema{
+	{"event_id", UInt64},
+}
+
+// Columns which are always saved, they can't be excluded by the columns selection
+var (
+	requiredSessionColumns = []string{"sessionid"}
+	requiredEventColumns   = []string{"sessionid", "event_id", "received_at", "batch_order_number"}
+)
@@ -0,0 +1,139 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+
+	"openreplay/backend/internal/config/connector"
+)
+
+const postgresTimeout = 2 * time.Minute
+
+// Postgres saves batches with COPY into plain tables which are created by migrations on start
+type Postgres struct {
+	cfg    *connector.Config
+	tables *Tables
+	conn   *pgxpool.Pool
+}
+
+func NewPostgres(cfg *connector.Config, tables *Tables) (*Postgres, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	conn, err := pgxpool.Connect(ctx, cfg.ConnectorPostgres)
+	if err != nil {
+		return nil, err
+	}
+	return &Postgres{
+		cfg:    cfg,
+		tables: tables,
+		conn:   conn,
+	}, nil
+}
+
+func (p *Postgres) copy(table string, schema Schema, batch []Row) error {
+	rows := schema.Rows(table, batch)
+	ctx, cancel := context.WithTimeout(context.Background(), postgresTimeout)
+	defer cancel()
+	_, err := p.conn.CopyFrom(ctx, pgx.Identifier{table}, schema.Names(), pgx.CopyFromRows(rows))
+	return err
+}
+
+func (p *Postgres) InsertEvents(batch []Row) error {
+	if err := p.copy(p.cfg.EventsTableName, p.tables.Events, batch); err != nil {
+		return err
+	}
+	log.Printf("events batch of %d events is successfully saved", len(batch))
+	return nil
+}
+
+func (p *Postgres) InsertSessions(batch []Row) error {
+	if err := p.copy(p.cfg.SessionsTableName, p.tables.Sessions, batch); err != nil {
+		return err
+	}
+	log.Printf("sessions batch of %d sessions is successfully saved", len(batch))
+	return nil
+}
+
+func (p *Postgres) Close() error {
+	p.conn.Close()
+	return nil
+}
+
+// Schema migrations (see Migrator)
+
+var postgresTypes = map[ColumnType]string{String: "text", UInt64: "bigint", Int64: "bigint", Bool: "boolean"}
+
+func (p *Postgres) exec(sql string, args ...interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), postgresTimeout)
+	defer cancel()
+	_, err := p.conn.Exec(ctx, sql, args...)
+	return err
+}
+
+func (p *Postgres) SchemaVersion() (int, error) {
+	if err := p.exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (version integer NOT NULL, applied_at timestamp DEFAULT now())", schemaVersionTable)); err != nil {
+		return 0, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), postgresTimeout)
+	defer cancel()
+	var version int
+	err := p.conn.QueryRow(ctx, fmt.Sprintf("SELECT COALESCE(MAX(version), 0) FROM %s", schemaVersionTable)).Scan(&version)
+	return version, err
+}
+
+func (p *Postgres) AddColumns(table string, columns Schema) error {
+	if len(columns) == 0 {
+		return nil
+	}
+	definitions := make([]string, 0, len(columns))
+	for _, column := range columns {
+		definitions = append(definitions, "ADD COLUMN IF NOT EXISTS "+column.Name+" "+postgresTypes[column.Type])
+	}
+	if err := p.exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s %s)", table, columns[0].Name, postgresTypes[columns[0].Type])); err != nil {
+		return err
+	}
+	if err := p.exec(fmt.Sprintf("ALTER TABLE %s %s", table, strings.Join(definitions, ", "))); err != nil {
+		return err
+	}
+	// For the deduplication after restarts
+	return p.exec(fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s_sessionid_idx ON %s (sessionid)", table, table))
+}
+
+func (p *Postgres) SetSchemaVersion(version int) error {
+	return p.exec(fmt.Sprintf("INSERT INTO %s (version) VALUES ($1)", schemaVersionTable), version)
+}
+
+// Deduplication (see Deduplicator)
+
+func (p *Postgres) ids(sql string) (map[uint64]bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), postgresTimeout)
+	defer cancel()
+	rows, err := p.conn.Query(ctx, sql)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := make(map[uint64]bool)
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids[uint64(id)] = true
+	}
+	return ids, rows.Err()
+}
+
+func (p *Postgres) SavedEvents(sessionIDs []uint64) (map[uint64]bool, error) {
+	return p.ids(savedEventsSQL(p.cfg.EventsTableName, sessionIDs))
+}
+
+func (p *Postgres) SavedSessions(sessionIDs []uint64) (map[uint64]bool, error) {
+	return p.ids(savedSessionsSQL(p.cfg.SessionsTableName, sessionIDs))
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/google/uuid"
 	"log"
 	"openreplay/backend/pkg/objectstorage"
+	"strings"
 
 	"openreplay/backend/internal/config/connector"
 
@@ -16,12 +17,13 @@ import (
 
 type Redshift struct {
 	cfg        *connector.Config
+	tables     *Tables
 	ctx        context.Context
 	db         *sql.DB
 	objStorage objectstorage.ObjectStorage
 }
 
-func NewRedshift(cfg *connector.Config, objStorage objectstorage.ObjectStorage) (*Redshift, error) {
+func NewRedshift(cfg *connector.Config, tables *Tables, objStorage objectstorage.ObjectStorage) (*Redshift, error) {
 	var source string
 	if cfg.ConnectionString != "" {
 		source = cfg.ConnectionString
@@ -39,37 +41,46 @@ func NewRedshift(cfg *connector.Config, objStorage objectstorage.ObjectStorage)
 	}
 	return &Redshift{
 		cfg:        cfg,
+		tables:     tables,
 		ctx:        context.Background(),
 		db:         sqldb,
 		objStorage: objStorage,
 	}, nil
 }
 
-func eventsToBuffer(batch []map[string]string) *bytes.Buffer {
+// csvEscaper escapes the delimiter, line breaks and backslashes for the ESCAPE option of COPY
+var csvEscaper = strings.NewReplacer(`\`, `\\`, "|", `\|`, "\n", "\\\n", "\r", "\\\r")
+
+func rowsToBuffer(table string, schema Schema, batch []Row) *bytes.Buffer {
 	buf := bytes.NewBuffer(nil)
 
 	// Write header
-	for _, column := range eventColumns {
-		buf.WriteString(column + "|")
-	}
-	buf.Truncate(buf.Len() - 1)
+	buf.WriteString(strings.Join(schema.Names(), "|"))
 
-	// Write data
-	for _, event := range batch {
+	// Write data, NULLs are empty values (EMPTYASNULL)
+	for _, values := range schema.Rows(table, batch) {
 		buf.WriteString("\n")
-		for _, column := range eventColumns {
-			buf.WriteString(event[column] + "|")
+		for i, value := range values {
+			if i > 0 {
+				buf.WriteString("|")
+			}
+			switch v := value.(type) {
+			case nil:
+			case string:
+				buf.WriteString(csvEscaper.Replace(v))
+			default:
+				buf.WriteString(fmt.Sprint(v))
+			}
 		}
-		buf.Truncate(buf.Len() - 1)
 	}
 	return buf
 }
 
-func (r *Redshift) InsertEvents(batch []map[string]string) error {
+func (r *Redshift) insert(table string, schema Schema, batch []Row) error {
 	// Send data to S3
-	fileName := fmt.Sprintf("connector_data/%s-%s.csv", r.cfg.EventsTableName, uuid.New().String())
+	fileName := fmt.Sprintf("connector_data/%s-%s.csv", table, uuid.New().String())
 	// Create csv file
-	buf := eventsToBuffer(batch)
+	buf := rowsToBuffer(table, schema, batch)
 
 	reader := bytes.NewReader(buf.Bytes())
 	if err := r.objStorage.Upload(reader, fileName, "text/csv", objectstorage.NoCompression); err != nil {
@@ -77,55 +88,30 @@ func (r *Redshift) InsertEvents(batch []map[string]string) error {
 		return err
 	}
 	// Copy data from s3 bucket to redshift
-	if err := r.Copy(r.cfg.EventsTableName, fileName, "|", true, false); err != nil {
+	if err := r.Copy(table, schema.Names(), fileName, "|", true, false); err != nil {
 		log.Printf("can't copy data from s3 to redshift: %s", err)
 		return err
 	}
-	log.Printf("events batch of %d events is successfully saved", len(batch))
 	return nil
 }
 
-func sessionsToBuffer(batch []map[string]string) *bytes.Buffer {
-	buf := bytes.NewBuffer(nil)
-
-	// Write header
-	for _, column := range sessionColumns {
-		buf.WriteString(column + "|")
-	}
-	buf.Truncate(buf.Len() - 1)
-
-	// Write data
-	for _, sess := range batch {
-		buf.WriteString("\n")
-		for _, column := range sessionColumns {
-			buf.WriteString(sess[column] + "|")
-		}
-		buf.Truncate(buf.Len() - 1)
+func (r *Redshift) InsertEvents(batch []Row) error {
+	if err := r.insert(r.cfg.EventsTableName, r.tables.Events, batch); err != nil {
+		return err
 	}
-	return buf
+	log.Printf("events batch of %d events is successfully saved", len(batch))
+	return nil
 }
 
-func (r *Redshift) InsertSessions(batch []map[string]string) error {
-	// Send data to S3
-	fileName := fmt.Sprintf("connector_data/%s-%s.csv", r.cfg.SessionsTableName, uuid.New().String())
-	// Create csv file
-	buf := sessionsToBuffer(batch)
-
-	reader := bytes.NewReader(buf.Bytes())
-	if err := r.objStorage.Upload(reader, fileName, "text/csv", objectstorage.NoCompression); err != nil {
-		log.Printf("can't upload file to s3: %s", err)
-		return err
-	}
-	// Copy data from s3 bucket to redshift
-	if err := r.Copy(r.cfg.SessionsTableName, fileName, "|", true, false); err != nil {
-		log.Printf("can't copy data from s3 to redshift: %s", err)
+func (r *Redshift) InsertSessions(batch []Row) error {
+	if err := r.insert(r.cfg.SessionsTableName, r.tables.Sessions, batch); err != nil {
 		return err
 	}
 	log.Printf("sessions batch of %d sessions is successfully saved", len(batch))
 	return nil
 }
 
-func (r *Redshift) Copy(tableName, fileName, delimiter string, creds, gzip bool) error {
+func (r *Redshift) Copy(tableName string, columns []string, fileName, delimiter string, creds, gzip bool) error {
 	var (
 		credentials string
 		gzipSQL     string
@@ -140,9 +126,9 @@ func (r *Redshift) Copy(tableName, fileName, delimiter string, creds, gzip bool)
 	bucketName := "rdshftbucket"
 	filePath := fmt.Sprintf("s3://%s/%s", bucketName, fileName)
 
-	copySQL := fmt.Sprintf(`COPY "%s" FROM '%s' WITH %s TIMEFORMAT 'auto' DATEFORMAT 'auto' TRUNCATECOLUMNS 
-		STATUPDATE ON %s DELIMITER AS '%s' IGNOREHEADER 1 REMOVEQUOTES ESCAPE TRIMBLANKS EMPTYASNULL ACCEPTANYDATE`,
-		tableName, filePath, gzipSQL, credentials, delimiter)
+	copySQL := fmt.Sprintf(`COPY "%s" (%s) FROM '%s' WITH %s TIMEFORMAT 'auto' DATEFORMAT 'auto' TRUNCATECOLUMNS 
+		STATUPDATE ON %s DELIMITER AS '%s' IGNOREHEADER 1 ESCAPE EMPTYASNULL ACCEPTANYDATE`,
+		tableName, strings.Join(columns, ", "), filePath, gzipSQL, credentials, delimiter)
 	log.Printf("Running command: %s", copySQL)
 
 	_, err := r.db.ExecContext(r.ctx, copySQL)
@@ -156,3 +142,85 @@ func (r *Redshift) ExecutionDuration(fileName string) (int, error) {
 func (r *Redshift) Close() error {
 	return r.db.Close()
 }
+
+// Schema migrations (see Migrator), Redshift doesn't support ADD COLUMN IF NOT EXISTS
+
+var redshiftTypes = map[ColumnType]string{String: "VARCHAR(65535)", UInt64: "BIGINT", Int64: "BIGINT", Bool: "BOOLEAN"}
+
+func (r *Redshift) SchemaVersion() (int, error) {
+	if _, err := r.db.ExecContext(r.ctx, fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (version INTEGER NOT NULL, applied_at TIMESTAMP DEFAULT GETDATE())", schemaVersionTable)); err != nil {
+		return 0, err
+	}
+	var version int
+	err := r.db.QueryRowContext(r.ctx, fmt.Sprintf("SELECT COALESCE(MAX(version), 0) FROM %s", schemaVersionTable)).Scan(&version)
+	return version, err
+}
+
+func (r *Redshift) AddColumns(table string, columns Schema) error {
+	if len(columns) == 0 {
+		return nil
+	}
+	createSQL := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s %s)", table, columns[0].Name, redshiftTypes[columns[0].Type])
+	if _, err := r.db.ExecContext(r.ctx, createSQL); err != nil {
+		return err
+	}
+	rows, err := r.db.QueryContext(r.ctx, "SELECT column_name FROM information_schema.columns WHERE table_name = $1", table)
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		existing[name] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, column := range columns {
+		if existing[column.Name] {
+			continue
+		}
+		alterSQL := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column.Name, redshiftTypes[column.Type])
+		if _, err := r.db.ExecContext(r.ctx, alterSQL); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Redshift) SetSchemaVersion(version int) error {
+	_, err := r.db.ExecContext(r.ctx, fmt.Sprintf("INSERT INTO %s (version) VALUES ($1)", schemaVersionTable), version)
+	return err
+}
+
+// Deduplication (see Deduplicator)
+
+func (r *Redshift) ids(query string) (map[uint64]bool, error) {
+	rows, err := r.db.QueryContext(r.ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := make(map[uint64]bool)
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids[uint64(id)] = true
+	}
+	return ids, rows.Err()
+}
+
+func (r *Redshift) SavedEvents(sessionIDs []uint64) (map[uint64]bool, error) {
+	return r.ids(savedEventsSQL(r.cfg.EventsTableName, sessionIDs))
+}
+
+func (r *Redshift) SavedSessions(sessionIDs []uint64) (map[uint64]bool, error) {
+	return r.ids(savedSessionsSQL(r.cfg.SessionsTableName, sessionIDs))
+}
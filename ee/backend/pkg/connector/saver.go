@@ -2,193 +2,180 @@ package connector
 
 import (
 	"fmt"
+	"hash/fnv"
 	"log"
+	"math"
 	"openreplay/backend/internal/http/geoip"
 	"openreplay/backend/pkg/projects"
 	"openreplay/backend/pkg/sessions"
 	"strconv"
+	"strings"
 	"time"
 
 	config "openreplay/backend/internal/config/connector"
 	"openreplay/backend/pkg/messages"
 )
 
-// Saver collect sessions and events and saves them to Redshift
+/*
+Saver collect sessions and events and saves them to the database exactly once:
+  - queue offsets are committed only after successful writes (see Commit), failed batches are retried;
+  - sessions which aren't saved yet are kept in the buffer, so they aren't lost on restart;
+  - every session keeps ids of the last applied batches, so batches delivered again are skipped;
+  - after restarts and failed writes rows which are in the database already are filtered out
+    by session id and event id (for databases which implement Deduplicator).
+*/
 type Saver struct {
 	cfg              *config.Config
 	db               Database
+	tables           *Tables
+	buffer           Buffer
 	sessModule       sessions.Sessions
 	projModule       projects.Projects
-	sessions         map[uint64]map[string]string
+	sessions         map[uint64]Row
 	updatedSessions  map[uint64]bool
+	savedSessions    map[uint64]Row
 	lastUpdate       map[uint64]time.Time
 	finishedSessions []uint64
-	events           []map[string]string
+	events           []Row
+	batch            *messages.BatchInfo // batch of the last handled message
+	replayedBatch    bool
+	recovering       bool
 }
 
-func New(cfg *config.Config, db Database, sessions sessions.Sessions, projects projects.Projects) *Saver {
+func New(cfg *config.Config, db Database, tables *Tables, buffer Buffer, sessions sessions.Sessions, projects projects.Projects) *Saver {
 	if cfg == nil {
 		log.Fatal("connector config is empty")
 	}
 	// Validate column names in sessions table
-	if err := validateColumnNames(sessionColumns); err != nil {
+	if err := validateColumnNames(tables.Sessions.Names()); err != nil {
 		log.Printf("can't validate column names: %s", err)
 	}
 	// Validate column names in events table
-	if err := validateColumnNames(eventColumns); err != nil {
+	if err := validateColumnNames(tables.Events.Names()); err != nil {
 		log.Printf("can't validate column names: %s", err)
 	}
-	return &Saver{
+	s := &Saver{
 		cfg:             cfg,
 		db:              db,
+		tables:          tables,
+		buffer:          buffer,
 		sessModule:      sessions,
 		projModule:      projects,
+		sessions:        make(map[uint64]Row),
 		updatedSessions: make(map[uint64]bool, 0),
 		lastUpdate:      make(map[uint64]time.Time, 0),
+		recovering:      true, // the previous run could fail after writes
 	}
+	if buffer != nil {
+		buffered, finished, err := buffer.Load()
+		if err != nil {
+			log.Fatalf("can't load buffered sessions: %s", err)
+		}
+		now := time.Now()
+		for sessionID, sess := range buffered {
+			s.sessions[sessionID] = sess
+			s.lastUpdate[sessionID] = now
+		}
+		for _, sessionID := range finished {
+			if _, ok := s.sessions[sessionID]; ok {
+				s.finishedSessions = append(s.finishedSessions, sessionID)
+			}
+		}
+		log.Printf("loaded %d buffered sessions, finished: %d", len(s.sessions), len(s.finishedSessions))
+	}
+	return s
 }
 
-func handleEvent(msg messages.Message) map[string]string {
-	event := make(map[string]string)
+// sessionMessages are message types which are used to fill the sessions table
+var sessionMessages = []int{messages.MsgSessionStart, messages.MsgSessionEnd, messages.MsgConnectionInformation,
+	messages.MsgMetadata, messages.MsgPageEvent, messages.MsgPerformanceTrackAggr, messages.MsgUserID,
+	messages.MsgUserAnonymousID, messages.MsgJSException, messages.MsgJSExceptionDeprecated,
+	messages.MsgInputEvent, messages.MsgMouseClick, messages.MsgIssueEvent, messages.MsgIssueEventDeprecated}
 
-	switch m := msg.(type) {
-	case *messages.ConsoleLog:
-		event["consolelog_level"] = QUOTES(m.Level)
-		event["consolelog_value"] = QUOTES(m.Value)
-	case *messages.CustomEvent:
-		event["customevent_name"] = QUOTES(m.Name)
-		event["customevent_payload"] = QUOTES(m.Payload)
-	case *messages.JSException:
-		event["jsexception_name"] = QUOTES(m.Name)
-		event["jsexception_message"] = QUOTES(m.Message)
-		event["jsexception_payload"] = QUOTES(m.Payload)
-		event["jsexception_metadata"] = QUOTES(m.Metadata)
-	case *messages.NetworkRequest:
-		event["networkrequest_type"] = QUOTES(m.Type)
-		event["networkrequest_method"] = QUOTES(m.Method)
-		event["networkrequest_url"] = QUOTES(m.URL)
-		event["networkrequest_request"] = QUOTES(m.Request)
-		event["networkrequest_response"] = QUOTES(m.Response)
-		event["networkrequest_status"] = fmt.Sprintf("%d", m.Status)
-		event["networkrequest_timestamp"] = fmt.Sprintf("%d", m.Timestamp)
-		event["networkrequest_duration"] = fmt.Sprintf("%d", m.Duration)
-	case *messages.IssueEvent:
-		event["issueevent_message_id"] = fmt.Sprintf("%d", m.MessageID)
-		event["issueevent_timestamp"] = fmt.Sprintf("%d", m.Timestamp)
-		event["issueevent_type"] = QUOTES(m.Type)
-		event["issueevent_context_string"] = QUOTES(m.ContextString)
-		event["issueevent_context"] = QUOTES(m.Context)
-		event["issueevent_payload"] = QUOTES(m.Payload)
-		event["issueevent_url"] = QUOTES(m.URL)
-	case *messages.CustomIssue:
-		event["customissue_name"] = QUOTES(m.Name)
-		event["customissue_payload"] = QUOTES(m.Payload)
-	}
+// MessageFilter returns message types which are needed for the selected columns
+func (s *Saver) MessageFilter() []int {
+	return append(s.tables.EventMessages(), sessionMessages...)
+}
 
-	if len(event) == 0 {
+func (s *Saver) handleEvent(msg messages.Message) Row {
+	if !s.tables.hasEvent(msg.TypeID()) {
+		return nil
+	}
+	event := make(Row)
+	if !eventRow(msg, event) {
 		return nil
 	}
-	event["sessionid"] = fmt.Sprintf("%d", msg.SessionID())
-	event["received_at"] = fmt.Sprintf("%d", uint64(time.Now().UnixMilli()))
-	event["batch_order_number"] = fmt.Sprintf("%d", 0)
+	event["sessionid"] = msg.SessionID()
+	event["event_id"] = eventID(msg)
+	event["received_at"] = uint64(time.Now().UnixMilli())
+	event["batch_order_number"] = uint64(0)
 	return event
 }
 
-func (s *Saver) updateSessionInfoFromCache(sessID uint64, sess map[string]string) error {
+func (s *Saver) updateSessionInfoFromCache(sessID uint64, sess Row) error {
 	info, err := s.sessModule.Get(sessID)
 	if err != nil {
 		return err
 	}
+	setString := func(column, value string) {
+		if sess.missing(column) && value != "" {
+			sess[column] = value
+		}
+	}
+	setUint := func(column string, value uint64) {
+		if sess.missing(column) && value != 0 {
+			sess[column] = value
+		}
+	}
 	// Check all required fields are present
 	if info.Duration != nil {
-		sess["session_duration"] = fmt.Sprintf("%d", *info.Duration)
+		sess["session_duration"] = *info.Duration
 	}
-	if sess["session_start_timestamp"] == "" {
-		sess["session_start_timestamp"] = fmt.Sprintf("%d", info.Timestamp)
+	if sess.missing("session_start_timestamp") {
+		sess["session_start_timestamp"] = info.Timestamp
 	}
-	if sess["session_end_timestamp"] == "" && info.Duration != nil {
-		sess["session_end_timestamp"] = fmt.Sprintf("%d", info.Timestamp+*info.Duration)
+	if sess.missing("session_end_timestamp") && info.Duration != nil {
+		sess["session_end_timestamp"] = info.Timestamp + *info.Duration
 	}
-	if sess["session_duration"] == "" && sess["session_start_timestamp"] != "" && sess["session_end_timestamp"] != "" {
-		start, err := strconv.Atoi(sess["session_start_timestamp"])
-		if err != nil {
-			log.Printf("Error parsing session_start_timestamp: %v", err)
-		}
-		end, err := strconv.Atoi(sess["session_end_timestamp"])
-		if err != nil {
-			log.Printf("Error parsing session_end_timestamp: %v", err)
-		}
+	if sess.missing("session_duration") {
+		start, end := sess.uint("session_start_timestamp"), sess.uint("session_end_timestamp")
 		if start != 0 && end != 0 {
-			sess["session_duration"] = fmt.Sprintf("%d", end-start)
+			sess["session_duration"] = end - start
 		}
 	}
-	if sess["user_agent"] == "" && info.UserAgent != "" {
-		sess["user_agent"] = QUOTES(info.UserAgent)
-	}
-	if sess["user_browser"] == "" && info.UserBrowser != "" {
-		sess["user_browser"] = QUOTES(info.UserBrowser)
-	}
-	if sess["user_browser_version"] == "" && info.UserBrowserVersion != "" {
-		sess["user_browser_version"] = QUOTES(info.UserBrowserVersion)
-	}
-	if sess["user_os"] == "" && info.UserOS != "" {
-		sess["user_os"] = QUOTES(info.UserOS)
-	}
-	if sess["user_os_version"] == "" && info.UserOSVersion != "" {
-		sess["user_os_version"] = QUOTES(info.UserOSVersion)
-	}
-	if sess["user_device"] == "" && info.UserDevice != "" {
-		sess["user_device"] = QUOTES(info.UserDevice)
-	}
-	if sess["user_device_type"] == "" && info.UserDeviceType != "" {
-		sess["user_device_type"] = QUOTES(info.UserDeviceType)
-	}
-	if sess["user_device_memory_size"] == "" && info.UserDeviceMemorySize != 0 {
-		sess["user_device_memory_size"] = fmt.Sprintf("%d", info.UserDeviceMemorySize)
-	}
-	if sess["user_device_heap_size"] == "" && info.UserDeviceHeapSize != 0 {
-		sess["user_device_heap_size"] = fmt.Sprintf("%d", info.UserDeviceHeapSize)
-	}
-	if sess["user_country"] == "" && info.UserCountry != "" {
-		sess["user_country"] = QUOTES(info.UserCountry)
-	}
-	if sess["user_city"] == "" && info.UserCity != "" {
-		sess["user_city"] = QUOTES(info.UserCity)
-	}
-	if sess["user_state"] == "" && info.UserState != "" {
-		sess["user_state"] = QUOTES(info.UserState)
-	}
-	if sess["user_uuid"] == "" && info.UserUUID != "" {
-		sess["user_uuid"] = QUOTES(info.UserUUID)
-	}
-	if sess["session_start_timestamp"] == "" && info.Timestamp != 0 {
-		sess["session_start_timestamp"] = fmt.Sprintf("%d", info.Timestamp)
-	}
-	if sess["user_anonymous_id"] == "" && info.UserAnonymousID != nil {
-		sess["user_anonymous_id"] = QUOTES(*info.UserAnonymousID)
-	}
-	if sess["user_id"] == "" && info.UserID != nil {
-		sess["user_id"] = QUOTES(*info.UserID)
-	}
-	if sess["pages_count"] == "" && info.PagesCount != 0 {
-		sess["pages_count"] = fmt.Sprintf("%d", info.PagesCount)
-	}
-	if sess["tracker_version"] == "" && info.TrackerVersion != "" {
-		sess["tracker_version"] = QUOTES(info.TrackerVersion)
-	}
-	if sess["rev_id"] == "" && info.RevID != "" {
-		sess["rev_id"] = QUOTES(info.RevID)
-	}
+	setString("user_agent", info.UserAgent)
+	setString("user_browser", info.UserBrowser)
+	setString("user_browser_version", info.UserBrowserVersion)
+	setString("user_os", info.UserOS)
+	setString("user_os_version", info.UserOSVersion)
+	setString("user_device", info.UserDevice)
+	setString("user_device_type", info.UserDeviceType)
+	setUint("user_device_memory_size", info.UserDeviceMemorySize)
+	setUint("user_device_heap_size", info.UserDeviceHeapSize)
+	setString("user_country", info.UserCountry)
+	setString("user_city", info.UserCity)
+	setString("user_state", info.UserState)
+	setString("user_uuid", info.UserUUID)
+	setUint("session_start_timestamp", info.Timestamp)
+	if info.UserAnonymousID != nil {
+		setString("user_anonymous_id", *info.UserAnonymousID)
+	}
+	if info.UserID != nil {
+		setString("user_id", *info.UserID)
+	}
+	setUint("pages_count", uint64(info.PagesCount))
+	setString("tracker_version", info.TrackerVersion)
+	setString("rev_id", info.RevID)
 	if info.ErrorsCount != 0 {
-		sess["errors_count"] = fmt.Sprintf("%d", info.ErrorsCount)
+		sess["errors_count"] = uint64(info.ErrorsCount)
 	}
 	if info.IssueScore != 0 {
-		sess["issue_score"] = fmt.Sprintf("%d", info.IssueScore)
+		sess["issue_score"] = uint64(info.IssueScore)
 	}
 	// Check int fields
 	for _, field := range sessionInts {
-		if sess[field] == "" {
-			sess[field] = fmt.Sprintf("%d", 0)
+		if sess.missing(field) {
+			sess[field] = uint64(0)
 		}
 	}
 	return nil
@@ -204,58 +191,37 @@ func (s *Saver) handleSession(msg messages.Message) {
 	default:
 		return
 	}
-	if s.sessions == nil {
-		s.sessions = make(map[uint64]map[string]string)
-	}
-	sess, ok := s.sessions[msg.SessionID()]
-	if !ok {
-		// Try to load session from cache
-		cached, err := s.sessModule.GetCached(msg.SessionID())
-		if err != nil && err != sessions.ErrSessionNotFound {
-			log.Printf("Failed to get cached session: %v", err)
-		}
-		if cached != nil {
-			sess = cached
-		} else {
-			sess = make(map[string]string)
-			sess[`sessionid`] = fmt.Sprintf("%d", msg.SessionID())
-		}
-	}
-	if s.sessions[msg.SessionID()] == nil {
-		s.sessions[msg.SessionID()] = make(map[string]string)
-		s.sessions[msg.SessionID()][`sessionid`] = fmt.Sprintf("%d", msg.SessionID())
-		sess = s.sessions[msg.SessionID()]
-	}
+	sess := s.session(msg.SessionID())
 
 	// Parse message and add to session
 	updated := true
 	switch m := msg.(type) {
 	case *messages.SessionStart:
-		sess["session_start_timestamp"] = fmt.Sprintf("%d", m.Timestamp)
-		sess["user_uuid"] = QUOTES(m.UserUUID)
-		sess["user_agent"] = QUOTES(m.UserAgent)
-		sess["user_os"] = QUOTES(m.UserOS)
-		sess["user_os_version"] = QUOTES(m.UserOSVersion)
-		sess["user_browser"] = QUOTES(m.UserBrowser)
-		sess["user_browser_version"] = QUOTES(m.UserBrowserVersion)
-		sess["user_device"] = QUOTES(m.UserDevice)
-		sess["user_device_type"] = QUOTES(m.UserDeviceType)
-		sess["user_device_memory_size"] = fmt.Sprintf("%d", m.UserDeviceMemorySize)
-		sess["user_device_heap_size"] = fmt.Sprintf("%d", m.UserDeviceHeapSize)
-		sess["tracker_version"] = QUOTES(m.TrackerVersion)
-		sess["rev_id"] = QUOTES(m.RevID)
+		sess["session_start_timestamp"] = m.Timestamp
+		sess["user_uuid"] = m.UserUUID
+		sess["user_agent"] = m.UserAgent
+		sess["user_os"] = m.UserOS
+		sess["user_os_version"] = m.UserOSVersion
+		sess["user_browser"] = m.UserBrowser
+		sess["user_browser_version"] = m.UserBrowserVersion
+		sess["user_device"] = m.UserDevice
+		sess["user_device_type"] = m.UserDeviceType
+		sess["user_device_memory_size"] = m.UserDeviceMemorySize
+		sess["user_device_heap_size"] = m.UserDeviceHeapSize
+		sess["tracker_version"] = m.TrackerVersion
+		sess["rev_id"] = m.RevID
 		geoInfo := geoip.UnpackGeoRecord(m.UserCountry)
-		sess["user_country"] = QUOTES(geoInfo.Country)
-		sess["user_city"] = QUOTES(geoInfo.City)
-		sess["user_state"] = QUOTES(geoInfo.State)
+		sess["user_country"] = geoInfo.Country
+		sess["user_city"] = geoInfo.City
+		sess["user_state"] = geoInfo.State
 	case *messages.SessionEnd:
-		sess["session_end_timestamp"] = fmt.Sprintf("%d", m.Timestamp)
+		sess["session_end_timestamp"] = m.Timestamp
 		if err := s.updateSessionInfoFromCache(msg.SessionID(), sess); err != nil {
 			log.Printf("Error updating session info from cache: %v", err)
 		}
 	case *messages.ConnectionInformation:
-		sess["connection_effective_bandwidth"] = fmt.Sprintf("%d", m.Downlink)
-		sess["connection_type"] = QUOTES(m.Type)
+		sess["connection_effective_bandwidth"] = m.Downlink
+		sess["connection_type"] = m.Type
 	case *messages.Metadata:
 		session, err := s.sessModule.Get(msg.SessionID())
 		if err != nil {
@@ -271,73 +237,118 @@ func (s *Saver) handleSession(msg messages.Message) {
 		if keyNo == 0 {
 			break
 		}
-		sess[fmt.Sprintf("metadata_%d", keyNo)] = QUOTES(m.Value)
+		sess[fmt.Sprintf("metadata_%d", keyNo)] = m.Value
 	case *messages.PageEvent:
-		sess["referrer"] = QUOTES(m.Referrer)
-		sess["first_contentful_paint"] = fmt.Sprintf("%d", m.FirstContentfulPaint)
-		sess["speed_index"] = fmt.Sprintf("%d", m.SpeedIndex)
-		sess["timing_time_to_interactive"] = fmt.Sprintf("%d", m.TimeToInteractive)
-		sess["visually_complete"] = fmt.Sprintf("%d", m.VisuallyComplete)
-		currUrlsCount, err := strconv.Atoi(sess["pages_count"])
-		if err != nil {
-			currUrlsCount = 0
-		}
-		sess["pages_count"] = fmt.Sprintf("%d", currUrlsCount+1)
+		sess["referrer"] = m.Referrer
+		sess["first_contentful_paint"] = m.FirstContentfulPaint
+		sess["speed_index"] = m.SpeedIndex
+		sess["timing_time_to_interactive"] = m.TimeToInteractive
+		sess["visually_complete"] = m.VisuallyComplete
+		sess.increment("pages_count")
 	case *messages.PerformanceTrackAggr:
-		sess["avg_cpu"] = fmt.Sprintf("%d", m.AvgCPU)
-		sess["avg_fps"] = fmt.Sprintf("%d", m.AvgFPS)
-		sess["max_cpu"] = fmt.Sprintf("%d", m.MaxCPU)
-		sess["max_fps"] = fmt.Sprintf("%d", m.MaxFPS)
-		sess["max_total_js_heap_size"] = fmt.Sprintf("%d", m.MaxTotalJSHeapSize)
-		sess["max_used_js_heap_size"] = fmt.Sprintf("%d", m.MaxUsedJSHeapSize)
+		sess["avg_cpu"] = m.AvgCPU
+		sess["avg_fps"] = m.AvgFPS
+		sess["max_cpu"] = m.MaxCPU
+		sess["max_fps"] = m.MaxFPS
+		sess["max_total_js_heap_size"] = m.MaxTotalJSHeapSize
+		sess["max_used_js_heap_size"] = m.MaxUsedJSHeapSize
 	case *messages.UserID:
 		if m.ID != "" {
-			sess["user_id"] = QUOTES(m.ID)
+			sess["user_id"] = m.ID
 		}
 	case *messages.UserAnonymousID:
-		sess["user_anonymous_id"] = QUOTES(m.ID)
+		sess["user_anonymous_id"] = m.ID
 	case *messages.JSException, *messages.JSExceptionDeprecated:
-		currExceptionsCount, err := strconv.Atoi(sess["js_exceptions_count"])
-		if err != nil {
-			currExceptionsCount = 0
-		}
-		sess["js_exceptions_count"] = fmt.Sprintf("%d", currExceptionsCount+1)
+		sess.increment("js_exceptions_count")
 	case *messages.InputEvent:
-		currInputsCount, err := strconv.Atoi(sess["inputs_count"])
-		if err != nil {
-			currInputsCount = 0
-		}
-		sess["inputs_count"] = fmt.Sprintf("%d", currInputsCount+1)
+		sess.increment("inputs_count")
 	case *messages.MouseClick:
-		currMouseClicksCount, err := strconv.Atoi(sess["clicks_count"])
-		if err != nil {
-			currMouseClicksCount = 0
-		}
-		sess["clicks_count"] = fmt.Sprintf("%d", currMouseClicksCount+1)
+		sess.increment("clicks_count")
 	case *messages.IssueEvent, *messages.IssueEventDeprecated:
-		currIssuesCount, err := strconv.Atoi(sess["issues_count"])
-		if err != nil {
-			currIssuesCount = 0
-		}
-		sess["issues_count"] = fmt.Sprintf("%d", currIssuesCount+1)
+		sess.increment("issues_count")
 	default:
 		updated = false
 	}
 	if updated {
-		if s.updatedSessions == nil {
-			s.updatedSessions = make(map[uint64]bool)
-		}
-		s.updatedSessions[msg.SessionID()] = true
+		s.markUpdated(msg.SessionID())
 	}
-	s.sessions[msg.SessionID()] = sess
 	s.lastUpdate[msg.SessionID()] = time.Now()
 }
 
+// session returns the session row from memory, cache or a new one
+func (s *Saver) session(sessionID uint64) Row {
+	if sess, ok := s.sessions[sessionID]; ok {
+		return sess
+	}
+	// Try to load session from cache
+	var sess Row
+	cached, err := s.sessModule.GetCached(sessionID)
+	if err != nil && err != sessions.ErrSessionNotFound {
+		log.Printf("Failed to get cached session: %v", err)
+	}
+	if cached != nil {
+		sess = decodeSession(s.tables, cached)
+	} else {
+		sess = make(Row)
+	}
+	sess["sessionid"] = sessionID
+	s.sessions[sessionID] = sess
+	s.lastUpdate[sessionID] = time.Now()
+	return sess
+}
+
+func (s *Saver) markUpdated(sessionID uint64) {
+	if s.updatedSessions == nil {
+		s.updatedSessions = make(map[uint64]bool)
+	}
+	s.updatedSessions[sessionID] = true
+}
+
+// Ids of the last applied batch of every topic are kept in the session row with the prefix
+const batchKeyPrefix = "_batch_"
+
+// replayed checks that the message batch has been applied to the session already. The queue delivers
+// uncommitted batches again after restarts and rebalances, while the session state is saved by commits.
+func (s *Saver) replayed(msg messages.Message) bool {
+	batch := msg.Meta().Batch()
+	if batch == nil {
+		return false
+	}
+	if batch == s.batch {
+		return s.replayedBatch
+	}
+	s.batch = batch
+	sess := s.session(msg.SessionID())
+	key := batchKeyPrefix + batch.Topic()
+	s.replayedBatch = batch.ID() <= sess.uint(key)
+	if s.replayedBatch {
+		log.Printf("skip replayed batch, %s", batch.Info())
+	} else {
+		sess[key] = batch.ID()
+		s.markUpdated(msg.SessionID())
+	}
+	return s.replayedBatch
+}
+
+// eventID is the same for the message delivered again: hash of the queue position and the message index.
+// It's positive to fit into signed integer columns.
+func eventID(msg messages.Message) uint64 {
+	hash := fnv.New64a()
+	if batch := msg.Meta().Batch(); batch != nil {
+		fmt.Fprintf(hash, "%s:%d:%d:", batch.Topic(), batch.Partition(), batch.ID())
+	}
+	fmt.Fprintf(hash, "%d:%d", msg.MsgID(), msg.TypeID())
+	return hash.Sum64() & math.MaxInt64
+}
+
 func (s *Saver) Handle(msg messages.Message) {
-	newEvent := handleEvent(msg)
+	if s.replayed(msg) {
+		return
+	}
+	newEvent := s.handleEvent(msg)
 	if newEvent != nil {
 		if s.events == nil {
-			s.events = make([]map[string]string, 0, 2)
+			s.events = make([]Row, 0, 2)
 		}
 		s.events = append(s.events, newEvent)
 	}
@@ -351,24 +362,34 @@ func (s *Saver) Handle(msg messages.Message) {
 	return
 }
 
-func (s *Saver) commitEvents() {
+func (s *Saver) commitEvents() error {
 	if len(s.events) == 0 {
 		log.Printf("empty events batch")
-		return
+		return nil
 	}
-	if err := s.db.InsertEvents(s.events); err != nil {
-		log.Printf("can't insert events: %s", err)
+	events := s.events
+	if s.recovering {
+		var err error
+		if events, err = s.newEvents(events); err != nil {
+			return err
+		}
+	}
+	if len(events) > 0 {
+		if err := s.db.InsertEvents(events); err != nil {
+			return err
+		}
 	}
 	s.events = nil
+	return nil
 }
 
-func (s *Saver) commitSessions() {
+func (s *Saver) commitSessions() error {
 	if len(s.finishedSessions) == 0 {
 		log.Printf("empty sessions batch")
-		return
+		return nil
 	}
 	l := len(s.finishedSessions)
-	sessions := make([]map[string]string, 0, len(s.finishedSessions))
+	sessions := make([]Row, 0, len(s.finishedSessions))
 	toKeep := make([]uint64, 0, len(s.finishedSessions))
 	toSend := make([]uint64, 0, len(s.finishedSessions))
 	for _, sessionID := range s.finishedSessions {
@@ -380,33 +401,85 @@ func (s *Saver) commitSessions() {
 			toSend = append(toSend, sessionID)
 		}
 	}
-	if err := s.db.InsertSessions(sessions); err != nil {
-		log.Printf("can't insert sessions: %s", err)
+	if s.recovering {
+		var err error
+		if sessions, err = s.newSessions(sessions); err != nil {
+			return err
+		}
+	}
+	if len(sessions) > 0 {
+		if err := s.db.InsertSessions(sessions); err != nil {
+			return err
+		}
 	}
 	log.Printf("finished: %d, to keep: %d, to send: %d", l, len(toKeep), len(toSend))
 	// Clear current list of finished sessions
+	if s.savedSessions == nil {
+		s.savedSessions = make(map[uint64]Row, len(toSend))
+	}
 	for _, sessionID := range toSend {
+		s.savedSessions[sessionID] = s.sessions[sessionID]
 		delete(s.sessions, sessionID)   // delete session info
 		delete(s.lastUpdate, sessionID) // delete last session update timestamp
 	}
 	s.finishedSessions = toKeep
+	return nil
 }
 
-// Commit saves batch to Redshift
-func (s *Saver) Commit() {
-	// Cache updated sessions
+// saveState caches updated sessions and keeps the unsaved ones in the buffer
+func (s *Saver) saveState() error {
 	start := time.Now()
-	for sessionID, _ := range s.updatedSessions {
-		if err := s.sessModule.AddCached(sessionID, s.sessions[sessionID]); err != nil {
+	updated := make(map[uint64]Row, len(s.updatedSessions))
+	for sessionID := range s.updatedSessions {
+		sess, ok := s.sessions[sessionID]
+		if ok {
+			updated[sessionID] = sess
+		} else if sess, ok = s.savedSessions[sessionID]; !ok {
+			continue
+		}
+		if err := s.sessModule.AddCached(sessionID, encodeSession(sess)); err != nil {
 			log.Printf("Error adding session to cache: %v", err)
 		}
 	}
 	log.Printf("Cached %d sessions in %s", len(s.updatedSessions), time.Since(start))
+	if s.buffer != nil {
+		saved := make([]uint64, 0, len(s.savedSessions))
+		for sessionID := range s.savedSessions {
+			saved = append(saved, sessionID)
+		}
+		if err := s.buffer.Save(updated, s.finishedSessions, saved); err != nil {
+			return err
+		}
+	}
 	s.updatedSessions = nil
-	// Commit events and sessions (send to Redshift)
-	s.commitEvents()
+	s.savedSessions = nil
+	return nil
+}
+
+// Commit saves events and finished sessions, then the state of the rest sessions.
+// The queue offsets must be committed only without an error, the batch is retried on the next commit otherwise.
+func (s *Saver) Commit() error {
+	// Commit events and sessions (send to the database)
+	if err := s.commitEvents(); err != nil {
+		s.recovering = true
+		return fmt.Errorf("can't insert events: %s", err)
+	}
 	s.checkZombieSessions()
-	s.commitSessions()
+	if err := s.commitSessions(); err != nil {
+		s.recovering = true
+		return fmt.Errorf("can't insert sessions: %s", err)
+	}
+	if err := s.saveState(); err != nil {
+		s.recovering = true
+		return fmt.Errorf("can't save sessions state: %s", err)
+	}
+	s.recovering = false
+	return nil
+}
+
+// Rebalanced is called when the queue partitions are reassigned, uncommitted batches could be delivered again
+func (s *Saver) Rebalanced() {
+	s.recovering = true
 }
 
 func (s *Saver) checkZombieSessions() {
@@ -427,7 +500,7 @@ func (s *Saver) checkZombieSessions() {
 			// Else update last update timestamp and try to wait for session end.
 			// Do that several times (save attempts number) after last attempt delete session from memory to avoid sessions with not filled fields
 			zombieSession := s.sessions[sessionID]
-			if zombieSession["session_start_timestamp"] == "" || zombieSession["session_end_timestamp"] == "" {
+			if zombieSession.missing("session_start_timestamp") || zombieSession.missing("session_end_timestamp") {
 				// Let's try to load session from cache
 				if err := s.updateSessionInfoFromCache(sessionID, zombieSession); err != nil {
 					log.Printf("Error updating zombie session info from cache: %v", err)
@@ -436,7 +509,7 @@ func (s *Saver) checkZombieSessions() {
 					log.Printf("Updated zombie session info from cache: %v", zombieSession)
 				}
 			}
-			if zombieSession["session_start_timestamp"] == "" || zombieSession["session_end_timestamp"] == "" {
+			if zombieSession.missing("session_start_timestamp") || zombieSession.missing("session_end_timestamp") {
 				s.lastUpdate[sessionID] = now
 				continue
 			}
@@ -449,9 +522,58 @@ func (s *Saver) checkZombieSessions() {
 	}
 }
 
+// Sessions are cached as strings, the version separates them from quoted values of older connectors
+const (
+	sessionCacheVersionKey = "_v"
+	sessionCacheVersion    = "2"
+)
+
+func encodeSession(sess Row) map[string]string {
+	data := make(map[string]string, len(sess)+1)
+	for column, value := range sess {
+		if value != nil {
+			data[column] = fmt.Sprint(value)
+		}
+	}
+	data[sessionCacheVersionKey] = sessionCacheVersion
+	return data
+}
+
+func decodeSession(tables *Tables, data map[string]string) Row {
+	legacy := data[sessionCacheVersionKey] == ""
+	sess := make(Row, len(data))
+	for key, value := range data {
+		if strings.HasPrefix(key, batchKeyPrefix) {
+			if id, err := strconv.ParseUint(value, 10, 64); err == nil {
+				sess[key] = id
+			}
+		}
+	}
+	for _, column := range tables.Sessions {
+		value, ok := data[column.Name]
+		if !ok {
+			continue
+		}
+		if legacy && column.Type == String {
+			if unquoted, err := strconv.Unquote(value); err == nil {
+				value = unquoted
+			}
+		}
+		typed, err := column.convert(value)
+		if err != nil {
+			log.Printf("can't decode cached %s column: %s", column.Name, err)
+			continue
+		}
+		sess[column.Name] = typed
+	}
+	return sess
+}
+
 func (s *Saver) Close() error {
-	// Close connection to Redshift
-	return nil
+	if s.buffer == nil {
+		return nil
+	}
+	return s.buffer.Close()
 }
 
 var reservedWords = []string{"ALL", "ANALYSE", "ANALYZE", "AND", "ANY", "ARRAY", "AS", "ASC", "ASYMMETRIC", "BOTH", "CASE", "CAST", "CHECK", "COLLATE", "COLUMN", "CONSTRAINT", "CREATE", "CROSS", "CURRENT_CATALOG", "CURRENT_DATE", "CURRENT_ROLE", "CURRENT_SCHEMA", "CURRENT_TIME", "CURRENT_TIMESTAMP", "CURRENT_USER", "DEFAULT", "DEFERRABLE", "DESC", "DISTINCT", "DO", "ELSE", "END", "EXCEPT", "FALSE", "FOR", "FOREIGN", "FREEZE", "FROM", "FULL", "GRANT", "GROUP", "HAVING", "ILIKE", "IN", "INITIALLY", "INNER", "INTERSECT", "INTO", "IS", "ISNULL", "JOIN", "LEADING", "LEFT", "LIKE", "LIMIT", "LOCALTIME", "LOCALTIMESTAMP", "NATURAL", "NEW", "NOT", "NOTNULL", "NULL", "OFF", "OFFSET", "OLD", "ON", "ONLY", "OR", "ORDER", "OUTER", "OVERLAPS", "PLACING", "PRIMARY", "REFERENCES", "RETURNING", "RIGHT", "SELECT", "SESSION_USER", "SIMILAR", "SOME", "SYMMETRIC", "TABLE", "THEN", "TO", "TRAILING", "TRUE", "UNION", "UNIQUE", "USER", "USING", "VERBOSE", "WHEN", "WHERE", "WINDOW", "WITH"}
@@ -0,0 +1,180 @@
+package connector
+
+import (
+	"errors"
+	"testing"
+
+	"openreplay/backend/internal/config/connector"
+	"openreplay/backend/pkg/messages"
+	"openreplay/backend/pkg/sessions"
+)
+
+type testSessions struct {
+	sessions.Sessions
+	cache map[uint64]map[string]string
+}
+
+func (s *testSessions) AddCached(sessionID uint64, data map[string]string) error {
+	s.cache[sessionID] = data
+	return nil
+}
+
+func (s *testSessions) GetCached(sessionID uint64) (map[string]string, error) {
+	if data, ok := s.cache[sessionID]; ok {
+		return data, nil
+	}
+	return nil, sessions.ErrSessionNotFound
+}
+
+// testDB saves rows and fails after the write if it's asked to
+type testDB struct {
+	events    []Row
+	sessions  []Row
+	failAfter bool
+}
+
+func (d *testDB) InsertEvents(batch []Row) error {
+	d.events = append(d.events, batch...)
+	if d.failAfter {
+		return errors.New("connection is lost")
+	}
+	return nil
+}
+
+func (d *testDB) InsertSessions(batch []Row) error {
+	d.sessions = append(d.sessions, batch...)
+	return nil
+}
+
+func (d *testDB) Close() error {
+	return nil
+}
+
+func (d *testDB) SavedEvents(sessionIDs []uint64) (map[uint64]bool, error) {
+	ids := make(map[uint64]bool)
+	for _, event := range d.events {
+		ids[event.uint("event_id")] = true
+	}
+	return ids, nil
+}
+
+func (d *testDB) SavedSessions(sessionIDs []uint64) (map[uint64]bool, error) {
+	ids := make(map[uint64]bool)
+	for _, sess := range d.sessions {
+		ids[sess.uint("sessionid")] = true
+	}
+	return ids, nil
+}
+
+// sized encodes message in the format of the 1st version of the batch protocol
+func sized(msg messages.Message) []byte {
+	data := msg.Encode()
+	size := len(data) - 1
+	return append([]byte{data[0], byte(size), byte(size >> 8), byte(size >> 16)}, data[1:]...)
+}
+
+func batch(msgs ...messages.Message) []byte {
+	data := (&messages.BatchMetadata{Version: 1, Timestamp: 1}).Encode()
+	for _, msg := range msgs {
+		data = append(data, sized(msg)...)
+	}
+	return data
+}
+
+func TestExactlyOnce(t *testing.T) {
+	const sessionID = 5
+	cfg := &connector.Config{}
+	tables, err := NewTables(cfg)
+	if err != nil {
+		t.Fatalf("can't init tables: %s", err)
+	}
+	dir := t.TempDir()
+	db := &testDB{}
+	sessModule := &testSessions{cache: make(map[uint64]map[string]string)}
+	first := batch(&messages.ConsoleLog{Level: "info", Value: "first"}, &messages.MouseClick{ID: 1, Label: "buy"})
+	second := batch(&messages.ConsoleLog{Level: "info", Value: "second"})
+
+	start := func() (*Saver, messages.MessageIterator) {
+		buffer, err := NewFileBuffer(dir, tables)
+		if err != nil {
+			t.Fatalf("can't init buffer: %s", err)
+		}
+		s := New(cfg, db, tables, buffer, sessModule, nil)
+		return s, messages.NewMessageIterator(s.Handle, s.MessageFilter(), true)
+	}
+
+	s, iterator := start()
+	iterator.Iterate(first, messages.NewBatchInfo(sessionID, "raw", 10, 0, 1))
+	if err := s.Commit(); err != nil {
+		t.Fatalf("can't commit: %s", err)
+	}
+	// The second batch is written, but the connection fails before the queue commit
+	iterator.Iterate(second, messages.NewBatchInfo(sessionID, "raw", 11, 0, 2))
+	db.failAfter = true
+	if err := s.Commit(); err == nil {
+		t.Fatalf("Expected commit error")
+	}
+	s.Close()
+
+	// Restart: the queue delivers both batches again
+	db.failAfter = false
+	s, iterator = start()
+	iterator.Iterate(first, messages.NewBatchInfo(sessionID, "raw", 10, 0, 1))
+	iterator.Iterate(second, messages.NewBatchInfo(sessionID, "raw", 11, 0, 2))
+	if err := s.Commit(); err != nil {
+		t.Fatalf("can't commit: %s", err)
+	}
+	s.Close()
+
+	values := make(map[string]int)
+	for _, event := range db.events {
+		values[event["consolelog_value"].(string)]++
+	}
+	if len(db.events) != 2 || values["first"] != 1 || values["second"] != 1 {
+		t.Errorf("Expected every event once, got %v", values)
+	}
+	if clicks := s.sessions[sessionID].uint("clicks_count"); clicks != 1 {
+		t.Errorf("Expected 1 click in the session, got %d", clicks)
+	}
+}
+
+func TestFileBuffer(t *testing.T) {
+	tables, err := NewTables(&connector.Config{})
+	if err != nil {
+		t.Fatalf("can't init tables: %s", err)
+	}
+	dir := t.TempDir()
+	buffer, err := NewFileBuffer(dir, tables)
+	if err != nil {
+		t.Fatalf("can't init buffer: %s", err)
+	}
+	if _, _, err := buffer.Load(); err != nil {
+		t.Fatalf("can't load empty buffer: %s", err)
+	}
+	rows := map[uint64]Row{
+		1: {"sessionid": uint64(1), "user_os": "Linux", batchKeyPrefix + "raw": uint64(7)},
+		2: {"sessionid": uint64(2), "pages_count": uint64(3)},
+	}
+	if err := buffer.Save(rows, []uint64{2}, nil); err != nil {
+		t.Fatalf("can't save: %s", err)
+	}
+	if err := buffer.Save(nil, []uint64{}, []uint64{2}); err != nil {
+		t.Fatalf("can't save: %s", err)
+	}
+	buffer.Close()
+
+	if buffer, err = NewFileBuffer(dir, tables); err != nil {
+		t.Fatalf("can't init buffer: %s", err)
+	}
+	defer buffer.Close()
+	loaded, finished, err := buffer.Load()
+	if err != nil {
+		t.Fatalf("can't load: %s", err)
+	}
+	if len(loaded) != 1 || len(finished) != 0 {
+		t.Fatalf("Expected 1 session without finished ones, got %v, %v", loaded, finished)
+	}
+	if sess := loaded[1]; sess["user_os"] != "Linux" || sess.uint(batchKeyPrefix+"raw") != 7 {
+		t.Errorf("Wrong buffered session: %v", sess)
+	}
+}
@@ -0,0 +1,162 @@
+package connector
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	metrics "openreplay/backend/pkg/metrics/connector"
+)
+
+type ColumnType int
+
+const (
+	String ColumnType = iota
+	UInt64
+	Int64
+	Bool
+)
+
+type Column struct {
+	Name string
+	Type ColumnType
+}
+
+// Schema describes the columns of a connector table, the order is used by all sinks
+type Schema []Column
+
+func (s Schema) Names() []string {
+	names := make([]string, 0, len(s))
+	for _, column := range s {
+		names = append(names, column.Name)
+	}
+	return names
+}
+
+func (s Schema) index(name string) int {
+	for i, column := range s {
+		if column.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Row is a table row with typed values (uint64, int64, string or bool), missing columns are NULLs
+type Row map[string]interface{}
+
+func (r Row) missing(name string) bool {
+	value, ok := r[name]
+	return !ok || value == nil || value == ""
+}
+
+func (r Row) uint(name string) uint64 {
+	value, _ := Column{name, UInt64}.convert(r[name])
+	res, _ := value.(uint64)
+	return res
+}
+
+func (r Row) increment(name string) {
+	r[name] = r.uint(name) + 1
+}
+
+// convert casts the value to the column type. Message attributes may have another type than
+// the column of older schema versions, and values decoded from the cache are strings.
+func (c Column) convert(value interface{}) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+	if s, ok := value.(string); ok && s == "" && c.Type != String {
+		return nil, nil
+	}
+	switch c.Type {
+	case String:
+		switch v := value.(type) {
+		case string:
+			return v, nil
+		case uint64:
+			return strconv.FormatUint(v, 10), nil
+		case int64:
+			return strconv.FormatInt(v, 10), nil
+		case bool:
+			return strconv.FormatBool(v), nil
+		}
+	case UInt64:
+		switch v := value.(type) {
+		case uint64:
+			return v, nil
+		case int64:
+			if v >= 0 {
+				return uint64(v), nil
+			}
+		case string:
+			return strconv.ParseUint(v, 10, 64)
+		case bool:
+			if v {
+				return uint64(1), nil
+			}
+			return uint64(0), nil
+		}
+	case Int64:
+		switch v := value.(type) {
+		case int64:
+			return v, nil
+		case uint64:
+			return int64(v), nil
+		case string:
+			return strconv.ParseInt(v, 10, 64)
+		}
+	case Bool:
+		switch v := value.(type) {
+		case bool:
+			return v, nil
+		case uint64:
+			return v != 0, nil
+		case int64:
+			return v != 0, nil
+		case string:
+			return strconv.ParseBool(v)
+		}
+	}
+	return nil, fmt.Errorf("can't convert %T to the type of %s column", value, c.Name)
+}
+
+// Values returns typed row values in the schema order
+func (s Schema) Values(row Row) ([]interface{}, error) {
+	values := make([]interface{}, 0, len(s))
+	for _, column := range s {
+		value, err := column.convert(row[column.Name])
+		if err != nil {
+			return nil, fmt.Errorf("wrong value of %s column: %s", column.Name, err)
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// Rows returns typed values of the batch rows, rows with wrong values are dropped and counted in the metric
+func (s Schema) Rows(table string, batch []Row) [][]interface{} {
+	rows := make([][]interface{}, 0, len(batch))
+	for _, row := range batch {
+		values, err := s.Values(row)
+		if err != nil {
+			log.Printf("can't convert %s row, it's dropped: %s", table, err)
+			metrics.IncreaseDroppedRows(table)
+			continue
+		}
+		rows = append(rows, values)
+	}
+	return rows
+}
+
+// partitionDate returns the date of the row by the timestamp column (in milliseconds) for partitioned files
+func partitionDate(values []interface{}, timestampIndex int) string {
+	ts := time.Now()
+	if timestampIndex >= 0 {
+		if ms, ok := values[timestampIndex].(uint64); ok && ms != 0 {
+			ts = time.UnixMilli(int64(ms))
+		}
+	}
+	return ts.UTC().Format("2006-01-02")
+}
@@ -0,0 +1,325 @@
+package connector
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"openreplay/backend/internal/config/connector"
+)
+
+/*
+	Snowflake sink uploads parquet files (see Files) to object storage and loads them with COPY INTO
+	from an external stage which points to CONNECTOR_FILES_PREFIX of the bucket.
+	Statements are executed by SQL API with key pair authentication, so no driver is needed:
+	https://docs.snowflake.com/en/developer-guide/sql-api/index
+*/
+
+const (
+	snowflakeTimeout     = 2 * time.Minute
+	snowflakeTokenTTL    = time.Hour
+	snowflakePollTimeout = time.Second
+)
+
+type Snowflake struct {
+	*Files
+	cfg         *connector.Config
+	client      *http.Client
+	key         *rsa.PrivateKey
+	fingerprint string
+	token       string
+	tokenExp    time.Time
+}
+
+type snowflakeResponse struct {
+	Code               string      `json:"code"`
+	Message            string      `json:"message"`
+	StatementHandle    string      `json:"statementHandle"`
+	StatementStatusUrl string      `json:"statementStatusUrl"`
+	Data               [][]*string `json:"data"` // all values are strings
+	ResultSetMetaData  struct {
+		PartitionInfo []struct{} `json:"partitionInfo"`
+	} `json:"resultSetMetaData"`
+}
+
+func NewSnowflake(cfg *connector.Config, tables *Tables, uploader Uploader) (*Snowflake, error) {
+	switch {
+	case cfg.Snowflake.Account == "" || cfg.Snowflake.User == "":
+		return nil, errors.New("snowflake account or user is empty")
+	case cfg.Snowflake.Stage == "":
+		return nil, errors.New("snowflake stage is empty")
+	}
+	files, err := NewFiles(cfg, tables, uploader, "parquet")
+	if err != nil {
+		return nil, err
+	}
+	key, err := loadPrivateKey(cfg.Snowflake.PrivateKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("can't load snowflake private key: %s", err)
+	}
+	publicKey, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256(publicKey)
+	s := &Snowflake{
+		Files:       files,
+		cfg:         cfg,
+		client:      &http.Client{Timeout: snowflakeTimeout},
+		key:         key,
+		fingerprint: "SHA256:" + base64.StdEncoding.EncodeToString(hash[:]),
+	}
+	return s, nil
+}
+
+func loadPrivateKey(path string) (*rsa.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("not a RSA private key")
+	}
+	return rsaKey, nil
+}
+
+// jwt returns the key pair authentication token, it's regenerated a few minutes before expiration
+func (s *Snowflake) jwt() (string, error) {
+	now := time.Now()
+	if s.token != "" && now.Add(5*time.Minute).Before(s.tokenExp) {
+		return s.token, nil
+	}
+	// Account identifier without region and cloud parts
+	account := strings.ToUpper(strings.SplitN(s.cfg.Snowflake.Account, ".", 2)[0])
+	user := strings.ToUpper(s.cfg.Snowflake.User)
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss": fmt.Sprintf("%s.%s.%s", account, user, s.fingerprint),
+		"sub": fmt.Sprintf("%s.%s", account, user),
+		"iat": now.Unix(),
+		"exp": now.Add(snowflakeTokenTTL).Unix(),
+	})
+	payload := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	hash := sha256.Sum256([]byte(payload))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, hash[:])
+	if err != nil {
+		return "", err
+	}
+	s.token = payload + "." + base64.RawURLEncoding.EncodeToString(signature)
+	s.tokenExp = now.Add(snowflakeTokenTTL)
+	return s.token, nil
+}
+
+func (s *Snowflake) request(method, path string, body []byte) (int, *snowflakeResponse, error) {
+	token, err := s.jwt()
+	if err != nil {
+		return 0, nil, err
+	}
+	url := fmt.Sprintf("https://%s.snowflakecomputing.com%s", s.cfg.Snowflake.Account, path)
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("X-Snowflake-Authorization-Token-Type", "KEYPAIR_JWT")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, nil, err
+	}
+	res := &snowflakeResponse{}
+	if err := json.Unmarshal(data, res); err != nil {
+		return resp.StatusCode, nil, fmt.Errorf("can't parse response with code %d: %s", resp.StatusCode, err)
+	}
+	return resp.StatusCode, res, nil
+}
+
+func (s *Snowflake) exec(statement string) error {
+	_, err := s.query(statement)
+	return err
+}
+
+// query runs the statement and waits for the result
+func (s *Snowflake) query(statement string) (*snowflakeResponse, error) {
+	request := map[string]interface{}{
+		"statement": statement,
+		"timeout":   int(snowflakeTimeout.Seconds()),
+	}
+	// User's defaults are used for empty values
+	for key, value := range map[string]string{
+		"database":  s.cfg.Snowflake.Database,
+		"schema":    s.cfg.Snowflake.Schema,
+		"warehouse": s.cfg.Snowflake.Warehouse,
+		"role":      s.cfg.Snowflake.Role,
+	} {
+		if value != "" {
+			request[key] = value
+		}
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	code, res, err := s.request("POST", "/api/v2/statements", body)
+	deadline := time.Now().Add(snowflakeTimeout)
+	for err == nil && code == http.StatusAccepted && time.Now().Before(deadline) {
+		time.Sleep(snowflakePollTimeout)
+		code, res, err = s.request("GET", res.StatementStatusUrl, nil)
+	}
+	switch {
+	case err != nil:
+		return nil, err
+	case code == http.StatusOK:
+		return res, nil
+	case code == http.StatusAccepted:
+		return nil, fmt.Errorf("snowflake statement %s is still running", res.StatementHandle)
+	}
+	return nil, fmt.Errorf("snowflake respond with the code %d: %s %s", code, res.Code, res.Message)
+}
+
+func (s *Snowflake) copy(table string, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	files := make([]string, 0, len(keys))
+	for _, key := range keys {
+		files = append(files, "'"+key+"'")
+	}
+	return s.exec(fmt.Sprintf("COPY INTO %s FROM @%s FILES = (%s) FILE_FORMAT = (TYPE = PARQUET) MATCH_BY_COLUMN_NAME = CASE_INSENSITIVE",
+		table, s.cfg.Snowflake.Stage, strings.Join(files, ", ")))
+}
+
+func (s *Snowflake) InsertEvents(batch []Row) error {
+	keys, err := s.write(s.cfg.EventsTableName, s.tables.Events, "received_at", batch)
+	if err != nil {
+		return err
+	}
+	if err := s.copy(s.cfg.EventsTableName, keys); err != nil {
+		return err
+	}
+	log.Printf("events batch of %d events is successfully saved", len(batch))
+	return nil
+}
+
+func (s *Snowflake) InsertSessions(batch []Row) error {
+	keys, err := s.write(s.cfg.SessionsTableName, s.tables.Sessions, "session_start_timestamp", batch)
+	if err != nil {
+		return err
+	}
+	if err := s.copy(s.cfg.SessionsTableName, keys); err != nil {
+		return err
+	}
+	log.Printf("sessions batch of %d sessions is successfully saved", len(batch))
+	return nil
+}
+
+// Schema migrations (see Migrator)
+
+var snowflakeTypes = map[ColumnType]string{String: "VARCHAR", UInt64: "NUMBER(20, 0)", Int64: "NUMBER(19, 0)", Bool: "BOOLEAN"}
+
+func (s *Snowflake) SchemaVersion() (int, error) {
+	if err := s.exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (version INTEGER NOT NULL, applied_at TIMESTAMP_NTZ)", schemaVersionTable)); err != nil {
+		return 0, err
+	}
+	res, err := s.query(fmt.Sprintf("SELECT COALESCE(MAX(version), 0) FROM %s", schemaVersionTable))
+	if err != nil {
+		return 0, err
+	}
+	if len(res.Data) == 0 || len(res.Data[0]) == 0 || res.Data[0][0] == nil {
+		return 0, nil
+	}
+	return strconv.Atoi(*res.Data[0][0])
+}
+
+func (s *Snowflake) AddColumns(table string, columns Schema) error {
+	if len(columns) == 0 {
+		return nil
+	}
+	definitions := make([]string, 0, len(columns))
+	for _, column := range columns {
+		definitions = append(definitions, column.Name+" "+snowflakeTypes[column.Type])
+	}
+	if err := s.exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s)", table, definitions[0])); err != nil {
+		return err
+	}
+	return s.exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s", table, strings.Join(definitions, ", ")))
+}
+
+func (s *Snowflake) SetSchemaVersion(version int) error {
+	return s.exec(fmt.Sprintf("INSERT INTO %s (version, applied_at) VALUES (%d, CURRENT_TIMESTAMP())", schemaVersionTable, version))
+}
+
+// Deduplication (see Deduplicator)
+
+func (s *Snowflake) ids(statement string) (map[uint64]bool, error) {
+	res, err := s.query(statement)
+	if err != nil {
+		return nil, err
+	}
+	data := res.Data
+	// Big results are split into partitions, the first one is in the response
+	for partition := 1; partition < len(res.ResultSetMetaData.PartitionInfo); partition++ {
+		path := fmt.Sprintf("/api/v2/statements/%s?partition=%d", res.StatementHandle, partition)
+		code, part, err := s.request("GET", path, nil)
+		if err != nil {
+			return nil, err
+		}
+		if code != http.StatusOK {
+			return nil, fmt.Errorf("snowflake respond with the code %d: %s %s", code, part.Code, part.Message)
+		}
+		data = append(data, part.Data...)
+	}
+	ids := make(map[uint64]bool, len(data))
+	for _, row := range data {
+		if len(row) == 0 || row[0] == nil {
+			continue
+		}
+		id, err := strconv.ParseUint(*row[0], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		ids[id] = true
+	}
+	return ids, nil
+}
+
+func (s *Snowflake) SavedEvents(sessionIDs []uint64) (map[uint64]bool, error) {
+	return s.ids(savedEventsSQL(s.cfg.EventsTableName, sessionIDs))
+}
+
+func (s *Snowflake) SavedSessions(sessionIDs []uint64) (map[uint64]bool, error) {
+	return s.ids(savedSessionsSQL(s.cfg.SessionsTableName, sessionIDs))
+}
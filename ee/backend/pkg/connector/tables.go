@@ -0,0 +1,124 @@
+package connector
+
+import (
+	"fmt"
+	"strings"
+
+	"openreplay/backend/internal/config/connector"
+)
+
+// Tables are the connector tables with the columns selected for the customer
+type Tables struct {
+	Sessions      Schema
+	Events        Schema
+	sessionsTable string
+	eventsTable   string
+	eventTypes    map[int]bool
+}
+
+/*
+NewTables selects columns of all schema versions:
+
+	EVENT_LEVEL - normal (default) or detailed, the last one adds columns of page, input, click, etc. events
+	CONNECTOR_SESSION_COLUMNS, CONNECTOR_EVENT_COLUMNS - comma separated columns to save, all by default
+	CONNECTOR_EXCLUDE_COLUMNS - comma separated columns of both tables to skip
+
+Required columns (session id, event id, receiving time and batch order) are always saved.
+*/
+func NewTables(cfg *connector.Config) (*Tables, error) {
+	detailed := false
+	switch strings.ToLower(cfg.EventLevel) {
+	case "", "normal":
+	case "detailed":
+		detailed = true
+	default:
+		return nil, fmt.Errorf("unknown event level: %s", cfg.EventLevel)
+	}
+	var sessions, events Schema
+	for _, m := range migrations {
+		sessions = append(sessions, m.Sessions...)
+		events = append(events, m.Events...)
+		if detailed {
+			events = append(events, m.DetailedEvents...)
+		}
+	}
+	exclude := make(map[string]bool, len(cfg.ExcludeColumns))
+	for _, name := range cfg.ExcludeColumns {
+		name = strings.TrimSpace(name)
+		if sessions.index(name) < 0 && events.index(name) < 0 {
+			return nil, fmt.Errorf("unknown column to exclude: %s", name)
+		}
+		exclude[name] = true
+	}
+	var err error
+	if sessions, err = selectColumns(sessions, cfg.SessionColumns, exclude, requiredSessionColumns); err != nil {
+		return nil, fmt.Errorf("wrong sessions columns: %s", err)
+	}
+	if events, err = selectColumns(events, cfg.EventColumns, exclude, requiredEventColumns); err != nil {
+		return nil, fmt.Errorf("wrong events columns: %s", err)
+	}
+	t := &Tables{
+		Sessions:      sessions,
+		Events:        events,
+		sessionsTable: cfg.SessionsTableName,
+		eventsTable:   cfg.EventsTableName,
+		eventTypes:    make(map[int]bool),
+	}
+	for _, typeID := range eventMessages {
+		for _, column := range messageColumns[typeID] {
+			if events.index(column.Name) >= 0 {
+				t.eventTypes[typeID] = true
+				break
+			}
+		}
+	}
+	return t, nil
+}
+
+func selectColumns(all Schema, include []string, exclude map[string]bool, required []string) (Schema, error) {
+	included := make(map[string]bool, len(include)+len(required))
+	for _, name := range include {
+		name = strings.TrimSpace(name)
+		if all.index(name) < 0 {
+			return nil, fmt.Errorf("unknown column: %s", name)
+		}
+		included[name] = true
+	}
+	isRequired := make(map[string]bool, len(required))
+	for _, name := range required {
+		isRequired[name] = true
+	}
+	res := make(Schema, 0, len(all))
+	for _, column := range all {
+		if isRequired[column.Name] || (len(included) == 0 || included[column.Name]) && !exclude[column.Name] {
+			res = append(res, column)
+		}
+	}
+	return res, nil
+}
+
+// filter returns the columns which are selected in the schema
+func (s Schema) filter(columns Schema) Schema {
+	res := make(Schema, 0, len(columns))
+	for _, column := range columns {
+		if s.index(column.Name) >= 0 {
+			res = append(res, column)
+		}
+	}
+	return res
+}
+
+// EventMessages returns message types which are saved to the events table
+func (t *Tables) EventMessages() []int {
+	types := make([]int, 0, len(t.eventTypes))
+	for _, typeID := range eventMessages {
+		if t.eventTypes[typeID] {
+			types = append(types, typeID)
+		}
+	}
+	return types
+}
+
+func (t *Tables) hasEvent(typeID int) bool {
+	return t.eventTypes[typeID]
+}
@@ -0,0 +1,141 @@
+package connector
+
+import (
+	"testing"
+
+	"openreplay/backend/internal/config/connector"
+	"openreplay/backend/pkg/messages"
+)
+
+func TestColumnsSelection(t *testing.T) {
+	cfg := &connector.Config{
+		EventLevel:     "normal",
+		SessionColumns: []string{"user_country", "pages_count"},
+		ExcludeColumns: []string{"networkrequest_request", "networkrequest_response"},
+	}
+	tables, err := NewTables(cfg)
+	if err != nil {
+		t.Fatalf("can't init tables: %s", err)
+	}
+	if names := tables.Sessions.Names(); len(names) != 3 || names[0] != "sessionid" {
+		t.Errorf("Wrong session columns: %v", names)
+	}
+	if tables.Events.index("networkrequest_response") >= 0 || tables.Events.index("networkrequest_url") < 0 {
+		t.Errorf("Wrong event columns: %v", tables.Events.Names())
+	}
+	if tables.hasEvent(messages.MsgPageEvent) || !tables.hasEvent(messages.MsgNetworkRequest) {
+		t.Errorf("Wrong event messages: %v", tables.EventMessages())
+	}
+
+	cfg.EventLevel = "detailed"
+	if tables, err = NewTables(cfg); err != nil {
+		t.Fatalf("can't init tables: %s", err)
+	}
+	if !tables.hasEvent(messages.MsgPageEvent) || tables.Events.index("pageevent_url") < 0 {
+		t.Errorf("Expected page events on detailed level: %v", tables.EventMessages())
+	}
+
+	cfg.EventColumns = []string{"unknown_column"}
+	if _, err := NewTables(cfg); err == nil {
+		t.Errorf("Expected error for unknown column")
+	}
+}
+
+type testMigrator struct {
+	version int
+	columns map[string][]string
+}
+
+func (m *testMigrator) SchemaVersion() (int, error) {
+	return m.version, nil
+}
+
+func (m *testMigrator) AddColumns(table string, columns Schema) error {
+	m.columns[table] = append(m.columns[table], columns.Names()...)
+	return nil
+}
+
+func (m *testMigrator) SetSchemaVersion(version int) error {
+	m.version = version
+	return nil
+}
+
+func TestMigrate(t *testing.T) {
+	cfg := &connector.Config{
+		SessionsTableName: "sessions",
+		EventsTableName:   "events",
+		ExcludeColumns:    []string{"rev_id"},
+	}
+	tables, err := NewTables(cfg)
+	if err != nil {
+		t.Fatalf("can't init tables: %s", err)
+	}
+	db := &testMigrator{version: 1, columns: make(map[string][]string)}
+	if err := Migrate(db, tables); err != nil {
+		t.Fatalf("can't migrate: %s", err)
+	}
+	if db.version != SchemaVersion() {
+		t.Errorf("Expected version %d, got %d", SchemaVersion(), db.version)
+	}
+	// Columns are created in the version order
+	if len(db.columns["sessions"]) != len(tables.Sessions) || db.columns["sessions"][0] != "sessionid" {
+		t.Errorf("Wrong session columns: %v", db.columns["sessions"])
+	}
+	for _, name := range db.columns["sessions"] {
+		if name == "rev_id" {
+			t.Errorf("Excluded column is created")
+		}
+	}
+	if last := db.columns["events"]; last[len(last)-1] != "event_id" {
+		t.Errorf("Wrong event columns: %v", last)
+	}
+}
+
+func TestEventRow(t *testing.T) {
+	tables, err := NewTables(&connector.Config{})
+	if err != nil {
+		t.Fatalf("can't init tables: %s", err)
+	}
+	s := &Saver{tables: tables}
+	msg := &messages.IssueEvent{MessageID: 42, Type: "click_rage", Context: "a|b\n\"c\""}
+	msg.SetSessionID(7)
+	event := s.handleEvent(msg)
+	if event == nil {
+		t.Fatalf("Expected issue event row")
+	}
+	values, err := tables.Events.Values(event)
+	if err != nil {
+		t.Fatalf("can't get values: %s", err)
+	}
+	row := make(map[string]interface{})
+	for i, column := range tables.Events {
+		row[column.Name] = values[i]
+	}
+	// Message id column has string type since the first version
+	if row["issueevent_message_id"] != "42" || row["issueevent_context"] != msg.Context || row["sessionid"] != uint64(7) {
+		t.Errorf("Wrong event row: %v", row)
+	}
+	if row["consolelog_value"] != nil {
+		t.Errorf("Expected null for columns of another message")
+	}
+}
+
+func TestSessionCache(t *testing.T) {
+	tables, err := NewTables(&connector.Config{})
+	if err != nil {
+		t.Fatalf("can't init tables: %s", err)
+	}
+	s := &Saver{tables: tables}
+	sess := Row{"sessionid": uint64(1), "user_os": `Mac "OS"`, "pages_count": uint64(3)}
+	decoded := decodeSession(s.tables, encodeSession(sess))
+	for column, value := range sess {
+		if decoded[column] != value {
+			t.Errorf("Wrong %s after cache: %v", column, decoded[column])
+		}
+	}
+	// Values cached by older connectors are quoted
+	legacy := decodeSession(s.tables, map[string]string{"sessionid": "1", "user_os": `"Mac \"OS\""`, "pages_count": "3"})
+	if legacy["user_os"] != `Mac "OS"` || legacy["pages_count"] != uint64(3) {
+		t.Errorf("Wrong legacy session: %v", legacy)
+	}
+}
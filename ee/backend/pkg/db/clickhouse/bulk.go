@@ -4,10 +4,14 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"openreplay/backend/pkg/metrics/database"
+	"syscall"
 	"time"
 
+	"github.com/ClickHouse/clickhouse-go/v2"
 	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
 )
 
@@ -17,15 +21,15 @@ type Bulk interface {
 }
 
 type bulkImpl struct {
-	conn   driver.Conn
+	sender *sender
 	table  string
 	query  string
 	values [][]interface{}
 }
 
-func NewBulk(conn driver.Conn, table, query string) (Bulk, error) {
+func newBulk(sender *sender, table, query string) (Bulk, error) {
 	switch {
-	case conn == nil:
+	case sender == nil || sender.conn == nil:
 		return nil, errors.New("clickhouse connection is empty")
 	case table == "":
 		return nil, errors.New("table is empty")
@@ -33,7 +37,7 @@ func NewBulk(conn driver.Conn, table, query string) (Bulk, error) {
 		return nil, errors.New("query is empty")
 	}
 	return &bulkImpl{
-		conn:   conn,
+		sender: sender,
 		table:  table,
 		query:  query,
 		values: make([][]interface{}, 0),
@@ -46,22 +50,207 @@ func (b *bulkImpl) Append(args ...interface{}) error {
 }
 
 func (b *bulkImpl) Send() error {
-	start := time.Now()
-	batch, err := b.conn.PrepareBatch(context.Background(), b.query)
-	if err != nil {
-		return fmt.Errorf("can't create new batch: %s", err)
-	}
-	for _, set := range b.values {
-		if err := batch.Append(set...); err != nil {
-			log.Printf("can't append value set to batch, err: %s", err)
-			log.Printf("failed query: %s", b.query)
-		}
+	if len(b.values) == 0 {
+		return nil
 	}
-	err = batch.Send()
-	// Save bulk metrics
-	database.RecordBulkElements(float64(len(b.values)), "ch", b.table)
-	database.RecordBulkInsertDuration(float64(time.Now().Sub(start).Milliseconds()), "ch", b.table)
+	err := b.sender.send(b.table, b.query, b.values)
 	// Prepare values slice for a new data
 	b.values = make([][]interface{}, 0)
 	return err
 }
+
+// Stop rejecting rows one by one if the whole batch doesn't match the table
+const maxRejectedRows = 100
+
+// Codes of clickhouse exceptions which could disappear after a while
+var transientCodes = map[int32]bool{
+	159: true, // TIMEOUT_EXCEEDED
+	164: true, // READONLY
+	202: true, // TOO_MANY_SIMULTANEOUS_QUERIES
+	209: true, // SOCKET_TIMEOUT
+	210: true, // NETWORK_ERROR
+	241: true, // MEMORY_LIMIT_EXCEEDED
+	242: true, // TABLE_IS_READ_ONLY
+	252: true, // TOO_MANY_PARTS
+	279: true, // ALL_CONNECTION_TRIES_FAILED
+	319: true, // UNKNOWN_STATUS_OF_INSERT
+	999: true, // KEEPER_EXCEPTION
+}
+
+// isTransient returns true only for network errors, timeouts and clickhouse exceptions which could disappear
+// after a while, all other errors won't be fixed by repeating the same insert
+func isTransient(err error) bool {
+	var exception *clickhouse.Exception
+	if errors.As(err, &exception) {
+		return transientCodes[exception.Code]
+	}
+	var netErr net.Error
+	switch {
+	case errors.As(err, &netErr),
+		errors.Is(err, context.DeadlineExceeded),
+		errors.Is(err, clickhouse.ErrAcquireConnTimeout),
+		errors.Is(err, io.EOF),
+		errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, syscall.ECONNREFUSED),
+		errors.Is(err, syscall.ECONNRESET),
+		errors.Is(err, syscall.EPIPE):
+		return true
+	}
+	return false
+}
+
+// sender inserts batches with retries and spills them to disk if clickhouse is unavailable.
+// It's used by the connector's worker only, so it isn't safe for concurrent use.
+// The worker never sleeps between attempts: after a failed insert the next one is postponed till retryAt,
+// and batches which come earlier are spilled (or dropped if spilling is disabled).
+type sender struct {
+	conn       driver.Conn
+	retries    int
+	backoff    time.Duration
+	maxBackoff time.Duration
+	spill      *spill    // nil if spilling is disabled
+	failures   int       // number of failed inserts in a row
+	retryAt    time.Time // clickhouse isn't used till this time after a failed insert
+}
+
+func (s *sender) delay(attempt int) time.Duration {
+	delay := s.backoff
+	for i := 1; i < attempt && delay < s.maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > s.maxBackoff {
+		delay = s.maxBackoff
+	}
+	return delay
+}
+
+func (s *sender) available() bool {
+	return !time.Now().Before(s.retryAt)
+}
+
+func (s *sender) succeeded() {
+	s.failures = 0
+	s.retryAt = time.Time{}
+}
+
+func (s *sender) failed() {
+	s.failures++
+	s.retryAt = time.Now().Add(s.delay(s.failures))
+}
+
+func (s *sender) send(table, query string, values [][]interface{}) error {
+	var err error
+	switch {
+	case s.spill != nil && s.spill.pending():
+		// Keep the order of inserts (newer rows of replacing tables win) until spilled batches are inserted
+		return s.spillBatch(table, query, values)
+	case !s.available():
+		err = errors.New("waiting for the next retry")
+	default:
+		// Next nodes of the cluster are used by the driver if the current one fails, so retries go without delays
+		for attempt := 0; ; attempt++ {
+			if err = s.insert(table, query, values); err == nil {
+				s.succeeded()
+				return nil
+			}
+			database.IncreaseBulkInsertErrors("ch", table)
+			if !isTransient(err) {
+				return fmt.Errorf("can't insert %s batch: %w", table, err)
+			}
+			if attempt >= s.retries {
+				break
+			}
+			log.Printf("can't insert %s batch, attempt: %d, err: %s", table, attempt+1, err)
+			database.IncreaseBulkRetries("ch", table)
+		}
+		s.failed()
+	}
+	if s.spill == nil {
+		return fmt.Errorf("clickhouse is unavailable, %d rows of %s are lost: %w", len(values), table, err)
+	}
+	log.Printf("clickhouse is unavailable, spill %s batch: %s", table, err)
+	return s.spillBatch(table, query, values)
+}
+
+func (s *sender) spillBatch(table, query string, values [][]interface{}) error {
+	if err := s.spill.save(table, query, values); err != nil {
+		return fmt.Errorf("can't spill %d rows of %s: %s", len(values), table, err)
+	}
+	database.IncreaseBulkSpilledRows(float64(len(values)), "ch", table)
+	return nil
+}
+
+// insert sends the batch once, rows which can't be converted to the table's types are skipped
+func (s *sender) insert(table, query string, values [][]interface{}) error {
+	start := time.Now()
+	rejected := make(map[int]bool)
+	for {
+		batch, err := s.conn.PrepareBatch(context.Background(), query)
+		if err != nil {
+			return fmt.Errorf("can't create new batch: %w", err)
+		}
+		failed := -1
+		for i, set := range values {
+			if rejected[i] {
+				continue
+			}
+			if err := batch.Append(set...); err != nil {
+				// The batch is broken after the failed append, so it's created again without the row
+				log.Printf("can't append value set to %s batch, err: %s", table, err)
+				failed = i
+				break
+			}
+		}
+		if failed < 0 {
+			if len(rejected) > 0 {
+				database.IncreaseBulkRejectedRows(float64(len(rejected)), "ch", table)
+			}
+			if err := batch.Send(); err != nil {
+				return err
+			}
+			break
+		}
+		rejected[failed] = true
+		if len(rejected) > maxRejectedRows {
+			database.IncreaseBulkRejectedRows(float64(len(values)), "ch", table)
+			return fmt.Errorf("too many rejected rows, failed query: %s", query)
+		}
+	}
+	// Save bulk metrics
+	database.RecordBulkElements(float64(len(values)-len(rejected)), "ch", table)
+	database.RecordBulkInsertDuration(float64(time.Now().Sub(start).Milliseconds()), "ch", table)
+	return nil
+}
+
+// resendSpilled inserts spilled batches in the original order until clickhouse fails again,
+// batches rejected by clickhouse are moved aside to not block the next ones
+func (s *sender) resendSpilled() {
+	for s.spill != nil && s.spill.pending() && s.available() {
+		header, values, err := s.spill.next()
+		if err != nil {
+			log.Printf("can't read spilled batch: %s", err)
+			if err := s.spill.failed(); err != nil {
+				log.Printf("can't mark spilled batch as failed: %s", err)
+			}
+			continue
+		}
+		if err := s.insert(header.Table, header.Query, values); err != nil {
+			database.IncreaseBulkInsertErrors("ch", header.Table)
+			if isTransient(err) {
+				log.Printf("clickhouse is still unavailable: %s", err)
+				s.failed()
+				return
+			}
+			log.Printf("can't insert spilled %s batch: %s", header.Table, err)
+			if err := s.spill.failed(); err != nil {
+				log.Printf("can't mark spilled batch as failed: %s", err)
+			}
+			continue
+		}
+		s.succeeded()
+		database.IncreaseBulkResentRows(float64(len(values)), "ch", header.Table)
+		if err := s.spill.done(); err != nil {
+			log.Printf("can't remove spilled batch: %s", err)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package clickhouse
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+)
+
+type testConn struct {
+	driver.Conn
+	err      error
+	badQuery string // query of unknown table
+	calls    int
+	rows     [][]interface{}
+}
+
+func (c *testConn) PrepareBatch(ctx context.Context, query string) (driver.Batch, error) {
+	c.calls++
+	if c.err != nil {
+		return nil, c.err
+	}
+	if query == c.badQuery {
+		return nil, fmt.Errorf("can't prepare batch: %w", &clickhouse.Exception{Code: 60, Name: "UNKNOWN_TABLE"})
+	}
+	return &testBatch{conn: c}, nil
+}
+
+type testBatch struct {
+	driver.Batch
+	conn *testConn
+	rows [][]interface{}
+}
+
+func (b *testBatch) Append(v ...interface{}) error {
+	if v[0] == "bad" {
+		return errors.New("converting string to UInt64 is unsupported")
+	}
+	b.rows = append(b.rows, v)
+	return nil
+}
+
+func (b *testBatch) Send() error {
+	b.conn.rows = append(b.conn.rows, b.rows...)
+	return nil
+}
+
+func TestSpillAndResend(t *testing.T) {
+	conn := &testConn{err: &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}}
+	s := &sender{conn: conn, retries: 2, backoff: time.Millisecond, maxBackoff: time.Millisecond}
+	dir := t.TempDir()
+	var err error
+	if s.spill, err = newSpill(dir, 0); err != nil {
+		t.Fatalf("can't init spill: %s", err)
+	}
+	label := "button"
+	now := time.Unix(1700000000, 0).UTC()
+	if err := s.send("clicks", "INSERT", [][]interface{}{
+		{uint64(1), uint16(2), now, &label, (*uint32)(nil), []*string{&label, nil}, true},
+		{"bad"},
+	}); err != nil {
+		t.Fatalf("Expected spilled batch, got: %s", err)
+	}
+	// Next batches are spilled without retries to keep the order of inserts
+	conn.err = nil
+	if err := s.send("pages", "INSERT", [][]interface{}{{uint64(3)}}); err != nil {
+		t.Fatalf("Expected spilled batch, got: %s", err)
+	}
+	if len(conn.rows) != 0 || len(s.spill.files) != 2 {
+		t.Fatalf("Expected 2 spilled batches, got %d", len(s.spill.files))
+	}
+
+	// Restart with the same directory
+	time.Sleep(2 * time.Millisecond)
+	if s.spill, err = newSpill(dir, 0); err != nil {
+		t.Fatalf("can't init spill: %s", err)
+	}
+	s.resendSpilled()
+	if s.spill.pending() || s.spill.size != 0 {
+		t.Errorf("Expected empty spill, got %d files", len(s.spill.files))
+	}
+	if len(conn.rows) != 2 {
+		t.Fatalf("Expected 2 inserted rows without rejected one, got %v", conn.rows)
+	}
+	row := conn.rows[0]
+	if row[0] != uint64(1) || row[1] != uint16(2) || !row[2].(time.Time).Equal(now) || *row[3].(*string) != label ||
+		row[4].(*uint32) != nil || row[5].([]*string)[1] != nil || row[6] != true {
+		t.Errorf("Wrong resent row: %v", row)
+	}
+	if conn.rows[1][0] != uint64(3) {
+		t.Errorf("Wrong order of resent batches: %v", conn.rows)
+	}
+}
+
+func TestSpillLimit(t *testing.T) {
+	conn := &testConn{err: &clickhouse.Exception{Code: 252, Name: "TOO_MANY_PARTS"}}
+	s := &sender{conn: conn, backoff: time.Millisecond, maxBackoff: time.Millisecond}
+	var err error
+	if s.spill, err = newSpill(t.TempDir(), 10); err != nil {
+		t.Fatalf("can't init spill: %s", err)
+	}
+	if err := s.send("clicks", "INSERT", [][]interface{}{{uint64(1)}}); err == nil {
+		t.Errorf("Expected error on spill limit")
+	}
+}
+
+func TestIsTransient(t *testing.T) {
+	for _, tc := range []struct {
+		err       error
+		transient bool
+	}{
+		{&clickhouse.Exception{Code: 252, Name: "TOO_MANY_PARTS"}, true},
+		{fmt.Errorf("can't create new batch: %w", &clickhouse.Exception{Code: 60, Name: "UNKNOWN_TABLE"}), false},
+		{fmt.Errorf("can't create new batch: %w", clickhouse.ErrAcquireConnTimeout), true},
+		{fmt.Errorf("can't create new batch: %w", context.DeadlineExceeded), true},
+		{clickhouse.ErrBatchAlreadySent, false},
+		{errors.New("too many rejected rows, failed query: INSERT"), false},
+	} {
+		if isTransient(tc.err) != tc.transient {
+			t.Errorf("Expected transient: %v for %s", tc.transient, tc.err)
+		}
+	}
+}
+
+func TestSendWithoutDelay(t *testing.T) {
+	conn := &testConn{err: context.DeadlineExceeded}
+	s := &sender{conn: conn, retries: 2, backoff: time.Hour, maxBackoff: time.Hour}
+	start := time.Now()
+	if err := s.send("clicks", "INSERT", [][]interface{}{{uint64(1)}}); err == nil {
+		t.Errorf("Expected error without spill")
+	}
+	if conn.calls != 3 {
+		t.Errorf("Expected 3 attempts, got %d", conn.calls)
+	}
+	// Next batches don't touch clickhouse till the backoff is over
+	if err := s.send("clicks", "INSERT", [][]interface{}{{uint64(2)}}); err == nil || conn.calls != 3 {
+		t.Errorf("Expected batch to be skipped during backoff, attempts: %d", conn.calls)
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("Send waits for retries")
+	}
+}
+
+func TestResendFailedSpill(t *testing.T) {
+	conn := &testConn{badQuery: "INSERT INTO unknown"}
+	dir := t.TempDir()
+	s := &sender{conn: conn}
+	var err error
+	if s.spill, err = newSpill(dir, 0); err != nil {
+		t.Fatalf("can't init spill: %s", err)
+	}
+	for _, query := range []string{conn.badQuery, "INSERT INTO pages"} {
+		if err := s.spill.save("pages", query, [][]interface{}{{uint64(1)}}); err != nil {
+			t.Fatalf("can't spill batch: %s", err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	// Unknown table isn't fixed by retries, so the batch is moved aside and the next one is inserted
+	s.resendSpilled()
+	if s.spill.pending() || len(conn.rows) != 1 {
+		t.Fatalf("Expected all batches to be processed, rows: %v", conn.rows)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 || !strings.HasSuffix(entries[0].Name(), spillFailedExt) {
+		t.Errorf("Expected failed batch in %s, got %v", filepath.Base(dir), entries)
+	}
+}
@@ -6,14 +6,12 @@ import (
 	"github.com/ClickHouse/clickhouse-go/v2"
 	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
 	"log"
+	"openreplay/backend/internal/config/common"
 	"openreplay/backend/pkg/db/types"
 	"openreplay/backend/pkg/hashid"
 	"openreplay/backend/pkg/messages"
 	"openreplay/backend/pkg/sessions"
 	"openreplay/backend/pkg/url"
-	"os"
-	"strings"
-	"time"
 
 	"openreplay/backend/pkg/license"
 )
@@ -56,45 +54,38 @@ func NewTask() *task {
 
 type connectorImpl struct {
 	conn       driver.Conn
+	sender     *sender
 	batches    map[string]Bulk //driver.Batch
 	workerTask chan *task
 	done       chan struct{}
 	finished   chan struct{}
 }
 
-func getEnv(key, fallback string) string {
-	if value, ok := os.LookupEnv(key); ok {
-		return value
-	}
-	return fallback
-}
-
-func NewConnector(url string) Connector {
+func NewConnector(cfg *common.Clickhouse) Connector {
 	license.CheckLicense()
-	url = strings.TrimPrefix(url, "tcp://")
-	url = strings.TrimSuffix(url, "/default")
-	userName := getEnv("CH_USERNAME", "default")
-	password := getEnv("CH_PASSWORD", "")
-	conn, err := clickhouse.Open(&clickhouse.Options{
-		Addr: []string{url},
-		Auth: clickhouse.Auth{
-			Database: "default",
-			Username: userName,
-			Password: password,
-		},
-		MaxOpenConns:    20,
-		MaxIdleConns:    15,
-		ConnMaxLifetime: 3 * time.Minute,
-		Compression: &clickhouse.Compression{
-			Method: clickhouse.CompressionLZ4,
-		},
-	})
+	opts, err := NewOptions(cfg)
+	if err != nil {
+		log.Fatalf("can't prepare clickhouse options: %s", err)
+	}
+	conn, err := clickhouse.Open(opts)
 	if err != nil {
 		log.Fatal(err)
 	}
+	s := &sender{
+		conn:       conn,
+		retries:    cfg.Retries,
+		backoff:    cfg.RetryBackoff,
+		maxBackoff: cfg.RetryMaxBackoff,
+	}
+	if cfg.SpillDir != "" {
+		if s.spill, err = newSpill(cfg.SpillDir, cfg.SpillSizeLimit); err != nil {
+			log.Fatalf("can't init clickhouse spill dir: %s", err)
+		}
+	}
 
 	c := &connectorImpl{
 		conn:       conn,
+		sender:     s,
 		batches:    make(map[string]Bulk, 13),
 		workerTask: make(chan *task, 1),
 		done:       make(chan struct{}),
@@ -105,7 +96,7 @@ func NewConnector(url string) Connector {
 }
 
 func (c *connectorImpl) newBatch(name, query string) error {
-	batch, err := NewBulk(c.conn, name, query)
+	batch, err := newBulk(c.sender, name, query)
 	if err != nil {
 		return fmt.Errorf("can't create new batch: %s", err)
 	}
@@ -167,6 +158,8 @@ func (c *connectorImpl) Stop() error {
 }
 
 func (c *connectorImpl) sendBulks(t *task) {
+	// Batches which weren't inserted while clickhouse was unavailable go first
+	c.sender.resendSpilled()
 	for _, b := range t.bulks {
 		if err := b.Send(); err != nil {
 			log.Printf("can't send batch: %s", err)
@@ -402,7 +395,8 @@ func (c *connectorImpl) InsertWebErrorEvent(session *sessions.Session, msg *type
 	}
 	// Check error source before insert to avoid panic from clickhouse lib
 	switch msg.Source {
-	case "js_exception", "bugsnag", "cloudwatch", "datadog", "elasticsearch", "newrelic", "rollbar", "sentry", "stackdriver", "sumologic":
+	case "js_exception", "bugsnag", "cloudwatch", "datadog", "elasticsearch", "newrelic", "rollbar", "sentry", "stackdriver", "sumologic",
+		"webhook", "otel", "loki", "opensearch", "http_logs":
 	default:
 		return fmt.Errorf("unknown error source: %s", msg.Source)
 	}
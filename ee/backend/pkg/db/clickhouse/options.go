@@ -0,0 +1,87 @@
+package clickhouse
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+
+	"openreplay/backend/internal/config/common"
+)
+
+// NewOptions returns connection options for all nodes of the cluster. With the in_order strategy
+// the driver connects to the first available node, so the next nodes are used for failover.
+func NewOptions(cfg *common.Clickhouse) (*clickhouse.Options, error) {
+	addrs := cfg.Addresses()
+	if len(addrs) == 0 {
+		return nil, errors.New("clickhouse address is empty")
+	}
+	userName, password := cfg.Credentials()
+	opts := &clickhouse.Options{
+		Addr: addrs,
+		Auth: clickhouse.Auth{
+			Database: cfg.DatabaseName(),
+			Username: userName,
+			Password: password,
+		},
+		DialTimeout:     cfg.DialTimeout,
+		MaxOpenConns:    20,
+		MaxIdleConns:    15,
+		ConnMaxLifetime: 3 * time.Minute,
+		Compression: &clickhouse.Compression{
+			Method: clickhouse.CompressionLZ4,
+		},
+	}
+	switch cfg.ConnOpenStrategy {
+	case "", "in_order":
+		opts.ConnOpenStrategy = clickhouse.ConnOpenInOrder
+	case "round_robin":
+		opts.ConnOpenStrategy = clickhouse.ConnOpenRoundRobin
+	default:
+		return nil, fmt.Errorf("unknown clickhouse connection open strategy: %s", cfg.ConnOpenStrategy)
+	}
+	if cfg.UseTLS {
+		tlsConfig, err := newTLSConfig(cfg)
+		if err != nil {
+			return nil, err
+		}
+		opts.TLS = tlsConfig
+	}
+	return opts, nil
+}
+
+// newTLSConfig returns tls config with the system CA pool + optional custom CA and client certificate
+func newTLSConfig(cfg *common.Clickhouse) (*tls.Config, error) {
+	roots, err := x509.SystemCertPool()
+	if err != nil {
+		log.Printf("can't load system cert pool: %s", err)
+		roots = x509.NewCertPool()
+	}
+	if cfg.CaCertFilePath != "" {
+		caCert, err := os.ReadFile(cfg.CaCertFilePath)
+		if err != nil {
+			return nil, fmt.Errorf("can't open cert file %s: %s", cfg.CaCertFilePath, err)
+		}
+		if !roots.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.CaCertFilePath)
+		}
+	}
+	tlsConfig := &tls.Config{
+		RootCAs:            roots,
+		InsecureSkipVerify: cfg.TLSSkipVerify,
+	}
+	if cfg.ClientCertPath != "" && cfg.ClientKeyPath != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.ClientCertPath, cfg.ClientKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("can't create x509 keypair from the client cert file %s and client key file %s: %s",
+				cfg.ClientCertPath, cfg.ClientKeyPath, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
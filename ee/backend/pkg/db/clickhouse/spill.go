@@ -0,0 +1,232 @@
+package clickhouse
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+	Spill keeps batches which can't be inserted while clickhouse is unavailable. Every batch is saved
+	into a separate file (header line with the table and the query + one line per row) and is inserted
+	again later in the same order. Values keep their go types, because the driver converts values by type.
+*/
+
+const (
+	spillExt       = ".jsonl"
+	spillFailedExt = ".failed" // batches rejected by clickhouse after spilling, kept for manual recovery
+)
+
+var errSpillLimit = errors.New("spill size limit is reached")
+
+type spillHeader struct {
+	Table string `json:"table"`
+	Query string `json:"query"`
+	Rows  int    `json:"rows"`
+}
+
+type spilledValue struct {
+	Type  string          `json:"t,omitempty"`
+	Value json.RawMessage `json:"v"`
+}
+
+// spillTypes are types of values which are appended to batches
+var spillTypes = make(map[string]reflect.Type)
+
+func init() {
+	for _, v := range []interface{}{
+		"", false, time.Time{},
+		uint8(0), uint16(0), uint32(0), uint64(0), int8(0), int16(0), int32(0), int64(0), int(0),
+		float32(0), float64(0),
+		(*string)(nil), (*uint8)(nil), (*uint16)(nil), (*uint32)(nil), (*uint64)(nil),
+		[]string{}, []*string{}, []uint64{},
+	} {
+		t := reflect.TypeOf(v)
+		spillTypes[t.String()] = t
+	}
+}
+
+func encodeValue(v interface{}) (*spilledValue, error) {
+	if v == nil {
+		return &spilledValue{Value: json.RawMessage("null")}, nil
+	}
+	t := reflect.TypeOf(v)
+	if _, ok := spillTypes[t.String()]; !ok {
+		return nil, fmt.Errorf("unsupported type of value: %s", t)
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return &spilledValue{Type: t.String(), Value: data}, nil
+}
+
+func decodeValue(v *spilledValue) (interface{}, error) {
+	if v.Type == "" {
+		return nil, nil
+	}
+	t, ok := spillTypes[v.Type]
+	if !ok {
+		return nil, fmt.Errorf("unsupported type of value: %s", v.Type)
+	}
+	value := reflect.New(t)
+	if err := json.Unmarshal(v.Value, value.Interface()); err != nil {
+		return nil, err
+	}
+	return value.Elem().Interface(), nil
+}
+
+type spill struct {
+	dir   string
+	limit int64
+	size  int64
+	files []string // pending batches in the insertion order
+}
+
+func newSpill(dir string, limit int64) (*spill, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s := &spill{dir: dir, limit: limit}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || entry.IsDir() {
+			continue
+		}
+		s.size += info.Size()
+		if strings.HasSuffix(entry.Name(), spillExt) {
+			s.files = append(s.files, filepath.Join(dir, entry.Name()))
+		}
+	}
+	// File names start with the timestamp
+	sort.Strings(s.files)
+	return s, nil
+}
+
+func (s *spill) pending() bool {
+	return len(s.files) > 0
+}
+
+func (s *spill) save(table, query string, values [][]interface{}) error {
+	data, err := json.Marshal(&spillHeader{Table: table, Query: query, Rows: len(values)})
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	row := make([]*spilledValue, 0)
+	for _, set := range values {
+		row = row[:0]
+		for _, v := range set {
+			value, err := encodeValue(v)
+			if err != nil {
+				return fmt.Errorf("can't encode %s row: %s", table, err)
+			}
+			row = append(row, value)
+		}
+		line, err := json.Marshal(row)
+		if err != nil {
+			return err
+		}
+		data = append(append(data, line...), '\n')
+	}
+	if s.limit > 0 && s.size+int64(len(data)) > s.limit {
+		return errSpillLimit
+	}
+	path := filepath.Join(s.dir, fmt.Sprintf("%020d-%s%s", time.Now().UnixNano(), table, spillExt))
+	tmpPath := path + ".tmp"
+	if err := writeFile(tmpPath, data); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+	s.size += int64(len(data))
+	s.files = append(s.files, path)
+	return nil
+}
+
+func writeFile(path string, data []byte) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// next returns the oldest spilled batch
+func (s *spill) next() (*spillHeader, [][]interface{}, error) {
+	file, err := os.Open(s.files[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 256*1024*1024)
+	header := &spillHeader{}
+	if !scanner.Scan() {
+		return nil, nil, fmt.Errorf("empty spill file %s", s.files[0])
+	}
+	if err := json.Unmarshal(scanner.Bytes(), header); err != nil {
+		return nil, nil, fmt.Errorf("can't decode header of %s: %s", s.files[0], err)
+	}
+	values := make([][]interface{}, 0, header.Rows)
+	for scanner.Scan() {
+		row := make([]*spilledValue, 0)
+		if err := json.Unmarshal(scanner.Bytes(), &row); err != nil {
+			return nil, nil, fmt.Errorf("can't decode row of %s: %s", s.files[0], err)
+		}
+		set := make([]interface{}, 0, len(row))
+		for _, v := range row {
+			value, err := decodeValue(v)
+			if err != nil {
+				return nil, nil, fmt.Errorf("can't decode row of %s: %s", s.files[0], err)
+			}
+			set = append(set, value)
+		}
+		values = append(values, set)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	if len(values) != header.Rows {
+		return nil, nil, fmt.Errorf("expected %d rows in %s, got %d", header.Rows, s.files[0], len(values))
+	}
+	return header, values, nil
+}
+
+// done removes the oldest batch after the insert
+func (s *spill) done() error {
+	path := s.files[0]
+	s.files = s.files[1:]
+	if info, err := os.Stat(path); err == nil {
+		s.size -= info.Size()
+	}
+	return os.Remove(path)
+}
+
+// failed keeps the oldest batch on disk, but doesn't insert it anymore
+func (s *spill) failed() error {
+	path := s.files[0]
+	s.files = s.files[1:]
+	return os.Rename(path, strings.TrimSuffix(path, spillExt)+spillFailedExt)
+}
@@ -10,8 +10,6 @@ import (
 	"openreplay/backend/pkg/queue/types"
 )
 
-const numberOfPartitions = 16
-
 type SessionFinder interface {
 	Find(sessionID, timestamp uint64)
 	Stop()
@@ -26,6 +24,7 @@ func (s *sessionFinderMock) Stop()                            {}
 // Finder implementation
 type sessionFinderImpl struct {
 	topicName        string
+	partitions       uint64
 	producerTimeout  int
 	producer         types.Producer
 	consumer         types.Consumer
@@ -46,8 +45,14 @@ func NewSessionFinder(cfg *config.Config, stg *storage.Storage) (SessionFinder,
 		return &sessionFinderMock{}, nil
 	}
 
+	// Failover topic has to be partitioned like raw topics, so a partition points to the storage with session files
+	partitions, err := queue.PartitionsNumber(cfg.TopicFailover)
+	if err != nil {
+		return nil, fmt.Errorf("can't get partitions number of failover topic: %s", err)
+	}
 	finder := &sessionFinderImpl{
 		topicName:        cfg.TopicFailover,
+		partitions:       partitions,
 		producerTimeout:  cfg.ProducerCloseTimeout,
 		notFoundSessions: make(map[uint64]struct{}),
 		storage:          stg,
@@ -94,7 +99,7 @@ func (s *sessionFinderImpl) findSession(sessionID, timestamp, partition uint64)
 	err := s.storage.Process(sessEnd)
 	if err == nil {
 		log.Printf("found session: %d in partition: %d, original: %d",
-			sessionID, partition, sessionID%numberOfPartitions)
+			sessionID, partition, types.SessionPartition(sessionID, s.partitions))
 		if _, ok := s.notFoundSessions[sessionID]; ok {
 			delete(s.notFoundSessions, sessionID)
 		}
@@ -107,7 +112,7 @@ func (s *sessionFinderImpl) findSession(sessionID, timestamp, partition uint64)
 
 	// Stop session search process if next partition is the same as original one
 	nextPartition := s.nextPartition(partition)
-	if nextPartition == sessionID%numberOfPartitions {
+	if nextPartition == types.SessionPartition(sessionID, s.partitions) {
 		log.Printf("failover mechanism didn't help; sessID: %d", sessionID)
 		s.notFoundSessions[sessionID] = struct{}{}
 		return
@@ -117,7 +122,7 @@ func (s *sessionFinderImpl) findSession(sessionID, timestamp, partition uint64)
 
 func (s *sessionFinderImpl) nextPartition(partition uint64) uint64 {
 	partition++
-	if partition > numberOfPartitions-1 {
+	if partition > s.partitions-1 {
 		partition = 0
 	}
 	return partition
@@ -132,7 +137,7 @@ func (s *sessionFinderImpl) sendSearchMessage(sessionID, timestamp, partition ui
 }
 
 func (s *sessionFinderImpl) Find(sessionID, timestamp uint64) {
-	s.sendSearchMessage(sessionID, timestamp, s.nextPartition(sessionID%numberOfPartitions))
+	s.sendSearchMessage(sessionID, timestamp, s.nextPartition(types.SessionPartition(sessionID, s.partitions)))
 }
 
 // Stop sends done signal to internal worker to close producer and consumer and exit from worker goroutine
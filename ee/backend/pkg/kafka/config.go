@@ -0,0 +1,82 @@
+package kafka
+
+import (
+	"log"
+	"os"
+	"strings"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+
+	"openreplay/backend/pkg/env"
+)
+
+/*
+	Connection settings shared by consumers, producers and admin clients:
+		KAFKA_USE_SSL - TLS encryption (KAFKA_SSL_CA, KAFKA_SSL_KEY, KAFKA_SSL_CERT)
+		KAFKA_USE_KERBEROS - GSSAPI authentication (KERBEROS_SERVICE_NAME, KERBEROS_PRINCIPAL, KERBEROS_KEYTAB_LOCATION)
+		KAFKA_SASL_MECHANISM - PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512 (KAFKA_SASL_USERNAME, KAFKA_SASL_PASSWORD)
+			or OAUTHBEARER with OIDC client credentials (KAFKA_OAUTH_TOKEN_ENDPOINT, KAFKA_OAUTH_CLIENT_ID,
+			KAFKA_OAUTH_CLIENT_SECRET and optional KAFKA_OAUTH_SCOPE, KAFKA_OAUTH_EXTENSIONS)
+	SASL mechanisms use sasl_ssl protocol together with KAFKA_USE_SSL, Kerberos always uses sasl_plaintext.
+*/
+
+func newConfig() *kafka.ConfigMap {
+	kafkaConfig := &kafka.ConfigMap{
+		"bootstrap.servers": env.String("KAFKA_SERVERS"),
+	}
+	applySecurityConfig(kafkaConfig)
+	return kafkaConfig
+}
+
+func applySecurityConfig(kafkaConfig *kafka.ConfigMap) {
+	kafkaConfig.SetKey("security.protocol", "plaintext")
+
+	// Apply ssl configuration
+	useSSL := env.Bool("KAFKA_USE_SSL")
+	if useSSL {
+		kafkaConfig.SetKey("security.protocol", "ssl")
+		kafkaConfig.SetKey("ssl.ca.location", os.Getenv("KAFKA_SSL_CA"))
+		kafkaConfig.SetKey("ssl.key.location", os.Getenv("KAFKA_SSL_KEY"))
+		kafkaConfig.SetKey("ssl.certificate.location", os.Getenv("KAFKA_SSL_CERT"))
+	}
+
+	// Apply Kerberos configuration
+	if env.Bool("KAFKA_USE_KERBEROS") {
+		kafkaConfig.SetKey("security.protocol", "sasl_plaintext")
+		kafkaConfig.SetKey("sasl.mechanisms", "GSSAPI")
+		kafkaConfig.SetKey("sasl.kerberos.service.name", os.Getenv("KERBEROS_SERVICE_NAME"))
+		kafkaConfig.SetKey("sasl.kerberos.principal", os.Getenv("KERBEROS_PRINCIPAL"))
+		kafkaConfig.SetKey("sasl.kerberos.keytab", os.Getenv("KERBEROS_KEYTAB_LOCATION"))
+		return
+	}
+
+	// Apply SASL configuration
+	mechanism := strings.ToUpper(env.StringOptional("KAFKA_SASL_MECHANISM"))
+	switch mechanism {
+	case "":
+		return
+	case "PLAIN", "SCRAM-SHA-256", "SCRAM-SHA-512":
+		kafkaConfig.SetKey("sasl.username", env.String("KAFKA_SASL_USERNAME"))
+		kafkaConfig.SetKey("sasl.password", env.String("KAFKA_SASL_PASSWORD"))
+	case "OAUTHBEARER":
+		kafkaConfig.SetKey("sasl.oauthbearer.method", "oidc")
+		kafkaConfig.SetKey("sasl.oauthbearer.token.endpoint.url", env.String("KAFKA_OAUTH_TOKEN_ENDPOINT"))
+		kafkaConfig.SetKey("sasl.oauthbearer.client.id", env.String("KAFKA_OAUTH_CLIENT_ID"))
+		kafkaConfig.SetKey("sasl.oauthbearer.client.secret", env.String("KAFKA_OAUTH_CLIENT_SECRET"))
+		if scope := env.StringOptional("KAFKA_OAUTH_SCOPE"); scope != "" {
+			kafkaConfig.SetKey("sasl.oauthbearer.scope", scope)
+		}
+		// Comma-separated key=value pairs, e.g. logicalCluster=lkc-xxx,identityPoolId=pool-xxx
+		if extensions := env.StringOptional("KAFKA_OAUTH_EXTENSIONS"); extensions != "" {
+			kafkaConfig.SetKey("sasl.oauthbearer.extensions", extensions)
+		}
+	default:
+		log.Fatalf("unsupported KAFKA_SASL_MECHANISM: %s", mechanism)
+	}
+	kafkaConfig.SetKey("sasl.mechanisms", mechanism)
+	if useSSL {
+		kafkaConfig.SetKey("security.protocol", "sasl_ssl")
+	} else {
+		kafkaConfig.SetKey("security.protocol", "sasl_plaintext")
+	}
+}
@@ -0,0 +1,64 @@
+package kafka
+
+import (
+	"testing"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+func configValue(t *testing.T, config *kafka.ConfigMap, key string) string {
+	value, err := config.Get(key, "")
+	if err != nil {
+		t.Fatalf("can't get %s: %s", key, err)
+	}
+	return value.(string)
+}
+
+func TestSecurityConfig(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		env      map[string]string
+		expected map[string]string
+	}{
+		{"plaintext", nil, map[string]string{"security.protocol": "plaintext", "sasl.mechanisms": ""}},
+		{"ssl", map[string]string{"KAFKA_USE_SSL": "true", "KAFKA_SSL_CA": "/ca.pem"},
+			map[string]string{"security.protocol": "ssl", "ssl.ca.location": "/ca.pem"}},
+		{"kerberos", map[string]string{"KAFKA_USE_KERBEROS": "true", "KAFKA_SASL_MECHANISM": "PLAIN"},
+			map[string]string{"security.protocol": "sasl_plaintext", "sasl.mechanisms": "GSSAPI"}},
+		{"scram", map[string]string{"KAFKA_SASL_MECHANISM": "scram-sha-512", "KAFKA_SASL_USERNAME": "user", "KAFKA_SASL_PASSWORD": "pass"},
+			map[string]string{"security.protocol": "sasl_plaintext", "sasl.mechanisms": "SCRAM-SHA-512", "sasl.username": "user", "sasl.password": "pass"}},
+		{"scram over ssl", map[string]string{"KAFKA_USE_SSL": "true", "KAFKA_SASL_MECHANISM": "PLAIN", "KAFKA_SASL_USERNAME": "user", "KAFKA_SASL_PASSWORD": "pass"},
+			map[string]string{"security.protocol": "sasl_ssl", "sasl.mechanisms": "PLAIN"}},
+		{"oauth", map[string]string{
+			"KAFKA_SASL_MECHANISM":       "OAUTHBEARER",
+			"KAFKA_OAUTH_TOKEN_ENDPOINT": "https://auth/token",
+			"KAFKA_OAUTH_CLIENT_ID":      "id",
+			"KAFKA_OAUTH_CLIENT_SECRET":  "secret",
+			"KAFKA_OAUTH_EXTENSIONS":     "logicalCluster=lkc-1",
+		}, map[string]string{
+			"security.protocol":                   "sasl_plaintext",
+			"sasl.mechanisms":                     "OAUTHBEARER",
+			"sasl.oauthbearer.method":             "oidc",
+			"sasl.oauthbearer.token.endpoint.url": "https://auth/token",
+			"sasl.oauthbearer.client.id":          "id",
+			"sasl.oauthbearer.scope":              "",
+			"sasl.oauthbearer.extensions":         "logicalCluster=lkc-1",
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("KAFKA_USE_SSL", "false")
+			t.Setenv("KAFKA_USE_KERBEROS", "false")
+			t.Setenv("KAFKA_SASL_MECHANISM", "")
+			for key, value := range tc.env {
+				t.Setenv(key, value)
+			}
+			config := &kafka.ConfigMap{}
+			applySecurityConfig(config)
+			for key, expected := range tc.expected {
+				if value := configValue(t, config, key); value != expected {
+					t.Errorf("expected %s to be %q, got %q", key, expected, value)
+				}
+			}
+		})
+	}
+}
@@ -38,28 +38,12 @@ func NewConsumer(
 		"group.id":                        group,
 		"auto.offset.reset":               "earliest",
 		"enable.auto.commit":              "false",
-		"security.protocol":               "plaintext",
 		"go.application.rebalance.enable": true,
 		"max.poll.interval.ms":            env.Int("KAFKA_MAX_POLL_INTERVAL_MS"),
 		"max.partition.fetch.bytes":       messageSizeLimit,
 		"go.logs.channel.enable":          true,
 	}
-	// Apply ssl configuration
-	if env.Bool("KAFKA_USE_SSL") {
-		kafkaConfig.SetKey("security.protocol", "ssl")
-		kafkaConfig.SetKey("ssl.ca.location", os.Getenv("KAFKA_SSL_CA"))
-		kafkaConfig.SetKey("ssl.key.location", os.Getenv("KAFKA_SSL_KEY"))
-		kafkaConfig.SetKey("ssl.certificate.location", os.Getenv("KAFKA_SSL_CERT"))
-	}
-
-	// Apply Kerberos configuration
-	if env.Bool("KAFKA_USE_KERBEROS") {
-		kafkaConfig.SetKey("security.protocol", "sasl_plaintext")
-		kafkaConfig.SetKey("sasl.mechanisms", "GSSAPI")
-		kafkaConfig.SetKey("sasl.kerberos.service.name", os.Getenv("KERBEROS_SERVICE_NAME"))
-		kafkaConfig.SetKey("sasl.kerberos.principal", os.Getenv("KERBEROS_PRINCIPAL"))
-		kafkaConfig.SetKey("sasl.kerberos.keytab", os.Getenv("KERBEROS_KEYTAB_LOCATION"))
-	}
+	applySecurityConfig(kafkaConfig)
 
 	c, err := kafka.NewConsumer(kafkaConfig)
 	if err != nil {
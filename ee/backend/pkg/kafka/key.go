@@ -1,12 +1,13 @@
 package kafka
 
-import "encoding/binary"
+import (
+	"encoding/binary"
 
-const PARTITIONS_EXPONENT = 3
-const PARTITIONS_MAX_INDEX uint64 = 1<<PARTITIONS_EXPONENT - 1
+	"openreplay/backend/pkg/queue/types"
+)
 
-func getKeyPartition(key uint64) int32 {
-	return int32(key & PARTITIONS_MAX_INDEX)
+func getKeyPartition(key, partitions uint64) int32 {
+	return int32(types.SessionPartition(key, partitions))
 }
 
 func encodeKey(key uint64) []byte {
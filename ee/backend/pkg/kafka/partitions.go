@@ -0,0 +1,81 @@
+package kafka
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+/*
+	Producer maps sessions to partitions by itself (see types.SessionPartition), so it needs the number
+	of partitions of every topic. The number is requested from topic metadata once and stays the same
+	for the lifetime of the producer, like the number of partitions of the ender, which is read on start.
+	Kafka can only add partitions, after that all producers and the ender have to be restarted together.
+*/
+
+const METADATA_TIMEOUT_MS = 5000
+
+type metadataClient interface {
+	GetMetadata(topic *string, allTopics bool, timeoutMs int) (*kafka.Metadata, error)
+}
+
+func getPartitionsNumber(client metadataClient, topic string) (uint64, error) {
+	metadata, err := client.GetMetadata(&topic, false, METADATA_TIMEOUT_MS)
+	if err != nil {
+		return 0, err
+	}
+	topicMetadata, ok := metadata.Topics[topic]
+	if !ok {
+		return 0, fmt.Errorf("topic %s not found", topic)
+	}
+	if topicMetadata.Error.Code() != kafka.ErrNoError {
+		return 0, topicMetadata.Error
+	}
+	if len(topicMetadata.Partitions) == 0 {
+		return 0, fmt.Errorf("topic %s has no partitions", topic)
+	}
+	return uint64(len(topicMetadata.Partitions)), nil
+}
+
+// PartitionsNumber requests the number of topic partitions from brokers
+func PartitionsNumber(topic string) (uint64, error) {
+	client, err := kafka.NewAdminClient(newConfig())
+	if err != nil {
+		return 0, err
+	}
+	defer client.Close()
+	return getPartitionsNumber(client, topic)
+}
+
+type partitionsCache struct {
+	client metadataClient
+	mutex  sync.RWMutex
+	topics map[string]uint64
+}
+
+func newPartitionsCache(client metadataClient) *partitionsCache {
+	return &partitionsCache{
+		client: client,
+		topics: make(map[string]uint64),
+	}
+}
+
+// get returns an error if metadata isn't available, a wrong number of partitions would move sessions to
+// partitions of other ender instances
+func (c *partitionsCache) get(topic string) (uint64, error) {
+	c.mutex.RLock()
+	partitions, ok := c.topics[topic]
+	c.mutex.RUnlock()
+	if ok {
+		return partitions, nil
+	}
+	partitions, err := getPartitionsNumber(c.client, topic)
+	if err != nil {
+		return 0, fmt.Errorf("can't get partitions number of %s topic: %w", topic, err)
+	}
+	c.mutex.Lock()
+	c.topics[topic] = partitions
+	c.mutex.Unlock()
+	return partitions, nil
+}
@@ -0,0 +1,64 @@
+package kafka
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+type testMetadataClient struct {
+	partitions map[string]int
+	err        error
+	requests   int
+}
+
+func (c *testMetadataClient) GetMetadata(topic *string, _ bool, _ int) (*kafka.Metadata, error) {
+	c.requests++
+	if c.err != nil {
+		return nil, c.err
+	}
+	metadata := &kafka.Metadata{Topics: make(map[string]kafka.TopicMetadata)}
+	if n, ok := c.partitions[*topic]; ok {
+		metadata.Topics[*topic] = kafka.TopicMetadata{Topic: *topic, Partitions: make([]kafka.PartitionMetadata, n)}
+	} else {
+		metadata.Topics[*topic] = kafka.TopicMetadata{Topic: *topic, Error: kafka.NewError(kafka.ErrUnknownTopicOrPart, "unknown topic", false)}
+	}
+	return metadata, nil
+}
+
+func TestPartitionsCache(t *testing.T) {
+	client := &testMetadataClient{partitions: map[string]int{"raw": 4, "empty": 0}}
+	cache := newPartitionsCache(client)
+	for i := 0; i < 2; i++ {
+		if n, err := cache.get("raw"); err != nil || n != 4 {
+			t.Errorf("expected 4 partitions, got %d: %v", n, err)
+		}
+	}
+	if client.requests != 1 {
+		t.Errorf("expected metadata to be requested once, got %d", client.requests)
+	}
+
+	// Number of partitions stays the same for the lifetime of the producer
+	client.partitions["raw"] = 8
+	if n, _ := cache.get("raw"); n != 4 {
+		t.Errorf("expected fixed number of partitions, got %d", n)
+	}
+
+	for _, topic := range []string{"unknown", "empty"} {
+		if _, err := cache.get(topic); err == nil {
+			t.Errorf("expected error for %s topic", topic)
+		}
+	}
+
+	// Errors aren't cached, so the topic is requested again
+	client.err = errors.New("brokers are down")
+	if _, err := cache.get("analytics"); err == nil {
+		t.Errorf("expected metadata error")
+	}
+	client.err = nil
+	client.partitions["analytics"] = 2
+	if n, err := cache.get("analytics"); err != nil || n != 2 {
+		t.Errorf("expected 2 partitions after error, got %d: %v", n, err)
+	}
+}
@@ -3,14 +3,14 @@ package kafka
 import (
 	"fmt"
 	"log"
-	"os"
 
-        "github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
 	"openreplay/backend/pkg/env"
 )
 
 type Producer struct {
-	producer *kafka.Producer
+	producer   *kafka.Producer
+	partitions *partitionsCache
 }
 
 func NewProducer(messageSizeLimit int, useBatch bool) *Producer {
@@ -18,7 +18,6 @@ func NewProducer(messageSizeLimit int, useBatch bool) *Producer {
 		"enable.idempotence":                    true,
 		"bootstrap.servers":                     env.String("KAFKA_SERVERS"),
 		"go.delivery.reports":                   true,
-		"security.protocol":                     "plaintext",
 		"go.batch.producer":                     useBatch,
 		"message.max.bytes":                     messageSizeLimit, // should be synced with broker config
 		"linger.ms":                             1000,
@@ -30,27 +29,16 @@ func NewProducer(messageSizeLimit int, useBatch bool) *Producer {
 		"max.in.flight.requests.per.connection": 1,
 		"compression.type":                      env.String("COMPRESSION_TYPE"),
 	}
-	// Apply ssl configuration
-	if env.Bool("KAFKA_USE_SSL") {
-		kafkaConfig.SetKey("security.protocol", "ssl")
-		kafkaConfig.SetKey("ssl.ca.location", os.Getenv("KAFKA_SSL_CA"))
-		kafkaConfig.SetKey("ssl.key.location", os.Getenv("KAFKA_SSL_KEY"))
-		kafkaConfig.SetKey("ssl.certificate.location", os.Getenv("KAFKA_SSL_CERT"))
-	}
-	// Apply Kerberos configuration
-	if env.Bool("KAFKA_USE_KERBEROS") {
-		kafkaConfig.SetKey("security.protocol", "sasl_plaintext")
-		kafkaConfig.SetKey("sasl.mechanisms", "GSSAPI")
-		kafkaConfig.SetKey("sasl.kerberos.service.name", os.Getenv("KERBEROS_SERVICE_NAME"))
-		kafkaConfig.SetKey("sasl.kerberos.principal", os.Getenv("KERBEROS_PRINCIPAL"))
-		kafkaConfig.SetKey("sasl.kerberos.keytab", os.Getenv("KERBEROS_KEYTAB_LOCATION"))
-	}
+	applySecurityConfig(kafkaConfig)
 
 	producer, err := kafka.NewProducer(kafkaConfig)
 	if err != nil {
 		log.Fatalln(err)
 	}
-	newProducer := &Producer{producer}
+	newProducer := &Producer{
+		producer:   producer,
+		partitions: newPartitionsCache(producer),
+	}
 	go newProducer.errorHandler()
 	return newProducer
 }
@@ -67,8 +55,12 @@ func (p *Producer) errorHandler() {
 }
 
 func (p *Producer) Produce(topic string, key uint64, value []byte) error {
+	partitions, err := p.partitions.get(topic)
+	if err != nil {
+		return err
+	}
 	p.producer.ProduceChannel() <- &kafka.Message{
-		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: getKeyPartition(key)},
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: getKeyPartition(key, partitions)},
 		Key:            encodeKey(key),
 		Value:          value,
 	}
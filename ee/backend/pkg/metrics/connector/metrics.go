@@ -0,0 +1,22 @@
+package connector
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var connectorDroppedRows = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "connector",
+		Name:      "dropped_rows_total",
+		Help:      "A counter displaying the number of rows which were dropped because of values of wrong type.",
+	},
+	[]string{"table"},
+)
+
+func IncreaseDroppedRows(table string) {
+	connectorDroppedRows.WithLabelValues(table).Inc()
+}
+
+func List() []prometheus.Collector {
+	return []prometheus.Collector{
+		connectorDroppedRows,
+	}
+}
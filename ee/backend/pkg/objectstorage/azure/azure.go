@@ -122,6 +122,17 @@ func (s *storageImpl) GetCreationTime(key string) *time.Time {
 	return get.LastModified
 }
 
+func (s *storageImpl) GetContentEncoding(key string) (string, error) {
+	props, err := s.client.ServiceClient().NewContainerClient(s.container).NewBlobClient(key).GetProperties(context.Background(), nil)
+	if err != nil {
+		return "", err
+	}
+	if props.ContentEncoding == nil {
+		return "", nil
+	}
+	return *props.ContentEncoding, nil
+}
+
 func (s *storageImpl) GetPreSignedUploadUrl(key string) (string, error) {
 	// Set the desired SAS permissions and options for uploading
 	sasQueryParams, err := sas.BlobSignatureValues{
@@ -140,6 +151,28 @@ func (s *storageImpl) GetPreSignedUploadUrl(key string) (string, error) {
 	return sasURL, nil
 }
 
+func (s *storageImpl) List(prefix string) ([]string, error) {
+	keys := make([]string, 0)
+	pager := s.client.NewListBlobsFlatPager(s.container, &azblob.ListBlobsFlatOptions{
+		Prefix: &prefix,
+	})
+	for pager.More() {
+		page, err := pager.NextPage(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range page.Segment.BlobItems {
+			keys = append(keys, *item.Name)
+		}
+	}
+	return keys, nil
+}
+
+func (s *storageImpl) Delete(key string) error {
+	_, err := s.client.DeleteBlob(context.Background(), s.container, key, nil)
+	return err
+}
+
 func loadFileTag() map[string]string {
 	// Load file tag from env
 	key := "retention"
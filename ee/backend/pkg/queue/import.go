@@ -1,18 +1,40 @@
 package queue
 
 import (
+	"openreplay/backend/pkg/env"
 	"openreplay/backend/pkg/kafka"
 	"openreplay/backend/pkg/license"
+	"openreplay/backend/pkg/memqueue"
 	"openreplay/backend/pkg/messages"
 	"openreplay/backend/pkg/queue/types"
 )
 
+// In-process queue for the all-in-one build and tests
+func useMemory() bool {
+	return env.StringOptional("QUEUE_TYPE") == "memory"
+}
+
 func NewConsumer(group string, topics []string, iterator messages.MessageIterator, autoCommit bool, messageSizeLimit int) types.Consumer {
 	license.CheckLicense()
-	return kafka.NewConsumer(group, topics, iterator, autoCommit, messageSizeLimit)
+	topics, deadLetter := setDeadLetter(group, topics, iterator, messageSizeLimit)
+	if useMemory() {
+		return withDeadLetter(memqueue.NewConsumer(group, topics, iterator, autoCommit), deadLetter)
+	}
+	return withDeadLetter(kafka.NewConsumer(group, topics, iterator, autoCommit, messageSizeLimit), deadLetter)
 }
 
 func NewProducer(messageSizeLimit int, useBatch bool) types.Producer {
 	license.CheckLicense()
+	if useMemory() {
+		return memqueue.NewProducer()
+	}
 	return kafka.NewProducer(messageSizeLimit, useBatch)
 }
+
+// PartitionsNumber returns the number of topic partitions which is used to map sessions (see types.SessionPartition)
+func PartitionsNumber(topic string) (uint64, error) {
+	if useMemory() {
+		return memqueue.Default().Partitions(), nil
+	}
+	return kafka.PartitionsNumber(topic)
+}
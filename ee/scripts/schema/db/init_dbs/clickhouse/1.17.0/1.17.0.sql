@@ -0,0 +1,4 @@
+CREATE OR REPLACE FUNCTION openreplay_version AS() -> 'v1.17.0-ee';
+
+ALTER TABLE experimental.events
+    MODIFY COLUMN source Nullable(Enum8('js_exception'=0, 'bugsnag'=1, 'cloudwatch'=2, 'datadog'=3, 'elasticsearch'=4, 'newrelic'=5, 'rollbar'=6, 'sentry'=7, 'stackdriver'=8, 'sumologic'=9, 'webhook'=10, 'otel'=11, 'loki'=12, 'opensearch'=13, 'http_logs'=14));
@@ -1,4 +1,4 @@
-CREATE OR REPLACE FUNCTION openreplay_version AS() -> 'v1.16.0-ee';
+CREATE OR REPLACE FUNCTION openreplay_version AS() -> 'v1.17.0-ee';
 CREATE DATABASE IF NOT EXISTS experimental;
 
 CREATE TABLE IF NOT EXISTS experimental.autocomplete
@@ -23,7 +23,7 @@ CREATE TABLE IF NOT EXISTS experimental.events
     name Nullable(String),
     payload Nullable(String),
     level Nullable(Enum8('info'=0, 'error'=1))              DEFAULT if(event_type == 'CUSTOM', 'info', null),
-    source Nullable(Enum8('js_exception'=0, 'bugsnag'=1, 'cloudwatch'=2, 'datadog'=3, 'elasticsearch'=4, 'newrelic'=5, 'rollbar'=6, 'sentry'=7, 'stackdriver'=8, 'sumologic'=9)),
+    source Nullable(Enum8('js_exception'=0, 'bugsnag'=1, 'cloudwatch'=2, 'datadog'=3, 'elasticsearch'=4, 'newrelic'=5, 'rollbar'=6, 'sentry'=7, 'stackdriver'=8, 'sumologic'=9, 'webhook'=10, 'otel'=11, 'loki'=12, 'opensearch'=13, 'http_logs'=14)),
     message Nullable(String),
     error_id Nullable(String),
     duration Nullable(UInt16),
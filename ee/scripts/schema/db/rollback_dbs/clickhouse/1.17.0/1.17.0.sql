@@ -0,0 +1,3 @@
+CREATE OR REPLACE FUNCTION openreplay_version AS() -> 'v1.16.0-ee';
+
+-- new values of events.source are kept, events of these sources can't be converted to the old enum
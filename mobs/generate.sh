@@ -1,2 +1,3 @@
 ruby run.rb
-gofmt -w ../backend/pkg/messages
\ No newline at end of file
+gofmt -w ../backend/pkg/messages
+gofmt -w ../ee/backend/pkg/connector
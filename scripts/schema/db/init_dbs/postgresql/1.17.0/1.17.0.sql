@@ -0,0 +1,185 @@
+\set previous_version 'v1.16.0'
+\set next_version 'v1.17.0'
+SELECT openreplay_version()                       AS current_version,
+       openreplay_version() = :'previous_version' AS valid_previous,
+       openreplay_version() = :'next_version'     AS is_next
+\gset
+
+\if :valid_previous
+\echo valid previous DB version :'previous_version', starting DB upgrade to :'next_version'
+BEGIN;
+SELECT format($fn_def$
+CREATE OR REPLACE FUNCTION openreplay_version()
+    RETURNS text AS
+$$
+SELECT '%1$s'
+$$ LANGUAGE sql IMMUTABLE;
+$fn_def$, :'next_version')
+\gexec
+
+--
+
+ALTER TABLE IF EXISTS public.errors
+    ADD COLUMN IF NOT EXISTS fingerprint   text   DEFAULT NULL,
+    ADD COLUMN IF NOT EXISTS first_seen_at bigint DEFAULT NULL,
+    ADD COLUMN IF NOT EXISTS last_seen_at  bigint DEFAULT NULL,
+    ADD COLUMN IF NOT EXISTS occurrences   bigint NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS regressed_at  bigint DEFAULT NULL,
+    ADD COLUMN IF NOT EXISTS resolved_at   bigint DEFAULT NULL;
+CREATE INDEX IF NOT EXISTS errors_project_id_last_seen_at_idx ON public.errors (project_id, last_seen_at);
+CREATE INDEX IF NOT EXISTS errors_project_id_fingerprint_idx ON public.errors (project_id, fingerprint);
+
+CREATE OR REPLACE FUNCTION errors_resolved_at() RETURNS trigger AS
+$$
+BEGIN
+    IF NEW.status = 'resolved' AND OLD.status IS DISTINCT FROM 'resolved' THEN
+        NEW.resolved_at = CAST(EXTRACT(epoch FROM now()) * 1000 AS BIGINT);
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS on_resolve ON public.errors;
+CREATE TRIGGER on_resolve
+    BEFORE UPDATE OF status
+    ON public.errors
+    FOR EACH ROW
+EXECUTE PROCEDURE errors_resolved_at();
+
+ALTER TYPE error_source ADD VALUE IF NOT EXISTS 'webhook';
+ALTER TYPE error_source ADD VALUE IF NOT EXISTS 'otel';
+ALTER TYPE error_source ADD VALUE IF NOT EXISTS 'loki';
+ALTER TYPE error_source ADD VALUE IF NOT EXISTS 'opensearch';
+ALTER TYPE error_source ADD VALUE IF NOT EXISTS 'http_logs';
+
+ALTER TYPE integration_provider ADD VALUE IF NOT EXISTS 'loki';
+ALTER TYPE integration_provider ADD VALUE IF NOT EXISTS 'opensearch';
+ALTER TYPE integration_provider ADD VALUE IF NOT EXISTS 'http_logs';
+
+CREATE TABLE IF NOT EXISTS public.session_webhooks
+(
+    subscription_id integer generated BY DEFAULT AS IDENTITY PRIMARY KEY,
+    project_id      integer   NOT NULL REFERENCES public.projects (project_id) ON DELETE CASCADE,
+    name            varchar(100) NULL,
+    endpoint        text      NOT NULL,
+    secret          text      NOT NULL,
+    event_types     text[]    NOT NULL DEFAULT '{session_end}'::text[],
+    issue_types     text[]    NOT NULL DEFAULT '{}'::text[],
+    user_id         text      NULL,
+    metadata        jsonb     NOT NULL DEFAULT '{}'::jsonb,
+    created_at      timestamp NOT NULL DEFAULT timezone('utc'::text, now()),
+    deleted_at      timestamp NULL
+);
+CREATE INDEX IF NOT EXISTS session_webhooks_project_id_idx ON public.session_webhooks (project_id) WHERE deleted_at IS NULL;
+
+CREATE TABLE IF NOT EXISTS public.session_webhook_deliveries
+(
+    id              bigint generated BY DEFAULT AS IDENTITY PRIMARY KEY,
+    delivery_id     text      NOT NULL,
+    subscription_id integer   NOT NULL REFERENCES public.session_webhooks (subscription_id) ON DELETE CASCADE,
+    session_id      bigint    NOT NULL,
+    event_type      text      NOT NULL,
+    attempt         smallint  NOT NULL,
+    status_code     smallint  NULL,
+    error           text      NULL,
+    duration        integer   NOT NULL,
+    created_at      timestamp NOT NULL DEFAULT timezone('utc'::text, now())
+);
+CREATE INDEX IF NOT EXISTS session_webhook_deliveries_subscription_id_created_at_idx ON public.session_webhook_deliveries (subscription_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS session_webhook_deliveries_delivery_id_idx ON public.session_webhook_deliveries (delivery_id);
+CREATE INDEX IF NOT EXISTS session_webhook_deliveries_session_id_idx ON public.session_webhook_deliveries (session_id);
+
+CREATE TABLE IF NOT EXISTS public.session_webhook_pending
+(
+    delivery_id     text      NOT NULL PRIMARY KEY,
+    subscription_id integer   NOT NULL REFERENCES public.session_webhooks (subscription_id) ON DELETE CASCADE,
+    session_id      bigint    NOT NULL,
+    event_type      text      NOT NULL,
+    body            text      NOT NULL,
+    attempt         smallint  NOT NULL,
+    next_attempt_at timestamp NOT NULL
+);
+CREATE INDEX IF NOT EXISTS session_webhook_pending_next_attempt_at_idx ON public.session_webhook_pending (next_attempt_at);
+CREATE INDEX IF NOT EXISTS session_webhook_pending_session_id_idx ON public.session_webhook_pending (session_id);
+
+CREATE TABLE IF NOT EXISTS public.issue_digests
+(
+    digest_id       integer generated BY DEFAULT AS IDENTITY PRIMARY KEY,
+    project_id      integer   NOT NULL REFERENCES public.projects (project_id) ON DELETE CASCADE,
+    webhook_id      integer   NOT NULL REFERENCES public.webhooks (webhook_id) ON DELETE CASCADE,
+    issue_types     text[]    NOT NULL DEFAULT '{}'::text[],
+    window_minutes  integer   NOT NULL DEFAULT 60,
+    threshold       integer   NOT NULL DEFAULT 10,
+    baseline_factor real      NOT NULL DEFAULT 0,
+    next_run_at     timestamp NULL,
+    created_at      timestamp NOT NULL DEFAULT timezone('utc'::text, now()),
+    deleted_at      timestamp NULL
+);
+CREATE INDEX IF NOT EXISTS issue_digests_project_id_idx ON public.issue_digests (project_id) WHERE deleted_at IS NULL;
+
+CREATE TABLE IF NOT EXISTS public.issue_digests_state
+(
+    digest_id    integer NOT NULL REFERENCES public.issue_digests (digest_id) ON DELETE CASCADE,
+    fingerprint  text    NOT NULL,
+    last_sent_at bigint  NOT NULL,
+    last_count   integer NOT NULL,
+    PRIMARY KEY (digest_id, fingerprint)
+);
+CREATE INDEX IF NOT EXISTS issue_digests_state_last_sent_at_idx ON public.issue_digests_state (last_sent_at);
+
+CREATE TABLE IF NOT EXISTS public.issue_digests_sessions
+(
+    partition      integer NOT NULL,
+    project_id     integer NOT NULL REFERENCES public.projects (project_id) ON DELETE CASCADE,
+    session_id     bigint  NOT NULL,
+    issue_type     text    NOT NULL,
+    url            text    NOT NULL,
+    context_string text    NOT NULL,
+    last_ts        bigint  NOT NULL,
+    events         integer NOT NULL,
+    PRIMARY KEY (partition, project_id, session_id, issue_type, url, context_string)
+);
+CREATE INDEX IF NOT EXISTS issue_digests_sessions_project_id_last_ts_idx ON public.issue_digests_sessions (project_id, last_ts);
+CREATE INDEX IF NOT EXISTS issue_digests_sessions_last_ts_idx ON public.issue_digests_sessions (last_ts);
+CREATE INDEX IF NOT EXISTS issue_digests_sessions_project_id_session_id_idx ON public.issue_digests_sessions (project_id, session_id);
+
+ALTER TABLE IF EXISTS public.projects
+    ADD COLUMN IF NOT EXISTS retention_days integer NULL DEFAULT NULL;
+
+CREATE TABLE IF NOT EXISTS public.erasure_requests
+(
+    request_id     integer generated BY DEFAULT AS IDENTITY PRIMARY KEY,
+    project_id     integer   NOT NULL REFERENCES public.projects (project_id) ON DELETE CASCADE,
+    user_id        text      NULL,
+    session_id     bigint    NULL,
+    status         text      NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'done', 'failed')),
+    attempts       smallint  NOT NULL DEFAULT 0,
+    error          text      NULL,
+    sessions_count integer   NOT NULL DEFAULT 0,
+    created_at     timestamp NOT NULL DEFAULT timezone('utc'::text, now()),
+    processed_at   timestamp NULL,
+    CHECK (user_id IS NOT NULL OR session_id IS NOT NULL)
+);
+CREATE INDEX IF NOT EXISTS erasure_requests_status_created_at_idx ON public.erasure_requests (created_at) WHERE status = 'pending';
+
+CREATE TABLE IF NOT EXISTS public.deletion_audit_log
+(
+    id         bigint generated BY DEFAULT AS IDENTITY PRIMARY KEY,
+    project_id integer   NOT NULL,
+    session_id bigint    NULL,
+    request_id integer   NULL,
+    reason     text      NOT NULL,
+    files      text[]    NOT NULL DEFAULT '{}'::text[],
+    tables     text[]    NOT NULL DEFAULT '{}'::text[],
+    created_at timestamp NOT NULL DEFAULT timezone('utc'::text, now())
+);
+CREATE INDEX IF NOT EXISTS deletion_audit_log_project_id_created_at_idx ON public.deletion_audit_log (project_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS deletion_audit_log_request_id_idx ON public.deletion_audit_log (request_id) WHERE request_id IS NOT NULL;
+
+COMMIT;
+
+\elif :is_next
+\echo new version detected :'next_version', nothing to do
+\else
+\warn skipping DB upgrade of :'next_version', expected previous version :'previous_version', found :'current_version'
+\endif
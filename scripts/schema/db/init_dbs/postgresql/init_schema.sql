@@ -1,4 +1,4 @@
-\set or_version 'v1.16.0'
+\set or_version 'v1.17.0'
 SET client_min_messages TO NOTICE;
 \set ON_ERROR_STOP true
 SELECT EXISTS (SELECT 1
@@ -112,6 +112,16 @@ BEGIN
 END;
 $$ LANGUAGE plpgsql;
 
+CREATE OR REPLACE FUNCTION errors_resolved_at() RETURNS trigger AS
+$$
+BEGIN
+    IF NEW.status = 'resolved' AND OLD.status IS DISTINCT FROM 'resolved' THEN
+        NEW.resolved_at = CAST(EXTRACT(epoch FROM now()) * 1000 AS BIGINT);
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
 -- All tables and types:
 
 DO
@@ -216,7 +226,8 @@ $$
                 }'::jsonb,
                 first_recorded_session_at timestamp without time zone NULL            DEFAULT NULL,
                 sessions_last_check_at    timestamp without time zone NULL            DEFAULT NULL,
-                beacon_size               integer                     NOT NULL        DEFAULT 0
+                beacon_size               integer                     NOT NULL        DEFAULT 0,
+                retention_days            integer                     NULL            DEFAULT NULL
             );
 
             CREATE INDEX projects_project_key_idx ON public.projects (project_key);
@@ -288,7 +299,7 @@ $$
             );
 
 
-            CREATE TYPE integration_provider AS ENUM ('bugsnag', 'cloudwatch', 'datadog', 'newrelic', 'rollbar', 'sentry', 'stackdriver', 'sumologic', 'elasticsearch'); --, 'jira', 'github');
+            CREATE TYPE integration_provider AS ENUM ('bugsnag', 'cloudwatch', 'datadog', 'newrelic', 'rollbar', 'sentry', 'stackdriver', 'sumologic', 'elasticsearch', 'loki', 'opensearch', 'http_logs'); --, 'jira', 'github');
             CREATE TABLE public.integrations
             (
                 project_id   integer              NOT NULL REFERENCES public.projects (project_id) ON DELETE CASCADE,
@@ -305,6 +316,124 @@ $$
             EXECUTE PROCEDURE notify_integration();
 
 
+            CREATE TABLE public.session_webhooks
+            (
+                subscription_id integer generated BY DEFAULT AS IDENTITY PRIMARY KEY,
+                project_id      integer   NOT NULL REFERENCES public.projects (project_id) ON DELETE CASCADE,
+                name            varchar(100) NULL,
+                endpoint        text      NOT NULL,
+                secret          text      NOT NULL,
+                event_types     text[]    NOT NULL DEFAULT '{session_end}'::text[],
+                issue_types     text[]    NOT NULL DEFAULT '{}'::text[],
+                user_id         text      NULL,
+                metadata        jsonb     NOT NULL DEFAULT '{}'::jsonb,
+                created_at      timestamp NOT NULL DEFAULT timezone('utc'::text, now()),
+                deleted_at      timestamp NULL
+            );
+            CREATE INDEX session_webhooks_project_id_idx ON public.session_webhooks (project_id) WHERE deleted_at IS NULL;
+
+            CREATE TABLE public.session_webhook_deliveries
+            (
+                id              bigint generated BY DEFAULT AS IDENTITY PRIMARY KEY,
+                delivery_id     text      NOT NULL,
+                subscription_id integer   NOT NULL REFERENCES public.session_webhooks (subscription_id) ON DELETE CASCADE,
+                session_id      bigint    NOT NULL,
+                event_type      text      NOT NULL,
+                attempt         smallint  NOT NULL,
+                status_code     smallint  NULL,
+                error           text      NULL,
+                duration        integer   NOT NULL,
+                created_at      timestamp NOT NULL DEFAULT timezone('utc'::text, now())
+            );
+            CREATE INDEX session_webhook_deliveries_subscription_id_created_at_idx ON public.session_webhook_deliveries (subscription_id, created_at DESC);
+            CREATE INDEX session_webhook_deliveries_delivery_id_idx ON public.session_webhook_deliveries (delivery_id);
+            CREATE INDEX session_webhook_deliveries_session_id_idx ON public.session_webhook_deliveries (session_id);
+
+            CREATE TABLE public.session_webhook_pending
+            (
+                delivery_id     text      NOT NULL PRIMARY KEY,
+                subscription_id integer   NOT NULL REFERENCES public.session_webhooks (subscription_id) ON DELETE CASCADE,
+                session_id      bigint    NOT NULL,
+                event_type      text      NOT NULL,
+                body            text      NOT NULL,
+                attempt         smallint  NOT NULL,
+                next_attempt_at timestamp NOT NULL
+            );
+            CREATE INDEX session_webhook_pending_next_attempt_at_idx ON public.session_webhook_pending (next_attempt_at);
+            CREATE INDEX session_webhook_pending_session_id_idx ON public.session_webhook_pending (session_id);
+
+            CREATE TABLE public.issue_digests
+            (
+                digest_id       integer generated BY DEFAULT AS IDENTITY PRIMARY KEY,
+                project_id      integer   NOT NULL REFERENCES public.projects (project_id) ON DELETE CASCADE,
+                webhook_id      integer   NOT NULL REFERENCES public.webhooks (webhook_id) ON DELETE CASCADE,
+                issue_types     text[]    NOT NULL DEFAULT '{}'::text[],
+                window_minutes  integer   NOT NULL DEFAULT 60,
+                threshold       integer   NOT NULL DEFAULT 10,
+                baseline_factor real      NOT NULL DEFAULT 0,
+                next_run_at     timestamp NULL,
+                created_at      timestamp NOT NULL DEFAULT timezone('utc'::text, now()),
+                deleted_at      timestamp NULL
+            );
+            CREATE INDEX issue_digests_project_id_idx ON public.issue_digests (project_id) WHERE deleted_at IS NULL;
+
+            CREATE TABLE public.issue_digests_state
+            (
+                digest_id    integer NOT NULL REFERENCES public.issue_digests (digest_id) ON DELETE CASCADE,
+                fingerprint  text    NOT NULL,
+                last_sent_at bigint  NOT NULL,
+                last_count   integer NOT NULL,
+                PRIMARY KEY (digest_id, fingerprint)
+            );
+            CREATE INDEX issue_digests_state_last_sent_at_idx ON public.issue_digests_state (last_sent_at);
+
+            CREATE TABLE public.issue_digests_sessions
+            (
+                partition      integer NOT NULL,
+                project_id     integer NOT NULL REFERENCES public.projects (project_id) ON DELETE CASCADE,
+                session_id     bigint  NOT NULL,
+                issue_type     text    NOT NULL,
+                url            text    NOT NULL,
+                context_string text    NOT NULL,
+                last_ts        bigint  NOT NULL,
+                events         integer NOT NULL,
+                PRIMARY KEY (partition, project_id, session_id, issue_type, url, context_string)
+            );
+            CREATE INDEX issue_digests_sessions_project_id_last_ts_idx ON public.issue_digests_sessions (project_id, last_ts);
+            CREATE INDEX issue_digests_sessions_last_ts_idx ON public.issue_digests_sessions (last_ts);
+            CREATE INDEX issue_digests_sessions_project_id_session_id_idx ON public.issue_digests_sessions (project_id, session_id);
+
+            CREATE TABLE public.erasure_requests
+            (
+                request_id     integer generated BY DEFAULT AS IDENTITY PRIMARY KEY,
+                project_id     integer   NOT NULL REFERENCES public.projects (project_id) ON DELETE CASCADE,
+                user_id        text      NULL,
+                session_id     bigint    NULL,
+                status         text      NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'done', 'failed')),
+                attempts       smallint  NOT NULL DEFAULT 0,
+                error          text      NULL,
+                sessions_count integer   NOT NULL DEFAULT 0,
+                created_at     timestamp NOT NULL DEFAULT timezone('utc'::text, now()),
+                processed_at   timestamp NULL,
+                CHECK (user_id IS NOT NULL OR session_id IS NOT NULL)
+            );
+            CREATE INDEX erasure_requests_status_created_at_idx ON public.erasure_requests (created_at) WHERE status = 'pending';
+
+            CREATE TABLE public.deletion_audit_log
+            (
+                id         bigint generated BY DEFAULT AS IDENTITY PRIMARY KEY,
+                project_id integer   NOT NULL,
+                session_id bigint    NULL,
+                request_id integer   NULL,
+                reason     text      NOT NULL,
+                files      text[]    NOT NULL DEFAULT '{}'::text[],
+                tables     text[]    NOT NULL DEFAULT '{}'::text[],
+                created_at timestamp NOT NULL DEFAULT timezone('utc'::text, now())
+            );
+            CREATE INDEX deletion_audit_log_project_id_created_at_idx ON public.deletion_audit_log (project_id, created_at DESC);
+            CREATE INDEX deletion_audit_log_request_id_idx ON public.deletion_audit_log (request_id) WHERE request_id IS NOT NULL;
+
+
             CREATE TABLE public.jira_cloud
             (
                 user_id  integer NOT NULL
@@ -357,7 +486,7 @@ $$
             CREATE INDEX issues_project_id_idx ON public.issues (project_id);
 
 
-            CREATE TYPE error_source AS ENUM ('js_exception', 'bugsnag', 'cloudwatch', 'datadog', 'newrelic', 'rollbar', 'sentry', 'stackdriver', 'sumologic', 'elasticsearch');
+            CREATE TYPE error_source AS ENUM ('js_exception', 'bugsnag', 'cloudwatch', 'datadog', 'newrelic', 'rollbar', 'sentry', 'stackdriver', 'sumologic', 'elasticsearch', 'webhook', 'otel', 'loki', 'opensearch', 'http_logs');
             CREATE TYPE error_status AS ENUM ('unresolved', 'resolved', 'ignored');
             CREATE TABLE public.errors
             (
@@ -370,9 +499,17 @@ $$
                 status               error_status NOT NULL DEFAULT 'unresolved',
                 parent_error_id      text                  DEFAULT NULL REFERENCES public.errors (error_id) ON DELETE SET NULL,
                 stacktrace           jsonb, --to save the stacktrace and not query S3 another time
-                stacktrace_parsed_at timestamp
+                stacktrace_parsed_at timestamp,
+                fingerprint          text                  DEFAULT NULL,
+                first_seen_at        bigint                DEFAULT NULL,
+                last_seen_at         bigint                DEFAULT NULL,
+                occurrences          bigint       NOT NULL DEFAULT 0,
+                regressed_at         bigint                DEFAULT NULL,
+                resolved_at          bigint                DEFAULT NULL
             );
             CREATE INDEX errors_project_id_source_idx ON public.errors (project_id, source);
+            CREATE INDEX errors_project_id_last_seen_at_idx ON public.errors (project_id, last_seen_at);
+            CREATE INDEX errors_project_id_fingerprint_idx ON public.errors (project_id, fingerprint);
             CREATE INDEX errors_message_gin_idx ON public.errors USING GIN (message gin_trgm_ops);
             CREATE INDEX errors_name_gin_idx ON public.errors USING GIN (name gin_trgm_ops);
             CREATE INDEX errors_project_id_idx ON public.errors (project_id);
@@ -383,6 +520,12 @@ $$
             CREATE INDEX errors_error_id_idx ON public.errors (error_id);
             CREATE INDEX errors_parent_error_id_idx ON public.errors (parent_error_id);
 
+            CREATE TRIGGER on_resolve
+                BEFORE UPDATE OF status
+                ON public.errors
+                FOR EACH ROW
+            EXECUTE PROCEDURE errors_resolved_at();
+
             CREATE TABLE public.user_favorite_errors
             (
                 user_id  integer NOT NULL REFERENCES public.users (user_id) ON DELETE CASCADE,
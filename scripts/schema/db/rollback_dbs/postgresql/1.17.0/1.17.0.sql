@@ -0,0 +1,60 @@
+\set previous_version 'v1.17.0'
+\set next_version 'v1.16.0'
+SELECT openreplay_version()                       AS current_version,
+       openreplay_version() = :'previous_version' AS valid_previous,
+       openreplay_version() = :'next_version'     AS is_next
+\gset
+
+\if :valid_previous
+\echo valid previous DB version :'previous_version', starting DB downgrade to :'next_version'
+BEGIN;
+SELECT format($fn_def$
+CREATE OR REPLACE FUNCTION openreplay_version()
+    RETURNS text AS
+$$
+SELECT '%1$s'
+$$ LANGUAGE sql IMMUTABLE;
+$fn_def$, :'next_version')
+\gexec
+
+--
+
+DROP TRIGGER IF EXISTS on_resolve ON public.errors;
+DROP FUNCTION IF EXISTS errors_resolved_at();
+DROP INDEX IF EXISTS public.errors_project_id_last_seen_at_idx;
+DROP INDEX IF EXISTS public.errors_project_id_fingerprint_idx;
+ALTER TABLE IF EXISTS public.errors
+    DROP COLUMN IF EXISTS fingerprint,
+    DROP COLUMN IF EXISTS first_seen_at,
+    DROP COLUMN IF EXISTS last_seen_at,
+    DROP COLUMN IF EXISTS occurrences,
+    DROP COLUMN IF EXISTS regressed_at,
+    DROP COLUMN IF EXISTS resolved_at;
+
+-- enum values can't be removed from error_source, errors of new sources are kept
+
+-- enum values can't be removed from integration_provider, only integrations are deleted
+DELETE
+FROM public.integrations
+WHERE provider::text IN ('loki', 'opensearch', 'http_logs');
+
+DROP TABLE IF EXISTS public.session_webhook_pending;
+DROP TABLE IF EXISTS public.session_webhook_deliveries;
+DROP TABLE IF EXISTS public.session_webhooks;
+
+DROP TABLE IF EXISTS public.issue_digests_sessions;
+DROP TABLE IF EXISTS public.issue_digests_state;
+DROP TABLE IF EXISTS public.issue_digests;
+
+DROP TABLE IF EXISTS public.deletion_audit_log;
+DROP TABLE IF EXISTS public.erasure_requests;
+ALTER TABLE IF EXISTS public.projects
+    DROP COLUMN IF EXISTS retention_days;
+
+COMMIT;
+
+\elif :is_next
+\echo new version detected :'next_version', nothing to do
+\else
+\warn skipping DB downgrade of :'next_version', expected previous version :'previous_version', found :'current_version'
+\endif